@@ -0,0 +1,158 @@
+// Command dispatchsim replays booking demand against a fleet offline to see
+// how a change to dispatch or pricing would play out. It runs the server's
+// own code for each step - the in-memory vehicle index to find candidates,
+// the road network to rank them, the dispatch strategies or batch matching to
+// choose one, the ETA model to time the trip and the fare calculation to
+// price it - against vehicles held in memory instead of the database.
+//
+// Demand and fleet positions are generated from a seed, so the same scenario
+// always gives the same report, or replayed from a scenario file:
+//
+//	go run ./cmd/dispatchsim -hours 4 -rate 40 -fleet small=30,medium=20,large=10 -strategy nearest,idle_weighted
+//	go run ./cmd/dispatchsim -scenarios scenarios.json -graph delhi.graph -json
+//
+// With -save the scenarios are written out with their generated requests and
+// vehicles, ready to be replayed after the code under test has changed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	"logi-craft/routing"
+)
+
+func main() {
+	configFile := flag.String("config", "", "JSON config file, as given to the server in LOGICRAFT_CONFIG")
+	graphFile := flag.String("graph", "", "road network to route over; straight lines if empty")
+	scenarioFile := flag.String("scenarios", "", "JSON file with a list of scenarios; the flags below describe one if empty")
+	saveFile := flag.String("save", "", "write the scenarios with their requests and vehicles to this file")
+	asJSON := flag.Bool("json", false, "print the reports as JSON")
+
+	name := flag.String("name", "generated", "scenario name")
+	seed := flag.Int64("seed", 1, "random seed")
+	start := flag.String("start", "2026-01-05T08:00:00+05:30", "simulated time the scenario starts, RFC 3339")
+	hours := flag.Float64("hours", 4, "hours over which bookings arrive")
+	rate := flag.Float64("rate", 40, "bookings per hour")
+	fleet := flag.String("fleet", "small=30,medium=20,large=10", "vehicles of each type")
+	radius := flag.Float64("radius", 12, "km from the centre over which pickups, dropoffs and vehicles are spread")
+	lat := flag.Float64("lat", 28.612894, "latitude of the centre")
+	lng := flag.Float64("lng", 77.216721, "longitude of the centre")
+	strategy := flag.String("strategy", "", "dispatch strategy, or several separated by commas to compare them; the configured one if empty")
+	batch := flag.Float64("batch", 0, "batch window in seconds; 0 matches each booking as it arrives")
+	candidates := flag.Int("candidates", 0, "nearest vehicles considered per booking; the configured number if 0")
+	flag.Parse()
+
+	if *configFile != "" {
+		if err := config.Load(*configFile); err != nil {
+			log.Fatalf("Error loading config %s: %v", *configFile, err)
+		}
+	}
+	if *graphFile != "" {
+		config.App.Routing.GraphFile = *graphFile
+	}
+	if err := routing.Setup(config.App.Routing); err != nil {
+		log.Fatal(err)
+	}
+
+	var scenarios []Scenario
+	if *scenarioFile != "" {
+		data, err := os.ReadFile(*scenarioFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &scenarios); err != nil {
+			log.Fatalf("Error reading scenarios %s: %v", *scenarioFile, err)
+		}
+	} else {
+		startAt, err := time.Parse(time.RFC3339, *start)
+		if err != nil {
+			log.Fatalf("Invalid -start: %v", err)
+		}
+		counts, err := parseFleet(*fleet)
+		if err != nil {
+			log.Fatalf("Invalid -fleet: %v", err)
+		}
+		base := Scenario{
+			Name:               *name,
+			Seed:               *seed,
+			Start:              startAt,
+			Hours:              *hours,
+			BookingsPerHour:    *rate,
+			Fleet:              counts,
+			Centre:             models.Coordinates{Latitude: *lat, Longitude: *lng},
+			RadiusKm:           *radius,
+			BatchWindowSeconds: *batch,
+			Candidates:         *candidates,
+		}
+		// Compared strategies see exactly the same requests and vehicles
+		for _, s := range strings.Split(*strategy, ",") {
+			sc := base
+			sc.Strategy = strings.TrimSpace(s)
+			if sc.Strategy != "" && strings.Contains(*strategy, ",") {
+				sc.Name = base.Name + "/" + sc.Strategy
+			}
+			scenarios = append(scenarios, sc)
+		}
+	}
+
+	var reports []Report
+	for i := range scenarios {
+		if err := scenarios[i].prepare(); err != nil {
+			log.Fatalf("Scenario %q: %v", scenarios[i].Name, err)
+		}
+		report, err := run(scenarios[i])
+		if err != nil {
+			log.Fatalf("Scenario %q: %v", scenarios[i].Name, err)
+		}
+		reports = append(reports, report)
+	}
+
+	if *saveFile != "" {
+		data, err := json.MarshalIndent(scenarios, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*saveFile, data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+		return
+	}
+	for i, r := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		r.print(os.Stdout)
+	}
+}
+
+// parseFleet reads vehicle counts such as "small=20,medium=15"
+func parseFleet(value string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, part := range strings.Split(value, ",") {
+		vehicleType, count, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not type=count", part)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q is not a count of vehicles", count)
+		}
+		counts[strings.TrimSpace(vehicleType)] = n
+	}
+	return counts, nil
+}
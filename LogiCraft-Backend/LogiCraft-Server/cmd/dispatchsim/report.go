@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"logi-craft/utils"
+)
+
+// pickupBuckets are the upper bounds in km of the pickup distance histogram
+var pickupBuckets = []float64{1, 2, 5, 10, math.Inf(1)}
+
+// Report sums up a scenario run
+type Report struct {
+	Scenario      string       `json:"scenario"`
+	Seed          int64        `json:"seed"`
+	Strategy      string       `json:"strategy"`
+	BatchWindow   float64      `json:"batch_window_seconds"`
+	Vehicles      int          `json:"vehicles"`
+	Requests      int          `json:"requests"`
+	Served        int          `json:"served"`
+	Unfulfilled   int          `json:"unfulfilled"`
+	PickupKm      Distribution `json:"pickup_km"`
+	PickupBuckets []Bucket     `json:"pickup_buckets"`
+	WaitMinutes   Distribution `json:"wait_minutes"`
+	Utilisation   float64      `json:"utilisation"` // share of fleet time spent on bookings while bookings arrive
+	Revenue       float64      `json:"revenue"`
+	AverageFare   float64      `json:"average_fare"`
+}
+
+// Distribution summarises a set of values
+type Distribution struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Bucket counts the values up to a bound that were above the bound before it
+type Bucket struct {
+	UpTo  float64 `json:"up_to"` // 0 for no bound
+	Count int     `json:"count"`
+}
+
+func (sim *simulation) report() Report {
+	s := sim.scenario
+	r := Report{
+		Scenario:    s.Name,
+		Seed:        s.Seed,
+		Strategy:    s.Strategy,
+		BatchWindow: s.BatchWindowSeconds,
+		Vehicles:    len(sim.vehicles),
+		Requests:    len(s.Requests),
+		Served:      len(sim.trips),
+		Unfulfilled: sim.failed,
+	}
+	if s.BatchWindowSeconds > 0 {
+		r.Strategy = "batch_matching"
+	}
+
+	pickups := make([]float64, len(sim.trips))
+	waits := make([]float64, len(sim.trips))
+	for i, t := range sim.trips {
+		pickups[i] = t.pickupKm
+		waits[i] = t.waitMinutes
+		r.Revenue += t.fare
+	}
+	r.PickupKm = distribution(pickups)
+	r.WaitMinutes = distribution(waits)
+	r.Revenue = utils.RoundMoney(r.Revenue)
+	if r.Served > 0 {
+		r.AverageFare = utils.RoundMoney(r.Revenue / float64(r.Served))
+	}
+
+	for _, bound := range pickupBuckets {
+		b := Bucket{}
+		if !math.IsInf(bound, 1) {
+			b.UpTo = bound
+		}
+		r.PickupBuckets = append(r.PickupBuckets, b)
+	}
+	for _, km := range pickups {
+		for i, bound := range pickupBuckets {
+			if km <= bound {
+				r.PickupBuckets[i].Count++
+				break
+			}
+		}
+	}
+
+	var busy float64
+	for _, v := range sim.vehicles {
+		busy += v.busy.Hours()
+	}
+	if len(sim.vehicles) > 0 {
+		r.Utilisation = round(busy/(float64(len(sim.vehicles))*s.Hours), 4)
+	}
+	return r
+}
+
+func distribution(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	// Nearest-rank percentiles
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return round(sorted[max(i, 0)], 2)
+	}
+	return Distribution{
+		Mean: round(sum/float64(len(sorted)), 2),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  round(sorted[len(sorted)-1], 2),
+	}
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+func (r Report) print(w io.Writer) {
+	fmt.Fprintf(w, "Scenario %s (seed %d, strategy %s", r.Scenario, r.Seed, r.Strategy)
+	if r.BatchWindow > 0 {
+		fmt.Fprintf(w, ", %gs batches", r.BatchWindow)
+	}
+	fmt.Fprintf(w, ", %d vehicles)\n", r.Vehicles)
+
+	unfulfilled := 0.0
+	if r.Requests > 0 {
+		unfulfilled = float64(r.Unfulfilled) / float64(r.Requests) * 100
+	}
+	fmt.Fprintf(w, "  Requests      %d, served %d, unfulfilled %d (%.1f%%)\n", r.Requests, r.Served, r.Unfulfilled, unfulfilled)
+	fmt.Fprintf(w, "  Pickup km     mean %.2f  p50 %.2f  p90 %.2f  p99 %.2f  max %.2f\n", r.PickupKm.Mean, r.PickupKm.P50, r.PickupKm.P90, r.PickupKm.P99, r.PickupKm.Max)
+	fmt.Fprintf(w, "  Pickup spread")
+	lower := 0.0
+	for _, b := range r.PickupBuckets {
+		if b.UpTo == 0 {
+			fmt.Fprintf(w, "  >%g: %d", lower, b.Count)
+		} else {
+			fmt.Fprintf(w, "  %g-%g: %d", lower, b.UpTo, b.Count)
+			lower = b.UpTo
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  Wait minutes  mean %.2f  p50 %.2f  p90 %.2f  p99 %.2f  max %.2f\n", r.WaitMinutes.Mean, r.WaitMinutes.P50, r.WaitMinutes.P90, r.WaitMinutes.P99, r.WaitMinutes.Max)
+	fmt.Fprintf(w, "  Utilisation   %.1f%%\n", r.Utilisation*100)
+	fmt.Fprintf(w, "  Revenue       %.2f (average fare %.2f)\n", r.Revenue, r.AverageFare)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	"logi-craft/utils"
+)
+
+// Scenario is one simulation run. Requests and Vehicles are generated from
+// the seed when they are empty and replayed as given otherwise.
+type Scenario struct {
+	Name               string             `json:"name"`
+	Seed               int64              `json:"seed"`
+	Start              time.Time          `json:"start"`             // simulated time the first bookings may arrive
+	Hours              float64            `json:"hours"`             // how long bookings keep arriving
+	BookingsPerHour    float64            `json:"bookings_per_hour"` // average arrival rate of generated bookings
+	Fleet              map[string]int     `json:"fleet"`             // generated vehicles of each type
+	Centre             models.Coordinates `json:"centre"`
+	RadiusKm           float64            `json:"radius_km"` // generated points lie within this distance of the centre
+	Strategy           string             `json:"strategy,omitempty"`
+	BatchWindowSeconds float64            `json:"batch_window_seconds,omitempty"`
+	Candidates         int                `json:"candidates,omitempty"`
+	Requests           []Request          `json:"requests,omitempty"`
+	Vehicles           []FleetVehicle     `json:"vehicles,omitempty"`
+}
+
+// Request is a booking made during a scenario
+type Request struct {
+	Minute      float64            `json:"minute"` // minutes after the start of the scenario
+	VehicleType string             `json:"vehicle_type"`
+	Pickup      models.Coordinates `json:"pickup"`
+	Dropoff     models.Coordinates `json:"dropoff"`
+}
+
+// FleetVehicle is a vehicle at the start of a scenario, free and with a driver
+type FleetVehicle struct {
+	VehicleNo   string             `json:"vehicle_no"`
+	VehicleType string             `json:"vehicle_type"`
+	Position    models.Coordinates `json:"position"`
+	Rating      float64            `json:"rating"` // the driver's average rating; 0 for none yet
+}
+
+// prepare fills in defaults and generates whatever the scenario does not replay
+func (s *Scenario) prepare() error {
+	if s.Strategy == "" {
+		s.Strategy = config.App.Dispatch.Strategy
+	}
+	if s.Candidates == 0 {
+		s.Candidates = config.App.Dispatch.Candidates
+	}
+	if s.Start.IsZero() {
+		return fmt.Errorf("start is required")
+	}
+	if s.Hours <= 0 {
+		return fmt.Errorf("hours must be positive")
+	}
+	for vehicleType := range s.Fleet {
+		if _, ok := utils.VehicleRates[vehicleType]; !ok {
+			return fmt.Errorf("unknown vehicle type %q", vehicleType)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(s.Seed))
+	types := make([]string, 0, len(s.Fleet))
+	for vehicleType, n := range s.Fleet {
+		if n > 0 {
+			types = append(types, vehicleType)
+		}
+	}
+	sort.Strings(types)
+
+	if len(s.Vehicles) == 0 {
+		for _, vehicleType := range types {
+			for i := 0; i < s.Fleet[vehicleType]; i++ {
+				s.Vehicles = append(s.Vehicles, FleetVehicle{
+					VehicleNo:   fmt.Sprintf("SIM-%s-%03d", vehicleType, i+1),
+					VehicleType: vehicleType,
+					Position:    s.randomPoint(rng),
+					Rating:      math.Round((3.5+rng.Float64()*1.5)*10) / 10,
+				})
+			}
+		}
+	}
+	if len(s.Vehicles) == 0 {
+		return fmt.Errorf("the scenario has no vehicles")
+	}
+
+	if len(s.Requests) == 0 {
+		if s.BookingsPerHour <= 0 {
+			return fmt.Errorf("bookings_per_hour must be positive to generate requests")
+		}
+		// Bookings arrive at random, each type as often as it makes up the fleet
+		minute := 0.0
+		for {
+			minute += rng.ExpFloat64() / s.BookingsPerHour * 60
+			if minute >= s.Hours*60 {
+				break
+			}
+			pick := rng.Intn(len(s.Vehicles))
+			s.Requests = append(s.Requests, Request{
+				Minute:      math.Round(minute*100) / 100,
+				VehicleType: s.Vehicles[pick].VehicleType,
+				Pickup:      s.randomPoint(rng),
+				Dropoff:     s.randomPoint(rng),
+			})
+		}
+	}
+	sort.SliceStable(s.Requests, func(i, j int) bool { return s.Requests[i].Minute < s.Requests[j].Minute })
+	return nil
+}
+
+// randomPoint returns a point spread evenly over the scenario's circle
+func (s *Scenario) randomPoint(rng *rand.Rand) models.Coordinates {
+	const kmPerDegree = 111.195
+	r := s.RadiusKm * math.Sqrt(rng.Float64())
+	theta := 2 * math.Pi * rng.Float64()
+	lat := s.Centre.Latitude + r*math.Cos(theta)/kmPerDegree
+	lng := s.Centre.Longitude + r*math.Sin(theta)/(kmPerDegree*math.Cos(s.Centre.Latitude*math.Pi/180))
+	// Keep coordinates to about a metre so saved scenarios stay readable
+	return models.Coordinates{Latitude: math.Round(lat*1e5) / 1e5, Longitude: math.Round(lng*1e5) / 1e5}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+	dispatch "logi-craft/routes/Dispatch"
+	tracking "logi-craft/routes/Tracking"
+	"logi-craft/routing"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of event, in the order events at the same moment are handled
+const (
+	vehicleFree = iota // a vehicle drops off its cargo
+	bookingMade        // a customer asks for a vehicle
+	batchClosed        // the batch window ends
+)
+
+type event struct {
+	at      time.Time
+	kind    int
+	seq     int // keeps events at the same moment in the order they were added
+	request int
+	vehicle *vehicle
+}
+
+type events []event
+
+func (q events) Len() int      { return len(q) }
+func (q events) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q events) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	if q[i].kind != q[j].kind {
+		return q[i].kind < q[j].kind
+	}
+	return q[i].seq < q[j].seq
+}
+func (q *events) Push(x any) { *q = append(*q, x.(event)) }
+func (q *events) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// vehicle is a simulated vehicle with its driver's assignment
+type vehicle struct {
+	models.Vehicle
+	assignment models.Assignment
+	busySince  time.Time
+	busy       time.Duration // time spent on bookings within the demand window
+}
+
+// simulation plays a scenario against vehicles held in memory
+type simulation struct {
+	scenario Scenario
+	start    time.Time
+	end      time.Time // when bookings stop arriving
+	store    *booking.MemoryStore
+	vehicles map[primitive.ObjectID]*vehicle
+	queue    events
+	seq      int
+	batch    []int // requests waiting for the batch window to close
+	trips    []trip
+	failed   int
+}
+
+// trip is a booking that got a vehicle
+type trip struct {
+	pickupKm    float64
+	waitMinutes float64 // from the booking to the vehicle reaching the pickup
+	fare        float64
+}
+
+// simID makes a vehicle ID from its position in the fleet, so runs do not
+// depend on the clock
+func simID(n int) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint64(id[4:], uint64(n+1))
+	return id
+}
+
+// run plays a scenario through and reports on it
+func run(s Scenario) (Report, error) {
+	// Every booking is dispatched with the scenario's strategy; rules by city
+	// would need the service areas in the database
+	config.App.Dispatch.Strategy = s.Strategy
+	config.App.Dispatch.StrategyRules = nil
+	config.App.Dispatch.Candidates = s.Candidates
+	if err := dispatch.Setup(config.App.Dispatch); err != nil {
+		return Report{}, err
+	}
+	sim := &simulation{
+		scenario: s,
+		start:    s.Start,
+		end:      s.Start.Add(time.Duration(s.Hours * float64(time.Hour))),
+		store:    booking.NewMemoryStore(),
+		vehicles: map[primitive.ObjectID]*vehicle{},
+	}
+
+	for i, fv := range s.Vehicles {
+		if _, ok := utils.VehicleRates[fv.VehicleType]; !ok {
+			return Report{}, fmt.Errorf("vehicle %s has unknown type %q", fv.VehicleNo, fv.VehicleType)
+		}
+		freed := s.Start
+		v := &vehicle{
+			Vehicle: models.Vehicle{
+				ID:          simID(i),
+				VehicleNo:   fv.VehicleNo,
+				VehicleType: fv.VehicleType,
+				Coordinates: fv.Position,
+				Online:      true,
+			},
+			assignment: models.Assignment{UID: simID(i), VehicleNo: fv.VehicleNo, FreedAt: &freed},
+		}
+		if fv.Rating > 0 {
+			v.assignment.Rating, v.assignment.Ratings = fv.Rating, 1
+		}
+		sim.vehicles[v.ID] = v
+		sim.store.Put(v.Vehicle, v.assignment)
+	}
+
+	for i, r := range s.Requests {
+		sim.push(event{at: s.Start.Add(time.Duration(r.Minute * float64(time.Minute))), kind: bookingMade, request: i})
+	}
+	for sim.queue.Len() > 0 {
+		e := heap.Pop(&sim.queue).(event)
+		switch e.kind {
+		case vehicleFree:
+			sim.free(e.vehicle, e.at)
+		case bookingMade:
+			sim.book(e.request, e.at)
+		case batchClosed:
+			sim.closeBatch(e.at)
+		}
+	}
+	return sim.report(), nil
+}
+
+func (sim *simulation) push(e event) {
+	e.seq = sim.seq
+	sim.seq++
+	heap.Push(&sim.queue, e)
+}
+
+// book finds a vehicle for a booking as it arrives, or adds it to the batch
+func (sim *simulation) book(request int, now time.Time) {
+	if window := sim.scenario.BatchWindowSeconds; window > 0 {
+		sim.batch = append(sim.batch, request)
+		if len(sim.batch) == 1 {
+			sim.push(event{at: now.Add(time.Duration(window * float64(time.Second))), kind: batchClosed})
+		}
+		return
+	}
+
+	vehicle, _, pickupKm, _, err := booking.PickVehicle(context.Background(), sim.store, sim.bookingFor(request), now)
+	if err != nil {
+		sim.failed++
+		return
+	}
+	sim.assign(request, sim.vehicles[vehicle.ID], pickupKm, now, now)
+}
+
+// bookingFor is what the server would store for a request
+func (sim *simulation) bookingFor(request int) models.Booking {
+	r := sim.scenario.Requests[request]
+	return models.Booking{VehicleType: r.VehicleType, PickupLocation: r.Pickup, DropoffLocation: r.Dropoff}
+}
+
+// closeBatch matches the bookings collected over the batch window to distinct
+// vehicles with the least total pickup distance, as the server does
+func (sim *simulation) closeBatch(now time.Time) {
+	pending := sim.batch
+	sim.batch = nil
+
+	bookings := make([]models.Booking, len(pending))
+	for i, request := range pending {
+		bookings[i] = sim.bookingFor(request)
+	}
+	for i, m := range booking.MatchBatch(context.Background(), sim.store, bookings) {
+		if m.Err != nil {
+			sim.failed++
+			continue
+		}
+		requestedAt := sim.start.Add(time.Duration(sim.scenario.Requests[pending[i]].Minute * float64(time.Minute)))
+		sim.assign(pending[i], sim.vehicles[m.Vehicle.ID], m.Distance, requestedAt, now)
+	}
+}
+
+// assign sends a vehicle to a booking and books the moment it will be free again
+func (sim *simulation) assign(request int, v *vehicle, pickupKm float64, requestedAt, now time.Time) {
+	r := sim.scenario.Requests[request]
+
+	toPickup := routing.Between(v.Coordinates, r.Pickup)
+	pickupAt := now.Add(tracking.TravelTime(v.VehicleType, toPickup, now))
+	ride := routing.Between(r.Pickup, r.Dropoff)
+	dropoffAt := pickupAt.Add(tracking.TravelTime(v.VehicleType, ride, pickupAt))
+
+	sim.trips = append(sim.trips, trip{
+		pickupKm:    pickupKm,
+		waitMinutes: pickupAt.Sub(requestedAt).Minutes(),
+		fare:        utils.CalculateFare(ride.Distance, v.VehicleType),
+	})
+
+	assignedAt := now
+	v.assignment.AssignedAt = &assignedAt
+	v.busySince = now
+	v.Busy = true
+	v.Coordinates = r.Dropoff
+	sim.store.Put(v.Vehicle, v.assignment)
+	sim.push(event{at: dropoffAt, kind: vehicleFree, vehicle: v})
+}
+
+// free makes a vehicle available again at its dropoff point
+func (sim *simulation) free(v *vehicle, now time.Time) {
+	// Only time within the demand window counts towards utilisation
+	from, to := v.busySince, now
+	if to.After(sim.end) {
+		to = sim.end
+	}
+	if to.After(from) {
+		v.busy += to.Sub(from)
+	}
+
+	freedAt := now
+	v.assignment.FreedAt = &freedAt
+	v.Busy = false
+	sim.store.Put(v.Vehicle, v.assignment)
+}
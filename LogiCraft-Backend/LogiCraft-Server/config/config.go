@@ -0,0 +1,368 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+type Config struct {
+	Company   CompanyDetails  `json:"company"`
+	Taxes     []TaxRate       `json:"taxes"`
+	Payments  PaymentConfig   `json:"payments"`
+	Earnings  EarningsConfig  `json:"earnings"`
+	ETA       ETAConfig       `json:"eta"`
+	Dispatch  DispatchConfig  `json:"dispatch"`
+	Geocoding GeocodingConfig `json:"geocoding"`
+	Notify    NotifyConfig    `json:"notify"`
+	Tracking  TrackingConfig  `json:"tracking"`
+	Sharing   SharingConfig   `json:"sharing"`
+	Routing   RoutingConfig   `json:"routing"`
+	Planning  PlanningConfig  `json:"planning"`
+	Presence  PresenceConfig  `json:"presence"`
+	Upgrades  UpgradeConfig   `json:"upgrades"`
+}
+
+type CompanyDetails struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	GSTIN   string `json:"gstin"`
+	Phone   string `json:"phone"`
+}
+
+// TaxRate is one GST component, e.g. CGST at 2.5 percent
+type TaxRate struct {
+	Name string  `json:"name"`
+	Rate float64 `json:"rate"`
+}
+
+type PaymentConfig struct {
+	Gateway  string `json:"gateway"`
+	Currency string `json:"currency"`
+	// RequirePreauth makes every booking secure its fare before a vehicle is dispatched
+	RequirePreauth bool `json:"require_preauth"`
+}
+
+type EarningsConfig struct {
+	// CommissionRate is the percentage of each fare kept by the company
+	CommissionRate float64 `json:"commission_rate"`
+	// CommissionByVehicleType overrides CommissionRate for specific vehicle types
+	CommissionByVehicleType map[string]float64 `json:"commission_by_vehicle_type"`
+}
+
+// CommissionFor returns the commission percentage for a vehicle type
+func (e EarningsConfig) CommissionFor(vehicleType string) float64 {
+	if rate, ok := e.CommissionByVehicleType[vehicleType]; ok {
+		return rate
+	}
+	return e.CommissionRate
+}
+
+type ETAConfig struct {
+	// Speeds is the average speed in km/h of each vehicle type
+	Speeds       map[string]float64 `json:"speeds"`
+	DefaultSpeed float64            `json:"default_speed"`
+	// TimeOfDay scales the speed during the given hours, e.g. 0.6 in the evening rush
+	TimeOfDay []SpeedBand `json:"time_of_day"`
+	// PickupRadius is how close in km a vehicle must be to count as arrived at pickup
+	PickupRadius float64 `json:"pickup_radius"`
+}
+
+// SpeedBand applies Factor to speeds from FromHour up to, but not including, ToHour
+type SpeedBand struct {
+	FromHour int     `json:"from_hour"`
+	ToHour   int     `json:"to_hour"`
+	Factor   float64 `json:"factor"`
+}
+
+// SpeedFor returns the expected average speed of a vehicle type at the given time
+func (e ETAConfig) SpeedFor(vehicleType string, at time.Time) float64 {
+	speed, ok := e.Speeds[vehicleType]
+	if !ok {
+		speed = e.DefaultSpeed
+	}
+	return speed * e.FactorAt(at)
+}
+
+// FactorAt returns how much traffic at the given time scales speeds by
+func (e ETAConfig) FactorAt(at time.Time) float64 {
+	hour := at.Hour()
+	for _, band := range e.TimeOfDay {
+		if hour >= band.FromHour && hour < band.ToHour {
+			return band.Factor
+		}
+	}
+	return 1
+}
+
+type DispatchConfig struct {
+	// ScheduleLeadMinutes is how long before its pickup time a scheduled booking gets a vehicle
+	ScheduleLeadMinutes int `json:"schedule_lead_minutes"`
+	// UnfulfilledAfterMinutes is how long past its pickup time a scheduled booking keeps waiting for a vehicle
+	UnfulfilledAfterMinutes int `json:"unfulfilled_after_minutes"`
+	// RecurringHorizonHours is how far ahead occurrences of recurring bookings are turned into bookings
+	RecurringHorizonHours int `json:"recurring_horizon_hours"`
+	// SearchRadiusKm is how far from the pickup point vehicles are searched for
+	SearchRadiusKm float64 `json:"search_radius_km"`
+	// Candidates is how many of the nearest vehicles are considered for a booking
+	Candidates int `json:"candidates"`
+	// MemoryIndex searches free vehicles in an in-memory index kept in step with the database
+	MemoryIndex bool `json:"memory_index"`
+	// Strategy chooses among the nearest free vehicles: nearest, idle_weighted,
+	// rating_weighted or least_recently_assigned
+	Strategy string `json:"strategy"`
+	// StrategyRules override Strategy for bookings in a city, of a vehicle type, or both. The first matching rule wins.
+	StrategyRules []StrategyRule `json:"strategy_rules"`
+	// IdleKmPerHour is how much further away in km idle_weighted lets a driver be for each hour they have waited
+	IdleKmPerHour float64 `json:"idle_km_per_hour"`
+	// RatingKmPerStar is how much further away in km rating_weighted lets a driver be for each star of rating
+	RatingKmPerStar float64 `json:"rating_km_per_star"`
+	// BatchWindowMs collects bookings for this long and matches them to vehicles together, keeping the
+	// total pickup distance low; 0 matches each booking to a vehicle as soon as it arrives
+	BatchWindowMs int `json:"batch_window_ms"`
+}
+
+// StrategyRule picks a dispatch strategy. City is the name of a service area
+// containing the pickup point; leave City or VehicleType empty to match any.
+type StrategyRule struct {
+	City        string `json:"city"`
+	VehicleType string `json:"vehicle_type"`
+	Strategy    string `json:"strategy"`
+}
+
+// ScheduleLead returns ScheduleLeadMinutes as a duration
+func (d DispatchConfig) ScheduleLead() time.Duration {
+	return time.Duration(d.ScheduleLeadMinutes) * time.Minute
+}
+
+// UnfulfilledAfter returns UnfulfilledAfterMinutes as a duration
+func (d DispatchConfig) UnfulfilledAfter() time.Duration {
+	return time.Duration(d.UnfulfilledAfterMinutes) * time.Minute
+}
+
+// RecurringHorizon returns RecurringHorizonHours as a duration
+func (d DispatchConfig) RecurringHorizon() time.Duration {
+	return time.Duration(d.RecurringHorizonHours) * time.Hour
+}
+
+// BatchWindow returns BatchWindowMs as a duration
+func (d DispatchConfig) BatchWindow() time.Duration {
+	return time.Duration(d.BatchWindowMs) * time.Millisecond
+}
+
+// GeocodingConfig selects the service used to turn addresses into coordinates.
+// Leave Provider empty to accept coordinates only.
+type GeocodingConfig struct {
+	Provider  string `json:"provider"` // "" or "nominatim"
+	URL       string `json:"url"`
+	UserAgent string `json:"user_agent"`
+}
+
+// RoutingConfig selects the road network used for driving distances and times.
+// Leave GraphFile empty to use straight-line distances.
+type RoutingConfig struct {
+	// GraphFile is an OpenStreetMap extract ending in .osm, or a road graph built from one by cmd/roadgraph
+	GraphFile string `json:"graph_file"`
+	// SnapRadiusKm is how far a point may be from the nearest road for it to be routed
+	SnapRadiusKm float64 `json:"snap_radius_km"`
+}
+
+// NotifyConfig selects how customers, receivers and drivers are messaged
+type NotifyConfig struct {
+	Provider   string `json:"provider"` // "log" or "webhook"
+	WebhookURL string `json:"webhook_url"`
+	// NearRadius is how close in km to the dropoff a vehicle is when the receiver is told it is near
+	NearRadius float64 `json:"near_radius"`
+}
+
+type TrackingConfig struct {
+	// LinkSecret signs public tracking links. Every instance must share it, and the server will not start without it.
+	LinkSecret string `json:"link_secret"`
+	// LinkTTLHours is how long a public tracking link works for
+	LinkTTLHours int `json:"link_ttl_hours"`
+	// DeviceTokenDays is how long the device token a driver gets at login may send location updates
+	DeviceTokenDays int `json:"device_token_days"`
+	// HistoryDays is how long every accepted vehicle position is kept for replaying trips
+	HistoryDays int `json:"history_days"`
+}
+
+// LinkTTL returns LinkTTLHours as a duration
+func (t TrackingConfig) LinkTTL() time.Duration {
+	return time.Duration(t.LinkTTLHours) * time.Hour
+}
+
+// HistoryRetention returns HistoryDays as a duration
+func (t TrackingConfig) HistoryRetention() time.Duration {
+	return time.Duration(t.HistoryDays) * 24 * time.Hour
+}
+
+// DeviceTokenTTL returns DeviceTokenDays as a duration
+func (t TrackingConfig) DeviceTokenTTL() time.Duration {
+	return time.Duration(t.DeviceTokenDays) * 24 * time.Hour
+}
+
+// SharingConfig controls part-load bookings, which share a vehicle with other bookings
+type SharingConfig struct {
+	// Capacities is how much cargo each vehicle type can carry
+	Capacities map[string]Capacity `json:"capacities"`
+	// MaxDetourKm is the most distance adding a shared booking may add to the trip of any booking on board, its own included
+	MaxDetourKm float64 `json:"max_detour_km"`
+	// MinFareShare is the smallest part of the whole-vehicle fare a shared booking pays
+	MinFareShare float64 `json:"min_fare_share"`
+}
+
+// Capacity is a cargo volume in cubic metres and weight in kg
+type Capacity struct {
+	Volume float64 `json:"volume"`
+	Weight float64 `json:"weight"`
+}
+
+// PlanningConfig controls route plans, which share many deliveries from a depot among a fleet
+type PlanningConfig struct {
+	// ShiftHours is how long after the start of a plan every route must be finished
+	ShiftHours float64 `json:"shift_hours"`
+	// ServiceMinutes is how long a delivery takes at its stop when it does not say
+	ServiceMinutes float64 `json:"service_minutes"`
+	// SearchSeconds is how long the planner may spend improving a plan
+	SearchSeconds int `json:"search_seconds"`
+	// MaxDeliveries caps the size of a single plan
+	MaxDeliveries int `json:"max_deliveries"`
+	// MaxVehicles is how many free vehicles nearest the depot a plan uses when it names none
+	MaxVehicles int `json:"max_vehicles"`
+}
+
+// SearchTime returns SearchSeconds as a duration
+func (p PlanningConfig) SearchTime() time.Duration {
+	return time.Duration(p.SearchSeconds) * time.Second
+}
+
+// PresenceConfig controls when drivers are taken offline without asking
+type PresenceConfig struct {
+	// TimeoutSeconds is how long an online driver's device may go without a heartbeat or location update
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// RequireShift keeps drivers with no shifts from going online; otherwise they may go online at any time
+	RequireShift bool `json:"require_shift"`
+}
+
+// Timeout returns TimeoutSeconds as a duration
+func (p PresenceConfig) Timeout() time.Duration {
+	return time.Duration(p.TimeoutSeconds) * time.Second
+}
+
+// UpgradeConfig says which larger vehicle types may serve a booking when no
+// vehicle of the type it asked for is free
+type UpgradeConfig struct {
+	// Options lists, for each vehicle type, the larger types that may take its bookings, in order of preference
+	Options map[string][]UpgradeOption `json:"options"`
+}
+
+// UpgradeOption is a larger vehicle type a booking may be moved to. Surcharge
+// is the percentage added to the original fare; 0 keeps the original price.
+// Scheduled bookings are dispatched after their fare is secured, so they are
+// only upgraded to options without a surcharge.
+type UpgradeOption struct {
+	VehicleType string  `json:"vehicle_type"`
+	Surcharge   float64 `json:"surcharge"`
+}
+
+// OptionsFor returns the upgrades allowed for a vehicle type, in order of preference
+func (u UpgradeConfig) OptionsFor(vehicleType string) []UpgradeOption {
+	return u.Options[vehicleType]
+}
+
+// App holds the active configuration. It starts with the defaults below and
+// can be overridden with Load.
+var App = Config{
+	Company: CompanyDetails{
+		Name:    "LogiCraft Logistics Pvt. Ltd.",
+		Address: "New Delhi, India",
+	},
+	Taxes: []TaxRate{
+		{Name: "CGST", Rate: 2.5},
+		{Name: "SGST", Rate: 2.5},
+	},
+	Payments: PaymentConfig{
+		Gateway:  "fake",
+		Currency: "INR",
+	},
+	Earnings: EarningsConfig{
+		CommissionRate: 20,
+	},
+	ETA: ETAConfig{
+		Speeds: map[string]float64{
+			"small":  30,
+			"medium": 25,
+			"large":  20,
+		},
+		DefaultSpeed: 25,
+		TimeOfDay: []SpeedBand{
+			{FromHour: 8, ToHour: 11, Factor: 0.6},
+			{FromHour: 17, ToHour: 21, Factor: 0.6},
+			{FromHour: 23, ToHour: 24, Factor: 1.3},
+			{FromHour: 0, ToHour: 6, Factor: 1.3},
+		},
+		PickupRadius: 0.5,
+	},
+	Dispatch: DispatchConfig{
+		ScheduleLeadMinutes:     30,
+		UnfulfilledAfterMinutes: 30,
+		RecurringHorizonHours:   24,
+		SearchRadiusKm:          25,
+		Candidates:              10,
+		MemoryIndex:             true,
+		Strategy:                "nearest",
+		IdleKmPerHour:           2,
+		RatingKmPerStar:         2,
+	},
+	Geocoding: GeocodingConfig{
+		URL:       "https://nominatim.openstreetmap.org",
+		UserAgent: "LogiCraft",
+	},
+	Notify: NotifyConfig{
+		Provider:   "log",
+		NearRadius: 1,
+	},
+	Tracking: TrackingConfig{
+		LinkTTLHours:    48,
+		DeviceTokenDays: 30,
+		HistoryDays:     30,
+	},
+	Sharing: SharingConfig{
+		Capacities: map[string]Capacity{
+			"small":  {Volume: 2, Weight: 750},
+			"medium": {Volume: 6, Weight: 2000},
+			"large":  {Volume: 15, Weight: 5000},
+		},
+		MaxDetourKm:  3,
+		MinFareShare: 0.25,
+	},
+	Routing: RoutingConfig{
+		SnapRadiusKm: 0.5,
+	},
+	Planning: PlanningConfig{
+		ShiftHours:     10,
+		ServiceMinutes: 10,
+		SearchSeconds:  5,
+		MaxDeliveries:  300,
+		MaxVehicles:    50,
+	},
+	Presence: PresenceConfig{
+		TimeoutSeconds: 180,
+	},
+	Upgrades: UpgradeConfig{
+		Options: map[string][]UpgradeOption{
+			"small":  {{VehicleType: "medium"}, {VehicleType: "large", Surcharge: 15}},
+			"medium": {{VehicleType: "large"}},
+		},
+	},
+}
+
+// Load reads a JSON config file on top of the defaults. Fields missing from
+// the file keep their default values.
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &App)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PollInterval is how often changes are polled for when the database has no
+// change streams. Each poll reaches back PollOverlap before the newest change
+// already seen, in case a write made just before it was not yet visible;
+// documents seen twice must be harmless to handle again.
+const (
+	PollInterval = 2 * time.Second
+	PollOverlap  = time.Second
+)
+
+var pollingOnce sync.Once
+
+// Change is the part of a change stream event the change feeds need
+type Change struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		ID primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"` // missing if deleted before an update could be looked up
+}
+
+// Watch opens a change stream on the database with the full document of every
+// update looked up. Change streams need a replica set; the first time one
+// cannot be opened this is logged, and callers poll for changes instead.
+func Watch(ctx context.Context, pipeline mongo.Pipeline) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := GetCollection("vehicles").Database().Watch(ctx, pipeline, opts)
+	if err != nil {
+		pollingOnce.Do(func() {
+			log.Printf("Change streams unavailable (%v); polling for changes every %s", err, PollInterval)
+		})
+	}
+	return stream, err
+}
+
+// ChangedVehicles returns the vehicles changed since the given time, or every
+// vehicle if it is zero, and when the newest of them changed
+func ChangedVehicles(ctx context.Context, since time.Time) ([]models.Vehicle, time.Time, error) {
+	filter := bson.M{}
+	if !since.IsZero() {
+		filter["updated_at"] = bson.M{"$gte": since.Add(-PollOverlap)}
+	}
+	cursor, err := GetCollection("vehicles").Find(ctx, filter)
+	if err != nil {
+		return nil, since, err
+	}
+	var vehicles []models.Vehicle
+	if err := cursor.All(ctx, &vehicles); err != nil {
+		return nil, since, err
+	}
+	for _, v := range vehicles {
+		if v.UpdatedAt != nil && v.UpdatedAt.After(since) {
+			since = *v.UpdatedAt
+		}
+	}
+	return vehicles, since, nil
+}
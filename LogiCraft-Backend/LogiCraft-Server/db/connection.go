@@ -1,45 +1,63 @@
-package db
-
-import (
-	"context"
-	"log"
-	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-var Client *mongo.Client
-
-func ConnectDB(uri string) *mongo.Client {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	clientOptions := options.Client().ApplyURI(uri)
-	clientOptions.SetMaxPoolSize(100)
-	clientOptions.SetMinPoolSize(10)
-	clientOptions.SetMaxConnIdleTime(5 * time.Minute)
-
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Check the connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Println("Connected to MongoDB!")
-	Client = client
-	return Client
-}
-
-// GetCollection gets the MongoDB collection
-func GetCollection(collName string) *mongo.Collection {
-	if Client == nil {
-		log.Fatal("MongoDB client is not initialized")
-	}
-	return Client.Database("Logi-Craft").Collection(collName)
-}
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var Client *mongo.Client
+
+func ConnectDB(uri string) *mongo.Client {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientOptions := options.Client().ApplyURI(uri)
+	clientOptions.SetMaxPoolSize(100)
+	clientOptions.SetMinPoolSize(10)
+	clientOptions.SetMaxConnIdleTime(5 * time.Minute)
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Check the connection
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Connected to MongoDB!")
+	Client = client
+	return Client
+}
+
+// GetCollection gets the MongoDB collection
+func GetCollection(collName string) *mongo.Collection {
+	if Client == nil {
+		log.Fatal("MongoDB client is not initialized")
+	}
+	return Client.Database("Logi-Craft").Collection(collName)
+}
+
+// NextSequence atomically increments and returns the named counter
+func NextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := GetCollection("counters").FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		opts).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LeaseTime is how long a lease lasts without being renewed
+const LeaseTime = 2 * time.Minute
+
+// NewLease returns a lease for work this server is starting
+func NewLease() *models.Lease {
+	return &models.Lease{Owner: primitive.NewObjectID(), Until: time.Now().Add(LeaseTime)}
+}
+
+// KeepLease renews a lease on a document until the returned function is called.
+// If another server has taken the document over the lease is no longer renewed.
+func KeepLease(collection string, id primitive.ObjectID, lease *models.Lease) (release func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(LeaseTime / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			result, err := GetCollection(collection).UpdateOne(ctx,
+				bson.M{"_id": id, "lease.owner": lease.Owner},
+				bson.M{"$set": bson.M{"lease.until": time.Now().Add(LeaseTime)}})
+			cancel()
+			if err != nil {
+				log.Printf("Renewing lease on %s %s failed: %v", collection, id.Hex(), err)
+			} else if result.MatchedCount == 0 {
+				log.Printf("Lease on %s %s was lost", collection, id.Hex())
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// TakeOver gives this server a new lease on one document matching filter
+// whose lease has run out, or that never had one, and decodes the document
+// into v. It returns mongo.ErrNoDocuments when there is nothing to take over.
+func TakeOver(ctx context.Context, collection string, filter bson.M, v interface{}) error {
+	lapsed := bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+		bson.M{"lease": bson.M{"$exists": false}},
+		bson.M{"lease.until": bson.M{"$lt": time.Now()}},
+	}}}}
+	return GetCollection(collection).FindOneAndUpdate(ctx, lapsed,
+		bson.M{"$set": bson.M{"lease": NewLease()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(v)
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNoTransactions is returned by Transaction when the database cannot run
+// transactions, as they need a replica set. Callers then make their writes
+// one at a time and undo them on failure.
+var ErrNoTransactions = errors.New("transactions unavailable")
+
+// illegalOperation is the server error code for transactions on a standalone server
+const illegalOperation = 20
+
+var noTransactionsOnce sync.Once
+
+// Transaction runs fn in a transaction and returns its result
+func Transaction(ctx context.Context, fn func(sc mongo.SessionContext) (interface{}, error)) (interface{}, error) {
+	session, err := Client.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, fn)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(illegalOperation) {
+		noTransactionsOnce.Do(func() {
+			log.Printf("Transactions unavailable (%v); writing without them", err)
+		})
+		return nil, ErrNoTransactions
+	}
+	return result, err
+}
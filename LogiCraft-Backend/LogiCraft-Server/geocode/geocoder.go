@@ -0,0 +1,46 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"logi-craft/config"
+	"logi-craft/models"
+)
+
+var (
+	ErrNotConfigured = errors.New("address lookup is not configured")
+	ErrNotFound      = errors.New("address not found")
+)
+
+// Geocoder is implemented by each address lookup service.
+type Geocoder interface {
+	// Lookup returns the coordinates of a free-form address.
+	Lookup(ctx context.Context, address string) (models.Coordinates, error)
+}
+
+// Default is the geocoder used by the server, set up by Setup. It is nil when
+// no provider is configured.
+var Default Geocoder
+
+// Setup selects the geocoder from the config.
+func Setup(cfg config.GeocodingConfig) error {
+	switch cfg.Provider {
+	case "":
+		Default = nil
+	case "nominatim":
+		Default = NewNominatim(cfg.URL, cfg.UserAgent)
+	default:
+		return fmt.Errorf("unknown geocoding provider %q", cfg.Provider)
+	}
+	return nil
+}
+
+// Lookup resolves an address with the default geocoder.
+func Lookup(ctx context.Context, address string) (models.Coordinates, error) {
+	if Default == nil {
+		return models.Coordinates{}, ErrNotConfigured
+	}
+	return Default.Lookup(ctx, address)
+}
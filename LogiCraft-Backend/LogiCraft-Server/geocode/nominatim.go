@@ -0,0 +1,69 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"logi-craft/models"
+)
+
+// Nominatim looks addresses up with an OpenStreetMap Nominatim server.
+type Nominatim struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+}
+
+func NewNominatim(baseURL, userAgent string) *Nominatim {
+	return &Nominatim{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *Nominatim) Lookup(ctx context.Context, address string) (models.Coordinates, error) {
+	query := url.Values{"q": {address}, "format": {"json"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.baseURL+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return models.Coordinates{}, err
+	}
+	// Nominatim's usage policy requires an identifying user agent
+	req.Header.Set("User-Agent", n.userAgent)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return models.Coordinates{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return models.Coordinates{}, fmt.Errorf("nominatim returned %s", resp.Status)
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return models.Coordinates{}, err
+	}
+	if len(results) == 0 {
+		return models.Coordinates{}, ErrNotFound
+	}
+
+	lat, err := strconv.ParseFloat(results[0].Lat, 64)
+	if err != nil {
+		return models.Coordinates{}, err
+	}
+	lng, err := strconv.ParseFloat(results[0].Lon, 64)
+	if err != nil {
+		return models.Coordinates{}, err
+	}
+	return models.Coordinates{Latitude: lat, Longitude: lng}, nil
+}
@@ -0,0 +1,120 @@
+package geoindex
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// retryInterval is how long Start waits before reloading after the change feed fails.
+const retryInterval = 5 * time.Second
+
+// Load fills the index with every vehicle in the database.
+func (ix *Index) Load(ctx context.Context) error {
+	_, err := ix.load(ctx)
+	return err
+}
+
+// load fills the index and returns when the newest vehicle last changed
+func (ix *Index) load(ctx context.Context) (time.Time, error) {
+	vehicles, since, err := db.ChangedVehicles(ctx, time.Time{})
+	if err != nil {
+		return since, err
+	}
+	ix.Replace(vehicles)
+	return since, nil
+}
+
+// loadChanged puts the vehicles changed since the given time into the index
+// and returns when the newest of them changed
+func (ix *Index) loadChanged(ctx context.Context, since time.Time) (time.Time, error) {
+	vehicles, since, err := db.ChangedVehicles(ctx, since)
+	if err != nil {
+		return since, err
+	}
+	for _, v := range vehicles {
+		ix.Put(v)
+	}
+	return since, nil
+}
+
+// Follow loads the index and then applies every change made to the vehicles
+// collection, by this instance or any other, until the change stream fails.
+// The stream is opened before loading so no change made in between is lost.
+// Without change streams it polls for changed vehicles instead.
+func (ix *Index) Follow(ctx context.Context) error {
+	stream, err := db.Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: bson.M{"ns.coll": "vehicles"}}}})
+	if err != nil {
+		return ix.poll(ctx)
+	}
+	defer stream.Close(context.Background())
+
+	loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err = ix.Load(loadCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for stream.Next(ctx) {
+		var event db.Change
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		switch event.OperationType {
+		case "insert", "update", "replace":
+			if event.FullDocument == nil {
+				// Deleted again before the update could be looked up
+				ix.Remove(event.DocumentKey.ID)
+				continue
+			}
+			var vehicle models.Vehicle
+			if err := bson.Unmarshal(event.FullDocument, &vehicle); err != nil {
+				return err
+			}
+			ix.Put(vehicle)
+		case "delete":
+			ix.Remove(event.DocumentKey.ID)
+		}
+	}
+	return stream.Err()
+}
+
+// poll loads the index and then puts every vehicle changed since the last poll
+// into it until a poll fails. Vehicles are never deleted, so only changes need
+// following.
+func (ix *Index) poll(ctx context.Context) error {
+	loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	since, err := ix.load(loadCtx)
+	cancel()
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(db.PollInterval):
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		since, err = ix.loadChanged(pollCtx, since)
+		cancel()
+	}
+	return err
+}
+
+// Start keeps the default index in step with the database in the background.
+// Whenever the change feed stops, the index is reloaded and followed again.
+func Start() {
+	go func() {
+		for {
+			if err := Default.Follow(context.Background()); err != nil {
+				log.Printf("Vehicle index feed failed: %v", err)
+			}
+			time.Sleep(retryInterval)
+		}
+	}()
+}
@@ -0,0 +1,288 @@
+// Package geoindex keeps an in-memory spatial index of vehicle positions so
+// the nearest free vehicles can be found without querying the database.
+package geoindex
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultCellSize is the side of a grid cell in degrees, roughly 2 km.
+const DefaultCellSize = 0.02
+
+// kmPerDegree is the length of a degree of latitude.
+const kmPerDegree = 111.195
+
+// Entry is what the index knows about a vehicle.
+type Entry struct {
+	ID          primitive.ObjectID
+	VehicleNo   string
+	VehicleType string
+	Position    models.Coordinates
+	Busy        bool
+	Online      bool // the vehicle's driver is online
+}
+
+// available reports whether the vehicle can be dispatched, and so belongs in the grid.
+func (e *Entry) available() bool {
+	return e.Online && !e.Busy
+}
+
+// Match is a vehicle returned by Nearest with its distance in km.
+type Match struct {
+	Entry
+	Distance float64
+}
+
+type cellKey struct {
+	vehicleType string
+	x, y        int
+}
+
+// Index is a grid of free vehicles per vehicle type. Busy vehicles and those
+// whose driver is offline are remembered but kept out of the grid until they
+// can be dispatched again.
+// It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	cellSize float64
+	ready    bool
+	entries  map[primitive.ObjectID]*Entry
+	numbers  map[string]primitive.ObjectID
+	cells    map[cellKey]map[primitive.ObjectID]*Entry
+	free     map[string]int // free vehicles per type
+}
+
+// New returns an empty index with cells of the given size in degrees.
+func New(cellSize float64) *Index {
+	return &Index{
+		cellSize: cellSize,
+		entries:  map[primitive.ObjectID]*Entry{},
+		numbers:  map[string]primitive.ObjectID{},
+		cells:    map[cellKey]map[primitive.ObjectID]*Entry{},
+		free:     map[string]int{},
+	}
+}
+
+// Default is the index used by the server, kept up to date by Start.
+var Default = New(DefaultCellSize)
+
+// Ready reports whether the index has been loaded and can be searched.
+func (ix *Index) Ready() bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.ready
+}
+
+// Len returns the number of vehicles in the index, busy or free.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.entries)
+}
+
+func (ix *Index) cellOf(vehicleType string, p models.Coordinates) cellKey {
+	return cellKey{
+		vehicleType: vehicleType,
+		x:           int(math.Floor(p.Longitude / ix.cellSize)),
+		y:           int(math.Floor(p.Latitude / ix.cellSize)),
+	}
+}
+
+// Put adds a vehicle or updates its position, type, busy flag and presence.
+func (ix *Index) Put(v models.Vehicle) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.put(v)
+}
+
+// Replace swaps the whole contents of the index for the given vehicles and
+// marks it ready.
+func (ix *Index) Replace(vehicles []models.Vehicle) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.entries = map[primitive.ObjectID]*Entry{}
+	ix.numbers = map[string]primitive.ObjectID{}
+	ix.cells = map[cellKey]map[primitive.ObjectID]*Entry{}
+	ix.free = map[string]int{}
+	for _, v := range vehicles {
+		ix.put(v)
+	}
+	ix.ready = true
+}
+
+func (ix *Index) put(v models.Vehicle) {
+	ix.remove(v.ID)
+	e := &Entry{ID: v.ID, VehicleNo: v.VehicleNo, VehicleType: v.VehicleType, Position: v.Coordinates, Busy: v.Busy, Online: v.Online}
+	ix.entries[v.ID] = e
+	ix.numbers[v.VehicleNo] = v.ID
+	if e.available() {
+		ix.link(e)
+	}
+}
+
+// SetBusy marks a vehicle busy or free without changing its position. It does
+// nothing if the vehicle is not in the index.
+func (ix *Index) SetBusy(vehicleNo string, busy bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	e, ok := ix.entries[ix.numbers[vehicleNo]]
+	if !ok || e.Busy == busy {
+		return
+	}
+	ix.update(e, func() { e.Busy = busy })
+}
+
+// SetOnline marks the driver of a vehicle online or offline. It does nothing
+// if the vehicle is not in the index.
+func (ix *Index) SetOnline(vehicleNo string, online bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	e, ok := ix.entries[ix.numbers[vehicleNo]]
+	if !ok || e.Online == online {
+		return
+	}
+	ix.update(e, func() { e.Online = online })
+}
+
+// update applies change to an entry, moving it into or out of the grid if
+// that changes whether it can be dispatched
+func (ix *Index) update(e *Entry, change func()) {
+	was := e.available()
+	change()
+	switch {
+	case was && !e.available():
+		ix.unlink(e)
+	case !was && e.available():
+		ix.link(e)
+	}
+}
+
+// Remove drops a vehicle from the index.
+func (ix *Index) Remove(id primitive.ObjectID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id primitive.ObjectID) {
+	e, ok := ix.entries[id]
+	if !ok {
+		return
+	}
+	if e.available() {
+		ix.unlink(e)
+	}
+	delete(ix.entries, id)
+	if ix.numbers[e.VehicleNo] == id {
+		delete(ix.numbers, e.VehicleNo)
+	}
+}
+
+func (ix *Index) link(e *Entry) {
+	key := ix.cellOf(e.VehicleType, e.Position)
+	cell := ix.cells[key]
+	if cell == nil {
+		cell = map[primitive.ObjectID]*Entry{}
+		ix.cells[key] = cell
+	}
+	cell[e.ID] = e
+	ix.free[e.VehicleType]++
+}
+
+func (ix *Index) unlink(e *Entry) {
+	key := ix.cellOf(e.VehicleType, e.Position)
+	delete(ix.cells[key], e.ID)
+	ix.free[e.VehicleType]--
+	if len(ix.cells[key]) == 0 {
+		delete(ix.cells, key)
+	}
+}
+
+// Nearest returns up to k free vehicles with online drivers of a type within maxKm of a point,
+// nearest first. It searches rings of cells outwards from the point and stops
+// once no unsearched cell can hold anything closer than what it has found.
+func (ix *Index) Nearest(vehicleType string, point models.Coordinates, k int, maxKm float64) []Match {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if k <= 0 {
+		return nil
+	}
+	centre := ix.cellOf(vehicleType, point)
+	maxRing := int(180/ix.cellSize) + 1
+	seen := 0
+
+	// found holds the k nearest so far, nearest first
+	found := make([]Match, 0, k)
+	worst := func() float64 {
+		if len(found) < k {
+			if maxKm > 0 {
+				return maxKm
+			}
+			return math.Inf(1)
+		}
+		return found[len(found)-1].Distance
+	}
+	search := func(dx, dy int) {
+		key := cellKey{vehicleType: vehicleType, x: centre.x + dx, y: centre.y + dy}
+		for _, e := range ix.cells[key] {
+			seen++
+			// The difference in latitude alone rules most vehicles out cheaply
+			limit := worst()
+			if math.Abs(e.Position.Latitude-point.Latitude)*kmPerDegree > limit {
+				continue
+			}
+			d := utils.HaversineDistance(point.Latitude, point.Longitude, e.Position.Latitude, e.Position.Longitude)
+			if d > limit || (len(found) == k && d == limit) {
+				continue
+			}
+			i := sort.Search(len(found), func(i int) bool { return found[i].Distance > d })
+			if len(found) < k {
+				found = append(found, Match{})
+			}
+			copy(found[i+1:], found[i:])
+			found[i] = Match{Entry: *e, Distance: d}
+		}
+	}
+
+	for r := 0; r <= maxRing && seen < ix.free[vehicleType]; r++ {
+		// Visit only the cells on the edge of ring r; those inside were searched already
+		if r == 0 {
+			search(0, 0)
+		}
+		for d := -r; d <= r && r > 0; d++ {
+			search(d, -r)
+			search(d, r)
+			if d != -r && d != r {
+				search(-r, d)
+				search(r, d)
+			}
+		}
+
+		// Every cell outside ring r is at least this far from the point
+		bound := ix.ringBound(point, r)
+		if maxKm > 0 && bound > maxKm {
+			break
+		}
+		if len(found) == k && found[k-1].Distance <= bound {
+			break
+		}
+	}
+	return found
+}
+
+// ringBound is a lower bound in km on the distance from point to any cell
+// outside the first r rings around its own cell. A degree of longitude is
+// shortest at the latitude furthest from the equator those cells reach.
+func (ix *Index) ringBound(point models.Coordinates, r int) float64 {
+	edge := float64(r) * ix.cellSize
+	lat := math.Min(math.Abs(point.Latitude)+edge+ix.cellSize, 90)
+	return edge * kmPerDegree * math.Cos(lat*math.Pi/180)
+}
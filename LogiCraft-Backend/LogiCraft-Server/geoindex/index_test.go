@@ -0,0 +1,235 @@
+package geoindex
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var vehicleTypes = []string{"small", "medium", "large"}
+
+// centre is the middle of the area test vehicles are spread over
+var centre = models.Coordinates{Latitude: 28.612894, Longitude: 77.216721}
+
+// offset returns a point the given km north and east of centre
+func offset(northKm, eastKm float64) models.Coordinates {
+	return models.Coordinates{
+		Latitude:  centre.Latitude + northKm/kmPerDegree,
+		Longitude: centre.Longitude + eastKm/(kmPerDegree*math.Cos(centre.Latitude*math.Pi/180)),
+	}
+}
+
+func vehicle(no, vehicleType string, at models.Coordinates, busy, online bool) models.Vehicle {
+	return models.Vehicle{ID: primitive.NewObjectID(), VehicleNo: no, VehicleType: vehicleType, Coordinates: at, Busy: busy, Online: online}
+}
+
+// fleet spreads n vehicles at random over spread degrees either side of centre
+func fleet(rng *rand.Rand, n int, spread float64) []models.Vehicle {
+	vehicles := make([]models.Vehicle, n)
+	for i := range vehicles {
+		at := models.Coordinates{
+			Latitude:  centre.Latitude + (rng.Float64()*2-1)*spread,
+			Longitude: centre.Longitude + (rng.Float64()*2-1)*spread,
+		}
+		vehicles[i] = vehicle(fmt.Sprintf("TEST-%05d", i), vehicleTypes[rng.Intn(len(vehicleTypes))], at, rng.Float64() < 0.3, rng.Float64() < 0.9)
+	}
+	return vehicles
+}
+
+// linearScan measures the distance to every free vehicle of a type with an
+// online driver and keeps the k nearest within maxKm
+func linearScan(vehicles []models.Vehicle, vehicleType string, point models.Coordinates, k int, maxKm float64) []Match {
+	var found []Match
+	for _, v := range vehicles {
+		if v.VehicleType != vehicleType || v.Busy || !v.Online {
+			continue
+		}
+		d := utils.HaversineDistance(point.Latitude, point.Longitude, v.Coordinates.Latitude, v.Coordinates.Longitude)
+		if maxKm <= 0 || d <= maxKm {
+			found = append(found, Match{Entry: Entry{ID: v.ID, VehicleNo: v.VehicleNo}, Distance: d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Distance < found[j].Distance })
+	if len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+func numbers(matches []Match) []string {
+	nos := make([]string, len(matches))
+	for i, m := range matches {
+		nos[i] = m.VehicleNo
+	}
+	return nos
+}
+
+func TestNearest(t *testing.T) {
+	vehicles := []models.Vehicle{
+		vehicle("NEAR", "small", offset(0.5, 0), false, true),
+		vehicle("MID", "small", offset(0, 3), false, true),
+		vehicle("FAR", "small", offset(-8, 0), false, true),
+		vehicle("OUTSIDE", "small", offset(30, 30), false, true),
+		vehicle("BUSY", "small", offset(0.1, 0), true, true),
+		vehicle("OFFLINE", "small", offset(0, 0.1), false, false),
+		vehicle("LARGE", "large", offset(0.2, 0.2), false, true),
+	}
+	ix := New(DefaultCellSize)
+	ix.Replace(vehicles)
+
+	tests := []struct {
+		name        string
+		vehicleType string
+		k           int
+		maxKm       float64
+		want        []string
+	}{
+		{"nearest first", "small", 10, 25, []string{"NEAR", "MID", "FAR"}},
+		{"k limits the result", "small", 2, 25, []string{"NEAR", "MID"}},
+		{"radius limits the result", "small", 10, 5, []string{"NEAR", "MID"}},
+		{"no radius", "small", 10, 0, []string{"NEAR", "MID", "FAR", "OUTSIDE"}},
+		{"other type", "large", 10, 25, []string{"LARGE"}},
+		{"no vehicles of type", "medium", 10, 25, []string{}},
+		{"k of zero", "small", 0, 25, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := numbers(ix.Nearest(tt.vehicleType, centre, tt.k, tt.maxKm))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Nearest(%s, k=%d, %.0f km) = %v, want %v", tt.vehicleType, tt.k, tt.maxKm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearestMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vehicles := fleet(rng, 5000, 0.3)
+	ix := New(DefaultCellSize)
+	ix.Replace(vehicles)
+
+	for _, k := range []int{1, 10, 50} {
+		for _, maxKm := range []float64{2, 25, 0} {
+			for i := 0; i < 50; i++ {
+				point := models.Coordinates{
+					Latitude:  centre.Latitude + (rng.Float64()*2-1)*0.4,
+					Longitude: centre.Longitude + (rng.Float64()*2-1)*0.4,
+				}
+				vehicleType := vehicleTypes[i%len(vehicleTypes)]
+				want := linearScan(vehicles, vehicleType, point, k, maxKm)
+				got := ix.Nearest(vehicleType, point, k, maxKm)
+				if len(got) != len(want) {
+					t.Fatalf("k=%d, %.0f km, point %v: index found %d vehicles, scan found %d", k, maxKm, point, len(got), len(want))
+				}
+				for j := range want {
+					if math.Abs(got[j].Distance-want[j].Distance) > 1e-9 {
+						t.Fatalf("k=%d, %.0f km, point %v, result %d: index %s at %f km, scan %s at %f km",
+							k, maxKm, point, j, got[j].VehicleNo, got[j].Distance, want[j].VehicleNo, want[j].Distance)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSetBusy(t *testing.T) {
+	ix := New(DefaultCellSize)
+	ix.Replace([]models.Vehicle{
+		vehicle("A", "small", offset(1, 0), false, true),
+		vehicle("B", "small", offset(2, 0), false, true),
+	})
+
+	steps := []struct {
+		vehicleNo string
+		busy      bool
+		want      []string
+	}{
+		{"A", true, []string{"B"}},
+		{"A", true, []string{"B"}}, // marking it busy twice changes nothing
+		{"B", true, []string{}},
+		{"A", false, []string{"A"}},
+		{"B", false, []string{"A", "B"}},
+		{"UNKNOWN", true, []string{"A", "B"}},
+	}
+	for i, s := range steps {
+		ix.SetBusy(s.vehicleNo, s.busy)
+		got := numbers(ix.Nearest("small", centre, 10, 25))
+		if fmt.Sprint(got) != fmt.Sprint(s.want) {
+			t.Errorf("step %d: after SetBusy(%s, %v) Nearest = %v, want %v", i, s.vehicleNo, s.busy, got, s.want)
+		}
+	}
+	if ix.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", ix.Len())
+	}
+}
+
+func TestSetOnline(t *testing.T) {
+	ix := New(DefaultCellSize)
+	ix.Replace([]models.Vehicle{
+		vehicle("A", "small", offset(1, 0), false, false),
+		vehicle("B", "small", offset(2, 0), true, true),
+	})
+
+	steps := []struct {
+		vehicleNo string
+		online    bool
+		want      []string
+	}{
+		{"A", true, []string{"A"}},
+		{"A", true, []string{"A"}}, // going online twice changes nothing
+		{"A", false, []string{}},
+		{"B", false, []string{}},
+		{"B", true, []string{}}, // still busy
+		{"UNKNOWN", true, []string{}},
+	}
+	for i, s := range steps {
+		ix.SetOnline(s.vehicleNo, s.online)
+		got := numbers(ix.Nearest("small", centre, 10, 25))
+		if fmt.Sprint(got) != fmt.Sprint(s.want) {
+			t.Errorf("step %d: after SetOnline(%s, %v) Nearest = %v, want %v", i, s.vehicleNo, s.online, got, s.want)
+		}
+	}
+
+	// A busy vehicle whose driver comes online is found once it is freed
+	ix.SetBusy("B", false)
+	if got := numbers(ix.Nearest("small", centre, 10, 25)); fmt.Sprint(got) != "[B]" {
+		t.Errorf("after freeing B, Nearest = %v, want [B]", got)
+	}
+}
+
+// BenchmarkNearest compares the index with a linear scan over every vehicle,
+// which is how bookings were matched before the index existed
+func BenchmarkNearest(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		rng := rand.New(rand.NewSource(1))
+		vehicles := fleet(rng, n, 0.3)
+		ix := New(DefaultCellSize)
+		ix.Replace(vehicles)
+
+		points := make([]models.Coordinates, 1024)
+		for i := range points {
+			points[i] = models.Coordinates{
+				Latitude:  centre.Latitude + (rng.Float64()*2-1)*0.3,
+				Longitude: centre.Longitude + (rng.Float64()*2-1)*0.3,
+			}
+		}
+
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ix.Nearest(vehicleTypes[i%len(vehicleTypes)], points[i%len(points)], 10, 25)
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				linearScan(vehicles, vehicleTypes[i%len(vehicleTypes)], points[i%len(points)], 10, 25)
+			}
+		})
+	}
+}
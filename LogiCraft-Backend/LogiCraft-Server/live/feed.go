@@ -0,0 +1,157 @@
+package live
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// poller remembers what the last poll saw, so only changes are published
+type poller struct {
+	since    time.Time // when the newest vehicle seen last changed
+	vehicles map[string]models.Vehicle
+	bookings map[primitive.ObjectID]models.Booking
+}
+
+// Follow publishes every change made to vehicles and bookings, by this
+// instance or any other, until the change stream fails. Without change streams
+// it polls once instead.
+func (h *Hub) Follow(ctx context.Context) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"ns.coll":       bson.M{"$in": bson.A{"vehicles", "bookings"}},
+		"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+	}}}}
+	stream, err := db.Watch(ctx, pipeline)
+	if err != nil {
+		pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		return h.poll(pollCtx)
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event db.Change
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		// Skip changes while no one is subscribed, and documents deleted before the update could be looked up
+		if event.FullDocument == nil || h.Len() == 0 {
+			continue
+		}
+		switch event.Namespace.Coll {
+		case "vehicles":
+			var vehicle models.Vehicle
+			if err := bson.Unmarshal(event.FullDocument, &vehicle); err != nil {
+				return err
+			}
+			h.PublishVehicle(vehicle)
+		case "bookings":
+			var booking models.Booking
+			if err := bson.Unmarshal(event.FullDocument, &booking); err != nil {
+				return err
+			}
+			h.PublishBooking(booking)
+		}
+	}
+	return stream.Err()
+}
+
+// poll publishes the vehicles and followed bookings that changed since the
+// last poll. Nothing is read while no one is subscribed, and subscribers are
+// sent the current state when they subscribe, so the first poll only finds
+// out where to start from.
+func (h *Hub) poll(ctx context.Context) error {
+	if h.Len() == 0 {
+		h.polled = poller{}
+		return nil
+	}
+
+	vehicles := db.GetCollection("vehicles")
+	if h.polled.vehicles == nil {
+		var latest models.Vehicle
+		err := vehicles.FindOne(ctx, bson.M{"updated_at": bson.M{"$exists": true}},
+			options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if latest.UpdatedAt != nil {
+			h.polled.since = *latest.UpdatedAt
+		}
+		h.polled.vehicles = make(map[string]models.Vehicle)
+	} else {
+		changed, since, err := db.ChangedVehicles(ctx, h.polled.since)
+		if err != nil {
+			return err
+		}
+		for _, v := range changed {
+			if last, ok := h.polled.vehicles[v.VehicleNo]; !ok || vehicleChanged(last, v) {
+				h.PublishVehicle(v)
+			}
+			h.polled.vehicles[v.VehicleNo] = v
+		}
+		h.polled.since = since
+	}
+
+	ids := h.Bookings()
+	if len(ids) == 0 {
+		h.polled.bookings = nil
+		return nil
+	}
+	cursor, err := db.GetCollection("bookings").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return err
+	}
+	followed := make(map[primitive.ObjectID]models.Booking, len(bookings))
+	for _, b := range bookings {
+		followed[b.ID] = b
+		if last, ok := h.polled.bookings[b.ID]; !ok || bookingChanged(last, b) {
+			h.PublishBooking(b)
+		}
+	}
+	h.polled.bookings = followed
+	return nil
+}
+
+func vehicleChanged(a, b models.Vehicle) bool {
+	return a.Coordinates != b.Coordinates || a.Busy != b.Busy || a.Online != b.Online ||
+		a.VehicleType != b.VehicleType || !sameTime(a.LocationAt, b.LocationAt)
+}
+
+func bookingChanged(a, b models.Booking) bool {
+	if a.JobStatus != b.JobStatus || a.VehicleNo != b.VehicleNo || (a.ETA == nil) != (b.ETA == nil) {
+		return true
+	}
+	return a.ETA != nil && !a.ETA.UpdatedAt.Equal(b.ETA.UpdatedAt)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// Start keeps the default hub fed with changes in the background. Whenever the
+// change feed stops, it is followed again.
+func Start() {
+	go func() {
+		for {
+			if err := Default.Follow(context.Background()); err != nil {
+				log.Printf("Live update feed failed: %v", err)
+			}
+			time.Sleep(db.PollInterval)
+		}
+	}()
+}
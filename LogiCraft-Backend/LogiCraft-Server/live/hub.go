@@ -0,0 +1,271 @@
+// Package live pushes vehicle positions and booking status changes to the
+// clients watching them, whichever server instance the change was made on.
+package live
+
+import (
+	"sync"
+	"time"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bufferSize is how many updates a subscriber may fall behind before it is dropped
+const bufferSize = 64
+
+// Kinds of update
+const (
+	KindPosition = "position" // a vehicle moved or its busy or online flag changed
+	KindStatus   = "status"   // a booking changed status, vehicle or ETA
+)
+
+// VehicleUpdate is where a vehicle is and whether it can be dispatched
+type VehicleUpdate struct {
+	VehicleNo   string             `json:"vehicle_no"`
+	VehicleType string             `json:"vehicle_type"`
+	Coordinates models.Coordinates `json:"coordinates"`
+	LocationAt  *time.Time         `json:"location_at,omitempty"`
+	Busy        bool               `json:"busy"`
+	Online      bool               `json:"online"`
+}
+
+// BookingUpdate is the state of a booking a client is following
+type BookingUpdate struct {
+	BookingID string             `json:"booking_id"`
+	VehicleNo string             `json:"vehicle_no,omitempty"`
+	JobStatus string             `json:"job_status"`
+	ETA       *models.BookingETA `json:"eta,omitempty"`
+}
+
+// Update is one message sent to a subscriber
+type Update struct {
+	Kind    string
+	Vehicle *VehicleUpdate
+	Booking *BookingUpdate
+}
+
+// Data returns the payload of the update
+func (u Update) Data() interface{} {
+	if u.Kind == KindStatus {
+		return u.Booking
+	}
+	return u.Vehicle
+}
+
+// Box is a map area, bounded by latitude and longitude
+type Box struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// Contains reports whether a point lies in the box
+func (b Box) Contains(c models.Coordinates) bool {
+	return c.Latitude >= b.MinLat && c.Latitude <= b.MaxLat &&
+		c.Longitude >= b.MinLng && c.Longitude <= b.MaxLng
+}
+
+// Filter picks the updates a subscriber gets: those of one vehicle, of one
+// booking and the vehicle serving it, or of every vehicle in a map area
+type Filter struct {
+	VehicleNo string
+	BookingID primitive.ObjectID
+	Box       *Box
+	// Until ends a subscription to a vehicle once the vehicle is no longer
+	// carrying this booking, for customers who may only follow the vehicle
+	// serving them
+	Until primitive.ObjectID
+}
+
+// servedBy returns the vehicle a booking's followers may follow, which is
+// only set while the booking is in transit
+func servedBy(b models.Booking) string {
+	if b.JobStatus != "in-transit" {
+		return ""
+	}
+	return b.VehicleNo
+}
+
+// PositionOf returns the update sent for a vehicle
+func PositionOf(v models.Vehicle) Update {
+	return Update{Kind: KindPosition, Vehicle: &VehicleUpdate{
+		VehicleNo:   v.VehicleNo,
+		VehicleType: v.VehicleType,
+		Coordinates: v.Coordinates,
+		LocationAt:  v.LocationAt,
+		Busy:        v.Busy,
+		Online:      v.Online,
+	}}
+}
+
+// StatusOf returns the update sent for a booking
+func StatusOf(b models.Booking) Update {
+	return Update{Kind: KindStatus, Booking: &BookingUpdate{
+		BookingID: b.ID.Hex(),
+		VehicleNo: b.VehicleNo,
+		JobStatus: b.JobStatus,
+		ETA:       b.ETA,
+	}}
+}
+
+// Subscription receives the updates matching its filter until it is
+// unsubscribed, or dropped for not keeping up
+type Subscription struct {
+	filter    Filter
+	vehicleNo string          // the vehicle followed, which for a booking is whichever serves it
+	inBox     map[string]bool // vehicles last seen in the box, so leaving it is sent too
+	updates   chan Update
+	closed    bool
+}
+
+// Updates returns the channel updates arrive on. It is closed when the
+// subscriber falls too far behind, or its Until booking leaves the vehicle; it
+// should then reconnect, which checks again what it may follow.
+func (s *Subscription) Updates() <-chan Update {
+	return s.updates
+}
+
+// Hub hands out updates to subscribers. It is safe for concurrent use.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	polled poller // only used by the goroutine following the feed
+}
+
+// NewHub returns a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+// Default is the hub used by the server, fed by Start
+var Default = NewHub()
+
+// Subscribe starts sending the updates matching a filter
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{
+		filter:    f,
+		vehicleNo: f.VehicleNo,
+		inBox:     map[string]bool{},
+		updates:   make(chan Update, bufferSize),
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Prime queues the current state of what a subscriber follows, loaded after
+// it subscribed so no change in between is missed. A booking subscription
+// follows the vehicle serving the booking from then on, while it is in transit.
+func (h *Hub) Prime(s *Subscription, vehicles []models.Vehicle, booking *models.Booking) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if booking != nil {
+		s.vehicleNo = servedBy(*booking)
+		h.send(s, StatusOf(*booking))
+	}
+	for _, v := range vehicles {
+		if s.filter.Box != nil && s.filter.Box.Contains(v.Coordinates) {
+			s.inBox[v.VehicleNo] = true
+		}
+		h.send(s, PositionOf(v))
+	}
+}
+
+// Unsubscribe stops sending updates to a subscriber
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, s)
+	s.close()
+}
+
+// Len returns the number of subscribers
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Bookings returns the bookings subscribers are following or whose vehicle
+// they may follow until the booking leaves it
+func (h *Hub) Bookings() []primitive.ObjectID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := map[primitive.ObjectID]bool{}
+	var ids []primitive.ObjectID
+	for s := range h.subs {
+		for _, id := range []primitive.ObjectID{s.filter.BookingID, s.filter.Until} {
+			if !id.IsZero() && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// PublishVehicle sends a vehicle's state to the subscribers following it or
+// whose box it is in or has just left
+func (h *Hub) PublishVehicle(v models.Vehicle) {
+	update := PositionOf(v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.vehicleNo != "" && s.vehicleNo == v.VehicleNo {
+			h.send(s, update)
+			continue
+		}
+		if s.filter.Box == nil {
+			continue
+		}
+		if s.filter.Box.Contains(v.Coordinates) {
+			s.inBox[v.VehicleNo] = true
+			h.send(s, update)
+		} else if s.inBox[v.VehicleNo] {
+			delete(s.inBox, v.VehicleNo)
+			h.send(s, update)
+		}
+	}
+}
+
+// PublishBooking sends a booking's state to the subscribers following it, who
+// from then on follow the vehicle now serving it, if it is in transit. Vehicle
+// subscriptions that end with the booking are dropped once it leaves their vehicle.
+func (h *Hub) PublishBooking(b models.Booking) {
+	update := StatusOf(b)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.filter.Until == b.ID && servedBy(b) != s.filter.VehicleNo {
+			delete(h.subs, s)
+			s.close()
+			continue
+		}
+		if s.filter.BookingID != b.ID {
+			continue
+		}
+		s.vehicleNo = servedBy(b)
+		h.send(s, update)
+	}
+}
+
+// send queues an update without blocking. A subscriber whose queue is full is
+// dropped rather than holding up everyone else.
+func (h *Hub) send(s *Subscription, u Update) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.updates <- u:
+	default:
+		delete(h.subs, s)
+		s.close()
+	}
+}
+
+func (s *Subscription) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.updates)
+	}
+}
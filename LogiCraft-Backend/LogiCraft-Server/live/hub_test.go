@@ -0,0 +1,82 @@
+package live
+
+import (
+	"testing"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// drain returns the updates queued for a subscriber and whether it is still open
+func drain(s *Subscription) ([]Update, bool) {
+	var got []Update
+	for {
+		select {
+		case u, ok := <-s.Updates():
+			if !ok {
+				return got, false
+			}
+			got = append(got, u)
+		default:
+			return got, true
+		}
+	}
+}
+
+func TestPublishBookingFollowsVehicleInTransit(t *testing.T) {
+	h := NewHub()
+	b := models.Booking{ID: primitive.NewObjectID(), VehicleNo: "V1", JobStatus: "in-transit"}
+	s := h.Subscribe(Filter{BookingID: b.ID})
+	h.Prime(s, nil, &b)
+
+	h.PublishVehicle(models.Vehicle{VehicleNo: "V1"})
+	if got, _ := drain(s); len(got) != 2 {
+		t.Fatalf("got %d updates while in transit, want the status and the position", len(got))
+	}
+
+	b.JobStatus = "completed"
+	h.PublishBooking(b)
+	h.PublishVehicle(models.Vehicle{VehicleNo: "V1"})
+	got, open := drain(s)
+	if !open || len(got) != 1 || got[0].Kind != KindStatus {
+		t.Errorf("after completion got %d updates (open %v), want only the status", len(got), open)
+	}
+}
+
+func TestPublishBookingEndsVehicleSubscription(t *testing.T) {
+	tests := []struct {
+		name      string
+		vehicleNo string
+		status    string
+		open      bool
+	}{
+		{"still carried", "V1", "in-transit", true},
+		{"completed", "V1", "completed", false},
+		{"cancelled", "V1", "cancelled", false},
+		{"moved to another vehicle", "V2", "in-transit", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			id := primitive.NewObjectID()
+			s := h.Subscribe(Filter{VehicleNo: "V1", Until: id})
+			other := h.Subscribe(Filter{VehicleNo: "V1"})
+
+			h.PublishBooking(models.Booking{ID: id, VehicleNo: tt.vehicleNo, JobStatus: tt.status})
+			if _, open := drain(s); open != tt.open {
+				t.Errorf("subscription open = %v, want %v", open, tt.open)
+			}
+			if _, open := drain(other); !open {
+				t.Error("subscription without Until was dropped")
+			}
+			want := 0
+			if tt.open {
+				want = 1 // the poller keeps checking the booking
+			}
+			if got := len(h.Bookings()); got != want {
+				t.Errorf("Bookings() has %d bookings, want %d", got, want)
+			}
+		})
+	}
+}
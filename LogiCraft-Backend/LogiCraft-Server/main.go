@@ -1,68 +1,246 @@
-package main
-
-import (
-	"fmt"
-	"logi-craft/db"
-	"logi-craft/routes"
-	analytics "logi-craft/routes/Analytics"
-	authentication "logi-craft/routes/Authentication"
-	booking "logi-craft/routes/Booking"
-	user "logi-craft/routes/User"
-	vehicles "logi-craft/routes/Vehicles"
-	"net/http"
-	"os"
-
-	"github.com/gorilla/mux"
-)
-
-func main() {
-	db.ConnectDB("mongodb://localhost:27017")
-
-	router := mux.NewRouter()
-	router.HandleFunc("/", routes.GetHome)
-
-	// Authentication
-	router.HandleFunc("/login", authentication.LoginHandler).Methods("POST")
-	router.HandleFunc("/signup", authentication.SignupHandler).Methods("POST")
-
-	// Bookings
-	router.HandleFunc("/book", booking.HandleBooking).Methods("POST")
-	router.HandleFunc("/booking/{bookingId}", booking.GetBookingByID).Methods("GET")
-	router.HandleFunc("/bookings/id/user/{uid}", booking.GetBookingsByUID).Methods("GET")
-	router.HandleFunc("/bookings/id/driver/{driverId}", booking.GetBookingsByDriverID).Methods("GET")
-	router.HandleFunc("/bookings", booking.GetAllBookings).Methods("GET")
-	router.HandleFunc("/complete-job/{bookingId}", booking.CompleteJobHandler).Methods("Get")
-
-	// Analytics
-	router.HandleFunc("/analysis/bookings", analytics.GetBookingAnalysis).Methods("GET")
-	router.HandleFunc("/analysis/vehicles", analytics.GetVehicleAnalysis).Methods("GET")
-	router.HandleFunc("/analysis/drivers", analytics.GetDriverAnalysis).Methods("GET")
-
-	// Users
-	router.HandleFunc("/users/{uid}", user.GetUserInfoById).Methods("GET")
-
-	// Assignments
-	router.HandleFunc("/assignment-user/{uid}", booking.GetAssignmentByUid).Methods("GET")
-	router.HandleFunc("/assignment-vehicle/{vehicle_no}", booking.GetAssignmentByVehicleNo).Methods("GET")
-	router.HandleFunc("/assignments", booking.GetAllAssignments).Methods("GET")
-	router.HandleFunc("/assignments/{uid}/assign_vehicle", booking.AssignVehicle).Methods("PUT")
-
-	// Vehicles
-	router.HandleFunc("/vehicle/{vehicleNo}", vehicles.GetVehicleInfoById).Methods("GET")
-	router.HandleFunc("/vehicles", vehicles.GetAllVehicles).Methods("GET")
-	router.HandleFunc("/add/vehicle", vehicles.AddVehicleHandler).Methods("POST")
-	router.HandleFunc("/vehicle/update-location/{vehicle_no}", vehicles.UpdateVehicleLocation).Methods("PUT")
-	router.HandleFunc("/vehicle-coords/{vehicle_no}", vehicles.GetVehicleCoordsHandler).Methods("GET")
-
-	port := "4001"
-	if len(os.Args) > 1 {
-		port = os.Args[1]
-	}
-
-	fmt.Printf("Starting server on port %s\n", port)
-	err := http.ListenAndServe(":"+port, router)
-
-	if err != nil {
-		panic(fmt.Sprintf("Unable to start server on port %s", port))
-	}
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/geocode"
+	"logi-craft/geoindex"
+	"logi-craft/live"
+	"logi-craft/notify"
+	"logi-craft/payments"
+	"logi-craft/routes"
+	analytics "logi-craft/routes/Analytics"
+	areas "logi-craft/routes/Areas"
+	authentication "logi-craft/routes/Authentication"
+	booking "logi-craft/routes/Booking"
+	dispatch "logi-craft/routes/Dispatch"
+	earnings "logi-craft/routes/Earnings"
+	imports "logi-craft/routes/Import"
+	invoice "logi-craft/routes/Invoice"
+	planning "logi-craft/routes/Planning"
+	presence "logi-craft/routes/Presence"
+	promo "logi-craft/routes/Promo"
+	recurring "logi-craft/routes/Recurring"
+	timeline "logi-craft/routes/Timeline"
+	tracking "logi-craft/routes/Tracking"
+	user "logi-craft/routes/User"
+	vehicles "logi-craft/routes/Vehicles"
+	wallet "logi-craft/routes/Wallet"
+	"logi-craft/routing"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func main() {
+	if path := os.Getenv("LOGICRAFT_CONFIG"); path != "" {
+		if err := config.Load(path); err != nil {
+			log.Fatalf("Unable to load config %s: %v", path, err)
+		}
+	}
+
+	db.ConnectDB("mongodb://localhost:27017")
+
+	if err := payments.Setup(config.App.Payments.Gateway); err != nil {
+		log.Fatal(err)
+	}
+	if err := geocode.Setup(config.App.Geocoding); err != nil {
+		log.Fatal(err)
+	}
+	if err := notify.Setup(config.App.Notify); err != nil {
+		log.Fatal(err)
+	}
+	if err := dispatch.Setup(config.App.Dispatch); err != nil {
+		log.Fatal(err)
+	}
+	if err := routing.Setup(config.App.Routing); err != nil {
+		log.Fatal(err)
+	}
+	if err := tracking.Setup(config.App.Tracking); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := booking.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := promo.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := wallet.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := earnings.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := tracking.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := timeline.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := recurring.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := areas.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := vehicles.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := dispatch.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := presence.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := presence.Backfill(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := authentication.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if err := invoice.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
+	cancel()
+
+	if config.App.Dispatch.MemoryIndex {
+		geoindex.Start()
+	}
+	earnings.StartWeeklyPayouts()
+	booking.StartScheduledDispatch()
+	recurring.StartRecurringBookings()
+	presence.StartPresenceChecks()
+	planning.StartRecovery()
+	imports.StartRecovery()
+	live.Start()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/", routes.GetHome)
+
+	// Authentication
+	router.HandleFunc("/login", authentication.LoginHandler).Methods("POST")
+	router.HandleFunc("/signup", authentication.SignupHandler).Methods("POST")
+	router.HandleFunc("/logout", authentication.LogoutHandler).Methods("POST")
+
+	// Bookings
+	router.HandleFunc("/book", booking.HandleBooking).Methods("POST")
+	router.HandleFunc("/booking/{bookingId}", booking.GetBookingByID).Methods("GET")
+	router.HandleFunc("/bookings/id/user/{uid}", booking.GetBookingsByUID).Methods("GET")
+	router.HandleFunc("/bookings/id/driver/{driverId}", booking.GetBookingsByDriverID).Methods("GET")
+	router.HandleFunc("/bookings", booking.GetAllBookings).Methods("GET")
+	router.HandleFunc("/complete-job/{bookingId}", booking.CompleteJobHandler).Methods("Get")
+	router.HandleFunc("/quote", booking.GetQuote).Methods("POST")
+	router.HandleFunc("/booking/{bookingId}/adjust", booking.AdjustBookingFare).Methods("PUT")
+	router.HandleFunc("/booking/{bookingId}/tracking", tracking.GetBookingTracking).Methods("GET")
+	router.HandleFunc("/booking/{bookingId}/eta-history", tracking.GetETAHistory).Methods("GET")
+	router.HandleFunc("/booking/{bookingId}/path", tracking.GetBookingPath).Methods("GET")
+	router.HandleFunc("/booking/{bookingId}/share", tracking.ShareTracking).Methods("POST")
+	router.HandleFunc("/track/{token}", tracking.GetPublicTracking).Methods("GET")
+	router.HandleFunc("/booking/{bookingId}/timeline", timeline.GetBookingTimeline).Methods("GET")
+	router.HandleFunc("/booking/{bookingId}/cancel", booking.CancelBooking).Methods("PUT")
+	router.HandleFunc("/booking/{bookingId}/proof", booking.AddProofOfDelivery).Methods("POST")
+	router.HandleFunc("/booking/{bookingId}/contact", booking.RelayMessage).Methods("POST")
+	router.HandleFunc("/booking/{bookingId}/rating", booking.RateDriver).Methods("POST")
+
+	// Recurring bookings
+	router.HandleFunc("/recurring-bookings", recurring.CreateRecurringBooking).Methods("POST")
+	router.HandleFunc("/recurring-bookings/user/{uid}", recurring.GetRecurringBookingsByUID).Methods("GET")
+	router.HandleFunc("/recurring-bookings/{recurringId}", recurring.GetRecurringBooking).Methods("GET")
+	router.HandleFunc("/recurring-bookings/{recurringId}/pause", recurring.PauseRecurringBooking).Methods("PUT")
+	router.HandleFunc("/recurring-bookings/{recurringId}/resume", recurring.ResumeRecurringBooking).Methods("PUT")
+	router.HandleFunc("/recurring-bookings/{recurringId}/end", recurring.EndRecurringBooking).Methods("PUT")
+	router.HandleFunc("/recurring-bookings/{recurringId}/skip", recurring.SkipOccurrence).Methods("POST")
+
+	// Bulk imports
+	router.HandleFunc("/imports", imports.CreateImport).Methods("POST")
+	router.HandleFunc("/imports/{jobId}", imports.GetImport).Methods("GET")
+
+	// Route planning
+	router.HandleFunc("/plans", planning.CreatePlan).Methods("POST")
+	router.HandleFunc("/plans/{planId}", planning.GetPlan).Methods("GET")
+	router.HandleFunc("/plans/{planId}/commit", planning.CommitPlan).Methods("POST")
+
+	// Service areas
+	router.HandleFunc("/service-areas", areas.CreateServiceArea).Methods("POST")
+	router.HandleFunc("/service-areas", areas.GetServiceAreas).Methods("GET")
+	router.HandleFunc("/service-areas/{areaId}", areas.GetServiceArea).Methods("GET")
+	router.HandleFunc("/service-areas/{areaId}", areas.UpdateServiceArea).Methods("PUT")
+	router.HandleFunc("/service-areas/{areaId}", areas.DeleteServiceArea).Methods("DELETE")
+
+	// Wallets and payments
+	router.HandleFunc("/wallet/{uid}", wallet.GetWallet).Methods("GET")
+	router.HandleFunc("/wallet/{uid}/transactions", wallet.GetWalletTransactions).Methods("GET")
+	router.HandleFunc("/wallet/{uid}/topup", wallet.TopUpWallet).Methods("POST")
+	router.HandleFunc("/wallet/{uid}/adjust", wallet.AdjustWallet).Methods("POST")
+	router.HandleFunc("/booking/{bookingId}/refund", wallet.RefundBooking).Methods("POST")
+
+	// Driver earnings and payouts
+	router.HandleFunc("/drivers/{driverId}/earnings", earnings.GetDriverEarnings).Methods("GET")
+	router.HandleFunc("/drivers/{driverId}/earnings/adjustments", earnings.AddDriverAdjustment).Methods("POST")
+	router.HandleFunc("/drivers/{driverId}/payouts", earnings.GetDriverPayouts).Methods("GET")
+	router.HandleFunc("/payouts", earnings.GetAllPayouts).Methods("GET")
+	router.HandleFunc("/payouts/run", earnings.RunPayouts).Methods("POST")
+	router.HandleFunc("/payouts/{payoutId}", earnings.GetPayoutStatement).Methods("GET")
+	router.HandleFunc("/payouts/{payoutId}/settle", earnings.SettlePayout).Methods("PUT")
+
+	// Driver presence and shifts
+	router.HandleFunc("/drivers/{driverId}/online", presence.GoOnline).Methods("POST")
+	router.HandleFunc("/drivers/{driverId}/offline", presence.GoOffline).Methods("POST")
+	router.HandleFunc("/drivers/{driverId}/heartbeat", presence.Heartbeat).Methods("POST")
+	router.HandleFunc("/drivers/{driverId}/shifts", presence.CreateShift).Methods("POST")
+	router.HandleFunc("/drivers/{driverId}/shifts", presence.GetDriverShifts).Methods("GET")
+	router.HandleFunc("/shifts/{shiftId}", presence.DeleteShift).Methods("DELETE")
+
+	// Invoices
+	router.HandleFunc("/booking/{bookingId}/invoices", invoice.GetInvoicesByBookingID).Methods("GET")
+	router.HandleFunc("/invoices/{invoiceNo}", invoice.GetInvoice).Methods("GET")
+	router.HandleFunc("/invoices/{invoiceNo}/pdf", invoice.GetInvoicePDF).Methods("GET")
+
+	// Promo codes
+	router.HandleFunc("/promo-codes", promo.CreatePromoCode).Methods("POST")
+	router.HandleFunc("/promo-codes", promo.GetAllPromoCodes).Methods("GET")
+	router.HandleFunc("/promo-codes/{code}/status", promo.UpdatePromoCodeStatus).Methods("PUT")
+
+	// Analytics
+	router.HandleFunc("/analysis/bookings", analytics.GetBookingAnalysis).Methods("GET")
+	router.HandleFunc("/analysis/vehicles", analytics.GetVehicleAnalysis).Methods("GET")
+	router.HandleFunc("/analysis/drivers", analytics.GetDriverAnalysis).Methods("GET")
+
+	// Users
+	router.HandleFunc("/users/{uid}", user.GetUserInfoById).Methods("GET")
+
+	// Assignments
+	router.HandleFunc("/assignment-user/{uid}", booking.GetAssignmentByUid).Methods("GET")
+	router.HandleFunc("/assignment-vehicle/{vehicle_no}", booking.GetAssignmentByVehicleNo).Methods("GET")
+	router.HandleFunc("/assignments", booking.GetAllAssignments).Methods("GET")
+	router.HandleFunc("/dispatch-decisions", dispatch.GetDispatchDecisions).Methods("GET")
+	router.HandleFunc("/assignments/{uid}/assign_vehicle", booking.AssignVehicle).Methods("PUT")
+
+	// Vehicles
+	router.HandleFunc("/vehicle/{vehicleNo}", vehicles.GetVehicleInfoById).Methods("GET")
+	router.HandleFunc("/vehicles", vehicles.GetAllVehicles).Methods("GET")
+	router.HandleFunc("/add/vehicle", vehicles.AddVehicleHandler).Methods("POST")
+	router.HandleFunc("/vehicle/update-location/{vehicle_no}", vehicles.UpdateVehicleLocation).Methods("PUT")
+	router.HandleFunc("/vehicle-coords/{vehicle_no}", vehicles.GetVehicleCoordsHandler).Methods("GET")
+	router.HandleFunc("/vehicle/{vehicle_no}/trail", vehicles.GetVehicleTrail).Methods("GET")
+	router.HandleFunc("/live", vehicles.StreamLiveUpdates).Methods("GET")
+
+	port := "4001"
+	if len(os.Args) > 1 {
+		port = os.Args[1]
+	}
+
+	fmt.Printf("Starting server on port %s\n", port)
+	err := http.ListenAndServe(":"+port, router)
+
+	if err != nil {
+		panic(fmt.Sprintf("Unable to start server on port %s", port))
+	}
+}
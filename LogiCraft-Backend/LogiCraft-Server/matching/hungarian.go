@@ -0,0 +1,118 @@
+// Package matching solves assignment problems, such as giving each of a batch
+// of bookings its own vehicle so the total pickup distance is as small as possible.
+package matching
+
+import "math"
+
+// Assign finds the assignment of rows to columns with the least total cost
+// using the Hungarian algorithm, in O(n²m) time for n rows and m columns.
+// cost[i][j] is the cost of giving column j to row i; +Inf marks a pair that
+// may not be matched. Each row gets at most one column and each column at most
+// one row. It returns the column given to each row, or -1 for rows left
+// unmatched because there were fewer columns or no allowed column was free.
+func Assign(cost [][]float64) []int {
+	n := len(cost)
+	if n == 0 {
+		return nil
+	}
+	m := len(cost[0])
+
+	// Forbidden pairs cost more than any assignment of allowed pairs, so they
+	// are only used when a row can have nothing else and are undone afterwards
+	forbidden := 1.0
+	for _, row := range cost {
+		for _, c := range row {
+			if !math.IsInf(c, 1) {
+				forbidden += math.Abs(c)
+			}
+		}
+	}
+
+	// The algorithm needs at least as many columns as rows, so solve the
+	// transposed problem when there are more rows
+	transposed := n > m
+	if transposed {
+		n, m = m, n
+	}
+	a := func(i, j int) float64 {
+		if transposed {
+			i, j = j, i
+		}
+		c := cost[i][j]
+		if math.IsInf(c, 1) {
+			return forbidden
+		}
+		return c
+	}
+
+	// Potentials u and v over 1-based rows and columns; p[j] is the row
+	// matched to column j, with column 0 standing for the row being added
+	u := make([]float64, n+1)
+	v := make([]float64, m+1)
+	p := make([]int, m+1)
+	way := make([]int, m+1)
+	minv := make([]float64, m+1)
+	used := make([]bool, m+1)
+
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j] = math.Inf(1)
+			used[j] = false
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				if cur := a(i0-1, j-1) - u[i0] - v[j]; cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		// Flip the augmenting path back to column 0
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	assigned := make([]int, len(cost))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+	for j := 1; j <= m; j++ {
+		if p[j] == 0 {
+			continue
+		}
+		row, col := p[j]-1, j-1
+		if transposed {
+			row, col = col, row
+		}
+		if !math.IsInf(cost[row][col], 1) {
+			assigned[row] = col
+		}
+	}
+	return assigned
+}
@@ -0,0 +1,116 @@
+package matching
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var inf = math.Inf(1)
+
+// best tries every assignment and returns the most pairs that can be matched
+// and the least total cost of matching that many
+func best(cost [][]float64) (int, float64) {
+	bestPairs, bestCost := 0, 0.0
+	var m int
+	if len(cost) > 0 {
+		m = len(cost[0])
+	}
+	used := make([]bool, m)
+	var try func(row, pairs int, total float64)
+	try = func(row, pairs int, total float64) {
+		if row == len(cost) {
+			if pairs > bestPairs || (pairs == bestPairs && total < bestCost) {
+				bestPairs, bestCost = pairs, total
+			}
+			return
+		}
+		try(row+1, pairs, total)
+		for j := 0; j < m; j++ {
+			if !used[j] && !math.IsInf(cost[row][j], 1) {
+				used[j] = true
+				try(row+1, pairs+1, total+cost[row][j])
+				used[j] = false
+			}
+		}
+	}
+	try(0, 0, 0)
+	return bestPairs, bestCost
+}
+
+// check reports an assignment that gives a column twice or uses a forbidden pair
+func check(cost [][]float64, assigned []int) (int, float64, error) {
+	if len(assigned) != len(cost) {
+		return 0, 0, fmt.Errorf("%d rows assigned, want %d", len(assigned), len(cost))
+	}
+	pairs, total := 0, 0.0
+	taken := map[int]bool{}
+	for i, j := range assigned {
+		if j < 0 {
+			continue
+		}
+		if taken[j] {
+			return 0, 0, fmt.Errorf("column %d given twice", j)
+		}
+		if math.IsInf(cost[i][j], 1) {
+			return 0, 0, fmt.Errorf("row %d given forbidden column %d", i, j)
+		}
+		taken[j] = true
+		pairs++
+		total += cost[i][j]
+	}
+	return pairs, total, nil
+}
+
+func TestAssign(t *testing.T) {
+	tests := []struct {
+		name string
+		cost [][]float64
+		want []int
+	}{
+		{"no rows", nil, nil},
+		{"one pair", [][]float64{{3}}, []int{0}},
+		{"cheapest overall, not greedily", [][]float64{{1, 2}, {1, 10}}, []int{1, 0}},
+		{"more columns than rows", [][]float64{{5, 1, 3}, {2, 1, 4}}, []int{1, 0}},
+		{"more rows than columns", [][]float64{{5}, {1}, {3}}, []int{-1, 0, -1}},
+		{"forbidden pair", [][]float64{{inf, 1}, {2, 3}}, []int{1, 0}},
+		{"row with nothing allowed", [][]float64{{inf, inf}, {2, 3}}, []int{-1, 0}},
+		{"forbidden pair kept out of the cheaper plan", [][]float64{{1, inf}, {1, inf}}, []int{0, -1}},
+		{"more matches beat a lower cost", [][]float64{{1, 100}, {1, inf}}, []int{1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Assign(tt.cost)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Assign(%v) = %v, want %v", tt.cost, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 500; n++ {
+		rows, cols := 1+rng.Intn(5), 1+rng.Intn(5)
+		cost := make([][]float64, rows)
+		for i := range cost {
+			cost[i] = make([]float64, cols)
+			for j := range cost[i] {
+				cost[i][j] = float64(rng.Intn(20))
+				if rng.Float64() < 0.3 {
+					cost[i][j] = inf
+				}
+			}
+		}
+
+		pairs, total, err := check(cost, Assign(cost))
+		if err != nil {
+			t.Fatalf("%v: %v", cost, err)
+		}
+		wantPairs, wantTotal := best(cost)
+		if pairs != wantPairs || total != wantTotal {
+			t.Errorf("%v: matched %d pairs for %v, want %d for %v", cost, pairs, total, wantPairs, wantTotal)
+		}
+	}
+}
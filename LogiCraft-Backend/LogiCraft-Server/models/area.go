@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ServiceArea is a region where bookings are accepted.
+type ServiceArea struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name         string             `bson:"name" json:"name"`
+	Polygon      GeoPolygon         `bson:"polygon" json:"polygon"`
+	VehicleTypes []string           `bson:"vehicle_types" json:"vehicle_types"` // empty means all types
+	Active       bool               `bson:"active" json:"active"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// GeoPolygon is a GeoJSON polygon. Each ring is a closed list of [longitude, latitude]
+// positions; the first ring is the outer boundary and any others are holes.
+type GeoPolygon struct {
+	Type        string        `bson:"type" json:"type"`
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// GeoPoint is a GeoJSON point at [longitude, latitude].
+type GeoPoint struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// PointOf converts coordinates to a GeoJSON point.
+func PointOf(c Coordinates) GeoPoint {
+	return GeoPoint{Type: "Point", Coordinates: []float64{c.Longitude, c.Latitude}}
+}
@@ -1,10 +1,30 @@
-package models
-
-import "go.mongodb.org/mongo-driver/bson/primitive"
-
-type Assignment struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UID       primitive.ObjectID `bson:"uid" json:"uid"`
-	VehicleNo string             `bson:"vehicle_no" json:"vehicle_no"`
-	BookingID string             `bson:"booking_id" json:"booking_id"`
-}
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons a driver went offline
+const (
+	OfflineByDriver   = "driver"      // the driver went offline
+	OfflineTimedOut   = "timeout"     // nothing was heard from the driver's device for too long
+	OfflineShiftEnded = "shift_ended" // the driver's shift ended
+)
+
+type Assignment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UID           primitive.ObjectID `bson:"uid" json:"uid"`
+	VehicleNo     string             `bson:"vehicle_no" json:"vehicle_no"`
+	BookingID     string             `bson:"booking_id" json:"booking_id"`
+	AssignedAt    *time.Time         `bson:"assigned_at,omitempty" json:"assigned_at,omitempty"`       // when the driver was last given a booking
+	FreedAt       *time.Time         `bson:"freed_at,omitempty" json:"freed_at,omitempty"`             // when the driver last became free
+	Rating        float64            `bson:"rating,omitempty" json:"rating,omitempty"`                 // average stars given by customers
+	Ratings       int                `bson:"ratings,omitempty" json:"ratings,omitempty"`               // number of ratings in the average
+	Online        bool               `bson:"online" json:"online"`                                     // the driver is available for dispatch
+	OnlineSince   *time.Time         `bson:"online_since,omitempty" json:"online_since,omitempty"`     // when the driver last went online
+	LastSeenAt    *time.Time         `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`     // last heartbeat or location update while online
+	OfflineAt     *time.Time         `bson:"offline_at,omitempty" json:"offline_at,omitempty"`         // when the driver last went offline
+	OfflineReason string             `bson:"offline_reason,omitempty" json:"offline_reason,omitempty"` // driver, timeout or shift_ended
+}
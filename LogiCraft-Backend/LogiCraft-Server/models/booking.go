@@ -1,35 +1,61 @@
-package models
-
-import "go.mongodb.org/mongo-driver/bson/primitive"
-
-type Vehicle struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	VehicleNo   string             `bson:"vehicle_no" json:"vehicle_no"`
-	VehicleType string             `bson:"vehicle_type" json:"vehicle_type"`
-	Coordinates Coordinates        `bson:"coordinates" json:"coordinates,omitempty"`
-	Busy        bool               `bson:"busy" json:"busy"`
-}
-
-type Coordinates struct {
-	Latitude  float64 `bson:"latitude" json:"latitude"`
-	Longitude float64 `bson:"longitude" json:"longitude"`
-}
-
-type Booking struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
-	VehicleNo       string             `bson:"vehicle_no" json:"vehicle_no"`
-	DriverID        primitive.ObjectID `bson:"driver_id" json:"driver_id"`
-	PickupLocation  Coordinates        `bson:"pickup_location" json:"pickup_location"`
-	DropoffLocation Coordinates        `bson:"dropoff_location" json:"dropoff_location"`
-	Distance        float64            `bson:"distance" json:"distance"`
-	Cost            float64            `bson:"cost" json:"cost"`
-	JobStatus       string             `bson:"job_status" json:"job_status"`
-}
-
-type Assignment struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UID       primitive.ObjectID `bson:"uid" json:"uid"`
-	VehicleNo string             `bson:"vehicle_no" json:"vehicle_no"`
-	BookingID string             `bson:"booking_id" json:"booking_id"`
-}
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Booking struct {
+	ID              primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID          primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	VehicleNo       string              `bson:"vehicle_no" json:"vehicle_no"`
+	VehicleType     string              `bson:"vehicle_type,omitempty" json:"vehicle_type,omitempty"`
+	UpgradedFrom    string              `bson:"upgraded_from,omitempty" json:"upgraded_from,omitempty"`   // vehicle type asked for when a larger one was given instead
+	AcceptUpgrade   bool                `bson:"accept_upgrade,omitempty" json:"accept_upgrade,omitempty"` // a larger vehicle may be given if none of the type asked for is free
+	DriverID        primitive.ObjectID  `bson:"driver_id" json:"driver_id"`
+	PickupLocation  Coordinates         `bson:"pickup_location" json:"pickup_location"`
+	DropoffLocation Coordinates         `bson:"dropoff_location" json:"dropoff_location"`
+	Distance        float64             `bson:"distance" json:"distance"`
+	Cargo           string              `bson:"cargo,omitempty" json:"cargo,omitempty"`
+	Load            *CargoLoad          `bson:"load,omitempty" json:"load,omitempty"`
+	Shared          bool                `bson:"shared,omitempty" json:"shared,omitempty"` // part-load booking that can share its vehicle
+	Sender          *Contact            `bson:"sender,omitempty" json:"sender,omitempty"`
+	Receiver        *Contact            `bson:"receiver,omitempty" json:"receiver,omitempty"`
+	ScheduledAt     *time.Time          `bson:"scheduled_at,omitempty" json:"scheduled_at,omitempty"`
+	RecurringID     *primitive.ObjectID `bson:"recurring_id,omitempty" json:"recurring_id,omitempty"`   // series the booking was generated from
+	PlanID          *primitive.ObjectID `bson:"plan_id,omitempty" json:"plan_id,omitempty"`             // route plan the booking was committed from
+	PlanDelivery    *int                `bson:"plan_delivery,omitempty" json:"plan_delivery,omitempty"` // index of the delivery in that plan
+	BaseFare        float64             `bson:"base_fare,omitempty" json:"base_fare,omitempty"`
+	Discount        *Discount           `bson:"discount,omitempty" json:"discount,omitempty"`
+	FareShare       float64             `bson:"fare_share,omitempty" json:"fare_share,omitempty"` // part of the whole-vehicle fare a shared booking pays
+	Cost            float64             `bson:"cost" json:"cost"`
+	Payment         *BookingPayment     `bson:"payment,omitempty" json:"payment,omitempty"`
+	JobStatus       string              `bson:"job_status" json:"job_status"`
+	InitialETA      *BookingETA         `bson:"initial_eta,omitempty" json:"initial_eta,omitempty"` // estimate given at booking time
+	ETA             *BookingETA         `bson:"eta,omitempty" json:"eta,omitempty"`                 // latest estimate
+	Proofs          []ProofOfDelivery   `bson:"proofs,omitempty" json:"proofs,omitempty"`
+	Rating          int                 `bson:"rating,omitempty" json:"rating,omitempty"` // stars the customer gave the driver
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+	ArrivedPickupAt *time.Time          `bson:"arrived_pickup_at,omitempty" json:"arrived_pickup_at,omitempty"`
+	NearDropoffAt   *time.Time          `bson:"near_dropoff_at,omitempty" json:"near_dropoff_at,omitempty"` // when the receiver was told the vehicle is near
+	CompletedAt     *time.Time          `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	CancelledAt     *time.Time          `bson:"cancelled_at,omitempty" json:"cancelled_at,omitempty"`
+}
+
+type BookingETA struct {
+	PickupAt  time.Time `bson:"pickup_at" json:"pickup_at"`
+	DropoffAt time.Time `bson:"dropoff_at" json:"dropoff_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ETAEstimate is a stored estimate, kept so estimates can be compared with actual times
+type ETAEstimate struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID primitive.ObjectID `bson:"booking_id" json:"booking_id"`
+	VehicleNo string             `bson:"vehicle_no" json:"vehicle_no"`
+	Position  Coordinates        `bson:"position" json:"position"`
+	PickupAt  time.Time          `bson:"pickup_at" json:"pickup_at"`
+	DropoffAt time.Time          `bson:"dropoff_at" json:"dropoff_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
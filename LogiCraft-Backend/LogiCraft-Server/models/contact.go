@@ -0,0 +1,8 @@
+package models
+
+// Contact is a person at one end of a booking, who may not be the customer who made it.
+type Contact struct {
+	Name         string `bson:"name" json:"name"`
+	Phone        string `bson:"phone" json:"phone"`
+	AddressNotes string `bson:"address_notes,omitempty" json:"address_notes,omitempty"` // e.g. gate number or floor
+}
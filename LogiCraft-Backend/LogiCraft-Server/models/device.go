@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceToken identifies the device a user logged in on, e.g. so a driver's
+// device can send location updates for their vehicle. Only a hash of the
+// token is stored.
+type DeviceToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	UserType   string             `bson:"user_type" json:"user_type"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"` // removed by the database after this
+}
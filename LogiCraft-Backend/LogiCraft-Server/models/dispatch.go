@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DispatchDecision records how a vehicle was chosen for a booking, so the
+// choice can be explained later.
+type DispatchDecision struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID   primitive.ObjectID  `bson:"booking_id" json:"booking_id"`
+	Strategy    string              `bson:"strategy" json:"strategy"`
+	City        string              `bson:"city,omitempty" json:"city,omitempty"`
+	VehicleType string              `bson:"vehicle_type" json:"vehicle_type"`
+	Pickup      Coordinates         `bson:"pickup" json:"pickup"`
+	Candidates  []DispatchCandidate `bson:"candidates" json:"candidates"`
+	VehicleNo   string              `bson:"vehicle_no" json:"vehicle_no"`
+	DriverID    primitive.ObjectID  `bson:"driver_id" json:"driver_id"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// DispatchCandidate is a vehicle that was considered for a booking, with the
+// inputs to its score. Lower scores win.
+type DispatchCandidate struct {
+	VehicleNo   string             `bson:"vehicle_no" json:"vehicle_no"`
+	DriverID    primitive.ObjectID `bson:"driver_id" json:"driver_id"`
+	Distance    float64            `bson:"distance" json:"distance"` // km from the pickup point
+	IdleMinutes float64            `bson:"idle_minutes,omitempty" json:"idle_minutes,omitempty"`
+	Rating      float64            `bson:"rating,omitempty" json:"rating,omitempty"`
+	AssignedAt  *time.Time         `bson:"assigned_at,omitempty" json:"assigned_at,omitempty"`
+	Score       float64            `bson:"score" json:"score"`
+	Chosen      bool               `bson:"chosen" json:"chosen"`
+}
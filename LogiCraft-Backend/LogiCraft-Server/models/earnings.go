@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	EarningTypeTrip      = "trip"
+	EarningTypeIncentive = "incentive"
+	EarningTypeDeduction = "deduction"
+
+	PayoutStatusPending = "pending"
+	PayoutStatusSettled = "settled"
+)
+
+// DriverEarning is one line of a driver's earnings ledger.
+type DriverEarning struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	DriverID    primitive.ObjectID  `bson:"driver_id" json:"driver_id"`
+	BookingID   *primitive.ObjectID `bson:"booking_id,omitempty" json:"booking_id,omitempty"`
+	Type        string              `bson:"type" json:"type"`
+	Description string              `bson:"description" json:"description"`
+	Gross       float64             `bson:"gross" json:"gross"`
+	Commission  float64             `bson:"commission" json:"commission"`
+	Amount      float64             `bson:"amount" json:"amount"` // what the driver is owed; negative for deductions
+	PayoutID    *primitive.ObjectID `bson:"payout_id,omitempty" json:"payout_id,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// Payout is a driver's statement for one payout period.
+type Payout struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	DriverID    primitive.ObjectID `bson:"driver_id" json:"driver_id"`
+	PeriodStart time.Time          `bson:"period_start" json:"period_start"`
+	PeriodEnd   time.Time          `bson:"period_end" json:"period_end"`
+	Trips       int                `bson:"trips" json:"trips"`
+	Gross       float64            `bson:"gross" json:"gross"`
+	Commission  float64            `bson:"commission" json:"commission"`
+	Incentives  float64            `bson:"incentives" json:"incentives"`
+	Deductions  float64            `bson:"deductions" json:"deductions"`
+	Net         float64            `bson:"net" json:"net"`
+	Status      string             `bson:"status" json:"status"`
+	Reference   string             `bson:"reference,omitempty" json:"reference,omitempty"` // bank transfer reference
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	SettledAt   *time.Time         `bson:"settled_at,omitempty" json:"settled_at,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Booking event types
+const (
+	EventCreated         = "created"
+	EventAssigned        = "assigned"
+	EventArrivedPickup   = "arrived_pickup"
+	EventStatusChanged   = "status_changed"
+	EventCancelled       = "cancelled"
+	EventProofAdded      = "proof_added"
+	EventFareAdjusted    = "fare_adjusted"
+	EventPaymentCaptured = "payment_captured"
+	EventRefunded        = "refunded"
+	EventRated           = "rated"
+)
+
+// Actor roles
+const (
+	ActorCustomer = "customer"
+	ActorDriver   = "driver"
+	ActorAdmin    = "admin"
+	ActorSystem   = "system"
+)
+
+type BookingEvent struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID primitive.ObjectID     `bson:"booking_id" json:"booking_id"`
+	Type      string                 `bson:"type" json:"type"`
+	Actor     EventActor             `bson:"actor" json:"actor"`
+	Data      map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// EventActor is who caused a booking event
+type EventActor struct {
+	Role string `bson:"role" json:"role"`
+	ID   string `bson:"id,omitempty" json:"id,omitempty"`
+}
+
+// ProofOfDelivery is evidence attached to a booking, such as a photo or the name of whoever signed for the goods
+type ProofOfDelivery struct {
+	Type      string    `bson:"type" json:"type"` // photo, signature or note
+	URL       string    `bson:"url,omitempty" json:"url,omitempty"`
+	Note      string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
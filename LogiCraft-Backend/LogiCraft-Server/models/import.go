@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ImportStatusProcessing = "processing"
+	ImportStatusCompleted  = "completed"
+)
+
+// ImportJob tracks a bulk upload of bookings from a CSV or JSON lines file.
+type ImportJob struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Format     string               `bson:"format" json:"format"`
+	Status     string               `bson:"status" json:"status"`
+	Total      int                  `bson:"total" json:"total"`         // data rows in the file
+	Processed  int                  `bson:"processed" json:"processed"` // rows rejected or attempted so far
+	Succeeded  int                  `bson:"succeeded" json:"succeeded"`
+	Failed     int                  `bson:"failed" json:"failed"`
+	Errors     []ImportRowError     `bson:"errors" json:"errors"`
+	BookingIDs []primitive.ObjectID `bson:"booking_ids" json:"booking_ids"`
+	CreatedAt  time.Time            `bson:"created_at" json:"created_at"`
+	FinishedAt *time.Time           `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+	Lease      *Lease               `bson:"lease,omitempty" json:"-"` // held while rows are being booked
+}
+
+// ImportRowError explains why one row of an import did not become a booking.
+// Rows are numbered from 1, not counting the CSV header.
+type ImportRowError struct {
+	Row     int    `bson:"row" json:"row"`
+	Message string `bson:"message" json:"message"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+type Invoice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	InvoiceNo     string             `bson:"invoice_no" json:"invoice_no"`
+	Type          string             `bson:"type" json:"type"`
+	BookingID     primitive.ObjectID `bson:"booking_id" json:"booking_id"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	IssuedAt      time.Time          `bson:"issued_at" json:"issued_at"`
+	Company       InvoiceParty       `bson:"company" json:"company"`
+	Customer      InvoiceParty       `bson:"customer" json:"customer"`
+	Lines         []InvoiceLine      `bson:"lines" json:"lines"`
+	TaxableAmount float64            `bson:"taxable_amount" json:"taxable_amount"`
+	Taxes         []InvoiceTax       `bson:"taxes" json:"taxes"`
+	Total         float64            `bson:"total" json:"total"`
+	Reference     string             `bson:"reference,omitempty" json:"reference,omitempty"`     // invoice a credit note cancels
+	CreditedBy    string             `bson:"credited_by,omitempty" json:"credited_by,omitempty"` // credit note that cancels this invoice
+	Reason        string             `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+type InvoiceParty struct {
+	Name    string `bson:"name" json:"name"`
+	Address string `bson:"address" json:"address"`
+	Phone   string `bson:"phone" json:"phone"`
+	GSTIN   string `bson:"gstin,omitempty" json:"gstin,omitempty"`
+}
+
+type InvoiceLine struct {
+	Description string  `bson:"description" json:"description"`
+	Amount      float64 `bson:"amount" json:"amount"`
+}
+
+type InvoiceTax struct {
+	Name   string  `bson:"name" json:"name"`
+	Rate   float64 `bson:"rate" json:"rate"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
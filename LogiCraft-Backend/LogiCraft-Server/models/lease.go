@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Lease is a server's hold on a document it is working on in the background,
+// such as a plan being solved or an import being booked. The server renews it
+// while it works, so if the server stops the lease runs out and another
+// server can take the work over.
+type Lease struct {
+	Owner primitive.ObjectID `bson:"owner"`
+	Until time.Time          `bson:"until"`
+}
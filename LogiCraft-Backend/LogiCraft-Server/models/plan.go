@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PlanStatusSolving    = "solving"
+	PlanStatusSolved     = "solved"
+	PlanStatusFailed     = "failed"
+	PlanStatusCommitting = "committing"
+	PlanStatusCommitted  = "committed"
+)
+
+// Plan shares a set of deliveries from one depot among several vehicles. It
+// is solved in the background, then committed to turn each delivery into a
+// booking on the vehicle its route gives it.
+type Plan struct {
+	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Status        string               `bson:"status" json:"status"`
+	Depot         Coordinates          `bson:"depot" json:"depot"`
+	StartAt       time.Time            `bson:"start_at" json:"start_at"`               // when the vehicles leave the depot
+	ReturnToDepot bool                 `bson:"return_to_depot" json:"return_to_depot"` // routes end back at the depot
+	Deliveries    []PlanDelivery       `bson:"deliveries" json:"deliveries"`
+	VehicleNos    []string             `bson:"vehicle_nos" json:"vehicle_nos"` // vehicles the plan may use
+	Routes        []PlanRoute          `bson:"routes" json:"routes"`
+	Unassigned    []int                `bson:"unassigned" json:"unassigned"` // deliveries no vehicle could make, by index
+	Distance      float64              `bson:"distance" json:"distance"`     // km over all routes
+	Error         string               `bson:"error,omitempty" json:"error,omitempty"`
+	BookingIDs    []primitive.ObjectID `bson:"booking_ids" json:"booking_ids"`
+	Errors        []PlanDeliveryError  `bson:"errors" json:"errors"` // deliveries that could not be booked on commit
+	CreatedAt     time.Time            `bson:"created_at" json:"created_at"`
+	SolvedAt      *time.Time           `bson:"solved_at,omitempty" json:"solved_at,omitempty"`
+	CommittedAt   *time.Time           `bson:"committed_at,omitempty" json:"committed_at,omitempty"`
+	PaymentMethod string               `bson:"payment_method,omitempty" json:"payment_method,omitempty"` // how the committed bookings are paid for
+	PaymentSource string               `bson:"payment_source,omitempty" json:"-"`
+	Lease         *Lease               `bson:"lease,omitempty" json:"-"` // held while the plan is being solved or committed
+}
+
+// PlanDelivery is one delivery to plan. A delivery with no window may be
+// made at any time during the shift.
+type PlanDelivery struct {
+	Ref            string      `bson:"ref,omitempty" json:"ref,omitempty"` // the customer's own reference
+	Location       Coordinates `bson:"location" json:"location"`
+	Load           CargoLoad   `bson:"load" json:"load"`
+	Earliest       *time.Time  `bson:"earliest,omitempty" json:"earliest,omitempty"`
+	Latest         *time.Time  `bson:"latest,omitempty" json:"latest,omitempty"`
+	ServiceMinutes float64     `bson:"service_minutes" json:"service_minutes"` // time spent unloading
+	Cargo          string      `bson:"cargo,omitempty" json:"cargo,omitempty"`
+	Receiver       *Contact    `bson:"receiver,omitempty" json:"receiver,omitempty"`
+}
+
+// PlanRoute is the deliveries one vehicle makes, in order.
+type PlanRoute struct {
+	VehicleNo   string     `bson:"vehicle_no" json:"vehicle_no"`
+	VehicleType string     `bson:"vehicle_type" json:"vehicle_type"`
+	Stops       []PlanStop `bson:"stops" json:"stops"`
+	Distance    float64    `bson:"distance" json:"distance"` // km
+	Load        CargoLoad  `bson:"load" json:"load"`
+}
+
+// PlanStop is a delivery on a route with when the vehicle is expected there.
+type PlanStop struct {
+	Delivery int       `bson:"delivery" json:"delivery"` // index into the plan's deliveries
+	ArriveAt time.Time `bson:"arrive_at" json:"arrive_at"`
+	DepartAt time.Time `bson:"depart_at" json:"depart_at"`
+}
+
+// PlanDeliveryError explains why a delivery did not become a booking when
+// its plan was committed.
+type PlanDeliveryError struct {
+	Delivery int    `bson:"delivery" json:"delivery"`
+	Message  string `bson:"message" json:"message"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VehiclePosition is one accepted location update, kept in a time-series
+// collection to replay where a vehicle went
+type VehiclePosition struct {
+	VehicleNo   string             `bson:"vehicle_no" json:"-"` // the series the position belongs to
+	DriverID    primitive.ObjectID `bson:"driver_id" json:"driver_id"`
+	Coordinates Coordinates        `bson:"coordinates" json:"coordinates"`
+	RecordedAt  time.Time          `bson:"recorded_at" json:"recorded_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PromoTypeFlat    = "flat"
+	PromoTypePercent = "percent"
+)
+
+type PromoCode struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Code         string             `bson:"code" json:"code"`
+	Description  string             `bson:"description" json:"description"`
+	Type         string             `bson:"type" json:"type"`   // "flat" or "percent"
+	Value        float64            `bson:"value" json:"value"` // amount off, or percentage off
+	MaxDiscount  float64            `bson:"max_discount" json:"max_discount"`
+	MinFare      float64            `bson:"min_fare" json:"min_fare"`
+	MaxUses      int64              `bson:"max_uses" json:"max_uses"`             // 0 means unlimited
+	PerUserLimit int64              `bson:"per_user_limit" json:"per_user_limit"` // 0 means unlimited
+	UsedCount    int64              `bson:"used_count" json:"used_count"`
+	VehicleTypes []string           `bson:"vehicle_types" json:"vehicle_types"` // empty means all types
+	ValidFrom    time.Time          `bson:"valid_from" json:"valid_from"`
+	ValidUntil   time.Time          `bson:"valid_until" json:"valid_until"`
+	Active       bool               `bson:"active" json:"active"`
+}
+
+// PromoUsage counts how many times a user has redeemed a promo code.
+type PromoUsage struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	PromoID primitive.ObjectID `bson:"promo_id" json:"promo_id"`
+	UserID  primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Count   int64              `bson:"count" json:"count"`
+}
+
+// Discount is the promo line stored on a booking.
+type Discount struct {
+	PromoID primitive.ObjectID `bson:"promo_id" json:"promo_id"`
+	Code    string             `bson:"code" json:"code"`
+	Amount  float64            `bson:"amount" json:"amount"`
+}
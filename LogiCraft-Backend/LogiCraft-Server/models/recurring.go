@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RecurringStatusActive = "active"
+	RecurringStatusPaused = "paused"
+	RecurringStatusEnded  = "ended"
+
+	FrequencyDaily    = "daily"
+	FrequencyWeekdays = "weekdays"
+	FrequencyCron     = "cron"
+)
+
+// RecurringBooking is a template that turns into a booking at every occurrence of its schedule.
+type RecurringBooking struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	VehicleType     string             `bson:"vehicle_type" json:"vehicle_type"`
+	PickupLocation  Coordinates        `bson:"pickup_location" json:"pickup_location"`
+	DropoffLocation Coordinates        `bson:"dropoff_location" json:"dropoff_location"`
+	Cargo           string             `bson:"cargo,omitempty" json:"cargo,omitempty"`
+	PaymentMethod   string             `bson:"payment_method" json:"payment_method"`
+	PaymentSource   string             `bson:"payment_source,omitempty" json:"-"`
+	Rule            ScheduleRule       `bson:"rule" json:"rule"`
+	Status          string             `bson:"status" json:"status"`
+	SkipDates       []string           `bson:"skip_dates" json:"skip_dates"` // YYYY-MM-DD in the rule's timezone
+	EndsAt          *time.Time         `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+	LastScheduledAt *time.Time         `bson:"last_scheduled_at,omitempty" json:"last_scheduled_at,omitempty"` // latest occurrence turned into a booking
+	LastError       string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ScheduleRule says when a recurring booking happens. Daily and weekdays rules
+// use Time; cron rules use a five field expression (minute hour day month weekday).
+type ScheduleRule struct {
+	Frequency string `bson:"frequency" json:"frequency"`           // daily, weekdays or cron
+	Time      string `bson:"time,omitempty" json:"time,omitempty"` // HH:MM
+	Cron      string `bson:"cron,omitempty" json:"cron,omitempty"`
+	Timezone  string `bson:"timezone" json:"timezone"` // IANA name, e.g. Asia/Kolkata
+}
+
+// Occurrence is one upcoming date of a recurring booking.
+type Occurrence struct {
+	At        time.Time           `json:"at"`
+	Skipped   bool                `json:"skipped"`
+	BookingID *primitive.ObjectID `json:"booking_id,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Shift is a weekly window in which a driver may be online. A shift whose end
+// is not after its start runs past midnight into the next day.
+type Shift struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	DriverID  primitive.ObjectID `bson:"driver_id" json:"driver_id"`
+	Days      []int              `bson:"days" json:"days"`         // days the shift starts on, 0 for Sunday to 6 for Saturday; empty for every day
+	Start     string             `bson:"start" json:"start"`       // HH:MM
+	End       string             `bson:"end" json:"end"`           // HH:MM
+	Timezone  string             `bson:"timezone" json:"timezone"` // IANA name, e.g. Asia/Kolkata
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
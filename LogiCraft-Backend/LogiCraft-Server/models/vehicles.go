@@ -1,16 +1,32 @@
-package models
-
-import "go.mongodb.org/mongo-driver/bson/primitive"
-
-type Vehicle struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	VehicleNo   string             `bson:"vehicle_no" json:"vehicle_no"`
-	VehicleType string             `bson:"vehicle_type" json:"vehicle_type"`
-	Coordinates Coordinates        `bson:"coordinates" json:"coordinates,omitempty"`
-	Busy        bool               `bson:"busy" json:"busy"`
-}
-
-type Coordinates struct {
-	Latitude  float64 `bson:"latitude" json:"latitude"`
-	Longitude float64 `bson:"longitude" json:"longitude"`
-}
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Vehicle struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	VehicleNo   string              `bson:"vehicle_no" json:"vehicle_no"`
+	VehicleType string              `bson:"vehicle_type" json:"vehicle_type"`
+	Coordinates Coordinates         `bson:"coordinates" json:"coordinates,omitempty"`
+	Location    *GeoPoint           `bson:"location,omitempty" json:"-"`                        // Coordinates as GeoJSON, for geospatial queries
+	LocationAt  *time.Time          `bson:"location_at,omitempty" json:"location_at,omitempty"` // when the driver's device recorded Coordinates
+	Busy        bool                `bson:"busy" json:"busy"`
+	Online      bool                `bson:"online" json:"online"`                       // the assigned driver is online and can be dispatched
+	Load        *CargoLoad          `bson:"load,omitempty" json:"load,omitempty"`       // cargo on board while carrying shared or planned bookings
+	PlanID      *primitive.ObjectID `bson:"plan_id,omitempty" json:"plan_id,omitempty"` // route plan the vehicle is driving
+	UpdatedAt   *time.Time          `bson:"updated_at,omitempty" json:"-"`              // set by the database on every change, so changes can be polled for
+}
+
+type Coordinates struct {
+	Latitude  float64 `bson:"latitude" json:"latitude"`
+	Longitude float64 `bson:"longitude" json:"longitude"`
+}
+
+// CargoLoad is an amount of cargo, by volume in cubic metres and weight in kg.
+type CargoLoad struct {
+	Volume float64 `bson:"volume" json:"volume"`
+	Weight float64 `bson:"weight" json:"weight"`
+}
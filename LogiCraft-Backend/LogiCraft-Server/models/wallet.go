@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PaymentMethodWallet = "wallet"
+	PaymentMethodCard   = "card"
+)
+
+type Wallet struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID  primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Balance float64            `bson:"balance" json:"balance"` // available to spend
+	Held    float64            `bson:"held" json:"held"`       // reserved for bookings in progress
+}
+
+// LedgerTransaction is one balanced double-entry posting. The debits and
+// credits of its entries always add up to the same amount.
+type LedgerTransaction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Type      string             `bson:"type" json:"type"` // topup, booking_charge, refund, adjustment
+	Reference string             `bson:"reference" json:"reference"`
+	Memo      string             `bson:"memo,omitempty" json:"memo,omitempty"`
+	Entries   []LedgerEntry      `bson:"entries" json:"entries"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type LedgerEntry struct {
+	Account string  `bson:"account" json:"account"`
+	Debit   float64 `bson:"debit" json:"debit"`
+	Credit  float64 `bson:"credit" json:"credit"`
+}
+
+// BookingPayment records how a booking is being paid for.
+type BookingPayment struct {
+	Method    string  `bson:"method" json:"method"` // wallet or card
+	Status    string  `bson:"status" json:"status"` // authorized, captured, voided, refunded
+	Amount    float64 `bson:"amount" json:"amount"`
+	PaymentID string  `bson:"payment_id,omitempty" json:"payment_id,omitempty"` // gateway payment for card bookings
+	Refunded  float64 `bson:"refunded,omitempty" json:"refunded,omitempty"`
+}
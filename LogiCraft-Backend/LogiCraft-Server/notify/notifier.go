@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"logi-craft/config"
+	"logi-craft/utils"
+)
+
+// Kinds of message
+const (
+	KindBookingCreated = "booking_created"
+	KindVehicleNear    = "vehicle_near"
+	KindRelay          = "relay" // a message passed between the parties of a booking
+)
+
+// Message is a text sent to one person about a booking.
+type Message struct {
+	Kind      string `json:"kind"`
+	BookingID string `json:"booking_id"`
+	Name      string `json:"name"`
+	Phone     string `json:"phone"`
+	Text      string `json:"text"`
+}
+
+// Notifier is implemented by each way of reaching people, e.g. SMS or push.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the notifier used by the server, set up by Setup.
+var Default Notifier = LogNotifier{}
+
+// Setup selects the notifier from the config.
+func Setup(cfg config.NotifyConfig) error {
+	switch cfg.Provider {
+	case "", "log":
+		Default = LogNotifier{}
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return fmt.Errorf("webhook notifier needs a webhook_url")
+		}
+		Default = NewWebhookNotifier(cfg.WebhookURL)
+	default:
+		return fmt.Errorf("unknown notify provider %q", cfg.Provider)
+	}
+	return nil
+}
+
+// Send delivers a message with the default notifier. Failing to notify someone
+// should not fail the change that caused it, so errors are logged rather than returned.
+func Send(ctx context.Context, msg Message) {
+	if msg.Phone == "" {
+		return
+	}
+	if err := Default.Send(ctx, msg); err != nil {
+		fmt.Printf("Error sending %s message for booking %s: %v\n", msg.Kind, msg.BookingID, err)
+	}
+}
+
+// LogNotifier prints messages instead of sending them. It is meant for local
+// development, and masks phone numbers so they do not end up in server logs.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, msg Message) error {
+	fmt.Printf("Notify %s (%s) about booking %s: %s\n", msg.Name, utils.MaskPhone(msg.Phone), msg.BookingID, msg.Text)
+	return nil
+}
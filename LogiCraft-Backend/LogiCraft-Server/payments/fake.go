@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DeclinedSource is a card token that the fake gateway always declines.
+const DeclinedSource = "tok_declined"
+
+// FakeGateway keeps payments in memory and approves everything except
+// DeclinedSource. It is meant for local development and tests.
+type FakeGateway struct {
+	mu       sync.Mutex
+	seq      int
+	payments map[string]*Payment
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{payments: make(map[string]*Payment)}
+}
+
+func (g *FakeGateway) Charge(ctx context.Context, req ChargeRequest) (Payment, error) {
+	payment, err := g.Authorize(ctx, req)
+	if err != nil {
+		return payment, err
+	}
+	return g.Capture(ctx, payment.ID, req.Amount)
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, req ChargeRequest) (Payment, error) {
+	if req.Source == DeclinedSource || req.Amount <= 0 {
+		return Payment{}, ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	payment := &Payment{
+		ID:     fmt.Sprintf("fake_pay_%d", g.seq),
+		Amount: req.Amount,
+		Status: StatusAuthorized,
+	}
+	g.payments[payment.ID] = payment
+	return *payment, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, paymentID string, amount float64) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != StatusAuthorized || amount > payment.Amount {
+		return *payment, ErrInvalidState
+	}
+	payment.Captured = amount
+	payment.Status = StatusCaptured
+	return *payment, nil
+}
+
+func (g *FakeGateway) Void(ctx context.Context, paymentID string) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != StatusAuthorized {
+		return *payment, ErrInvalidState
+	}
+	payment.Status = StatusVoided
+	return *payment, nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, paymentID string, amount float64) (Payment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	payment, ok := g.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != StatusCaptured && payment.Status != StatusRefunded {
+		return *payment, ErrInvalidState
+	}
+	if payment.Refunded+amount > payment.Captured {
+		return *payment, ErrInvalidState
+	}
+	payment.Refunded += amount
+	if payment.Refunded >= payment.Captured {
+		payment.Status = StatusRefunded
+	}
+	return *payment, nil
+}
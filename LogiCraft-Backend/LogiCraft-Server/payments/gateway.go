@@ -0,0 +1,62 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDeclined        = errors.New("payment declined")
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrInvalidState    = errors.New("payment is not in a valid state for this operation")
+)
+
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusVoided     = "voided"
+	StatusRefunded   = "refunded"
+)
+
+// ChargeRequest describes money to be taken from a customer's card or other source.
+type ChargeRequest struct {
+	Amount    float64
+	Currency  string
+	Source    string // card token or other gateway-specific source
+	Reference string // our own reference, e.g. a booking or wallet ID
+}
+
+// Payment is the gateway's record of a charge or authorisation.
+type Payment struct {
+	ID       string  `json:"id"`
+	Amount   float64 `json:"amount"`
+	Captured float64 `json:"captured"`
+	Refunded float64 `json:"refunded"`
+	Status   string  `json:"status"`
+}
+
+// Gateway is implemented by each payment provider.
+type Gateway interface {
+	// Charge authorises and captures in one step, e.g. for wallet top-ups.
+	Charge(ctx context.Context, req ChargeRequest) (Payment, error)
+	// Authorize places a hold that can later be captured or voided.
+	Authorize(ctx context.Context, req ChargeRequest) (Payment, error)
+	Capture(ctx context.Context, paymentID string, amount float64) (Payment, error)
+	Void(ctx context.Context, paymentID string) (Payment, error)
+	Refund(ctx context.Context, paymentID string, amount float64) (Payment, error)
+}
+
+// Default is the gateway used by the server, set up by Setup.
+var Default Gateway = NewFakeGateway()
+
+// Setup selects the gateway by name.
+func Setup(name string) error {
+	switch name {
+	case "", "fake":
+		Default = NewFakeGateway()
+	default:
+		return fmt.Errorf("unknown payment gateway %q", name)
+	}
+	return nil
+}
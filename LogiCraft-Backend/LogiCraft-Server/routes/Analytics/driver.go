@@ -1,60 +1,88 @@
-package analytics
-
-import (
-	"context"
-	"encoding/json"
-	"net/http"
-	"time"
-
-	"logi-craft/db"
-
-	"go.mongodb.org/mongo-driver/bson"
-)
-
-type DriverStatus struct {
-	TotalDrivers int `json:"total_drivers"`
-	NotVerified  int `json:"not_verified"`
-	Free         int `json:"free"`
-	Busy         int `json:"busy"`
-}
-
-func GetDriverAnalysis(w http.ResponseWriter, r *http.Request) {
-	// enableCORS(w)
-	// fmt.Println("Driver analaytics requested")
-
-	collection := db.GetCollection("assignments")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// get total drivers
-	totalCount, err := collection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, "Failed to fetch assignments", http.StatusInternalServerError)
-		return
-	}
-
-	// Get not verified drivers
-	notVerifiedCount, err := collection.CountDocuments(ctx, bson.M{"vehicle_no": ""})
-	if err != nil {
-		http.Error(w, "Failed to fetch not verified drivers", http.StatusInternalServerError)
-		return
-	}
-
-	// get free drivers (vehicle_no is not empty and booking_id is empty)
-	freeCount, err := collection.CountDocuments(ctx, bson.M{"vehicle_no": bson.M{"$ne": ""}, "booking_id": ""})
-	if err != nil {
-		http.Error(w, "Failed to fetch free drivers", http.StatusInternalServerError)
-		return
-	}
-
-	// Calculate busy drivers (total - not_verified - free)
-	busyCount := totalCount - notVerifiedCount - freeCount
-
-	json.NewEncoder(w).Encode(DriverStatus{
-		TotalDrivers: int(totalCount),
-		NotVerified:  int(notVerifiedCount),
-		Free:         int(freeCount),
-		Busy:         int(busyCount),
-	})
-}
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DriverStatus counts drivers by presence. Drivers on a trip are counted as
+// on trip whether or not they are still online.
+type DriverStatus struct {
+	TotalDrivers int `json:"total_drivers"`
+	NotVerified  int `json:"not_verified"` // no vehicle assigned
+	Online       int `json:"online"`       // online and free for dispatch
+	OnTrip       int `json:"on_trip"`      // driving a vehicle that is busy with a booking
+	Offline      int `json:"offline"`
+}
+
+func GetDriverAnalysis(w http.ResponseWriter, r *http.Request) {
+	// enableCORS(w)
+	// fmt.Println("Driver analaytics requested")
+
+	collection := db.GetCollection("assignments")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Whether a driver is on a trip is read from their vehicle, which is busy until its bookings end
+	count := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	verified := bson.M{"$ne": bson.A{"$vehicle_no", ""}}
+	busy := bson.M{"$eq": bson.A{"$vehicle.busy", true}}
+	online := bson.M{"$eq": bson.A{"$online", true}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "vehicles",
+			"localField":   "vehicle_no",
+			"foreignField": "vehicle_no",
+			"as":           "vehicle",
+		}}},
+		{{Key: "$set", Value: bson.M{"vehicle": bson.M{"$arrayElemAt": bson.A{"$vehicle", 0}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          nil,
+			"total":        bson.M{"$sum": 1},
+			"not_verified": count(bson.M{"$not": bson.A{verified}}),
+			"online":       count(bson.M{"$and": bson.A{verified, online, bson.M{"$not": bson.A{busy}}}}),
+			"on_trip":      count(bson.M{"$and": bson.A{verified, busy}}),
+			"offline":      count(bson.M{"$and": bson.A{verified, bson.M{"$not": bson.A{online}}, bson.M{"$not": bson.A{busy}}}}),
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		http.Error(w, "Failed to fetch assignments", http.StatusInternalServerError)
+		return
+	}
+	var counts []struct {
+		Total       int `bson:"total"`
+		NotVerified int `bson:"not_verified"`
+		Online      int `bson:"online"`
+		OnTrip      int `bson:"on_trip"`
+		Offline     int `bson:"offline"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		http.Error(w, "Failed to count drivers", http.StatusInternalServerError)
+		return
+	}
+
+	var status DriverStatus
+	if len(counts) > 0 {
+		c := counts[0]
+		status = DriverStatus{
+			TotalDrivers: c.Total,
+			NotVerified:  c.NotVerified,
+			Online:       c.Online,
+			OnTrip:       c.OnTrip,
+			Offline:      c.Offline,
+		}
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,227 @@
+package areas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AreaResponse struct {
+	Success bool               `json:"success"`
+	Message string             `json:"message"`
+	Area    models.ServiceArea `json:"area,omitempty"`
+}
+
+type AreasResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Areas   []models.ServiceArea `json:"areas"`
+}
+
+// areaRequest is the body accepted when creating or replacing a service area
+type areaRequest struct {
+	Name         string            `json:"name"`
+	Polygon      models.GeoPolygon `json:"polygon"`
+	VehicleTypes []string          `json:"vehicle_types"`
+	Active       *bool             `json:"active"` // defaults to true
+}
+
+// decodeArea reads and checks a service area from the request body, writing an error response if it is invalid
+func decodeArea(w http.ResponseWriter, r *http.Request) (models.ServiceArea, bool) {
+	var req areaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return models.ServiceArea{}, false
+	}
+
+	area := models.ServiceArea{
+		Name:         strings.TrimSpace(req.Name),
+		Polygon:      req.Polygon,
+		VehicleTypes: []string{},
+		Active:       req.Active == nil || *req.Active,
+	}
+	if area.Name == "" {
+		http.Error(w, "Missing area name", http.StatusBadRequest)
+		return area, false
+	}
+	if err := validatePolygon(area.Polygon); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return area, false
+	}
+	for _, t := range req.VehicleTypes {
+		if _, ok := utils.VehicleRates[t]; !ok {
+			http.Error(w, "Invalid vehicle type "+t, http.StatusBadRequest)
+			return area, false
+		}
+		area.VehicleTypes = append(area.VehicleTypes, t)
+	}
+	return area, true
+}
+
+// writeStoreError reports a failure to save an area. MongoDB rejects polygons
+// that cross themselves, which is a client error.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && len(writeErr.WriteErrors) > 0 {
+		http.Error(w, "Polygon rejected: "+writeErr.WriteErrors[0].Message, http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to save service area", http.StatusInternalServerError)
+}
+
+// CreateServiceArea lets an admin add a service area
+func CreateServiceArea(w http.ResponseWriter, r *http.Request) {
+	area, ok := decodeArea(w, r)
+	if !ok {
+		return
+	}
+	area.CreatedAt = time.Now()
+	area.UpdatedAt = area.CreatedAt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.GetCollection("service_areas").InsertOne(ctx, area)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	area.ID = result.InsertedID.(primitive.ObjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AreaResponse{Success: true, Message: "Service area created successfully", Area: area})
+}
+
+// GetServiceAreas lists service areas, optionally only ?active=true or ?active=false
+func GetServiceAreas(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	switch r.URL.Query().Get("active") {
+	case "true":
+		filter["active"] = true
+	case "false":
+		filter["active"] = false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := db.GetCollection("service_areas").Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch service areas", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	areas := []models.ServiceArea{}
+	if err = cursor.All(ctx, &areas); err != nil {
+		http.Error(w, "Error decoding service areas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AreasResponse{Success: true, Message: "Service areas retrieved successfully", Areas: areas})
+}
+
+// GetServiceArea retrieves one service area
+func GetServiceArea(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["areaId"])
+	if err != nil {
+		http.Error(w, "Invalid Area ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var area models.ServiceArea
+	err = db.GetCollection("service_areas").FindOne(ctx, bson.M{"_id": id}).Decode(&area)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(AreaResponse{Success: false, Message: "Service area not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch service area", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AreaResponse{Success: true, Message: "Service area retrieved successfully", Area: area})
+}
+
+// UpdateServiceArea lets an admin replace the name, polygon, vehicle types and active flag of an area
+func UpdateServiceArea(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["areaId"])
+	if err != nil {
+		http.Error(w, "Invalid Area ID", http.StatusBadRequest)
+		return
+	}
+	area, ok := decodeArea(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"name":          area.Name,
+		"polygon":       area.Polygon,
+		"vehicle_types": area.VehicleTypes,
+		"active":        area.Active,
+		"updated_at":    time.Now(),
+	}}
+	err = db.GetCollection("service_areas").FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&area)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(AreaResponse{Success: false, Message: "Service area not found"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AreaResponse{Success: true, Message: "Service area updated successfully", Area: area})
+}
+
+// DeleteServiceArea lets an admin remove a service area
+func DeleteServiceArea(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["areaId"])
+	if err != nil {
+		http.Error(w, "Invalid Area ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.GetCollection("service_areas").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		http.Error(w, "Failed to delete service area", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(AreaResponse{Success: false, Message: "Service area not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AreaResponse{Success: true, Message: "Service area deleted successfully"})
+}
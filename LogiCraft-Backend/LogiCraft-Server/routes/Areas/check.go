@@ -0,0 +1,187 @@
+package areas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Serviceability says whether a trip can be booked and with which vehicle types
+type Serviceability struct {
+	Serviceable  bool     `json:"serviceable"`
+	Reason       string   `json:"reason,omitempty"`
+	VehicleTypes []string `json:"vehicle_types"` // offered for the whole trip
+}
+
+// EnsureIndexes lets areas be looked up by the points they contain
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("service_areas").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "polygon", Value: "2dsphere"}},
+	})
+	return err
+}
+
+// Check works out whether a trip from pickup to dropoff is inside the active
+// service areas, and whether vehicleType is offered on it. Leave vehicleType
+// empty to only check the locations. Until at least one area is active every
+// trip is serviceable, so a new deployment works before any areas are drawn.
+func Check(ctx context.Context, pickup, dropoff models.Coordinates, vehicleType string) (Serviceability, error) {
+	collection := db.GetCollection("service_areas")
+
+	n, err := collection.CountDocuments(ctx, bson.M{"active": true})
+	if err != nil {
+		return Serviceability{}, err
+	}
+	if n == 0 {
+		return result(allTypes(), vehicleType), nil
+	}
+
+	pickupTypes, err := typesAt(ctx, pickup)
+	if err != nil {
+		return Serviceability{}, err
+	}
+	if pickupTypes == nil {
+		return Serviceability{Reason: "Pickup location is outside our service area", VehicleTypes: []string{}}, nil
+	}
+	dropoffTypes, err := typesAt(ctx, dropoff)
+	if err != nil {
+		return Serviceability{}, err
+	}
+	if dropoffTypes == nil {
+		return Serviceability{Reason: "Dropoff location is outside our service area", VehicleTypes: []string{}}, nil
+	}
+
+	var offered []string
+	for _, t := range pickupTypes {
+		if contains(dropoffTypes, t) {
+			offered = append(offered, t)
+		}
+	}
+	return result(offered, vehicleType), nil
+}
+
+func result(offered []string, vehicleType string) Serviceability {
+	if offered == nil {
+		offered = []string{}
+	}
+	s := Serviceability{Serviceable: true, VehicleTypes: offered}
+	if len(offered) == 0 {
+		s.Serviceable = false
+		s.Reason = "No vehicle types are offered on this route"
+	} else if vehicleType != "" && !contains(offered, vehicleType) {
+		s.Serviceable = false
+		s.Reason = fmt.Sprintf("Vehicle type %s is not offered on this route", vehicleType)
+	}
+	return s
+}
+
+// NamesAt returns the names of the active service areas containing a point, in name order
+func NamesAt(ctx context.Context, point models.Coordinates) ([]string, error) {
+	found, err := areasAt(ctx, point)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(found))
+	for i, area := range found {
+		names[i] = area.Name
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// areasAt returns the active areas containing a point
+func areasAt(ctx context.Context, point models.Coordinates) ([]models.ServiceArea, error) {
+	filter := bson.M{
+		"active":  true,
+		"polygon": bson.M{"$geoIntersects": bson.M{"$geometry": models.PointOf(point)}},
+	}
+	cursor, err := db.GetCollection("service_areas").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.ServiceArea
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// typesAt returns the vehicle types offered at a point by the active areas
+// containing it, or nil if no active area contains it
+func typesAt(ctx context.Context, point models.Coordinates) ([]string, error) {
+	found, err := areasAt(ctx, point)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	types := []string{}
+	for _, area := range found {
+		if len(area.VehicleTypes) == 0 {
+			return allTypes(), nil
+		}
+		for _, t := range area.VehicleTypes {
+			if !contains(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+func allTypes() []string {
+	types := make([]string, 0, len(utils.VehicleRates))
+	for t := range utils.VehicleRates {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolygon checks that a polygon is well formed GeoJSON before it is stored
+func validatePolygon(p models.GeoPolygon) error {
+	if p.Type != "Polygon" {
+		return errors.New("polygon type must be Polygon")
+	}
+	if len(p.Coordinates) == 0 {
+		return errors.New("polygon needs at least one ring")
+	}
+	for _, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return errors.New("each ring needs at least four positions")
+		}
+		for _, pos := range ring {
+			if len(pos) != 2 {
+				return errors.New("positions must be [longitude, latitude]")
+			}
+			if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+				return errors.New("position is out of range")
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return errors.New("each ring must end where it starts")
+		}
+	}
+	return nil
+}
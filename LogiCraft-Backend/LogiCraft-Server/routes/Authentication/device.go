@@ -0,0 +1,209 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoDevice is returned when a request carries no device token or one that
+// is unknown, revoked or expired
+var ErrNoDevice = errors.New("device is not authenticated")
+
+// ErrNotAdmin is returned when a request's device belongs to a user who is not an admin
+var ErrNotAdmin = errors.New("user is not an admin")
+
+// ErrNotOwner is returned when a request's device belongs to a user other than
+// the one whose data it asks for
+var ErrNotOwner = errors.New("device belongs to another user")
+
+// EnsureIndexes lets device tokens be looked up by hash and removes them once they expire
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("device_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// IssueDeviceToken creates a token a user's device sends in an
+// "Authorization: Bearer" header, e.g. with a driver's location updates
+func IssueDeviceToken(ctx context.Context, userID primitive.ObjectID, userType string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generating device token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	now := time.Now()
+	_, err := db.GetCollection("device_tokens").InsertOne(ctx, models.DeviceToken{
+		UserID:    userID,
+		UserType:  userType,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.App.Tracking.DeviceTokenTTL()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("storing device token: %w", err)
+	}
+	return token, nil
+}
+
+// DeviceDriver returns the driver whose device sent a request
+func DeviceDriver(ctx context.Context, r *http.Request) (primitive.ObjectID, error) {
+	device, err := deviceOf(ctx, r)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if device.UserType != "driver" {
+		return primitive.NilObjectID, ErrNoDevice
+	}
+	return device.UserID, nil
+}
+
+// DeviceAdmin returns the admin whose device sent a request
+func DeviceAdmin(ctx context.Context, r *http.Request) (primitive.ObjectID, error) {
+	device, err := deviceOf(ctx, r)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if device.UserType != "admin" {
+		return primitive.NilObjectID, ErrNotAdmin
+	}
+	return device.UserID, nil
+}
+
+// AuthorizeAdmin checks that a request was sent from an admin's device,
+// answering it with an error if not
+func AuthorizeAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	_, err := DeviceAdmin(ctx, r)
+	return authorized(w, err)
+}
+
+// AuthorizeDevice returns the user whose device sent a request, answering it
+// with an error if the device could not be authenticated
+func AuthorizeDevice(ctx context.Context, w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	userID, err := DeviceUser(ctx, r)
+	return userID, authorized(w, err)
+}
+
+// AuthorizeStream is AuthorizeDevice for Server-Sent Events. Browsers cannot
+// set headers on them, so the device token may also be given as ?token=.
+func AuthorizeStream(ctx context.Context, w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	token := bearerToken(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	device, err := deviceFor(ctx, token)
+	return device.UserID, authorized(w, err)
+}
+
+// AuthorizeUser checks that a request was sent from the given user's device,
+// answering it with an error if not
+func AuthorizeUser(ctx context.Context, w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) bool {
+	uid, err := DeviceUser(ctx, r)
+	if err == nil && uid != userID {
+		err = ErrNotOwner
+	}
+	return authorized(w, err)
+}
+
+// authorized answers a request with an error if its device could not be
+// authenticated, and reports whether it could
+func authorized(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case ErrNoDevice:
+		http.Error(w, "Device is not authenticated", http.StatusUnauthorized)
+	case ErrNotAdmin:
+		http.Error(w, "Only admins may do this", http.StatusForbidden)
+	case ErrNotOwner:
+		http.Error(w, "Device belongs to another user", http.StatusForbidden)
+	default:
+		fmt.Printf("Error authenticating device: %v\n", err)
+		http.Error(w, "Failed to authenticate device", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// DeviceUser returns the user, of any type, whose device sent a request
+func DeviceUser(ctx context.Context, r *http.Request) (primitive.ObjectID, error) {
+	device, err := deviceOf(ctx, r)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return device.UserID, nil
+}
+
+func deviceOf(ctx context.Context, r *http.Request) (models.DeviceToken, error) {
+	return deviceFor(ctx, bearerToken(r))
+}
+
+// deviceFor returns the device a token was issued to
+func deviceFor(ctx context.Context, token string) (models.DeviceToken, error) {
+	if token == "" {
+		return models.DeviceToken{}, ErrNoDevice
+	}
+
+	// The TTL index removes expired tokens only once a minute, so check the expiry too
+	now := time.Now()
+	var device models.DeviceToken
+	err := db.GetCollection("device_tokens").FindOneAndUpdate(ctx,
+		bson.M{"token_hash": hashToken(token), "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"last_used_at": now}}).Decode(&device)
+	if err == mongo.ErrNoDocuments {
+		return models.DeviceToken{}, ErrNoDevice
+	}
+	return device, err
+}
+
+// LogoutHandler revokes the device token the request was sent with
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "Missing device token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.GetCollection("device_tokens").DeleteOne(ctx, bson.M{"token_hash": hashToken(token)}); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Logged out"})
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
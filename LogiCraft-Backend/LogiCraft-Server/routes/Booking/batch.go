@@ -0,0 +1,371 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/matching"
+	"logi-craft/models"
+	dispatch "logi-craft/routes/Dispatch"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errVehicleTaken is returned when a vehicle matched in a batch or planned for
+// a route was taken by another booking, or its driver went offline, before it
+// could be claimed
+var errVehicleTaken = errors.New("vehicle taken by another booking")
+
+// batchRequest is a booking waiting in the current batch for a vehicle
+type batchRequest struct {
+	booking models.Booking
+	reply   chan BatchMatch // buffered so the batch never waits for the caller
+	gone    bool            // the caller stopped waiting; guarded by batcher.mu
+}
+
+// BatchMatch is the vehicle a batch gave a booking
+type BatchMatch struct {
+	Vehicle    models.Vehicle
+	Assignment models.Assignment
+	Distance   float64                  // km from the pickup point by road
+	Decision   *models.DispatchDecision // recorded once the booking has the vehicle
+	Err        error
+}
+
+// batcher collects bookings over the batch window and matches them to
+// vehicles together
+type batcher struct {
+	mu      sync.Mutex
+	pending []*batchRequest
+}
+
+var batch = &batcher{}
+
+// matchInBatch adds a booking to the current batch and waits for the batch to
+// give it a vehicle. Unlike findVehicle, the vehicle is already claimed when
+// this returns, so it must be given back with unloadVehicle if the booking fails.
+func matchInBatch(ctx context.Context, booking models.Booking) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	req := &batchRequest{booking: booking, reply: make(chan BatchMatch, 1)}
+
+	batch.mu.Lock()
+	batch.pending = append(batch.pending, req)
+	if len(batch.pending) == 1 {
+		// The first booking in a batch opens the window
+		time.AfterFunc(config.App.Dispatch.BatchWindow(), batch.flush)
+	}
+	batch.mu.Unlock()
+
+	select {
+	case m := <-req.reply:
+		return m.Vehicle, m.Assignment, m.Distance, m.Decision, m.Err
+	case <-ctx.Done():
+	}
+
+	batch.mu.Lock()
+	req.gone = true
+	var late *BatchMatch
+	select {
+	case m := <-req.reply:
+		// Matched just as the caller gave up
+		late = &m
+	default:
+	}
+	batch.mu.Unlock()
+
+	if late != nil && late.Err == nil {
+		release(late.Vehicle.VehicleNo, booking)
+	}
+	return models.Vehicle{}, models.Assignment{}, 0, nil, ctx.Err()
+}
+
+// flush closes the current batch and matches its bookings
+func (b *batcher) flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bookings := make([]models.Booking, len(pending))
+	for i, req := range pending {
+		bookings[i] = req.booking
+	}
+	for i, m := range MatchBatch(ctx, fleet, bookings) {
+		b.deliver(pending[i], m)
+	}
+}
+
+// deliver hands a match to the booking waiting for it, or gives the vehicle
+// back if the booking stopped waiting
+func (b *batcher) deliver(req *batchRequest, m BatchMatch) {
+	b.mu.Lock()
+	gone := req.gone
+	if !gone {
+		req.reply <- m
+	}
+	b.mu.Unlock()
+
+	if gone && m.Err == nil {
+		release(m.Vehicle.VehicleNo, req.booking)
+	}
+}
+
+// release frees a vehicle claimed for a booking that was never stored
+func release(vehicleNo string, booking models.Booking) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := unloadVehicle(ctx, vehicleNo, booking); err != nil {
+		fmt.Printf("Error releasing vehicle %s: %v\n", vehicleNo, err)
+	}
+}
+
+// MatchBatch gives bookings distinct free vehicles from store with the least
+// total pickup distance, one vehicle type at a time, and claims all the
+// vehicles of a type together. The matches are in the order of the bookings.
+func MatchBatch(ctx context.Context, store Store, bookings []models.Booking) []BatchMatch {
+	var types []string
+	byType := map[string][]int{}
+	for i, b := range bookings {
+		if _, ok := byType[b.VehicleType]; !ok {
+			types = append(types, b.VehicleType)
+		}
+		byType[b.VehicleType] = append(byType[b.VehicleType], i)
+	}
+
+	matches := make([]BatchMatch, len(bookings))
+	for _, vehicleType := range types {
+		indexes := byType[vehicleType]
+		group := make([]models.Booking, len(indexes))
+		for j, i := range indexes {
+			group[j] = bookings[i]
+		}
+		for j, m := range matchGroup(ctx, store, group) {
+			matches[indexes[j]] = m
+		}
+	}
+	return matches
+}
+
+// matchGroup matches bookings of one vehicle type and claims their vehicles
+// together, so either every booking in the group gets the vehicle it was
+// matched with or, if another booking took one of them first, the group is
+// matched again among the vehicles still free.
+func matchGroup(ctx context.Context, store Store, group []models.Booking) []BatchMatch {
+	for attempt := 0; attempt < claimAttempts; attempt++ {
+		matches, err := solveBatch(ctx, store, group)
+		if err != nil {
+			return failedGroup(len(group), err)
+		}
+
+		var vehicles []models.Vehicle
+		for _, m := range matches {
+			if m.Err == nil {
+				vehicles = append(vehicles, m.Vehicle)
+			}
+		}
+		if len(vehicles) == 0 {
+			return matches
+		}
+		err = store.Claim(ctx, vehicles)
+		if err == errVehicleTaken {
+			continue
+		} else if err != nil {
+			return failedGroup(len(group), err)
+		}
+		return matches
+	}
+	return failedGroup(len(group), errNoVehicles)
+}
+
+// failedGroup returns matches failing every booking of a group with err
+func failedGroup(n int, err error) []BatchMatch {
+	matches := make([]BatchMatch, n)
+	for i := range matches {
+		matches[i] = BatchMatch{Err: err}
+	}
+	return matches
+}
+
+// solveBatch finds the best assignment of free vehicles to the bookings in
+// group, which all want the same vehicle type. The vehicles are not claimed.
+func solveBatch(ctx context.Context, store Store, group []models.Booking) ([]BatchMatch, error) {
+	// Each booking considers enough vehicles to still have its usual choice
+	// after every other booking in the batch has taken one of them
+	limit := config.App.Dispatch.Candidates + len(group) - 1
+
+	var vehicles []models.Vehicle
+	columns := map[primitive.ObjectID]int{}
+	nearby := make([]map[int]float64, len(group))
+	for i, booking := range group {
+		found, err := nearestFree(ctx, store, booking.VehicleType, booking.PickupLocation, limit)
+		if err != nil {
+			return nil, err
+		}
+		nearby[i] = map[int]float64{}
+		for _, v := range found {
+			col, ok := columns[v.ID]
+			if !ok {
+				col = len(vehicles)
+				columns[v.ID] = col
+				vehicles = append(vehicles, v.Vehicle)
+			}
+			nearby[i][col] = v.Distance
+		}
+	}
+
+	numbers := make([]string, len(vehicles))
+	for i, v := range vehicles {
+		numbers[i] = v.VehicleNo
+	}
+	drivers, err := store.Drivers(ctx, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	// A booking can only have vehicles near it that somebody is assigned to drive
+	cost := make([][]float64, len(group))
+	for i := range group {
+		cost[i] = make([]float64, len(vehicles))
+		for col, v := range vehicles {
+			d, ok := nearby[i][col]
+			if _, driven := drivers[v.VehicleNo]; !ok || !driven {
+				d = math.Inf(1)
+			}
+			cost[i][col] = d
+		}
+	}
+	assigned := matching.Assign(cost)
+
+	matches := make([]BatchMatch, len(group))
+	for i, booking := range group {
+		if assigned[i] < 0 {
+			matches[i] = BatchMatch{Err: errNoVehicles}
+			continue
+		}
+		v := vehicles[assigned[i]]
+		matches[i] = BatchMatch{
+			Vehicle:    v,
+			Assignment: drivers[v.VehicleNo],
+			Distance:   cost[i][assigned[i]],
+			Decision:   batchDecision(booking, vehicles, nearby[i], drivers, assigned[i]),
+		}
+	}
+	return matches, nil
+}
+
+// vehicleClaim is a free vehicle to mark busy, with any other fields to set on it
+type vehicleClaim struct {
+	id  primitive.ObjectID
+	set bson.M
+}
+
+func (c vehicleClaim) update() bson.M {
+	set := bson.M{"busy": true}
+	for k, v := range c.set {
+		set[k] = v
+	}
+	return bson.M{"$set": set, "$currentDate": bson.M{"updated_at": true}}
+}
+
+// undo frees a claimed vehicle and removes the other fields set by the claim
+func (c vehicleClaim) undo() bson.M {
+	update := bson.M{"$set": bson.M{"busy": false}, "$currentDate": bson.M{"updated_at": true}}
+	if len(c.set) > 0 {
+		unset := bson.M{}
+		for k := range c.set {
+			unset[k] = ""
+		}
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// claimVehicles marks every vehicle busy, or none of them if any was taken
+// first. The claims are made in one transaction where the database supports
+// it; otherwise claims already made are undone when one fails.
+func claimVehicles(ctx context.Context, claims []vehicleClaim) error {
+	_, err := db.Transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		vehicles := db.GetCollection("vehicles")
+		for _, c := range claims {
+			result, err := vehicles.UpdateOne(sc, bson.M{"_id": c.id, "busy": false, "online": true}, c.update())
+			if err != nil {
+				return nil, err
+			}
+			if result.ModifiedCount != 1 {
+				return nil, errVehicleTaken
+			}
+		}
+		return nil, nil
+	})
+
+	if err == db.ErrNoTransactions {
+		return claimEach(ctx, claims)
+	}
+	return err
+}
+
+// claimEach claims vehicles one at a time, undoing the claims already made if
+// one of the vehicles was taken first
+func claimEach(ctx context.Context, claims []vehicleClaim) error {
+	vehicles := db.GetCollection("vehicles")
+	for i, c := range claims {
+		result, err := vehicles.UpdateOne(ctx, bson.M{"_id": c.id, "busy": false, "online": true}, c.update())
+		if err == nil && result.ModifiedCount == 1 {
+			continue
+		}
+		for _, made := range claims[:i] {
+			if _, undoErr := vehicles.UpdateOne(ctx, bson.M{"_id": made.id}, made.undo()); undoErr != nil {
+				fmt.Printf("Error undoing vehicle claim: %v\n", undoErr)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("claiming vehicle: %w", err)
+		}
+		return errVehicleTaken
+	}
+	return nil
+}
+
+// batchDecision describes the vehicles a batched booking could have had and
+// the one the batch gave it. Candidates are scored by pickup distance; a
+// nearer one may have gone to another booking in the batch.
+func batchDecision(booking models.Booking, vehicles []models.Vehicle, nearby map[int]float64, drivers map[string]models.Assignment, chosen int) *models.DispatchDecision {
+	decision := models.DispatchDecision{
+		BookingID:   booking.ID,
+		Strategy:    dispatch.BatchMatching,
+		VehicleType: booking.VehicleType,
+		Pickup:      booking.PickupLocation,
+		VehicleNo:   vehicles[chosen].VehicleNo,
+		DriverID:    drivers[vehicles[chosen].VehicleNo].UID,
+		CreatedAt:   time.Now(),
+	}
+	for col, d := range nearby {
+		v := vehicles[col]
+		a, ok := drivers[v.VehicleNo]
+		if !ok {
+			continue
+		}
+		decision.Candidates = append(decision.Candidates, models.DispatchCandidate{
+			VehicleNo:  v.VehicleNo,
+			DriverID:   a.UID,
+			Distance:   d,
+			Rating:     a.Rating,
+			AssignedAt: a.AssignedAt,
+			Score:      d,
+			Chosen:     col == chosen,
+		})
+	}
+	sort.Slice(decision.Candidates, func(i, j int) bool { return decision.Candidates[i].Distance < decision.Candidates[j].Distance })
+	return &decision
+}
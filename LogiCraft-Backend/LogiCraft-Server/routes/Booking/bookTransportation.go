@@ -1,156 +1,457 @@
-package booking
-
-import (
-	"context"
-	"encoding/json"
-	"math"
-	"net/http"
-	"time"
-
-	"logi-craft/db"
-	"logi-craft/models"
-	"logi-craft/utils"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-type BookingRequest struct {
-	UserID        string             `json:"user_id"`
-	VehicleType   string             `json:"vehicle_type"`
-	PickupCoords  models.Coordinates `json:"pickup_coords"`
-	DropoffCoords models.Coordinates `json:"dropoff_coords"`
-	Distance      float64            `json:"distance"`
-	Cost          float64            `json:"estimatedCost"`
-}
-
-var vehiclesCollection *mongo.Collection
-var bookingsCollection *mongo.Collection
-
-// Setup MongoDB collections
-func InitDB(client *mongo.Client) {
-	vehiclesCollection = db.GetCollection("vehicles")
-	bookingsCollection = db.GetCollection("bookings")
-}
-
-// Handle booking request
-func HandleBooking(w http.ResponseWriter, r *http.Request) {
-	var req BookingRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
-
-	// Parse user_id from string to ObjectID
-	userID, err := primitive.ObjectIDFromHex(req.UserID)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
-
-	filter := bson.M{
-		"vehicle_type": req.VehicleType,
-		"busy":         false,
-	}
-
-	var availableVehicles []models.Vehicle
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	vehiclesCollection = db.GetCollection("vehicles")
-
-	// Fetch available vehicles
-	cursor, err := vehiclesCollection.Find(ctx, filter)
-	if err != nil {
-		http.Error(w, "Error fetching vehicles", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	if err = cursor.All(ctx, &availableVehicles); err != nil {
-		http.Error(w, "Error decoding vehicles", http.StatusInternalServerError)
-		return
-	}
-
-	if len(availableVehicles) == 0 {
-
-		http.Error(w, "No available vehicles found", http.StatusNotFound)
-		return
-	}
-
-	// Calculate distance and find the closest vehicle
-	var closestVehicle models.Vehicle
-	var shortestDistance float64 = math.Inf(1)
-
-	for _, vehicle := range availableVehicles {
-		distance := utils.HaversineDistance(
-			req.PickupCoords.Latitude, req.PickupCoords.Longitude,
-			vehicle.Coordinates.Latitude, vehicle.Coordinates.Longitude,
-		)
-		if distance < shortestDistance {
-			shortestDistance = distance
-			closestVehicle = vehicle
-		}
-	}
-
-	// Get the DriverID from the Assignments collection
-	var assignment models.Assignment
-	assignmentFilter := bson.M{"vehicle_no": closestVehicle.VehicleNo}
-	err = db.GetCollection("assignments").FindOne(ctx, assignmentFilter).Decode(&assignment)
-	if err != nil {
-		http.Error(w, "Error finding assignment for vehicle", http.StatusInternalServerError)
-		return
-	}
-
-	newBooking := models.Booking{
-		UserID:          userID,
-		VehicleNo:       closestVehicle.VehicleNo,
-		DriverID:        assignment.UID,
-		PickupLocation:  req.PickupCoords,
-		DropoffLocation: req.DropoffCoords,
-		Distance:        req.Distance,
-		Cost:            req.Cost,
-		JobStatus:       "in-transit",
-	}
-
-	bookingsCollection = db.GetCollection("bookings")
-
-	// Insert the new booking and capture the result
-	insertResult, err := bookingsCollection.InsertOne(ctx, newBooking)
-	if err != nil {
-		http.Error(w, "Error creating booking", http.StatusInternalServerError)
-		return
-	}
-
-	bookingID := insertResult.InsertedID.(primitive.ObjectID)
-
-	// Update the assignment collection with the new booking ID
-	assignmentUpdate := bson.M{
-		"$set": bson.M{
-			"booking_id": bookingID.Hex(), // Convert ObjectID to string and set it in assignment
-		},
-	}
-
-	_, err = db.GetCollection("assignments").UpdateOne(ctx, bson.M{"uid": assignment.UID}, assignmentUpdate)
-	if err != nil {
-		http.Error(w, "Error updating assignment with booking ID", http.StatusInternalServerError)
-		return
-	}
-
-	// Mark the vehicle as busy
-	update := bson.M{"$set": bson.M{"busy": true}}
-	_, err = vehiclesCollection.UpdateOne(ctx, bson.M{"_id": closestVehicle.ID}, update)
-	if err != nil {
-		http.Error(w, "Error updating vehicle status", http.StatusInternalServerError)
-		return
-	}
-
-	// Send a success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"booking": newBooking,
-	})
-}
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/payments"
+	areas "logi-craft/routes/Areas"
+	dispatch "logi-craft/routes/Dispatch"
+	promo "logi-craft/routes/Promo"
+	timeline "logi-craft/routes/Timeline"
+	tracking "logi-craft/routes/Tracking"
+	wallet "logi-craft/routes/Wallet"
+	"logi-craft/routing"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BookingRequest struct {
+	UserID        string              `json:"user_id"`
+	VehicleType   string              `json:"vehicle_type"`
+	PickupCoords  models.Coordinates  `json:"pickup_coords"`
+	DropoffCoords models.Coordinates  `json:"dropoff_coords"`
+	Distance      float64             `json:"distance"`
+	Cost          float64             `json:"estimatedCost"` // the client's estimate; the fare is always priced on the server
+	Cargo         string              `json:"cargo"`
+	Load          *models.CargoLoad   `json:"load"`         // cargo volume and weight, required for shared bookings
+	Shared        bool                `json:"shared"`       // part-load booking that can share its vehicle with others
+	Sender        *models.Contact     `json:"sender"`       // who hands the goods over, if not the customer
+	Receiver      *models.Contact     `json:"receiver"`     // who takes delivery
+	ScheduledAt   *time.Time          `json:"scheduled_at"` // leave empty to dispatch straight away
+	PromoCode     string              `json:"promo_code"`
+	PaymentMethod string              `json:"payment_method"` // wallet or card
+	PaymentSource string              `json:"payment_source"` // card token for card payments
+	AcceptUpgrade bool                `json:"accept_upgrade"` // take a larger vehicle if none of vehicle_type is free
+	UpgradeTo     string              `json:"upgrade_to"`     // take this larger vehicle type, offered earlier, if none of vehicle_type is free
+	RecurringID   *primitive.ObjectID `json:"-"`              // set when generated from a recurring series
+
+	planned *PlannedVehicle // set when committed from a route plan
+}
+
+// BookingError is a booking request that was refused, with the HTTP status to
+// report it with. Upgrades lists the larger vehicles the customer could book instead.
+type BookingError struct {
+	Status   int
+	Message  string
+	Upgrades []UpgradeOffer
+}
+
+func (e *BookingError) Error() string {
+	return e.Message
+}
+
+// errNoVehicles is returned when no free vehicle of the requested type exists
+var errNoVehicles = &BookingError{Status: http.StatusNotFound, Message: "No available vehicles found"}
+
+// BookingResult is a newly created booking. PickupDistance and ETA are only
+// set when a vehicle was dispatched straight away.
+type BookingResult struct {
+	Booking        models.Booking
+	PickupDistance float64
+	ETA            *models.BookingETA
+}
+
+var vehiclesCollection *mongo.Collection
+var bookingsCollection *mongo.Collection
+
+// Setup MongoDB collections
+func InitDB(client *mongo.Client) {
+	vehiclesCollection = db.GetCollection("vehicles")
+	bookingsCollection = db.GetCollection("bookings")
+}
+
+// Handle booking request
+func HandleBooking(w http.ResponseWriter, r *http.Request) {
+	var req BookingRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := CreateBooking(ctx, req)
+	if err != nil {
+		writeBookingError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"booking": result.Booking,
+	}
+	if result.ETA != nil {
+		response["pickup_distance"] = result.PickupDistance
+		response["eta"] = result.ETA
+	}
+
+	// Send a success response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeBookingError reports a CreateBooking failure to the client
+func writeBookingError(w http.ResponseWriter, err error) {
+	var bookingErr *BookingError
+	if errors.As(err, &bookingErr) {
+		if len(bookingErr.Upgrades) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(bookingErr.Status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success":  false,
+				"message":  bookingErr.Message,
+				"upgrades": bookingErr.Upgrades,
+			})
+			return
+		}
+		http.Error(w, bookingErr.Message, bookingErr.Status)
+		return
+	}
+	fmt.Printf("Error creating booking: %v\n", err)
+	http.Error(w, "Error creating booking", http.StatusInternalServerError)
+}
+
+// CreateBooking prices a booking request, secures payment and stores the booking.
+// Bookings scheduled further ahead than the dispatch lead time are stored as
+// "scheduled" and get a vehicle later from DispatchDue; all others are given the
+// closest free vehicle straight away.
+func CreateBooking(ctx context.Context, req BookingRequest) (*BookingResult, error) {
+	req.planned = nil
+	return createBooking(ctx, req)
+}
+
+func createBooking(ctx context.Context, req BookingRequest) (*BookingResult, error) {
+	// Parse user_id from string to ObjectID
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return nil, &BookingError{Status: http.StatusBadRequest, Message: "Invalid user ID"}
+	}
+
+	if _, ok := utils.VehicleRates[req.VehicleType]; !ok {
+		return nil, &BookingError{Status: http.StatusBadRequest, Message: "Invalid vehicle type"}
+	}
+	if req.UpgradeTo != "" && len(upgradeOptions(req.VehicleType, []string{req.UpgradeTo}, upgradeChoice{vehicleType: req.UpgradeTo})) == 0 {
+		return nil, &BookingError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Vehicle type %s cannot take %s bookings", req.UpgradeTo, req.VehicleType)}
+	}
+	area, err := areas.Check(ctx, req.PickupCoords, req.DropoffCoords, req.VehicleType)
+	if err != nil {
+		return nil, err
+	}
+	if !area.Serviceable {
+		return nil, &BookingError{Status: http.StatusBadRequest, Message: area.Reason}
+	}
+	if err := checkLoad(req.VehicleType, req.Shared, req.Load); err != nil {
+		return nil, err
+	}
+	if err := cleanContact(req.Sender, "sender"); err != nil {
+		return nil, err
+	}
+	if err := cleanContact(req.Receiver, "receiver"); err != nil {
+		return nil, err
+	}
+
+	// Price the trip on the server as GetQuote does, so promo discounts are
+	// worked out from a fare the client cannot set
+	trip := routing.Between(req.PickupCoords, req.DropoffCoords).Distance
+	distance := req.Distance
+	if distance == 0 {
+		distance = trip
+	}
+	fare := utils.CalculateFare(trip, req.VehicleType)
+
+	// Shared bookings pay for the part of the vehicle their cargo takes up
+	var share float64
+	if req.Shared {
+		share = fareShare(req.VehicleType, *req.Load)
+		fare = utils.RoundMoney(fare * share)
+	}
+
+	// Check the promo code up front so an invalid code fails before a vehicle is picked
+	var promoCode *models.PromoCode
+	var discount *models.Discount
+	cost := fare
+	if req.PromoCode != "" {
+		var amount float64
+		promoCode, amount, err = promo.Validate(ctx, req.PromoCode, userID, req.VehicleType, fare)
+		if promo.IsRejection(err) {
+			return nil, &BookingError{Status: http.StatusBadRequest, Message: err.Error()}
+		} else if err != nil {
+			return nil, fmt.Errorf("validating promo code: %w", err)
+		}
+		discount = &models.Discount{PromoID: promoCode.ID, Code: promoCode.Code, Amount: amount}
+		cost = utils.RoundMoney(fare - amount)
+	}
+
+	now := time.Now()
+	newBooking := models.Booking{
+		ID:              primitive.NewObjectID(), // known before dispatch so the decision can refer to it
+		UserID:          userID,
+		VehicleType:     req.VehicleType,
+		PickupLocation:  req.PickupCoords,
+		DropoffLocation: req.DropoffCoords,
+		Distance:        distance,
+		Cargo:           req.Cargo,
+		Load:            req.Load,
+		Shared:          req.Shared,
+		Sender:          req.Sender,
+		Receiver:        req.Receiver,
+		RecurringID:     req.RecurringID,
+		AcceptUpgrade:   req.AcceptUpgrade,
+		BaseFare:        fare,
+		Discount:        discount,
+		FareShare:       share,
+		Cost:            cost,
+		JobStatus:       "in-transit",
+		CreatedAt:       now,
+	}
+
+	scheduled := req.ScheduledAt != nil && req.ScheduledAt.After(now.Add(config.App.Dispatch.ScheduleLead()))
+
+	// Shared bookings are not batched; they are matched against vehicles already on the road
+	batched := !scheduled && !newBooking.Shared && req.planned == nil && config.App.Dispatch.BatchWindow() > 0
+
+	var closestVehicle models.Vehicle
+	var assignment models.Assignment
+	var shortestDistance float64
+	var decision *models.DispatchDecision
+	claimed := false // the vehicle was claimed for this booking, so is given back if it fails
+	if scheduled {
+		newBooking.JobStatus = "scheduled"
+		newBooking.ScheduledAt = req.ScheduledAt
+	} else if req.planned != nil {
+		closestVehicle, assignment = req.planned.Vehicle, req.planned.Assignment
+		shortestDistance = routing.Between(closestVehicle.Coordinates, req.PickupCoords).Distance
+		newBooking.VehicleNo = closestVehicle.VehicleNo
+		newBooking.DriverID = assignment.UID
+		newBooking.PlanID = &req.planned.PlanID
+		newBooking.PlanDelivery = &req.planned.Delivery
+
+		// The plan already knows when the vehicle reaches each stop on its route
+		eta := req.planned.ETA
+		newBooking.InitialETA = &eta
+		newBooking.ETA = &eta
+	} else {
+		if batched {
+			closestVehicle, assignment, shortestDistance, decision, err = matchInBatch(ctx, newBooking)
+		} else {
+			closestVehicle, assignment, shortestDistance, decision, err = findVehicle(ctx, newBooking)
+		}
+		if err == errNoVehicles {
+			// An upgraded vehicle is claimed like any unbatched one
+			choice := upgradeChoice{accept: req.AcceptUpgrade, vehicleType: req.UpgradeTo}
+			closestVehicle, assignment, shortestDistance, decision, err = upgradeVehicle(ctx, &newBooking, area.VehicleTypes, choice)
+			cost = newBooking.Cost
+		}
+		if err != nil {
+			return nil, err
+		}
+		claimed = true
+		newBooking.VehicleNo = closestVehicle.VehicleNo
+		newBooking.DriverID = assignment.UID
+
+		// Estimate pickup and dropoff times from the closest vehicle's position
+		eta := tracking.Estimate(newBooking.VehicleType, closestVehicle.Coordinates, newBooking, now)
+		newBooking.InitialETA = &eta
+		newBooking.ETA = &eta
+	}
+
+	// The vehicle is claimed by findVehicle, matchInBatch or upgradeVehicle, so give it back
+	// if the booking fails. A planned vehicle is given back by the plan.
+	unload := func() {
+		if claimed {
+			unloadVehicle(ctx, closestVehicle.VehicleNo, newBooking)
+		}
+	}
+
+	// Count the redemption atomically; concurrent bookings cannot push the code past its limits
+	if promoCode != nil {
+		err = promo.Redeem(ctx, promoCode, userID)
+		if err != nil {
+			unload()
+		}
+		if promo.IsRejection(err) {
+			return nil, &BookingError{Status: http.StatusConflict, Message: err.Error()}
+		} else if err != nil {
+			return nil, fmt.Errorf("redeeming promo code: %w", err)
+		}
+	}
+
+	// Secure the fare before the vehicle is dispatched
+	newBooking.Payment, err = wallet.Authorize(ctx, userID, req.PaymentMethod, req.PaymentSource, cost)
+	if err != nil {
+		if promoCode != nil {
+			promo.Release(ctx, promoCode, userID)
+		}
+		unload()
+		switch err {
+		case wallet.ErrPaymentRequired, wallet.ErrUnknownMethod:
+			return nil, &BookingError{Status: http.StatusBadRequest, Message: err.Error()}
+		case wallet.ErrInsufficientFunds, payments.ErrDeclined:
+			return nil, &BookingError{Status: http.StatusPaymentRequired, Message: err.Error()}
+		}
+		return nil, fmt.Errorf("authorising payment: %w", err)
+	}
+
+	bookingsCollection = db.GetCollection("bookings")
+
+	// Insert the new booking and capture the result
+	insertResult, err := bookingsCollection.InsertOne(ctx, newBooking)
+	if err != nil {
+		if promoCode != nil {
+			promo.Release(ctx, promoCode, userID)
+		}
+		wallet.Void(ctx, userID, newBooking.Payment)
+		unload()
+		return nil, fmt.Errorf("inserting booking: %w", err)
+	}
+
+	newBooking.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+	details := map[string]interface{}{
+		"vehicle_type": newBooking.VehicleType,
+		"cost":         newBooking.Cost,
+		"scheduled_at": newBooking.ScheduledAt,
+	}
+	if newBooking.UpgradedFrom != "" {
+		details["upgraded_from"] = newBooking.UpgradedFrom
+	}
+	timeline.Record(ctx, newBooking.ID, models.EventCreated, timeline.Actor(models.ActorCustomer, userID), details)
+	notifyBooked(ctx, newBooking)
+
+	result := &BookingResult{Booking: newBooking}
+	if scheduled {
+		return result, nil
+	}
+
+	if err := commitAssignment(ctx, newBooking, closestVehicle, assignment, shortestDistance); err != nil {
+		abandonBooking(newBooking)
+		return nil, err
+	}
+	// Only now is the decision certain to have been carried out
+	if decision != nil {
+		dispatch.Record(ctx, *decision)
+	}
+	result.PickupDistance = shortestDistance
+	result.ETA = newBooking.ETA
+	return result, nil
+}
+
+// PickVehicle returns the free vehicle of the booking's type in store chosen
+// for it at now by the configured dispatch strategy, together with its driver
+// assignment, distance from the pickup point in km and the decision to record
+// once the booking has it. The vehicle is not claimed.
+func PickVehicle(ctx context.Context, store Store, booking models.Booking, now time.Time) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	nearby, err := nearestFree(ctx, store, booking.VehicleType, booking.PickupLocation, config.App.Dispatch.Candidates)
+	if err != nil {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, err
+	}
+	if len(nearby) == 0 {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+	}
+
+	numbers := make([]string, len(nearby))
+	for i, v := range nearby {
+		numbers[i] = v.VehicleNo
+	}
+	drivers, err := store.Drivers(ctx, numbers)
+	if err != nil {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, err
+	}
+
+	var candidates []dispatch.Candidate
+	for _, v := range nearby {
+		// A vehicle nobody is assigned to drive cannot take the booking
+		if a, ok := drivers[v.VehicleNo]; ok {
+			candidates = append(candidates, dispatch.Candidate{Vehicle: v.Vehicle, Assignment: a, Distance: v.Distance})
+		}
+	}
+	if len(candidates) == 0 {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+	}
+
+	chosen, decision, err := dispatch.Decide(ctx, booking, candidates, now)
+	if err != nil {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, err
+	}
+	return chosen.Vehicle, chosen.Assignment, chosen.Distance, &decision, nil
+}
+
+// driversOf returns the driver assignments of the given vehicles by vehicle number
+func driversOf(ctx context.Context, vehicleNos []string) (map[string]models.Assignment, error) {
+	cursor, err := db.GetCollection("assignments").Find(ctx, bson.M{"vehicle_no": bson.M{"$in": vehicleNos}})
+	if err != nil {
+		return nil, fmt.Errorf("finding assignments for vehicles: %w", err)
+	}
+	var assignments []models.Assignment
+	if err := cursor.All(ctx, &assignments); err != nil {
+		return nil, fmt.Errorf("decoding assignments: %w", err)
+	}
+	drivers := make(map[string]models.Assignment, len(assignments))
+	for _, a := range assignments {
+		drivers[a.VehicleNo] = a
+	}
+	return drivers, nil
+}
+
+// commitAssignment links a stored booking to its driver and records the
+// assignment on the timeline. The vehicle must already have been claimed for
+// the booking. A vehicle carrying shared loads keeps the latest booking on its
+// assignment.
+func commitAssignment(ctx context.Context, booking models.Booking, vehicle models.Vehicle, assignment models.Assignment, pickupDistance float64) error {
+	// Update the assignment collection with the new booking ID
+	assignmentUpdate := bson.M{
+		"$set": bson.M{
+			"booking_id":  booking.ID.Hex(), // Convert ObjectID to string and set it in assignment
+			"assigned_at": time.Now(),
+		},
+	}
+
+	_, err := db.GetCollection("assignments").UpdateOne(ctx, bson.M{"uid": assignment.UID}, assignmentUpdate)
+	if err != nil {
+		return fmt.Errorf("updating assignment with booking ID: %w", err)
+	}
+
+	details := map[string]interface{}{
+		"vehicle_no":      booking.VehicleNo,
+		"driver_id":       booking.DriverID.Hex(),
+		"pickup_distance": pickupDistance,
+	}
+	if booking.Shared {
+		details["shared"] = true
+		details["load"] = booking.Load
+	}
+	if booking.PlanID != nil {
+		details["plan_id"] = booking.PlanID.Hex()
+	}
+	timeline.Record(ctx, booking.ID, models.EventAssigned, models.EventActor{Role: models.ActorSystem}, details)
+
+	if booking.ETA != nil {
+		if err := tracking.Record(ctx, booking, vehicle.Coordinates, *booking.ETA); err != nil {
+			fmt.Printf("Error recording ETA for booking %s: %v\n", booking.ID.Hex(), err)
+		}
+	}
+	return nil
+}
@@ -1,252 +1,318 @@
-package booking
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"time"
-
-	"logi-craft/db"
-	"logi-craft/models"
-	"logi-craft/utils"
-
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-type BookingResponse struct {
-	Success bool           `json:"success"`
-	Message string         `json:"message"`
-	Booking models.Booking `json:"booking,omitempty"`
-}
-
-type BookingsResponse struct {
-	Success  bool             `json:"success"`
-	Message  string           `json:"message"`
-	Bookings []models.Booking `json:"bookings,omitempty"`
-}
-
-func GetAllBookings(w http.ResponseWriter, r *http.Request) {
-	collection := db.GetCollection("bookings")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Get all Bookings from Bookings collection
-	cursor, err := collection.Find(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, "Failed to fetch bookings", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	var bookings []bson.M
-	if err := cursor.All(ctx, &bookings); err != nil {
-		http.Error(w, "Failed to parse bookings", http.StatusInternalServerError)
-		return
-	}
-
-	// Send all booking data
-	json.NewEncoder(w).Encode(bookings)
-}
-
-// GetBookingDetails retrieves booking details by booking ID
-func GetBookingByID(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Booking details requested")
-	params := mux.Vars(r)
-	bookingId := params["bookingId"]
-	if bookingId == "" {
-		http.Error(w, "Missing Booking ID parameter", http.StatusBadRequest)
-		return
-	}
-	id, err := primitive.ObjectIDFromHex(bookingId)
-	collection := db.GetCollection("bookings")
-	filter := bson.M{"_id": id}
-	var booking models.Booking
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	err = collection.FindOne(ctx, filter).Decode(&booking)
-	if err == mongo.ErrNoDocuments {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(BookingResponse{Success: false, Message: "Booking not found"})
-		return
-	} else if err != nil {
-		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(BookingResponse{
-		Success: true,
-		Message: "Booking retrieved successfully",
-		Booking: booking,
-	})
-}
-
-// GetBookingsByUID retrieves bookings by user UID
-func GetBookingsByUID(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Bookings for user requested")
-	params := mux.Vars(r)
-	uid := params["uid"]
-	if uid == "" {
-		http.Error(w, "Missing UID parameter", http.StatusBadRequest)
-		return
-	}
-	id, err := primitive.ObjectIDFromHex(uid)
-	collection := db.GetCollection("bookings")
-	filter := bson.M{"user_id": id}
-	var bookings []models.Booking
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Find all bookings that match the uid
-	cursor, err := collection.Find(ctx, filter)
-	if err != nil {
-		http.Error(w, "Failed to fetch bookings", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	// Decode the bookings into the slice
-	if err = cursor.All(ctx, &bookings); err != nil {
-		http.Error(w, "Error decoding bookings", http.StatusInternalServerError)
-		return
-	}
-
-	if len(bookings) == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(BookingsResponse{Success: false, Message: "No bookings found for this user"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(BookingsResponse{
-		Success:  true,
-		Message:  "Bookings retrieved successfully",
-		Bookings: bookings,
-	})
-}
-
-// GetBookingsByDriverID retrieves bookings by user DriverID
-func GetBookingsByDriverID(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Bookings for user requested")
-	params := mux.Vars(r)
-	driver_id := params["driverId"]
-	if driver_id == "" {
-		http.Error(w, "Missing DriverId parameter", http.StatusBadRequest)
-		return
-	}
-
-	id, err := primitive.ObjectIDFromHex(driver_id)
-	collection := db.GetCollection("bookings")
-	filter := bson.M{"driver_id": id}
-	var bookings []models.Booking
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Find all bookings that match the uid
-	cursor, err := collection.Find(ctx, filter)
-	if err != nil {
-		http.Error(w, "Failed to fetch bookings", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	// Decode the bookings into the slice
-	if err = cursor.All(ctx, &bookings); err != nil {
-		http.Error(w, "Error decoding bookings", http.StatusInternalServerError)
-		return
-	}
-
-	if len(bookings) == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(BookingsResponse{Success: false, Message: "No bookings found for this user"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(BookingsResponse{
-		Success:  true,
-		Message:  "Bookings retrieved successfully",
-		Bookings: bookings,
-	})
-}
-
-func CompleteJobHandler(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Completing job")
-
-	bookingCollection := db.GetCollection("bookings")
-	vehicleCollection := db.GetCollection("vehicles")
-	assignmentCollection := db.GetCollection("assignments")
-
-	vars := mux.Vars(r)
-	bookingID := vars["bookingId"]
-
-	id, err := primitive.ObjectIDFromHex(bookingID)
-	// fmt.Println(bookingID)
-
-	// Fetch booking details using booking ID
-	var booking models.Booking
-	err = bookingCollection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&booking)
-	if err != nil {
-		fmt.Println(err)
-		http.Error(w, `{"message": "Booking not found"}`, http.StatusNotFound)
-		return
-	}
-	// fmt.Println(booking)
-
-	// Fetch vehicle details using vehicle number
-	var vehicle models.Vehicle
-	err = vehicleCollection.FindOne(r.Context(), bson.M{"vehicle_no": booking.VehicleNo}).Decode(&vehicle)
-	if err != nil {
-		http.Error(w, `{"message": "Vehicle not found"}`, http.StatusNotFound)
-		return
-	}
-
-	// Calculate distance using the HaversineDistance function
-	distance := utils.HaversineDistance(vehicle.Coordinates.Latitude, vehicle.Coordinates.Longitude, booking.DropoffLocation.Latitude, booking.DropoffLocation.Longitude)
-
-	if distance <= 5.0 {
-		// Update job status to completed
-		_, err = bookingCollection.UpdateOne(r.Context(), bson.M{"_id": booking.ID}, bson.M{
-			"$set": bson.M{"job_status": "completed"},
-		})
-		if err != nil {
-			http.Error(w, `{"message": "Failed to update job status"}`, http.StatusInternalServerError)
-			return
-		}
-
-		// Mark vehicle as not busy
-		_, err = vehicleCollection.UpdateOne(r.Context(), bson.M{"_id": vehicle.ID}, bson.M{
-			"$set": bson.M{"busy": false},
-		})
-		if err != nil {
-			http.Error(w, `{"message": "Failed to complete job"}`, http.StatusInternalServerError)
-			return
-		}
-
-		// Clear booking ID in assignment collection
-		_, err = assignmentCollection.UpdateOne(r.Context(), bson.M{"vehicle_no": booking.VehicleNo}, bson.M{
-			"$set": bson.M{"booking_id": ""},
-		})
-		if err != nil {
-			http.Error(w, "Failed to update assignment", http.StatusInternalServerError)
-			return
-		}
-
-		// Respond with success message
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode("Job marked as completed")
-	} else {
-		// Send message that vehicle is out of range
-		http.Error(w, `{"message": "You are not in range of the delivery location"}`, http.StatusBadRequest)
-
-	}
-}
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+	earnings "logi-craft/routes/Earnings"
+	invoice "logi-craft/routes/Invoice"
+	timeline "logi-craft/routes/Timeline"
+	wallet "logi-craft/routes/Wallet"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BookingResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Booking models.Booking `json:"booking,omitempty"`
+}
+
+type BookingsResponse struct {
+	Success    bool             `json:"success"`
+	Message    string           `json:"message"`
+	Bookings   []models.Booking `json:"bookings"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// GetAllBookings retrieves a page of bookings. See parseListQuery for the supported query parameters.
+func GetAllBookings(w http.ResponseWriter, r *http.Request) {
+	listBookings(w, r, bson.M{})
+}
+
+// listBookings writes one page of the bookings matching base and the request's query parameters.
+// Contact phone numbers are masked unless the request carries the device token of the customer who made the booking.
+func listBookings(w http.ResponseWriter, r *http.Request, base bson.M) {
+	query, err := parseListQuery(r.URL.Query(), base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collection := db.GetCollection("bookings")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter, opts := query.findArgs()
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch bookings", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	bookings := []models.Booking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		http.Error(w, "Error decoding bookings", http.StatusInternalServerError)
+		return
+	}
+
+	response := BookingsResponse{
+		Success: true,
+		Message: "Bookings retrieved successfully",
+	}
+
+	if int64(len(bookings)) > query.limit {
+		bookings = bookings[:query.limit]
+		last := bookings[len(bookings)-1]
+		next := pageCursor{ID: last.ID}
+		if query.sortField == "cost" {
+			next.Value = last.Cost
+		}
+		response.NextCursor = encodeCursor(next)
+	}
+	viewer := viewerOf(ctx, r)
+	for i := range bookings {
+		maskContacts(&bookings[i], viewer)
+	}
+	response.Bookings = bookings
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetBookingDetails retrieves booking details by booking ID. Contact numbers on
+// your own booking are unmasked when the request carries your device token.
+func GetBookingByID(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Booking details requested")
+	params := mux.Vars(r)
+	bookingId := params["bookingId"]
+	if bookingId == "" {
+		http.Error(w, "Missing Booking ID parameter", http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(bookingId)
+	collection := db.GetCollection("bookings")
+	filter := bson.M{"_id": id}
+	var booking models.Booking
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = collection.FindOne(ctx, filter).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BookingResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+	maskContacts(&booking, viewerOf(ctx, r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BookingResponse{
+		Success: true,
+		Message: "Booking retrieved successfully",
+		Booking: booking,
+	})
+}
+
+// GetBookingsByUID retrieves a page of bookings made by a user
+func GetBookingsByUID(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid := params["uid"]
+	if uid == "" {
+		http.Error(w, "Missing UID parameter", http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	listBookings(w, r, bson.M{"user_id": id})
+}
+
+// GetBookingsByDriverID retrieves a page of bookings assigned to a driver
+func GetBookingsByDriverID(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driver_id := params["driverId"]
+	if driver_id == "" {
+		http.Error(w, "Missing DriverId parameter", http.StatusBadRequest)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(driver_id)
+	if err != nil {
+		http.Error(w, "Invalid DriverId format", http.StatusBadRequest)
+		return
+	}
+
+	listBookings(w, r, bson.M{"driver_id": id})
+}
+
+func CompleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	// fmt.Println("Completing job")
+
+	bookingCollection := db.GetCollection("bookings")
+	vehicleCollection := db.GetCollection("vehicles")
+
+	vars := mux.Vars(r)
+	bookingID := vars["bookingId"]
+
+	id, err := primitive.ObjectIDFromHex(bookingID)
+	// fmt.Println(bookingID)
+
+	// Fetch booking details using booking ID
+	var booking models.Booking
+	err = bookingCollection.FindOne(r.Context(), bson.M{"_id": id}).Decode(&booking)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, `{"message": "Booking not found"}`, http.StatusNotFound)
+		return
+	}
+	// fmt.Println(booking)
+
+	// Fetch vehicle details using vehicle number
+	var vehicle models.Vehicle
+	err = vehicleCollection.FindOne(r.Context(), bson.M{"vehicle_no": booking.VehicleNo}).Decode(&vehicle)
+	if err != nil {
+		http.Error(w, `{"message": "Vehicle not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// Calculate distance using the HaversineDistance function
+	distance := utils.HaversineDistance(vehicle.Coordinates.Latitude, vehicle.Coordinates.Longitude, booking.DropoffLocation.Latitude, booking.DropoffLocation.Longitude)
+
+	if distance <= 5.0 {
+		// Complete the job only if it is still under way, so a repeated request
+		// cannot free the vehicle, charge or invoice a second time
+		completedAt := time.Now()
+		err = bookingCollection.FindOneAndUpdate(r.Context(),
+			bson.M{"_id": booking.ID, "job_status": "in-transit"},
+			bson.M{"$set": bson.M{"job_status": "completed", "completed_at": completedAt}}).Decode(&booking)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, `{"message": "Job is not in transit"}`, http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, `{"message": "Failed to update job status"}`, http.StatusInternalServerError)
+			return
+		}
+
+		// Unload the cargo and free the vehicle and driver unless other shared bookings are still on board.
+		// The job is complete by now, so it is still charged and invoiced if this fails.
+		if err := unloadVehicle(r.Context(), vehicle.VehicleNo, booking); err != nil {
+			fmt.Printf("Error freeing vehicle %s for booking %s: %v\n", vehicle.VehicleNo, booking.ID.Hex(), err)
+		}
+
+		booking.CompletedAt = &completedAt
+		timeline.Record(r.Context(), booking.ID, models.EventStatusChanged, timeline.Actor(models.ActorDriver, booking.DriverID), map[string]interface{}{
+			"from": booking.JobStatus,
+			"to":   "completed",
+		})
+
+		// Charge, credit the driver and invoice the completed booking; the job is already complete, so failures here are only logged
+		booking.JobStatus = "completed"
+		if err := wallet.Capture(r.Context(), booking); err != nil {
+			fmt.Printf("Error capturing payment for booking %s: %v\n", booking.ID.Hex(), err)
+		}
+		if err := earnings.RecordTrip(r.Context(), booking); err != nil {
+			fmt.Printf("Error recording driver earnings for booking %s: %v\n", booking.ID.Hex(), err)
+		}
+		if _, err := invoice.Generate(r.Context(), booking); err != nil {
+			fmt.Printf("Error generating invoice for booking %s: %v\n", booking.ID.Hex(), err)
+		}
+
+		// Respond with success message
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode("Job marked as completed")
+	} else {
+		// Send message that vehicle is out of range
+		http.Error(w, `{"message": "You are not in range of the delivery location"}`, http.StatusBadRequest)
+
+	}
+}
+
+// AdjustBookingFare lets an admin change the fare of a booking. If the booking
+// has already been invoiced, the invoice is credited and a new one is issued.
+func AdjustBookingFare(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var adjustment struct {
+		Cost   float64 `json:"cost"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&adjustment); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if adjustment.Cost < 0 {
+		http.Error(w, "Cost cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	newCost := utils.RoundMoney(adjustment.Cost)
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"cost": newCost}}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BookingResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to adjust booking", http.StatusInternalServerError)
+		return
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventFareAdjusted, models.EventActor{Role: models.ActorAdmin}, map[string]interface{}{
+		"from":   booking.Cost,
+		"to":     newCost,
+		"reason": adjustment.Reason,
+	})
+	booking.Cost = newCost
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Booking adjusted successfully",
+		"booking": booking,
+	}
+
+	if booking.JobStatus == "completed" {
+		creditNote, newInvoice, err := invoice.Reissue(ctx, booking, adjustment.Reason)
+		if err != nil {
+			http.Error(w, "Booking adjusted but invoice could not be reissued", http.StatusInternalServerError)
+			return
+		}
+		response["credit_note"] = creditNote
+		response["invoice"] = newInvoice
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
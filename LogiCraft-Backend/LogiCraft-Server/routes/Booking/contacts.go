@@ -0,0 +1,197 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/notify"
+	authentication "logi-craft/routes/Authentication"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxRelayLength caps the length of a message passed between the parties of a booking
+const maxRelayLength = 500
+
+// cleanContact checks a sender or receiver and normalises its phone number
+func cleanContact(contact *models.Contact, role string) error {
+	if contact == nil {
+		return nil
+	}
+	contact.Name = strings.TrimSpace(contact.Name)
+	if contact.Name == "" {
+		return &BookingError{Status: http.StatusBadRequest, Message: "Missing " + role + " name"}
+	}
+	phone := utils.NormalizePhone(contact.Phone)
+	if phone == "" {
+		return &BookingError{Status: http.StatusBadRequest, Message: "Invalid " + role + " phone number"}
+	}
+	contact.Phone = phone
+	return nil
+}
+
+// viewerOf returns the user whose device token a request carries, or "" if it
+// carries none. Contacts are only unmasked for a user identified this way.
+func viewerOf(ctx context.Context, r *http.Request) string {
+	userID, err := authentication.DeviceUser(ctx, r)
+	if err != nil {
+		if err != authentication.ErrNoDevice {
+			fmt.Printf("Error authenticating device: %v\n", err)
+		}
+		return ""
+	}
+	return userID.Hex()
+}
+
+// maskContacts hides the sender and receiver phone numbers from everyone but
+// the customer who made the booking. Drivers reach them through RelayMessage.
+func maskContacts(booking *models.Booking, viewer string) {
+	if viewer != "" && viewer == booking.UserID.Hex() {
+		return
+	}
+	booking.Sender = maskedContact(booking.Sender)
+	booking.Receiver = maskedContact(booking.Receiver)
+}
+
+func maskedContact(contact *models.Contact) *models.Contact {
+	if contact == nil {
+		return nil
+	}
+	masked := *contact
+	masked.Phone = utils.MaskPhone(contact.Phone)
+	return &masked
+}
+
+// notifyBooked tells the receiver that a delivery to them has been booked
+func notifyBooked(ctx context.Context, booking models.Booking) {
+	if booking.Receiver == nil {
+		return
+	}
+	from := "A LogiCraft customer"
+	if booking.Sender != nil {
+		from = booking.Sender.Name
+	}
+	text := fmt.Sprintf("%s has booked a delivery to you.", from)
+	if booking.ScheduledAt != nil {
+		text = fmt.Sprintf("%s has booked a delivery to you for %s.", from, booking.ScheduledAt.Format("2 Jan 15:04"))
+	}
+	notify.Send(ctx, notify.Message{
+		Kind:      notify.KindBookingCreated,
+		BookingID: booking.ID.Hex(),
+		Name:      booking.Receiver.Name,
+		Phone:     booking.Receiver.Phone,
+		Text:      text,
+	})
+}
+
+// RelayMessage passes a message from the customer or driver of a booking to
+// another party without either side learning the other's phone number
+func RelayMessage(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		To      string `json:"to"` // customer, driver, sender or receiver
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" || len(req.Message) > maxRelayLength {
+		http.Error(w, fmt.Sprintf("Message must be 1 to %d characters", maxRelayLength), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BookingResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	viewer := viewerOf(ctx, r)
+	if viewer == "" {
+		http.Error(w, "Device is not authenticated", http.StatusUnauthorized)
+		return
+	}
+	from := actorFor(booking, viewer)
+	if from.Role != models.ActorCustomer && from.Role != models.ActorDriver {
+		http.Error(w, "Only the customer or driver of a booking can send messages", http.StatusForbidden)
+		return
+	}
+	if req.To == from.Role {
+		http.Error(w, "Cannot send a message to yourself", http.StatusBadRequest)
+		return
+	}
+
+	var name, phone string
+	switch req.To {
+	case models.ActorCustomer, models.ActorDriver:
+		userID := booking.UserID
+		if req.To == models.ActorDriver {
+			userID = booking.DriverID
+		}
+		var user models.User
+		err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err != nil && err != mongo.ErrNoDocuments {
+			http.Error(w, "Failed to fetch recipient", http.StatusInternalServerError)
+			return
+		}
+		name, phone = user.Name, user.PhoneNumber
+	case "sender", "receiver":
+		contact := booking.Sender
+		if req.To == "receiver" {
+			contact = booking.Receiver
+		}
+		if contact != nil {
+			name, phone = contact.Name, contact.Phone
+		}
+	default:
+		http.Error(w, "To must be customer, driver, sender or receiver", http.StatusBadRequest)
+		return
+	}
+	if phone == "" {
+		http.Error(w, "No phone number on record for the "+req.To, http.StatusConflict)
+		return
+	}
+
+	sender := "your driver"
+	if from.Role == models.ActorCustomer {
+		sender = "the customer"
+	} else if booking.VehicleNo != "" {
+		sender = "your driver (" + booking.VehicleNo + ")"
+	}
+	notify.Send(ctx, notify.Message{
+		Kind:      notify.KindRelay,
+		BookingID: booking.ID.Hex(),
+		Name:      name,
+		Phone:     phone,
+		Text:      fmt.Sprintf("Message from %s: %s", sender, req.Message),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BookingResponse{Success: true, Message: "Message sent"})
+}
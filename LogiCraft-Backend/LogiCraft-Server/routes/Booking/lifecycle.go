@@ -0,0 +1,200 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/payments"
+	promo "logi-craft/routes/Promo"
+	timeline "logi-craft/routes/Timeline"
+	wallet "logi-craft/routes/Wallet"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// actorFor works out who made a request from the uid they sent
+func actorFor(booking models.Booking, uid string) models.EventActor {
+	switch uid {
+	case booking.UserID.Hex():
+		return models.EventActor{Role: models.ActorCustomer, ID: uid}
+	case booking.DriverID.Hex():
+		return models.EventActor{Role: models.ActorDriver, ID: uid}
+	}
+	return models.EventActor{Role: models.ActorAdmin, ID: uid}
+}
+
+// CancelBooking cancels a booking that is scheduled or still in transit, frees
+// its vehicle and gives back any payment authorisation and promo redemption
+func CancelBooking(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UID    string `json:"uid"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "job_status": bson.M{"$in": bson.A{"in-transit", "scheduled"}}},
+		bson.M{"$set": bson.M{"job_status": "cancelled", "cancelled_at": now}}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Booking not found or can no longer be cancelled", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to cancel booking", http.StatusInternalServerError)
+		return
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventCancelled, actorFor(booking, req.UID), map[string]interface{}{
+		"reason": req.Reason,
+	})
+
+	if err := releaseBooking(ctx, booking); err != nil {
+		fmt.Printf("Error releasing booking %s: %v\n", booking.ID.Hex(), err)
+		http.Error(w, "Failed to free vehicle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BookingResponse{Success: true, Message: "Booking cancelled successfully"})
+}
+
+// AddProofOfDelivery attaches a photo, signature or note to a booking
+func AddProofOfDelivery(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UID  string `json:"uid"`
+		Type string `json:"type"`
+		URL  string `json:"url"`
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Type != "photo" && req.Type != "signature" && req.Type != "note" {
+		http.Error(w, "Type must be photo, signature or note", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	proof := models.ProofOfDelivery{Type: req.Type, URL: req.URL, Note: req.Note, CreatedAt: time.Now()}
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$push": bson.M{"proofs": proof}}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(BookingResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to add proof", http.StatusInternalServerError)
+		return
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventProofAdded, actorFor(booking, req.UID), map[string]interface{}{
+		"type": proof.Type,
+		"url":  proof.URL,
+		"note": proof.Note,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(BookingResponse{Success: true, Message: "Proof added successfully"})
+}
+
+// releaseBooking frees the vehicle and driver of a booking that will not go ahead
+// and gives back its payment authorisation and promo redemption
+func releaseBooking(ctx context.Context, booking models.Booking) error {
+	if booking.VehicleNo != "" {
+		if err := unloadVehicle(ctx, booking.VehicleNo, booking); err != nil {
+			return err
+		}
+	}
+
+	if booking.Payment != nil && booking.Payment.Status == payments.StatusAuthorized {
+		if err := wallet.Void(ctx, booking.UserID, booking.Payment); err != nil {
+			fmt.Printf("Error voiding payment for booking %s: %v\n", booking.ID.Hex(), err)
+		} else {
+			db.GetCollection("bookings").UpdateOne(ctx, bson.M{"_id": booking.ID}, bson.M{"$set": bson.M{"payment.status": payments.StatusVoided}})
+		}
+	}
+
+	if booking.Discount != nil {
+		var promoCode models.PromoCode
+		if err := db.GetCollection("promo_codes").FindOne(ctx, bson.M{"_id": booking.Discount.PromoID}).Decode(&promoCode); err == nil {
+			promo.Release(ctx, &promoCode, booking.UserID)
+		}
+	}
+	return nil
+}
+
+// abandonBooking cancels a booking that was stored but could not be given its
+// vehicle, and gives back its vehicle, payment authorisation and promo
+// redemption, so a retry by the customer starts afresh
+func abandonBooking(booking models.Booking) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.GetCollection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "job_status": booking.JobStatus},
+		bson.M{"$set": bson.M{"job_status": "cancelled", "cancelled_at": time.Now()}})
+	if err != nil {
+		fmt.Printf("Error cancelling booking %s: %v\n", booking.ID.Hex(), err)
+	}
+	timeline.Record(ctx, booking.ID, models.EventCancelled, models.EventActor{Role: models.ActorSystem}, map[string]interface{}{
+		"reason": "The vehicle could not be assigned",
+	})
+	if err := releaseBooking(ctx, booking); err != nil {
+		fmt.Printf("Error releasing booking %s: %v\n", booking.ID.Hex(), err)
+	}
+}
+
+// CancelScheduled cancels a booking that is still waiting for its scheduled
+// dispatch. It returns false if the booking had already been dispatched or closed.
+func CancelScheduled(ctx context.Context, id primitive.ObjectID, actor models.EventActor, reason string) (bool, error) {
+	var booking models.Booking
+	err := db.GetCollection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "job_status": "scheduled"},
+		bson.M{"$set": bson.M{"job_status": "cancelled", "cancelled_at": time.Now()}}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventCancelled, actor, map[string]interface{}{
+		"reason": reason,
+	})
+	return true, releaseBooking(ctx, booking)
+}
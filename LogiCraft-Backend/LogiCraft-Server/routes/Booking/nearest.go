@@ -0,0 +1,118 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/geoindex"
+	"logi-craft/models"
+	"logi-craft/routing"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NearbyVehicle is a free vehicle found near a point with its distance from it
+type NearbyVehicle struct {
+	models.Vehicle `bson:",inline"`
+	Distance       float64 `bson:"distance"` // km
+}
+
+// nearestVehicles returns up to limit vehicles matching filter within the
+// search radius of a point, nearest first. The search runs on the 2dsphere
+// index of vehicle locations, so only the candidates are read.
+func nearestVehicles(ctx context.Context, filter bson.M, point models.Coordinates, limit int) ([]NearbyVehicle, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":               models.PointOf(point),
+			"key":                "location",
+			"query":              filter,
+			"maxDistance":        config.App.Dispatch.SearchRadiusKm * 1000, // metres
+			"distanceField":      "distance",
+			"distanceMultiplier": 0.001, // report km
+			"spherical":          true,
+		}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := db.GetCollection("vehicles").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("searching for vehicles: %w", err)
+	}
+	var nearby []NearbyVehicle
+	if err := cursor.All(ctx, &nearby); err != nil {
+		return nil, fmt.Errorf("decoding vehicles: %w", err)
+	}
+	return nearby, nil
+}
+
+// nearestFree returns up to limit of the nearest free vehicles of a type with
+// online drivers to a point in store, nearest first by road
+func nearestFree(ctx context.Context, store Store, vehicleType string, point models.Coordinates, limit int) ([]NearbyVehicle, error) {
+	nearby, err := store.Nearest(ctx, vehicleType, point, limit)
+	if err != nil {
+		return nil, err
+	}
+	return byRoad(nearby, point), nil
+}
+
+// nearestIndexed finds the nearest free vehicles in the in-memory index. The
+// index can trail changes made on other instances by a moment, so its
+// candidates are read back and any that were taken or went offline since are
+// dropped.
+func nearestIndexed(ctx context.Context, vehicleType string, point models.Coordinates, limit int) ([]NearbyVehicle, error) {
+
+	matches := geoindex.Default.Nearest(vehicleType, point, limit, config.App.Dispatch.SearchRadiusKm)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+
+	cursor, err := db.GetCollection("vehicles").Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "busy": false, "online": true})
+	if err != nil {
+		return nil, fmt.Errorf("fetching vehicles: %w", err)
+	}
+	var vehicles []models.Vehicle
+	if err := cursor.All(ctx, &vehicles); err != nil {
+		return nil, fmt.Errorf("decoding vehicles: %w", err)
+	}
+	free := make(map[primitive.ObjectID]models.Vehicle, len(vehicles))
+	for _, v := range vehicles {
+		free[v.ID] = v
+	}
+
+	var nearby []NearbyVehicle
+	for _, m := range matches {
+		if v, ok := free[m.ID]; ok {
+			d := utils.HaversineDistance(point.Latitude, point.Longitude, v.Coordinates.Latitude, v.Coordinates.Longitude)
+			nearby = append(nearby, NearbyVehicle{Vehicle: v, Distance: d})
+		}
+	}
+	return nearby, nil
+}
+
+// byRoad replaces the straight-line distances of vehicles found near a point
+// with their driving distances to it and puts them back in order, nearest first.
+// The vehicles are still found by straight line, which is never longer than the road.
+func byRoad(nearby []NearbyVehicle, point models.Coordinates) []NearbyVehicle {
+	if routing.Default == nil || len(nearby) == 0 {
+		return nearby
+	}
+	positions := make([]models.Coordinates, len(nearby))
+	for i, v := range nearby {
+		positions[i] = v.Coordinates
+	}
+	for i, trip := range routing.ToPoint(positions, point) {
+		nearby[i].Distance = trip.Distance
+	}
+	sort.SliceStable(nearby, func(i, j int) bool { return nearby[i].Distance < nearby[j].Distance })
+	return nearby
+}
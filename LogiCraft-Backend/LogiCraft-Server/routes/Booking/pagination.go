@@ -0,0 +1,187 @@
+package booking
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"logi-craft/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// sortFields maps the values accepted in ?sort= to booking fields.
+// Bookings are created in _id order, so _id doubles as the creation time.
+var sortFields = map[string]string{
+	"created_at": "_id",
+	"cost":       "cost",
+}
+
+// listQuery is a parsed set of filters, sort order and page position for a booking list
+type listQuery struct {
+	filter    bson.M
+	sortField string
+	sortDir   int
+	limit     int64
+	after     *pageCursor
+}
+
+// pageCursor marks the last booking of a page. It is sent to clients as an opaque string.
+type pageCursor struct {
+	Value interface{}        `json:"v,omitempty"`
+	ID    primitive.ObjectID `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseListQuery reads status, from, to, vehicle_type, vehicle_no, sort, limit and
+// cursor from the query string and combines them with the base filter
+func parseListQuery(values url.Values, base bson.M) (*listQuery, error) {
+	q := &listQuery{filter: bson.M{}, sortField: "_id", sortDir: -1, limit: defaultPageSize}
+	for k, v := range base {
+		q.filter[k] = v
+	}
+
+	if status := values.Get("status"); status != "" {
+		q.filter["job_status"] = status
+	}
+	if vehicleType := values.Get("vehicle_type"); vehicleType != "" {
+		q.filter["vehicle_type"] = vehicleType
+	}
+	if vehicleNo := values.Get("vehicle_no"); vehicleNo != "" {
+		q.filter["vehicle_no"] = vehicleNo
+	}
+
+	idRange := bson.M{}
+	if from := values.Get("from"); from != "" {
+		t, err := parseDate(from)
+		if err != nil {
+			return nil, errors.New("invalid from date")
+		}
+		idRange["$gte"] = primitive.NewObjectIDFromTimestamp(t)
+	}
+	if to := values.Get("to"); to != "" {
+		t, err := parseDate(to)
+		if err != nil {
+			return nil, errors.New("invalid to date")
+		}
+		idRange["$lt"] = primitive.NewObjectIDFromTimestamp(t)
+	}
+	if len(idRange) > 0 {
+		q.filter["_id"] = idRange
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		dir := 1
+		if sort[0] == '-' {
+			dir = -1
+			sort = sort[1:]
+		}
+		field, ok := sortFields[sort]
+		if !ok {
+			return nil, errors.New("invalid sort field")
+		}
+		q.sortField = field
+		q.sortDir = dir
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid limit")
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		q.limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.after = c
+	}
+
+	return q, nil
+}
+
+// parseDate accepts either a full RFC 3339 timestamp or a plain YYYY-MM-DD date
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// findArgs returns the filter and options to fetch one page. One extra document
+// is requested so the caller can tell whether another page follows.
+func (q *listQuery) findArgs() (bson.M, *options.FindOptions) {
+	filter := q.filter
+	if q.after != nil {
+		op := "$gt"
+		if q.sortDir < 0 {
+			op = "$lt"
+		}
+
+		var position bson.M
+		if q.sortField == "_id" {
+			position = bson.M{"_id": bson.M{op: q.after.ID}}
+		} else {
+			// Ties on the sort field are broken by _id so no booking is skipped or repeated
+			position = bson.M{"$or": bson.A{
+				bson.M{q.sortField: bson.M{op: q.after.Value}},
+				bson.M{q.sortField: q.after.Value, "_id": bson.M{op: q.after.ID}},
+			}}
+		}
+		filter = bson.M{"$and": bson.A{q.filter, position}}
+	}
+
+	sort := bson.D{{Key: q.sortField, Value: q.sortDir}}
+	if q.sortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: q.sortDir})
+	}
+
+	return filter, options.Find().SetSort(sort).SetLimit(q.limit + 1)
+}
+
+// EnsureIndexes creates the indexes that back the booking list filters and
+// the scheduled dispatcher
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("bookings").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "driver_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "job_status", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "vehicle_no", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "job_status", Value: 1}, {Key: "scheduled_at", Value: 1}}},
+	})
+	return err
+}
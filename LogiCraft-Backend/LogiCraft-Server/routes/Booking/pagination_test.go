@@ -0,0 +1,302 @@
+package booking
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		cursor pageCursor
+	}{
+		{"by id", pageCursor{ID: id}},
+		{"by cost", pageCursor{Value: 249.5, ID: id}},
+		{"zero cost", pageCursor{Value: 0.0, ID: id}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(encodeCursor(tt.cursor))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != tt.cursor.ID || fmt.Sprint(got.Value) != fmt.Sprint(tt.cursor.Value) {
+				t.Errorf("decoded %+v, want %+v", *got, tt.cursor)
+			}
+		})
+	}
+
+	for _, bad := range []string{"not base64!", "bm90IGpzb24", "eyJpZCI6MX0"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) accepted a bad cursor", bad)
+		}
+	}
+}
+
+// dated replaces the object ids bounding a date range with their times, since
+// ids made for the same time differ in their other bytes
+func dated(filter bson.M) bson.M {
+	out := bson.M{}
+	for k, v := range filter {
+		out[k] = v
+	}
+	if idRange, ok := filter["_id"].(bson.M); ok {
+		times := bson.M{}
+		for op, id := range idRange {
+			times[op] = id.(primitive.ObjectID).Timestamp().UTC()
+		}
+		out["_id"] = times
+	}
+	return out
+}
+
+func TestParseListQuery(t *testing.T) {
+	userID := primitive.NewObjectID()
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+	cursor := pageCursor{ID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name      string
+		query     string
+		filter    bson.M
+		sortField string
+		sortDir   int
+		limit     int64
+		after     *pageCursor
+		err       string
+	}{
+		{
+			name:      "defaults",
+			filter:    bson.M{"user_id": userID},
+			sortField: "_id", sortDir: -1, limit: defaultPageSize,
+		},
+		{
+			name:      "filters",
+			query:     "status=Completed&vehicle_type=small&vehicle_no=DL01AB1234",
+			filter:    bson.M{"user_id": userID, "job_status": "Completed", "vehicle_type": "small", "vehicle_no": "DL01AB1234"},
+			sortField: "_id", sortDir: -1, limit: defaultPageSize,
+		},
+		{
+			name:  "date range",
+			query: "from=2026-10-01&to=2026-10-19T12:30:00Z",
+			filter: bson.M{"user_id": userID, "_id": bson.M{
+				"$gte": primitive.NewObjectIDFromTimestamp(from),
+				"$lt":  primitive.NewObjectIDFromTimestamp(to),
+			}},
+			sortField: "_id", sortDir: -1, limit: defaultPageSize,
+		},
+		{
+			name:      "cheapest first",
+			query:     "sort=cost",
+			filter:    bson.M{"user_id": userID},
+			sortField: "cost", sortDir: 1, limit: defaultPageSize,
+		},
+		{
+			name:      "oldest first",
+			query:     "sort=created_at",
+			filter:    bson.M{"user_id": userID},
+			sortField: "_id", sortDir: 1, limit: defaultPageSize,
+		},
+		{
+			name:      "limit capped",
+			query:     "limit=500",
+			filter:    bson.M{"user_id": userID},
+			sortField: "_id", sortDir: -1, limit: maxPageSize,
+		},
+		{
+			name:      "cursor",
+			query:     "limit=5&cursor=" + encodeCursor(cursor),
+			filter:    bson.M{"user_id": userID},
+			sortField: "_id", sortDir: -1, limit: 5,
+			after: &cursor,
+		},
+		{name: "bad from date", query: "from=yesterday", err: "invalid from date"},
+		{name: "bad to date", query: "to=2026-13-01", err: "invalid to date"},
+		{name: "unknown sort field", query: "sort=-driver_id", err: "invalid sort field"},
+		{name: "zero limit", query: "limit=0", err: "invalid limit"},
+		{name: "bad limit", query: "limit=ten", err: "invalid limit"},
+		{name: "bad cursor", query: "cursor=%25%25", err: "invalid cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := parseListQuery(values, bson.M{"user_id": userID})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(dated(q.filter)) != fmt.Sprint(dated(tt.filter)) {
+				t.Errorf("filter = %v, want %v", q.filter, tt.filter)
+			}
+			if q.sortField != tt.sortField || q.sortDir != tt.sortDir || q.limit != tt.limit {
+				t.Errorf("sort %s %d limit %d, want %s %d limit %d", q.sortField, q.sortDir, q.limit, tt.sortField, tt.sortDir, tt.limit)
+			}
+			if fmt.Sprint(q.after) != fmt.Sprint(tt.after) {
+				t.Errorf("after = %v, want %v", q.after, tt.after)
+			}
+		})
+	}
+}
+
+// listed is a booking as far as the list filters can see it
+type listed struct {
+	id   primitive.ObjectID
+	cost float64
+}
+
+func (b listed) field(name string) interface{} {
+	if name == "_id" {
+		return b.id
+	}
+	return b.cost
+}
+
+// compare orders two values of a sort field
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case primitive.ObjectID:
+		other := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], other[:])
+	case float64:
+		switch {
+		case a < b.(float64):
+			return -1
+		case a > b.(float64):
+			return 1
+		}
+	}
+	return 0
+}
+
+// matches evaluates the subset of query operators findArgs produces
+func matches(b listed, filter bson.M) bool {
+	for key, want := range filter {
+		switch key {
+		case "$and":
+			for _, f := range want.(bson.A) {
+				if !matches(b, f.(bson.M)) {
+					return false
+				}
+			}
+		case "$or":
+			any := false
+			for _, f := range want.(bson.A) {
+				any = any || matches(b, f.(bson.M))
+			}
+			if !any {
+				return false
+			}
+		default:
+			ops, ok := want.(bson.M)
+			if !ok {
+				if compare(b.field(key), want) != 0 {
+					return false
+				}
+				continue
+			}
+			for op, value := range ops {
+				c := compare(b.field(key), value)
+				if (op == "$lt" && c >= 0) || (op == "$gt" && c <= 0) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// page runs findArgs over bookings in memory the way the database would
+func page(bookings []listed, q *listQuery) []listed {
+	filter, opts := q.findArgs()
+	var found []listed
+	for _, b := range bookings {
+		if matches(b, filter) {
+			found = append(found, b)
+		}
+	}
+	keys := opts.Sort.(bson.D)
+	sort.SliceStable(found, func(i, j int) bool {
+		for _, k := range keys {
+			if c := compare(found[i].field(k.Key), found[j].field(k.Key)); c != 0 {
+				return c*k.Value.(int) < 0
+			}
+		}
+		return false
+	})
+	if int64(len(found)) > *opts.Limit {
+		found = found[:*opts.Limit]
+	}
+	return found
+}
+
+func TestPagesCoverEveryBookingOnce(t *testing.T) {
+	// Many bookings share a cost, so pages must break ties by _id
+	var bookings []listed
+	for i := 0; i < 47; i++ {
+		bookings = append(bookings, listed{id: primitive.NewObjectIDFromTimestamp(time.Unix(int64(1_700_000_000+i), 0)), cost: float64(100 + 50*(i%4))})
+	}
+
+	for _, order := range []string{"", "created_at", "cost", "-cost"} {
+		for _, limit := range []int{1, 5, 10, 47, 100} {
+			t.Run(fmt.Sprintf("sort %q limit %d", order, limit), func(t *testing.T) {
+				values := url.Values{"limit": {fmt.Sprint(limit)}}
+				if order != "" {
+					values.Set("sort", order)
+				}
+				seen := map[primitive.ObjectID]int{}
+				for pages := 0; ; pages++ {
+					if pages > len(bookings) {
+						t.Fatal("pages never ran out")
+					}
+					q, err := parseListQuery(values, bson.M{})
+					if err != nil {
+						t.Fatal(err)
+					}
+					found := page(bookings, q)
+					more := int64(len(found)) > q.limit
+					if more {
+						found = found[:q.limit]
+					}
+					for _, b := range found {
+						seen[b.id]++
+					}
+					if !more {
+						break
+					}
+					// Built the way the list handler builds it
+					last := found[len(found)-1]
+					next := pageCursor{ID: last.id}
+					if q.sortField == "cost" {
+						next.Value = last.cost
+					}
+					values.Set("cursor", encodeCursor(next))
+				}
+				if len(seen) != len(bookings) {
+					t.Errorf("saw %d of %d bookings", len(seen), len(bookings))
+				}
+				for id, n := range seen {
+					if n != 1 {
+						t.Errorf("booking %s listed %d times", id.Hex(), n)
+					}
+				}
+			})
+		}
+	}
+}
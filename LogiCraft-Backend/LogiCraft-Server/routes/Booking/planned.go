@@ -0,0 +1,77 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"logi-craft/geoindex"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PlannedVehicle is the vehicle a route plan gives a booking, already claimed
+// for the plan, and when the plan expects it at each end of the trip
+type PlannedVehicle struct {
+	Vehicle    models.Vehicle
+	Assignment models.Assignment
+	PlanID     primitive.ObjectID
+	Delivery   int // index of the delivery in the plan
+	ETA        models.BookingETA
+}
+
+// PlanClaim is a vehicle to claim for a route plan with the cargo of every delivery on its route
+type PlanClaim struct {
+	Vehicle models.Vehicle
+	Load    models.CargoLoad
+}
+
+// ClaimForPlan marks every vehicle of a route plan busy with the load of its
+// route, or none of them if any is no longer free or online. A claimed vehicle is freed
+// once all of its planned bookings are completed, cancelled or fail.
+func ClaimForPlan(ctx context.Context, planID primitive.ObjectID, vehicles []PlanClaim) error {
+	claims := make([]vehicleClaim, len(vehicles))
+	for i, v := range vehicles {
+		claims[i] = vehicleClaim{id: v.Vehicle.ID, set: bson.M{"load": v.Load, "plan_id": planID}}
+	}
+	err := claimVehicles(ctx, claims)
+	if err == errVehicleTaken {
+		return &BookingError{Status: http.StatusConflict, Message: "A vehicle in the plan is no longer free or its driver is offline"}
+	} else if err != nil {
+		return err
+	}
+	for _, v := range vehicles {
+		geoindex.Default.SetBusy(v.Vehicle.VehicleNo, true)
+	}
+	return nil
+}
+
+// ReleasePlanned takes the load of a planned delivery that will not be booked
+// off its vehicle, freeing the vehicle once nothing on its route is left.
+func ReleasePlanned(ctx context.Context, vehicleNo string, load models.CargoLoad) error {
+	return unloadVehicle(ctx, vehicleNo, models.Booking{Load: &load})
+}
+
+// CreatePlannedBooking books a delivery from a route plan on the vehicle the
+// plan gives it. The vehicle must already carry the booking's load, claimed
+// with ClaimForPlan; the load is taken off again if the booking fails, and the
+// vehicle is freed once nothing on its route is left.
+func CreatePlannedBooking(ctx context.Context, req BookingRequest, vehicle PlannedVehicle) (*BookingResult, error) {
+	if req.Load == nil {
+		return nil, &BookingError{Status: http.StatusBadRequest, Message: "Planned bookings must give the cargo volume and weight"}
+	}
+	req.Shared = false
+	req.ScheduledAt = nil
+	req.planned = &vehicle
+
+	result, err := createBooking(ctx, req)
+	if err != nil {
+		if unloadErr := unloadVehicle(ctx, vehicle.Vehicle.VehicleNo, models.Booking{Load: req.Load}); unloadErr != nil {
+			fmt.Printf("Error unloading vehicle %s: %v\n", vehicle.Vehicle.VehicleNo, unloadErr)
+		}
+		return nil, err
+	}
+	return result, nil
+}
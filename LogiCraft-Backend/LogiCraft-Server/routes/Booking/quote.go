@@ -0,0 +1,117 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/models"
+	areas "logi-craft/routes/Areas"
+	promo "logi-craft/routes/Promo"
+	"logi-craft/routing"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type QuoteRequest struct {
+	UserID        string             `json:"user_id"`
+	VehicleType   string             `json:"vehicle_type"`
+	PickupCoords  models.Coordinates `json:"pickup_coords"`
+	DropoffCoords models.Coordinates `json:"dropoff_coords"`
+	PromoCode     string             `json:"promo_code"`
+	Load          *models.CargoLoad  `json:"load"`
+	Shared        bool               `json:"shared"`
+}
+
+type QuoteResponse struct {
+	Success  bool             `json:"success"`
+	Message  string           `json:"message"`
+	Distance float64          `json:"distance"`
+	BaseFare float64          `json:"base_fare"`
+	Discount *models.Discount `json:"discount,omitempty"`
+	Total    float64          `json:"total"`
+
+	// FareShare is the part of the whole-vehicle fare a shared booking pays
+	FareShare float64 `json:"fare_share,omitempty"`
+
+	// Whether the trip is inside the service areas, and which vehicle types are offered on it
+	Serviceable  bool     `json:"serviceable"`
+	Reason       string   `json:"reason,omitempty"`
+	VehicleTypes []string `json:"vehicle_types"`
+}
+
+// GetQuote returns the fare for a trip, with a promo code applied if one is given
+func GetQuote(w http.ResponseWriter, r *http.Request) {
+	var req QuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := utils.VehicleRates[req.VehicleType]; !ok {
+		http.Error(w, "Invalid vehicle type", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkLoad(req.VehicleType, req.Shared, req.Load); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	distance := routing.Between(req.PickupCoords, req.DropoffCoords).Distance
+	baseFare := utils.CalculateFare(distance, req.VehicleType)
+
+	var share float64
+	if req.Shared {
+		share = fareShare(req.VehicleType, *req.Load)
+		baseFare = utils.RoundMoney(baseFare * share)
+	}
+
+	response := QuoteResponse{
+		Success:   true,
+		Message:   "Quote calculated successfully",
+		Distance:  distance,
+		BaseFare:  baseFare,
+		Total:     baseFare,
+		FareShare: share,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	area, err := areas.Check(ctx, req.PickupCoords, req.DropoffCoords, req.VehicleType)
+	if err != nil {
+		http.Error(w, "Failed to check service area", http.StatusInternalServerError)
+		return
+	}
+	response.Serviceable = area.Serviceable
+	response.Reason = area.Reason
+	response.VehicleTypes = area.VehicleTypes
+
+	if req.PromoCode != "" {
+		userID, err := primitive.ObjectIDFromHex(req.UserID)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		promoCode, amount, err := promo.Validate(ctx, req.PromoCode, userID, req.VehicleType, baseFare)
+		if promo.IsRejection(err) {
+			// The quote is still valid without the promo code, so report why it was not applied
+			response.Message = err.Error()
+		} else if err != nil {
+			fmt.Printf("Error validating promo code %s: %v\n", req.PromoCode, err)
+			http.Error(w, "Failed to check promo code", http.StatusInternalServerError)
+			return
+		} else {
+			response.Discount = &models.Discount{PromoID: promoCode.ID, Code: promoCode.Code, Amount: amount}
+			response.Total = utils.RoundMoney(baseFare - amount)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,88 @@
+package booking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	timeline "logi-craft/routes/Timeline"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RateDriver lets a customer rate the driver of their completed booking once,
+// from 1 to 5 stars, and folds the rating into the driver's average
+func RateDriver(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		UID   string `json:"uid"`
+		Stars int    `json:"stars"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(req.UID)
+	if err != nil {
+		http.Error(w, "Invalid User ID", http.StatusBadRequest)
+		return
+	}
+	if req.Stars < 1 || req.Stars > 5 {
+		http.Error(w, "Stars must be between 1 and 5", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "user_id": userID, "job_status": "completed", "rating": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"rating": req.Stars}}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Booking not found, not completed or already rated", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to rate booking", http.StatusInternalServerError)
+		return
+	}
+
+	// Update the running average in one step so concurrent ratings are not lost
+	average := bson.M{"$divide": bson.A{
+		bson.M{"$add": bson.A{
+			bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$rating", 0}}, bson.M{"$ifNull": bson.A{"$ratings", 0}}}},
+			req.Stars,
+		}},
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$ratings", 0}}, 1}},
+	}}
+	_, err = db.GetCollection("assignments").UpdateOne(ctx, bson.M{"uid": booking.DriverID}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"rating":  average,
+			"ratings": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$ratings", 0}}, 1}},
+		}}},
+	})
+	if err != nil {
+		fmt.Printf("Error updating rating for driver %s: %v\n", booking.DriverID.Hex(), err)
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventRated, timeline.Actor(models.ActorCustomer, userID), map[string]interface{}{
+		"stars":     req.Stars,
+		"driver_id": booking.DriverID.Hex(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BookingResponse{Success: true, Message: "Driver rated successfully"})
+}
@@ -0,0 +1,184 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	areas "logi-craft/routes/Areas"
+	dispatch "logi-craft/routes/Dispatch"
+	timeline "logi-craft/routes/Timeline"
+	tracking "logi-craft/routes/Tracking"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DispatchDue gives a vehicle to every scheduled booking whose pickup time is
+// within the dispatch lead time. Each booking is claimed with a conditional
+// update first, so instances running this at the same time never dispatch a
+// booking twice. Bookings that still have no vehicle well after their pickup
+// time are marked unfulfilled and their payment and promo are released.
+// It returns the number of bookings dispatched.
+func DispatchDue(ctx context.Context) (int, error) {
+	bookings := db.GetCollection("bookings")
+	now := time.Now()
+
+	filter := bson.M{
+		"job_status":   "scheduled",
+		"scheduled_at": bson.M{"$lte": now.Add(config.App.Dispatch.ScheduleLead())},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "scheduled_at", Value: 1}}).SetProjection(bson.M{"_id": 1})
+	cursor, err := bookings.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var due []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &due); err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	for _, d := range due {
+		var booking models.Booking
+		err := bookings.FindOneAndUpdate(ctx,
+			bson.M{"_id": d.ID, "job_status": "scheduled"},
+			bson.M{"$set": bson.M{"job_status": "dispatching"}}).Decode(&booking)
+		if err == mongo.ErrNoDocuments {
+			// Claimed by another instance or cancelled in the meantime
+			continue
+		} else if err != nil {
+			return dispatched, err
+		}
+
+		ok, err := dispatchScheduled(ctx, booking, now)
+		if err != nil {
+			// Put the booking back so the next run can try again
+			bookings.UpdateOne(ctx, bson.M{"_id": booking.ID, "job_status": "dispatching"}, bson.M{"$set": bson.M{"job_status": "scheduled"}})
+			return dispatched, err
+		}
+		if ok {
+			dispatched++
+		}
+	}
+	return dispatched, nil
+}
+
+// dispatchScheduled assigns a vehicle to a claimed scheduled booking
+func dispatchScheduled(ctx context.Context, booking models.Booking, now time.Time) (bool, error) {
+	bookings := db.GetCollection("bookings")
+
+	vehicle, assignment, pickupDistance, decision, err := findVehicle(ctx, booking)
+	if err == errNoVehicles && booking.AcceptUpgrade {
+		vehicle, assignment, pickupDistance, decision, err = upgradeScheduled(ctx, &booking)
+	}
+	if err == errNoVehicles {
+		if booking.ScheduledAt != nil && now.After(booking.ScheduledAt.Add(config.App.Dispatch.UnfulfilledAfter())) {
+			return false, markUnfulfilled(ctx, booking)
+		}
+		_, err = bookings.UpdateOne(ctx, bson.M{"_id": booking.ID, "job_status": "dispatching"}, bson.M{"$set": bson.M{"job_status": "scheduled"}})
+		return false, err
+	} else if err != nil {
+		return false, err
+	}
+
+	eta := tracking.Estimate(booking.VehicleType, vehicle.Coordinates, booking, now)
+	booking.VehicleNo = vehicle.VehicleNo
+	booking.DriverID = assignment.UID
+	booking.JobStatus = "in-transit"
+	booking.InitialETA = &eta
+	booking.ETA = &eta
+
+	set := bson.M{
+		"vehicle_no":  booking.VehicleNo,
+		"driver_id":   booking.DriverID,
+		"job_status":  booking.JobStatus,
+		"initial_eta": booking.InitialETA,
+		"eta":         booking.ETA,
+	}
+	if booking.UpgradedFrom != "" {
+		set["vehicle_type"] = booking.VehicleType
+		set["upgraded_from"] = booking.UpgradedFrom
+	}
+	_, err = bookings.UpdateOne(ctx, bson.M{"_id": booking.ID, "job_status": "dispatching"}, bson.M{"$set": set})
+	if err != nil {
+		unloadVehicle(ctx, vehicle.VehicleNo, booking)
+		return false, err
+	}
+
+	details := map[string]interface{}{
+		"from": "scheduled",
+		"to":   "in-transit",
+	}
+	if booking.UpgradedFrom != "" {
+		details["vehicle_type"] = booking.VehicleType
+		details["upgraded_from"] = booking.UpgradedFrom
+	}
+	timeline.Record(ctx, booking.ID, models.EventStatusChanged, models.EventActor{Role: models.ActorSystem}, details)
+
+	if err := commitAssignment(ctx, booking, vehicle, assignment, pickupDistance); err != nil {
+		return false, err
+	}
+	if decision != nil {
+		dispatch.Record(ctx, *decision)
+	}
+	return true, nil
+}
+
+// upgradeScheduled gives a scheduled booking that accepted upgrades a larger
+// vehicle offered on its route. Its fare was secured when it was booked, so
+// only upgrades at the original price are used.
+func upgradeScheduled(ctx context.Context, booking *models.Booking) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	area, err := areas.Check(ctx, booking.PickupLocation, booking.DropoffLocation, "")
+	if err != nil {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, fmt.Errorf("checking service area: %w", err)
+	}
+	return upgradeVehicle(ctx, booking, area.VehicleTypes, upgradeChoice{accept: true, freeOnly: true})
+}
+
+// markUnfulfilled gives up on a scheduled booking that never found a vehicle
+func markUnfulfilled(ctx context.Context, booking models.Booking) error {
+	_, err := db.GetCollection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "job_status": "dispatching"},
+		bson.M{"$set": bson.M{"job_status": "unfulfilled", "cancelled_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventStatusChanged, models.EventActor{Role: models.ActorSystem}, map[string]interface{}{
+		"from":   "scheduled",
+		"to":     "unfulfilled",
+		"reason": "No vehicle available",
+	})
+
+	if err := releaseBooking(ctx, booking); err != nil {
+		return fmt.Errorf("releasing unfulfilled booking: %w", err)
+	}
+	return nil
+}
+
+// StartScheduledDispatch checks every 30 seconds for scheduled bookings that
+// are due a vehicle. It is safe to run on every instance.
+func StartScheduledDispatch() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := DispatchDue(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Scheduled dispatch failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Scheduled dispatch assigned %d bookings", n)
+			}
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
@@ -0,0 +1,364 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/geoindex"
+	"logi-craft/models"
+	dispatch "logi-craft/routes/Dispatch"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// loadEpsilon absorbs rounding left over after cargo is added to and taken off a vehicle
+const loadEpsilon = 1e-6
+
+// claimAttempts is how many free vehicles a booking tries to claim before giving up
+const claimAttempts = 3
+
+// checkLoad validates the cargo a booking declares. Shared bookings must declare it.
+func checkLoad(vehicleType string, shared bool, load *models.CargoLoad) error {
+	if load == nil {
+		if shared {
+			return &BookingError{Status: http.StatusBadRequest, Message: "Shared bookings must give the cargo volume and weight"}
+		}
+		return nil
+	}
+	if load.Volume <= 0 || load.Weight <= 0 {
+		return &BookingError{Status: http.StatusBadRequest, Message: "Cargo volume and weight must be positive"}
+	}
+	capacity, ok := config.App.Sharing.Capacities[vehicleType]
+	if !ok {
+		if shared {
+			return &BookingError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Vehicle type %s cannot be shared", vehicleType)}
+		}
+		return nil
+	}
+	if load.Volume > capacity.Volume || load.Weight > capacity.Weight {
+		return &BookingError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Cargo does not fit in a %s vehicle", vehicleType)}
+	}
+	return nil
+}
+
+// fareShare is the part of the whole-vehicle fare a shared booking pays: the
+// larger of the parts of the vehicle's volume and weight its cargo takes up,
+// but never less than MinFareShare
+func fareShare(vehicleType string, load models.CargoLoad) float64 {
+	capacity := config.App.Sharing.Capacities[vehicleType]
+	share := math.Max(load.Volume/capacity.Volume, load.Weight/capacity.Weight)
+	return math.Min(math.Max(share, config.App.Sharing.MinFareShare), 1)
+}
+
+// findVehicle picks the vehicle for a booking and claims it, so it must be
+// given back with unloadVehicle if the booking fails. Shared bookings first try
+// to join a vehicle already carrying shared loads; other bookings, and shared
+// ones no such vehicle has room for, get the free vehicle the dispatch
+// strategy chooses.
+func findVehicle(ctx context.Context, booking models.Booking) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	if booking.Shared {
+		vehicle, assignment, pickupDistance, decision, err := joinSharedVehicle(ctx, booking)
+		if err != errNoVehicles {
+			return vehicle, assignment, pickupDistance, decision, err
+		}
+	}
+	return claimVehicle(ctx, booking)
+}
+
+// claimVehicle takes the free vehicle the dispatch strategy chooses for a
+// booking. The vehicle is marked busy, with a shared booking's cargo on board,
+// only if it is still free and its driver online, so a vehicle another booking
+// took first is passed over for the next best one.
+func claimVehicle(ctx context.Context, booking models.Booking) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	for i := 0; i < claimAttempts; i++ {
+		vehicle, assignment, pickupDistance, decision, err := PickVehicle(ctx, fleet, booking, time.Now())
+		if err != nil {
+			return vehicle, assignment, pickupDistance, nil, err
+		}
+		claimed := vehicle
+		claimed.Load = nil
+		if booking.Shared {
+			claimed.Load = booking.Load
+		}
+		err = fleet.Claim(ctx, []models.Vehicle{claimed})
+		if err == nil {
+			return vehicle, assignment, pickupDistance, decision, nil
+		} else if err != errVehicleTaken {
+			return vehicle, assignment, 0, nil, err
+		}
+	}
+	return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+}
+
+// sharedCandidate is a vehicle on a shared run that a booking could join
+type sharedCandidate struct {
+	vehicle  models.Vehicle
+	extra    float64 // km added to the vehicle's remaining route
+	toPickup float64 // km the vehicle drives before reaching the new pickup
+}
+
+// joinSharedVehicle adds a shared booking to the vehicle already carrying
+// shared loads that has room for its cargo and needs the least extra driving to
+// serve it without taking any booking on board more than MaxDetourKm out of its way
+func joinSharedVehicle(ctx context.Context, booking models.Booking) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	capacity := config.App.Sharing.Capacities[booking.VehicleType]
+	withRoom := func(filter bson.M) bson.M {
+		filter["busy"] = true
+		filter["online"] = true // drivers who went offline finish their loads but take no more
+		filter["load"] = bson.M{"$exists": true}
+		filter["plan_id"] = bson.M{"$exists": false} // planned vehicles follow their plan
+		filter["load.volume"] = bson.M{"$lte": capacity.Volume - booking.Load.Volume + loadEpsilon}
+		filter["load.weight"] = bson.M{"$lte": capacity.Weight - booking.Load.Weight + loadEpsilon}
+		return filter
+	}
+
+	vehicles, err := nearestVehicles(ctx, withRoom(bson.M{"vehicle_type": booking.VehicleType}), booking.PickupLocation, config.App.Dispatch.Candidates)
+	if err != nil {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, err
+	}
+
+	var candidates []sharedCandidate
+	for _, nearby := range vehicles {
+		vehicle := nearby.Vehicle
+		cursor, err := db.GetCollection("bookings").Find(ctx, bson.M{"vehicle_no": vehicle.VehicleNo, "job_status": "in-transit"})
+		if err != nil {
+			return models.Vehicle{}, models.Assignment{}, 0, nil, fmt.Errorf("fetching bookings on vehicle: %w", err)
+		}
+		var onBoard []models.Booking
+		if err := cursor.All(ctx, &onBoard); err != nil {
+			return models.Vehicle{}, models.Assignment{}, 0, nil, fmt.Errorf("decoding bookings on vehicle: %w", err)
+		}
+
+		route := planRoute(vehicle.Coordinates, onBoard)
+		extra, toPickup, ok := insertBooking(vehicle.Coordinates, route, booking, config.App.Sharing.MaxDetourKm)
+		if ok {
+			candidates = append(candidates, sharedCandidate{vehicle: vehicle, extra: extra, toPickup: toPickup})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].extra < candidates[j].extra })
+
+	for i, c := range candidates {
+		// Reserve the space conditionally; another booking may have filled the vehicle since it was read
+		result, err := db.GetCollection("vehicles").UpdateOne(ctx, withRoom(bson.M{"_id": c.vehicle.ID}), bson.M{
+			"$inc":         bson.M{"load.volume": booking.Load.Volume, "load.weight": booking.Load.Weight},
+			"$currentDate": bson.M{"updated_at": true},
+		})
+		if err != nil {
+			return models.Vehicle{}, models.Assignment{}, 0, nil, fmt.Errorf("reserving space on vehicle: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		var assignment models.Assignment
+		err = db.GetCollection("assignments").FindOne(ctx, bson.M{"vehicle_no": c.vehicle.VehicleNo}).Decode(&assignment)
+		if err != nil {
+			unloadVehicle(ctx, c.vehicle.VehicleNo, booking)
+			return models.Vehicle{}, models.Assignment{}, 0, nil, fmt.Errorf("finding assignment for vehicle: %w", err)
+		}
+		return c.vehicle, assignment, c.toPickup, sharedDecision(booking, candidates, i, assignment), nil
+	}
+	return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+}
+
+// sharedDecision describes how a shared booking chose the vehicle it joined.
+// Candidates are scored by the extra km they would drive; any scoring better
+// than the chosen one had filled up by the time space was reserved.
+func sharedDecision(booking models.Booking, candidates []sharedCandidate, chosen int, assignment models.Assignment) *models.DispatchDecision {
+	decision := models.DispatchDecision{
+		BookingID:   booking.ID,
+		Strategy:    dispatch.SharedInsertion,
+		VehicleType: booking.VehicleType,
+		Pickup:      booking.PickupLocation,
+		VehicleNo:   candidates[chosen].vehicle.VehicleNo,
+		DriverID:    assignment.UID,
+		CreatedAt:   time.Now(),
+	}
+	for i, c := range candidates {
+		entry := models.DispatchCandidate{VehicleNo: c.vehicle.VehicleNo, Distance: c.toPickup, Score: c.extra}
+		if i == chosen {
+			entry.DriverID = assignment.UID
+			entry.Chosen = true
+		}
+		decision.Candidates = append(decision.Candidates, entry)
+	}
+	return &decision
+}
+
+// unloadVehicle takes a booking's cargo off its vehicle and frees the vehicle
+// and its driver once nothing else is on board. Only shared and planned
+// vehicles keep track of their load; others are freed straight away.
+func unloadVehicle(ctx context.Context, vehicleNo string, booking models.Booking) error {
+	vehicles := db.GetCollection("vehicles")
+
+	if booking.Load != nil {
+		_, err := vehicles.UpdateOne(ctx,
+			bson.M{"vehicle_no": vehicleNo, "load": bson.M{"$exists": true}},
+			bson.M{
+				"$inc":         bson.M{"load.volume": -booking.Load.Volume, "load.weight": -booking.Load.Weight},
+				"$currentDate": bson.M{"updated_at": true},
+			})
+		if err != nil {
+			return fmt.Errorf("unloading vehicle: %w", err)
+		}
+	}
+
+	empty := bson.M{
+		"vehicle_no": vehicleNo,
+		"$or": bson.A{
+			bson.M{"load": bson.M{"$exists": false}},
+			bson.M{"load.volume": bson.M{"$lte": loadEpsilon}, "load.weight": bson.M{"$lte": loadEpsilon}},
+		},
+	}
+	result, err := vehicles.UpdateOne(ctx, empty, bson.M{
+		"$set":         bson.M{"busy": false},
+		"$unset":       bson.M{"load": "", "plan_id": ""},
+		"$currentDate": bson.M{"updated_at": true},
+	})
+	if err != nil {
+		return fmt.Errorf("freeing vehicle: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		// Other shared or planned bookings are still on board
+		return nil
+	}
+	geoindex.Default.SetBusy(vehicleNo, false)
+
+	_, err = db.GetCollection("assignments").UpdateOne(ctx, bson.M{"vehicle_no": vehicleNo}, bson.M{"$set": bson.M{"booking_id": "", "freed_at": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("updating assignment: %w", err)
+	}
+	return nil
+}
+
+// stop is a point a vehicle on a shared run still has to visit
+type stop struct {
+	at        models.Coordinates
+	bookingID primitive.ObjectID
+	pickup    bool
+}
+
+func distance(a, b models.Coordinates) float64 {
+	return utils.HaversineDistance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+}
+
+// planRoute orders the remaining stops of a vehicle's bookings by always
+// driving to the nearest stop it may visit next. A dropoff can only be visited
+// after its pickup.
+func planRoute(from models.Coordinates, bookings []models.Booking) []stop {
+	var pending []stop
+	for _, b := range bookings {
+		if b.ArrivedPickupAt == nil {
+			pending = append(pending, stop{at: b.PickupLocation, bookingID: b.ID, pickup: true})
+		}
+		pending = append(pending, stop{at: b.DropoffLocation, bookingID: b.ID})
+	}
+
+	var route []stop
+	picked := map[primitive.ObjectID]bool{}
+	for _, b := range bookings {
+		if b.ArrivedPickupAt != nil {
+			picked[b.ID] = true
+		}
+	}
+	position := from
+	for len(pending) > 0 {
+		next := -1
+		for i, s := range pending {
+			if !s.pickup && !picked[s.bookingID] {
+				continue
+			}
+			if next < 0 || distance(position, s.at) < distance(position, pending[next].at) {
+				next = i
+			}
+		}
+		s := pending[next]
+		if s.pickup {
+			picked[s.bookingID] = true
+		}
+		route = append(route, s)
+		position = s.at
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+	return route
+}
+
+// odometer returns how far the vehicle has driven on reaching each stop of a route
+func odometer(from models.Coordinates, route []stop) []float64 {
+	km := make([]float64, len(route))
+	position, total := from, 0.0
+	for i, s := range route {
+		total += distance(position, s.at)
+		km[i] = total
+		position = s.at
+	}
+	return km
+}
+
+// insertBooking finds where to add a booking's pickup and dropoff to a route
+// with the least extra driving. A placement only fits if it delays no dropoff
+// already on the route by more than maxDetour km, the new booking travels at
+// most maxDetour km further than the direct trip, and the route grows by at
+// most maxDetour km more than that trip. It returns the extra km, the km driven
+// before the new pickup and whether any placement fits.
+func insertBooking(from models.Coordinates, route []stop, booking models.Booking, maxDetour float64) (float64, float64, bool) {
+	before := odometer(from, route)
+	length := 0.0
+	if len(route) > 0 {
+		length = before[len(route)-1]
+	}
+	direct := distance(booking.PickupLocation, booking.DropoffLocation)
+
+	best, bestToPickup, found := 0.0, 0.0, false
+	for i := 0; i <= len(route); i++ {
+		for j := i; j <= len(route); j++ {
+			// Pickup goes before stop i of the old route and dropoff before stop j
+			candidate := make([]stop, 0, len(route)+2)
+			candidate = append(candidate, route[:i]...)
+			candidate = append(candidate, stop{at: booking.PickupLocation, pickup: true})
+			candidate = append(candidate, route[i:j]...)
+			candidate = append(candidate, stop{at: booking.DropoffLocation})
+			candidate = append(candidate, route[j:]...)
+			after := odometer(from, candidate)
+
+			pickupAt, dropoffAt := after[i], after[j+1]
+			if dropoffAt-pickupAt-direct > maxDetour {
+				continue
+			}
+			fits := true
+			for k, s := range route {
+				shifted := k + 1
+				if k >= j {
+					shifted = k + 2
+				} else if k < i {
+					shifted = k
+				}
+				if !s.pickup && after[shifted]-before[k] > maxDetour {
+					fits = false
+					break
+				}
+			}
+			if !fits {
+				continue
+			}
+
+			// The vehicle itself may go no more than maxDetour out of its way to fetch the new cargo
+			extra := after[len(after)-1] - length
+			if extra-direct > maxDetour {
+				continue
+			}
+			if !found || extra < best {
+				best, bestToPickup, found = extra, pickupAt, true
+			}
+		}
+	}
+	return best, bestToPickup, found
+}
@@ -0,0 +1,80 @@
+package booking
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// east returns the point km east of where the test vehicle starts, along the
+// equator so distances add up exactly
+func east(km float64) models.Coordinates {
+	return models.Coordinates{Longitude: km / (6371 * math.Pi / 180)}
+}
+
+func dropoff(km float64) stop {
+	return stop{at: east(km)}
+}
+
+func TestInsertBooking(t *testing.T) {
+	tests := []struct {
+		name      string
+		route     []stop
+		pickup    float64
+		drop      float64
+		maxDetour float64
+		fits      bool
+		extra     float64
+		toPickup  float64
+	}{
+		{"empty route", nil, 0.5, 8, 1, true, 8, 0.5},
+		{"too far to fetch", nil, 3, 8, 1, false, 0, 0},
+		{"on the way", []stop{dropoff(10)}, 3, 6, 1, true, 0, 3},
+		{"carried past the last dropoff", []stop{dropoff(10)}, 3, 12, 1, true, 2, 3},
+		{"only fits after the last dropoff", []stop{dropoff(10)}, 8, 2, 3, true, 8, 12},
+		{"turning back is too far", []stop{dropoff(10)}, 8, 2, 1, false, 0, 0},
+		{"opposite direction", []stop{dropoff(10)}, -5, -8, 2, false, 0, 0},
+		{"pickups may be delayed", []stop{{at: east(4), pickup: true}, dropoff(10)}, 3, 6, 1, true, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			booking := models.Booking{PickupLocation: east(tt.pickup), DropoffLocation: east(tt.drop)}
+			extra, toPickup, fits := insertBooking(east(0), tt.route, booking, tt.maxDetour)
+			if fits != tt.fits {
+				t.Fatalf("fits = %v, want %v", fits, tt.fits)
+			}
+			if !fits {
+				return
+			}
+			if math.Abs(extra-tt.extra) > 1e-6 || math.Abs(toPickup-tt.toPickup) > 1e-6 {
+				t.Errorf("extra %f km, %f km to the pickup; want %f and %f", extra, toPickup, tt.extra, tt.toPickup)
+			}
+		})
+	}
+}
+
+func TestPlanRoute(t *testing.T) {
+	waiting := models.Booking{ID: primitive.NewObjectID(), PickupLocation: east(5), DropoffLocation: east(2)}
+	onBoard := models.Booking{ID: primitive.NewObjectID(), PickupLocation: east(-1), DropoffLocation: east(8), ArrivedPickupAt: &time.Time{}}
+
+	route := planRoute(east(0), []models.Booking{waiting, onBoard})
+	// The nearest stop is the waiting dropoff at 2 km, but it cannot come
+	// before its pickup
+	want := []stop{
+		{at: east(5), bookingID: waiting.ID, pickup: true},
+		{at: east(2), bookingID: waiting.ID},
+		{at: east(8), bookingID: onBoard.ID},
+	}
+	if len(route) != len(want) {
+		t.Fatalf("route has %d stops, want %d", len(route), len(want))
+	}
+	for i := range want {
+		if route[i] != want[i] {
+			t.Errorf("stop %d = %+v, want %+v", i, route[i], want[i])
+		}
+	}
+}
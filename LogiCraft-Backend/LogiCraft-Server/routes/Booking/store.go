@@ -0,0 +1,157 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/geoindex"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store holds the vehicles dispatch chooses from and their drivers'
+// assignments. The server keeps them in MongoDB; cmd/dispatchsim keeps them
+// in memory and runs the same matching against them.
+type Store interface {
+	// Nearest returns up to limit free vehicles of a type with online drivers
+	// within the search radius of a point, nearest first by straight line
+	Nearest(ctx context.Context, vehicleType string, point models.Coordinates, limit int) ([]NearbyVehicle, error)
+	// Drivers returns the driver assignments of the given vehicles by vehicle number
+	Drivers(ctx context.Context, vehicleNos []string) (map[string]models.Assignment, error)
+	// Claim marks every vehicle busy, with its load on board if it has one, if
+	// all are still free with online drivers. Otherwise none is claimed and
+	// errVehicleTaken is returned.
+	Claim(ctx context.Context, vehicles []models.Vehicle) error
+}
+
+// fleet is the store the server dispatches from
+var fleet Store = mongoStore{}
+
+// mongoStore is the vehicles and assignments collections. Vehicles are
+// searched in the in-memory index when it is loaded and the database otherwise.
+type mongoStore struct{}
+
+func (mongoStore) Nearest(ctx context.Context, vehicleType string, point models.Coordinates, limit int) ([]NearbyVehicle, error) {
+	if geoindex.Default.Ready() {
+		return nearestIndexed(ctx, vehicleType, point, limit)
+	}
+	return nearestVehicles(ctx, bson.M{"vehicle_type": vehicleType, "busy": false, "online": true}, point, limit)
+}
+
+func (mongoStore) Drivers(ctx context.Context, vehicleNos []string) (map[string]models.Assignment, error) {
+	return driversOf(ctx, vehicleNos)
+}
+
+func (mongoStore) Claim(ctx context.Context, vehicles []models.Vehicle) error {
+	claims := make([]vehicleClaim, len(vehicles))
+	ids := make([]primitive.ObjectID, len(vehicles))
+	for i, v := range vehicles {
+		claims[i] = vehicleClaim{id: v.ID}
+		if v.Load != nil {
+			claims[i].set = bson.M{"load": v.Load}
+		}
+		ids[i] = v.ID
+	}
+	err := claimVehicles(ctx, claims)
+	if err == errVehicleTaken {
+		// The index thought the vehicles free, so bring it up to date
+		refreshIndex(ctx, ids)
+		return err
+	} else if err != nil {
+		return err
+	}
+	for _, v := range vehicles {
+		geoindex.Default.SetBusy(v.VehicleNo, true)
+	}
+	return nil
+}
+
+// refreshIndex puts the vehicles as they are now in the database into the index
+func refreshIndex(ctx context.Context, ids []primitive.ObjectID) {
+	cursor, err := db.GetCollection("vehicles").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		fmt.Printf("Error refreshing vehicles in the index: %v\n", err)
+		return
+	}
+	var vehicles []models.Vehicle
+	if err := cursor.All(ctx, &vehicles); err != nil {
+		fmt.Printf("Error refreshing vehicles in the index: %v\n", err)
+		return
+	}
+	for _, v := range vehicles {
+		geoindex.Default.Put(v)
+	}
+}
+
+// MemoryStore keeps vehicles and their drivers' assignments in memory, so
+// dispatch can be run offline. It is safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	index    *geoindex.Index
+	vehicles map[primitive.ObjectID]models.Vehicle
+	drivers  map[string]models.Assignment
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		index:    geoindex.New(geoindex.DefaultCellSize),
+		vehicles: map[primitive.ObjectID]models.Vehicle{},
+		drivers:  map[string]models.Assignment{},
+	}
+}
+
+// Put adds a vehicle with its driver's assignment, or replaces both
+func (s *MemoryStore) Put(vehicle models.Vehicle, assignment models.Assignment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vehicles[vehicle.ID] = vehicle
+	s.drivers[vehicle.VehicleNo] = assignment
+	s.index.Put(vehicle)
+}
+
+func (s *MemoryStore) Nearest(ctx context.Context, vehicleType string, point models.Coordinates, limit int) ([]NearbyVehicle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var nearby []NearbyVehicle
+	for _, m := range s.index.Nearest(vehicleType, point, limit, config.App.Dispatch.SearchRadiusKm) {
+		nearby = append(nearby, NearbyVehicle{Vehicle: s.vehicles[m.ID], Distance: m.Distance})
+	}
+	return nearby, nil
+}
+
+func (s *MemoryStore) Drivers(ctx context.Context, vehicleNos []string) (map[string]models.Assignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drivers := make(map[string]models.Assignment, len(vehicleNos))
+	for _, no := range vehicleNos {
+		if a, ok := s.drivers[no]; ok {
+			drivers[no] = a
+		}
+	}
+	return drivers, nil
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, vehicles []models.Vehicle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, vehicle := range vehicles {
+		v, ok := s.vehicles[vehicle.ID]
+		if !ok || v.Busy || !v.Online {
+			return errVehicleTaken
+		}
+	}
+	for _, vehicle := range vehicles {
+		v := s.vehicles[vehicle.ID]
+		v.Busy = true
+		v.Load = vehicle.Load
+		s.vehicles[v.ID] = v
+		s.index.SetBusy(v.VehicleNo, true)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package booking
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// smallVehicle is a free small vehicle with an online driver the given km
+// east of the origin
+func smallVehicle(no string, km float64) models.Vehicle {
+	return models.Vehicle{ID: primitive.NewObjectID(), VehicleNo: no, VehicleType: "small", Coordinates: east(km), Online: true}
+}
+
+func storeOf(vehicles ...models.Vehicle) *MemoryStore {
+	store := NewMemoryStore()
+	for _, v := range vehicles {
+		store.Put(v, models.Assignment{UID: primitive.NewObjectID(), VehicleNo: v.VehicleNo})
+	}
+	return store
+}
+
+func smallAt(km float64) models.Booking {
+	return models.Booking{VehicleType: "small", PickupLocation: east(km)}
+}
+
+func TestPickVehicle(t *testing.T) {
+	ctx := context.Background()
+	taken := smallVehicle("taken", 0.2)
+	offline := smallVehicle("offline", 0.3)
+	offline.Online = false
+	store := storeOf(smallVehicle("far", 3), smallVehicle("near", 1), taken, offline)
+	if err := store.Claim(ctx, []models.Vehicle{taken}); err != nil {
+		t.Fatalf("Claim of a free vehicle: %v", err)
+	}
+	if err := store.Claim(ctx, []models.Vehicle{taken}); err != errVehicleTaken {
+		t.Errorf("Claim of a taken vehicle: err = %v, want errVehicleTaken", err)
+	}
+
+	vehicle, _, distance, decision, err := PickVehicle(ctx, store, smallAt(0), time.Now())
+	if err != nil {
+		t.Fatalf("PickVehicle: %v", err)
+	}
+	if vehicle.VehicleNo != "near" || math.Abs(distance-1) > 1e-9 {
+		t.Errorf("PickVehicle chose %s %.3f km away, want near 1 km away", vehicle.VehicleNo, distance)
+	}
+	if len(decision.Candidates) != 2 {
+		t.Errorf("decision has %d candidates, want the 2 free vehicles", len(decision.Candidates))
+	}
+
+	if _, _, _, _, err := PickVehicle(ctx, store, models.Booking{VehicleType: "large", PickupLocation: east(0)}, time.Now()); err != errNoVehicles {
+		t.Errorf("PickVehicle for a type with no vehicles: err = %v, want errNoVehicles", err)
+	}
+}
+
+func TestMatchBatch(t *testing.T) {
+	ctx := context.Background()
+	store := storeOf(smallVehicle("west", 1), smallVehicle("east", 3))
+
+	// Taking the nearest vehicle for the first booking would send the other
+	// vehicle 2.5 km to the second
+	bookings := []models.Booking{smallAt(2.1), {VehicleType: "large", PickupLocation: east(0)}, smallAt(3.5)}
+	matches := MatchBatch(ctx, store, bookings)
+
+	want := []string{"west", "", "east"}
+	for i, m := range matches {
+		if want[i] == "" {
+			if m.Err != errNoVehicles {
+				t.Errorf("booking %d: err = %v, want errNoVehicles", i, m.Err)
+			}
+			continue
+		}
+		if m.Err != nil {
+			t.Errorf("booking %d: %v", i, m.Err)
+			continue
+		}
+		if m.Vehicle.VehicleNo != want[i] {
+			t.Errorf("booking %d got %s, want %s", i, m.Vehicle.VehicleNo, want[i])
+		}
+	}
+
+	// Both vehicles were claimed
+	if _, _, _, _, err := PickVehicle(ctx, store, smallAt(0), time.Now()); err != errNoVehicles {
+		t.Errorf("PickVehicle after the batch: err = %v, want errNoVehicles", err)
+	}
+}
+
+func TestClaimIsAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	free, taken := smallVehicle("free", 1), smallVehicle("taken", 2)
+	store := storeOf(free, taken)
+	if err := store.Claim(ctx, []models.Vehicle{taken}); err != nil {
+		t.Fatalf("Claim of a free vehicle: %v", err)
+	}
+
+	if err := store.Claim(ctx, []models.Vehicle{free, taken}); err != errVehicleTaken {
+		t.Fatalf("Claim with a taken vehicle: err = %v, want errVehicleTaken", err)
+	}
+	if vehicle, _, _, _, err := PickVehicle(ctx, store, smallAt(0), time.Now()); err != nil || vehicle.VehicleNo != "free" {
+		t.Errorf("PickVehicle after a failed claim got %s (err %v), want the vehicle left free", vehicle.VehicleNo, err)
+	}
+}
@@ -0,0 +1,140 @@
+package booking
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	"logi-craft/utils"
+)
+
+// UpgradeOffer is a larger vehicle type that is free to take a booking when
+// none of the type it asked for is, with what the booking would cost on it
+type UpgradeOffer struct {
+	VehicleType string  `json:"vehicle_type"`
+	BaseFare    float64 `json:"base_fare"`
+	Cost        float64 `json:"cost"`
+}
+
+// upgradeChoice is what the customer said about larger vehicles
+type upgradeChoice struct {
+	accept      bool   // take the first free upgrade without asking
+	vehicleType string // take only this upgrade, already offered to them
+	freeOnly    bool   // only upgrades without a surcharge, for bookings already paid for
+}
+
+// upgradeOptions returns the upgrades allowed for a booking's vehicle type that
+// are offered on its route and fit the customer's choice, in order of preference
+func upgradeOptions(vehicleType string, offered []string, choice upgradeChoice) []config.UpgradeOption {
+	var options []config.UpgradeOption
+	for _, opt := range config.App.Upgrades.OptionsFor(vehicleType) {
+		if _, ok := utils.VehicleRates[opt.VehicleType]; !ok || !contains(offered, opt.VehicleType) {
+			continue
+		}
+		if choice.vehicleType != "" && opt.VehicleType != choice.vehicleType {
+			continue
+		}
+		if choice.freeOnly && opt.Surcharge > 0 {
+			continue
+		}
+		options = append(options, opt)
+	}
+	return options
+}
+
+// upgradeFare is the base fare of a booking moved to a larger vehicle
+func upgradeFare(fare float64, opt config.UpgradeOption) float64 {
+	return utils.RoundMoney(fare * (1 + opt.Surcharge/100))
+}
+
+// upgradeVehicle is called when no vehicle of a booking's type is free. If the
+// customer accepted an upgrade it gives the booking the first allowed larger
+// type with a free vehicle, moving the booking to that type and repricing it.
+// Otherwise it refuses the booking with the upgrades that are free, so the
+// customer can choose one and book again. Shared bookings pay for part of the
+// vehicle they asked for, so they are never upgraded.
+func upgradeVehicle(ctx context.Context, booking *models.Booking, offered []string, choice upgradeChoice) (models.Vehicle, models.Assignment, float64, *models.DispatchDecision, error) {
+	if booking.Shared {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+	}
+	options := upgradeOptions(booking.VehicleType, offered, choice)
+	if len(options) == 0 {
+		return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+	}
+
+	if !choice.accept && choice.vehicleType == "" {
+		var offers []UpgradeOffer
+		for _, opt := range options {
+			free, err := hasFreeVehicle(ctx, opt.VehicleType, booking.PickupLocation)
+			if err != nil {
+				return models.Vehicle{}, models.Assignment{}, 0, nil, err
+			}
+			if free {
+				fare := upgradeFare(booking.BaseFare, opt)
+				offers = append(offers, UpgradeOffer{VehicleType: opt.VehicleType, BaseFare: fare, Cost: costWith(*booking, fare)})
+			}
+		}
+		if len(offers) == 0 {
+			return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+		}
+		return models.Vehicle{}, models.Assignment{}, 0, nil, &BookingError{
+			Status:   http.StatusConflict,
+			Message:  fmt.Sprintf("No %s vehicles are available; a larger vehicle can be booked instead", booking.VehicleType),
+			Upgrades: offers,
+		}
+	}
+
+	for _, opt := range options {
+		upgraded := *booking
+		upgraded.VehicleType = opt.VehicleType
+		vehicle, assignment, distance, decision, err := claimVehicle(ctx, upgraded)
+		if err == errNoVehicles {
+			continue
+		} else if err != nil {
+			return vehicle, assignment, distance, nil, err
+		}
+		booking.UpgradedFrom = booking.VehicleType
+		booking.VehicleType = opt.VehicleType
+		booking.BaseFare = upgradeFare(booking.BaseFare, opt)
+		booking.Cost = costWith(*booking, booking.BaseFare)
+		return vehicle, assignment, distance, decision, nil
+	}
+	return models.Vehicle{}, models.Assignment{}, 0, nil, errNoVehicles
+}
+
+// costWith is what a booking costs at a base fare once its discount is taken off
+func costWith(booking models.Booking, fare float64) float64 {
+	if booking.Discount == nil {
+		return fare
+	}
+	return utils.RoundMoney(fare - booking.Discount.Amount)
+}
+
+// hasFreeVehicle reports whether a free vehicle of a type with a driver is
+// within the search radius of a point
+func hasFreeVehicle(ctx context.Context, vehicleType string, point models.Coordinates) (bool, error) {
+	nearby, err := nearestFree(ctx, fleet, vehicleType, point, config.App.Dispatch.Candidates)
+	if err != nil || len(nearby) == 0 {
+		return false, err
+	}
+	numbers := make([]string, len(nearby))
+	for i, v := range nearby {
+		numbers[i] = v.VehicleNo
+	}
+	drivers, err := driversOf(ctx, numbers)
+	if err != nil {
+		return false, err
+	}
+	return len(drivers) > 0, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// decisionLimit is the most decisions GetDispatchDecisions returns
+const decisionLimit = 50
+
+type DecisionsResponse struct {
+	Success   bool                      `json:"success"`
+	Message   string                    `json:"message"`
+	Decisions []models.DispatchDecision `json:"decisions"`
+}
+
+// EnsureIndexes supports looking decisions up by booking and by any driver
+// who was considered
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("dispatch_decisions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "candidates.driver_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Record stores a dispatch decision. Failing to record a decision should not
+// fail the booking, so errors are logged rather than returned.
+func Record(ctx context.Context, decision models.DispatchDecision) {
+	if _, err := db.GetCollection("dispatch_decisions").InsertOne(ctx, decision); err != nil {
+		fmt.Printf("Error recording dispatch decision for booking %s: %v\n", decision.BookingID.Hex(), err)
+	}
+}
+
+// GetDispatchDecisions returns the latest decisions for a booking, or those in
+// which a driver was considered, newest first
+func GetDispatchDecisions(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	query := r.URL.Query()
+	if hex := query.Get("booking_id"); hex != "" {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+			return
+		}
+		filter["booking_id"] = id
+	}
+	if hex := query.Get("driver_id"); hex != "" {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			http.Error(w, "Invalid Driver ID", http.StatusBadRequest)
+			return
+		}
+		filter["candidates.driver_id"] = id
+	}
+	if len(filter) == 0 {
+		http.Error(w, "booking_id or driver_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(decisionLimit)
+	cursor, err := db.GetCollection("dispatch_decisions").Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch dispatch decisions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	decisions := []models.DispatchDecision{}
+	if err = cursor.All(ctx, &decisions); err != nil {
+		http.Error(w, "Error decoding dispatch decisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DecisionsResponse{
+		Success:   true,
+		Message:   "Dispatch decisions retrieved successfully",
+		Decisions: decisions,
+	})
+}
@@ -0,0 +1,211 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	areas "logi-craft/routes/Areas"
+)
+
+// Strategy names
+const (
+	Nearest               = "nearest"
+	IdleWeighted          = "idle_weighted"
+	RatingWeighted        = "rating_weighted"
+	LeastRecentlyAssigned = "least_recently_assigned"
+
+	// SharedInsertion is recorded for shared bookings that join a vehicle
+	// already on a shared run, which is chosen by extra km driven instead
+	SharedInsertion = "shared_insertion"
+	// BatchMatching is recorded for bookings matched together in a batch with
+	// the least total pickup distance
+	BatchMatching = "batch_matching"
+)
+
+// maxIdle caps how long idle_weighted counts a driver as waiting, so drivers
+// who have been offline for days are not sent across the city
+const maxIdle = 8 * time.Hour
+
+// neutralRating is the rating that neither helps nor hurts a driver under
+// rating_weighted; drivers with no ratings yet are given it
+const neutralRating = 4.0
+
+// Candidate is a free vehicle that could serve a booking
+type Candidate struct {
+	Vehicle    models.Vehicle
+	Assignment models.Assignment
+	Distance   float64 // km from the pickup point
+}
+
+// idle returns how long the candidate's driver has been waiting for work
+func (c Candidate) idle(now time.Time) time.Duration {
+	freed := c.Assignment.FreedAt
+	if freed == nil {
+		return maxIdle
+	}
+	if assigned := c.Assignment.AssignedAt; assigned != nil && assigned.After(*freed) {
+		return 0
+	}
+	return min(now.Sub(*freed), maxIdle)
+}
+
+// rating returns the driver's average rating, or the neutral rating if they have none
+func (c Candidate) rating() float64 {
+	if c.Assignment.Ratings == 0 {
+		return neutralRating
+	}
+	return c.Assignment.Rating
+}
+
+// Strategy scores a candidate for a booking. The lowest score wins and ties go to the nearer vehicle.
+type Strategy interface {
+	Score(c Candidate, now time.Time) float64
+}
+
+// ScoreFunc lets an ordinary function be used as a Strategy
+type ScoreFunc func(c Candidate, now time.Time) float64
+
+func (f ScoreFunc) Score(c Candidate, now time.Time) float64 {
+	return f(c, now)
+}
+
+// strategies are the available strategies by name
+var strategies = map[string]Strategy{
+	// Nearest picks the vehicle closest to the pickup point
+	Nearest: ScoreFunc(func(c Candidate, now time.Time) float64 {
+		return c.Distance
+	}),
+	// IdleWeighted lets drivers who have waited longer win over slightly nearer ones
+	IdleWeighted: ScoreFunc(func(c Candidate, now time.Time) float64 {
+		return c.Distance - config.App.Dispatch.IdleKmPerHour*c.idle(now).Hours()
+	}),
+	// RatingWeighted lets better rated drivers win over slightly nearer ones
+	RatingWeighted: ScoreFunc(func(c Candidate, now time.Time) float64 {
+		return c.Distance - config.App.Dispatch.RatingKmPerStar*(c.rating()-neutralRating)
+	}),
+	// LeastRecentlyAssigned picks the driver whose last booking was longest ago,
+	// scoring by the hour of that booking; drivers never assigned score 0
+	LeastRecentlyAssigned: ScoreFunc(func(c Candidate, now time.Time) float64 {
+		if c.Assignment.AssignedAt == nil {
+			return 0
+		}
+		return float64(c.Assignment.AssignedAt.Unix()) / 3600
+	}),
+}
+
+// Setup checks that every strategy named in the config exists.
+func Setup(cfg config.DispatchConfig) error {
+	if _, ok := strategies[cfg.Strategy]; !ok {
+		return fmt.Errorf("unknown dispatch strategy %q", cfg.Strategy)
+	}
+	for _, rule := range cfg.StrategyRules {
+		if _, ok := strategies[rule.Strategy]; !ok {
+			return fmt.Errorf("unknown dispatch strategy %q", rule.Strategy)
+		}
+	}
+	return nil
+}
+
+// strategyFor returns the name of the strategy configured for a booking and
+// the city of its pickup point, if any rule needed to know it
+func strategyFor(ctx context.Context, booking models.Booking) (string, string, error) {
+	cfg := config.App.Dispatch
+
+	var cities []string
+	for _, rule := range cfg.StrategyRules {
+		if rule.City != "" {
+			var err error
+			if cities, err = areas.NamesAt(ctx, booking.PickupLocation); err != nil {
+				return "", "", err
+			}
+			break
+		}
+	}
+
+	for _, rule := range cfg.StrategyRules {
+		if rule.VehicleType != "" && rule.VehicleType != booking.VehicleType {
+			continue
+		}
+		if rule.City == "" {
+			return rule.Strategy, "", nil
+		}
+		for _, city := range cities {
+			if city == rule.City {
+				return rule.Strategy, city, nil
+			}
+		}
+	}
+	return cfg.Strategy, "", nil
+}
+
+// Decide picks one of the candidates for a booking at now with the strategy
+// configured for it and returns the decision with every candidate's score.
+// The decision is not recorded; the caller passes it to Record once the
+// booking has been given the vehicle.
+func Decide(ctx context.Context, booking models.Booking, candidates []Candidate, now time.Time) (Candidate, models.DispatchDecision, error) {
+	if len(candidates) == 0 {
+		return Candidate{}, models.DispatchDecision{}, fmt.Errorf("no candidates to choose from")
+	}
+	name, city, err := strategyFor(ctx, booking)
+	if err != nil {
+		return Candidate{}, models.DispatchDecision{}, fmt.Errorf("choosing dispatch strategy: %w", err)
+	}
+
+	best, scored, err := Choose(name, candidates, now)
+	if err != nil {
+		return Candidate{}, models.DispatchDecision{}, err
+	}
+	decision := models.DispatchDecision{
+		BookingID:   booking.ID,
+		Strategy:    name,
+		City:        city,
+		VehicleType: booking.VehicleType,
+		Pickup:      booking.PickupLocation,
+		Candidates:  scored,
+		VehicleNo:   candidates[best].Vehicle.VehicleNo,
+		DriverID:    candidates[best].Assignment.UID,
+		CreatedAt:   now,
+	}
+	return candidates[best], decision, nil
+}
+
+// Choose scores candidates with the named strategy and returns the index of
+// the winner with every candidate's entry for the decision log. It touches
+// no database.
+func Choose(name string, candidates []Candidate, now time.Time) (int, []models.DispatchCandidate, error) {
+	strategy, ok := strategies[name]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown dispatch strategy %q", name)
+	}
+	if len(candidates) == 0 {
+		return 0, nil, fmt.Errorf("no candidates to choose from")
+	}
+
+	scored := make([]models.DispatchCandidate, len(candidates))
+	best := 0
+	for i, c := range candidates {
+		entry := models.DispatchCandidate{
+			VehicleNo:  c.Vehicle.VehicleNo,
+			DriverID:   c.Assignment.UID,
+			Distance:   c.Distance,
+			Rating:     c.Assignment.Rating,
+			AssignedAt: c.Assignment.AssignedAt,
+			Score:      strategy.Score(c, now),
+		}
+		if idle := c.idle(now); idle > 0 {
+			entry.IdleMinutes = math.Round(idle.Minutes())
+		}
+		scored[i] = entry
+
+		top := scored[best]
+		if entry.Score < top.Score || (entry.Score == top.Score && entry.Distance < top.Distance) {
+			best = i
+		}
+	}
+	scored[best].Chosen = true
+	return best, scored, nil
+}
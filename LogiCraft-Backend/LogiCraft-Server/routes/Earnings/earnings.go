@@ -0,0 +1,297 @@
+package earnings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type EarningsResponse struct {
+	Success  bool                   `json:"success"`
+	Message  string                 `json:"message"`
+	Unpaid   float64                `json:"unpaid"`
+	Earnings []models.DriverEarning `json:"earnings,omitempty"`
+}
+
+type PayoutsResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Payouts []models.Payout `json:"payouts,omitempty"`
+}
+
+type StatementResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Payout  models.Payout          `json:"payout,omitempty"`
+	Entries []models.DriverEarning `json:"entries,omitempty"`
+}
+
+// GetDriverEarnings retrieves a driver's earnings ledger, newest first
+func GetDriverEarnings(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverID, err := primitive.ObjectIDFromHex(params["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid DriverId format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := db.GetCollection("driver_earnings").Find(ctx, bson.M{"driver_id": driverID}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch earnings", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []models.DriverEarning
+	if err = cursor.All(ctx, &entries); err != nil {
+		http.Error(w, "Error decoding earnings", http.StatusInternalServerError)
+		return
+	}
+
+	var unpaid float64
+	for _, entry := range entries {
+		if entry.PayoutID == nil {
+			unpaid += entry.Amount
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EarningsResponse{
+		Success:  true,
+		Message:  "Earnings retrieved successfully",
+		Unpaid:   utils.RoundMoney(unpaid),
+		Earnings: entries,
+	})
+}
+
+// AddDriverAdjustment lets an admin record an incentive or deduction for a driver
+func AddDriverAdjustment(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverID, err := primitive.ObjectIDFromHex(params["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid DriverId format", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Type        string  `json:"type"`
+		Amount      float64 `json:"amount"`
+		Description string  `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Type != models.EarningTypeIncentive && req.Type != models.EarningTypeDeduction {
+		http.Error(w, "Type must be incentive or deduction", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	earning, err := AddAdjustment(ctx, driverID, req.Type, req.Amount, req.Description)
+	if err != nil {
+		http.Error(w, "Failed to record adjustment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(earning)
+}
+
+// GetDriverPayouts retrieves the payout statements of a driver, newest first
+func GetDriverPayouts(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	driverID, err := primitive.ObjectIDFromHex(params["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid DriverId format", http.StatusBadRequest)
+		return
+	}
+	listPayouts(w, bson.M{"driver_id": driverID})
+}
+
+// GetAllPayouts retrieves payouts, optionally filtered by ?status=pending or ?status=settled
+func GetAllPayouts(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	listPayouts(w, filter)
+}
+
+func listPayouts(w http.ResponseWriter, filter bson.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "period_end", Value: -1}})
+	cursor, err := db.GetCollection("payouts").Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch payouts", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var payouts []models.Payout
+	if err = cursor.All(ctx, &payouts); err != nil {
+		http.Error(w, "Error decoding payouts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PayoutsResponse{
+		Success: true,
+		Message: "Payouts retrieved successfully",
+		Payouts: payouts,
+	})
+}
+
+// GetPayoutStatement retrieves a payout together with the earnings it covers
+func GetPayoutStatement(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	payoutID, err := primitive.ObjectIDFromHex(params["payoutId"])
+	if err != nil {
+		http.Error(w, "Invalid Payout ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var payout models.Payout
+	err = db.GetCollection("payouts").FindOne(ctx, bson.M{"_id": payoutID}).Decode(&payout)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(StatementResponse{Success: false, Message: "Payout not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch payout", http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := db.GetCollection("driver_earnings").Find(ctx, bson.M{"payout_id": payoutID}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch earnings", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []models.DriverEarning
+	if err = cursor.All(ctx, &entries); err != nil {
+		http.Error(w, "Error decoding earnings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatementResponse{
+		Success: true,
+		Message: "Payout statement retrieved successfully",
+		Payout:  payout,
+		Entries: entries,
+	})
+}
+
+// RunPayouts lets an admin run the payout batch by hand. Without a period_end
+// it pays out everything earned before the start of the current week.
+func RunPayouts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PeriodEnd time.Time `json:"period_end"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.PeriodEnd.IsZero() {
+		req.PeriodEnd = WeekStart(time.Now())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	payouts, err := RunPayoutBatch(ctx, req.PeriodEnd)
+	if err != nil {
+		http.Error(w, "Failed to run payout batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PayoutsResponse{
+		Success: true,
+		Message: "Payout batch completed",
+		Payouts: payouts,
+	})
+}
+
+// SettlePayout lets an admin mark a payout as paid to the driver
+func SettlePayout(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	payoutID, err := primitive.ObjectIDFromHex(params["payoutId"])
+	if err != nil {
+		http.Error(w, "Invalid Payout ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Reference string `json:"reference"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	now := time.Now()
+	var payout models.Payout
+	err = db.GetCollection("payouts").FindOneAndUpdate(ctx,
+		bson.M{"_id": payoutID, "status": models.PayoutStatusPending},
+		bson.M{"$set": bson.M{"status": models.PayoutStatusSettled, "reference": req.Reference, "settled_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&payout)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Payout not found or already settled", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to settle payout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payout)
+}
@@ -0,0 +1,206 @@
+package earnings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes makes sure a booking is only ever credited to a driver once
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("driver_earnings").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "type", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"booking_id": bson.M{"$exists": true},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "driver_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	return err
+}
+
+// RecordTrip credits the driver of a completed booking with their share of the fare.
+// The commission is taken from the fare before any promo discount, so promotions
+// run by the company do not reduce what the driver earns.
+func RecordTrip(ctx context.Context, booking models.Booking) error {
+	var vehicle models.Vehicle
+	err := db.GetCollection("vehicles").FindOne(ctx, bson.M{"vehicle_no": booking.VehicleNo}).Decode(&vehicle)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	gross := booking.BaseFare
+	if gross == 0 {
+		gross = booking.Cost
+	}
+	gross = utils.RoundMoney(gross)
+	commission := utils.RoundMoney(gross * config.App.Earnings.CommissionFor(vehicle.VehicleType) / 100)
+
+	bookingID := booking.ID
+	_, err = db.GetCollection("driver_earnings").InsertOne(ctx, models.DriverEarning{
+		DriverID:    booking.DriverID,
+		BookingID:   &bookingID,
+		Type:        models.EarningTypeTrip,
+		Description: fmt.Sprintf("Trip %s, vehicle %s", booking.ID.Hex(), booking.VehicleNo),
+		Gross:       gross,
+		Commission:  commission,
+		Amount:      utils.RoundMoney(gross - commission),
+		CreatedAt:   time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// Already credited, e.g. the job was completed twice
+		return nil
+	}
+	return err
+}
+
+// AddAdjustment records an incentive or deduction against a driver
+func AddAdjustment(ctx context.Context, driverID primitive.ObjectID, earningType string, amount float64, description string) (models.DriverEarning, error) {
+	amount = utils.RoundMoney(amount)
+	if earningType == models.EarningTypeDeduction {
+		amount = -amount
+	}
+
+	earning := models.DriverEarning{
+		DriverID:    driverID,
+		Type:        earningType,
+		Description: description,
+		Gross:       amount,
+		Amount:      amount,
+		CreatedAt:   time.Now(),
+	}
+	result, err := db.GetCollection("driver_earnings").InsertOne(ctx, earning)
+	if err != nil {
+		return earning, err
+	}
+	earning.ID = result.InsertedID.(primitive.ObjectID)
+	return earning, nil
+}
+
+// WeekStart returns midnight on the Monday of the week containing t
+func WeekStart(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.AddDate(0, 0, -daysSinceMonday).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// RunPayoutBatch gathers every unpaid earning created before periodEnd into one
+// payout statement per driver. Earnings are claimed with a conditional update,
+// so concurrent batches on different instances never pay an earning twice.
+func RunPayoutBatch(ctx context.Context, periodEnd time.Time) ([]models.Payout, error) {
+	earningsCollection := db.GetCollection("driver_earnings")
+	payoutsCollection := db.GetCollection("payouts")
+
+	unpaid := bson.M{"payout_id": bson.M{"$exists": false}, "created_at": bson.M{"$lt": periodEnd}}
+	driverIDs, err := earningsCollection.Distinct(ctx, "driver_id", unpaid)
+	if err != nil {
+		return nil, err
+	}
+
+	var payouts []models.Payout
+	for _, value := range driverIDs {
+		driverID, ok := value.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+
+		payout := models.Payout{
+			ID:        primitive.NewObjectID(),
+			DriverID:  driverID,
+			PeriodEnd: periodEnd,
+			Status:    models.PayoutStatusPending,
+			CreatedAt: time.Now(),
+		}
+		if _, err := payoutsCollection.InsertOne(ctx, payout); err != nil {
+			return payouts, err
+		}
+
+		filter := bson.M{"driver_id": driverID, "payout_id": bson.M{"$exists": false}, "created_at": bson.M{"$lt": periodEnd}}
+		result, err := earningsCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"payout_id": payout.ID}})
+		if err != nil {
+			return payouts, err
+		}
+		if result.ModifiedCount == 0 {
+			// Another instance claimed these earnings first
+			payoutsCollection.DeleteOne(ctx, bson.M{"_id": payout.ID})
+			continue
+		}
+
+		cursor, err := earningsCollection.Find(ctx, bson.M{"payout_id": payout.ID})
+		if err != nil {
+			return payouts, err
+		}
+		var entries []models.DriverEarning
+		if err := cursor.All(ctx, &entries); err != nil {
+			return payouts, err
+		}
+
+		summarise(&payout, entries)
+		_, err = payoutsCollection.ReplaceOne(ctx, bson.M{"_id": payout.ID}, payout)
+		if err != nil {
+			return payouts, err
+		}
+		payouts = append(payouts, payout)
+	}
+
+	return payouts, nil
+}
+
+// summarise fills in the statement totals of a payout from its earnings
+func summarise(payout *models.Payout, entries []models.DriverEarning) {
+	for i, entry := range entries {
+		if i == 0 || entry.CreatedAt.Before(payout.PeriodStart) {
+			payout.PeriodStart = entry.CreatedAt
+		}
+
+		switch entry.Type {
+		case models.EarningTypeTrip:
+			payout.Trips++
+			payout.Gross += entry.Gross
+			payout.Commission += entry.Commission
+		case models.EarningTypeIncentive:
+			payout.Incentives += entry.Amount
+		case models.EarningTypeDeduction:
+			payout.Deductions += -entry.Amount
+		}
+		payout.Net += entry.Amount
+	}
+
+	payout.Gross = utils.RoundMoney(payout.Gross)
+	payout.Commission = utils.RoundMoney(payout.Commission)
+	payout.Incentives = utils.RoundMoney(payout.Incentives)
+	payout.Deductions = utils.RoundMoney(payout.Deductions)
+	payout.Net = utils.RoundMoney(payout.Net)
+}
+
+// StartWeeklyPayouts checks every hour for earnings from previous weeks that
+// have not been paid out and batches them. It is safe to run on every instance.
+func StartWeeklyPayouts() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			payouts, err := RunPayoutBatch(ctx, WeekStart(time.Now()))
+			cancel()
+			if err != nil {
+				log.Printf("Weekly payout batch failed: %v", err)
+			} else if len(payouts) > 0 {
+				log.Printf("Weekly payout batch created %d payouts", len(payouts))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
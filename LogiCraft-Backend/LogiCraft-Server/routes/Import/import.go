@@ -0,0 +1,274 @@
+package imports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/geocode"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxUploadSize caps the size of an uploaded file in bytes
+const maxUploadSize = 5 << 20
+
+// storedJob is an import job as stored, with the rows still to be booked so
+// another server can finish the job if this one stops
+type storedJob struct {
+	models.ImportJob `bson:",inline"`
+	Pending          []pendingRow `bson:"pending"`
+	Current          *int         `bson:"current,omitempty"` // row being booked
+}
+
+type ImportResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Job     models.ImportJob `json:"job,omitempty"`
+}
+
+// CreateImport accepts a CSV or JSON lines file of shipments for a user. Every
+// row is validated straight away and the report of rejected rows is returned
+// with the job; valid rows are then booked in the background through the same
+// path as a single booking. Poll GetImport with the job ID for progress.
+//
+// The format comes from ?format=csv|jsonl or else the Content-Type. Rows that
+// leave payment_method or payment_source empty use ?payment_method= and
+// ?payment_source=.
+func CreateImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, err := primitive.ObjectIDFromHex(query.Get("user_id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = formatFromContentType(r.Header.Get("Content-Type"))
+	}
+	if format != "csv" && format != "jsonl" {
+		http.Error(w, "Format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	rows, rowErrors, err := readRows(format, http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pending []pendingRow
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		req, problems := validate(row, userID.Hex(), query.Get("payment_method"), query.Get("payment_source"))
+		if len(problems) > 0 {
+			rowErrors = append(rowErrors, models.ImportRowError{Row: i + 1, Message: strings.Join(problems, "; ")})
+			continue
+		}
+		p := pendingRow{Row: i + 1, Request: req}
+		if row.PickupLat == nil {
+			p.PickupAddress = row.PickupAddress
+		}
+		if row.DropoffLat == nil {
+			p.DropoffAddress = row.DropoffAddress
+		}
+		pending = append(pending, p)
+	}
+
+	sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+
+	now := time.Now()
+	job := models.ImportJob{
+		UserID:     userID,
+		Format:     format,
+		Status:     models.ImportStatusProcessing,
+		Total:      len(rows),
+		Processed:  len(rowErrors),
+		Failed:     len(rowErrors),
+		Errors:     rowErrors,
+		BookingIDs: []primitive.ObjectID{},
+		CreatedAt:  now,
+	}
+	if job.Errors == nil {
+		job.Errors = []models.ImportRowError{}
+	}
+	if len(pending) == 0 {
+		job.Status = models.ImportStatusCompleted
+		job.FinishedAt = &now
+	} else {
+		job.Lease = db.NewLease()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.GetCollection("import_jobs").InsertOne(ctx, storedJob{ImportJob: job, Pending: pending})
+	if err != nil {
+		http.Error(w, "Failed to create import job", http.StatusInternalServerError)
+		return
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	if len(pending) > 0 {
+		go process(job, pending)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ImportResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d rows accepted, %d rejected", len(pending), len(rowErrors)),
+		Job:     job,
+	})
+}
+
+func formatFromContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "csv"):
+		return "csv"
+	case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"), strings.Contains(contentType, "json"):
+		return "jsonl"
+	}
+	return ""
+}
+
+// process books each validated row in turn and records the outcome on the
+// job, taking the row off the job's pending rows. The job's lease is held
+// until every row is done.
+func process(job models.ImportJob, rows []pendingRow) {
+	release := db.KeepLease("import_jobs", job.ID, job.Lease)
+	defer release()
+	jobs := db.GetCollection("import_jobs")
+	jobID := job.ID
+
+	for _, row := range rows {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		// Marked first, so a server taking the job over knows the row may have been booked
+		if _, err := jobs.UpdateOne(ctx, bson.M{"_id": jobID}, bson.M{"$set": bson.M{"current": row.Row}}); err != nil {
+			fmt.Printf("Error updating import job %s: %v\n", jobID.Hex(), err)
+			cancel()
+			return
+		}
+		bookingID, err := bookRow(ctx, row)
+
+		if err != nil {
+			record(ctx, jobID, row.Row, bson.M{
+				"$inc":  bson.M{"processed": 1, "failed": 1},
+				"$push": bson.M{"errors": models.ImportRowError{Row: row.Row, Message: err.Error()}},
+			})
+		} else {
+			record(ctx, jobID, row.Row, bson.M{
+				"$inc":  bson.M{"processed": 1, "succeeded": 1},
+				"$push": bson.M{"booking_ids": bookingID},
+			})
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := jobs.UpdateOne(ctx, bson.M{"_id": jobID, "lease.owner": job.Lease.Owner}, bson.M{
+		"$set":   bson.M{"status": models.ImportStatusCompleted, "finished_at": time.Now()},
+		"$unset": bson.M{"lease": "", "pending": "", "current": ""},
+	})
+	if err != nil {
+		fmt.Printf("Error completing import job %s: %v\n", jobID.Hex(), err)
+	}
+}
+
+// record stores the outcome of a row and takes it off the job's pending rows
+func record(ctx context.Context, jobID primitive.ObjectID, row int, update bson.M) {
+	update["$pull"] = bson.M{"pending": bson.M{"row": row}}
+	update["$unset"] = bson.M{"current": ""}
+	if _, err := db.GetCollection("import_jobs").UpdateOne(ctx, bson.M{"_id": jobID}, update); err != nil {
+		fmt.Printf("Error updating import job %s: %v\n", jobID.Hex(), err)
+	}
+}
+
+// bookRow looks up any addresses given instead of coordinates and creates the booking.
+// Errors are worded for the row report.
+func bookRow(ctx context.Context, row pendingRow) (primitive.ObjectID, error) {
+	req := row.Request
+
+	var err error
+	if row.PickupAddress != "" {
+		if req.PickupCoords, err = lookup(ctx, row.PickupAddress); err != nil {
+			return primitive.NilObjectID, fmt.Errorf("pickup_address: %v", err)
+		}
+	}
+	if row.DropoffAddress != "" {
+		if req.DropoffCoords, err = lookup(ctx, row.DropoffAddress); err != nil {
+			return primitive.NilObjectID, fmt.Errorf("dropoff_address: %v", err)
+		}
+	}
+
+	result, err := booking.CreateBooking(ctx, req)
+	if err != nil {
+		var bookingErr *booking.BookingError
+		if errors.As(err, &bookingErr) {
+			return primitive.NilObjectID, bookingErr
+		}
+		fmt.Printf("Error creating booking for import row %d: %v\n", row.Row, err)
+		return primitive.NilObjectID, errors.New("Error creating booking")
+	}
+	return result.Booking.ID, nil
+}
+
+func lookup(ctx context.Context, address string) (models.Coordinates, error) {
+	coords, err := geocode.Lookup(ctx, address)
+	switch {
+	case err == geocode.ErrNotConfigured:
+		return coords, errors.New("address lookup is not available, give coordinates instead")
+	case err == geocode.ErrNotFound:
+		return coords, errors.New("address not found")
+	case err != nil:
+		fmt.Printf("Error looking up address %q: %v\n", address, err)
+		return coords, errors.New("address lookup failed")
+	}
+	return coords, nil
+}
+
+// GetImport returns an import job with its progress and per-row errors
+func GetImport(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	jobID, err := primitive.ObjectIDFromHex(params["jobId"])
+	if err != nil {
+		http.Error(w, "Invalid Job ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.ImportJob
+	err = db.GetCollection("import_jobs").FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ImportResponse{Success: false, Message: "Import job not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch import job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ImportResponse{
+		Success: true,
+		Message: "Import job retrieved successfully",
+		Job:     job,
+	})
+}
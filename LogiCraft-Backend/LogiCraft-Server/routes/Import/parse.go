@@ -0,0 +1,236 @@
+package imports
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+	"logi-craft/utils"
+)
+
+// maxRows caps the size of a single import
+const maxRows = 1000
+
+// ImportRow is one shipment in an upload. In a CSV file the field names are
+// the column headers; in JSON lines each line is one object. Either the
+// coordinates or the address of each end must be given.
+type ImportRow struct {
+	PickupLat      *float64 `json:"pickup_lat"`
+	PickupLng      *float64 `json:"pickup_lng"`
+	PickupAddress  string   `json:"pickup_address"`
+	DropoffLat     *float64 `json:"dropoff_lat"`
+	DropoffLng     *float64 `json:"dropoff_lng"`
+	DropoffAddress string   `json:"dropoff_address"`
+	VehicleType    string   `json:"vehicle_type"`
+	Cargo          string   `json:"cargo"`
+	ScheduledAt    string   `json:"scheduled_at"` // RFC 3339, empty to dispatch straight away
+	PromoCode      string   `json:"promo_code"`
+	PaymentMethod  string   `json:"payment_method"`
+	PaymentSource  string   `json:"payment_source"`
+}
+
+// pendingRow is a row that passed validation and is waiting to be booked.
+// Addresses are only looked up when the matching coordinates are missing.
+type pendingRow struct {
+	Row            int                    `bson:"row"`
+	Request        booking.BookingRequest `bson:"request"`
+	PickupAddress  string                 `bson:"pickup_address,omitempty"`
+	DropoffAddress string                 `bson:"dropoff_address,omitempty"`
+}
+
+// readRows splits an upload into rows. A row that cannot be decoded at all is
+// kept as nil with an error so it still shows up in the report.
+func readRows(format string, body io.Reader) ([]*ImportRow, []models.ImportRowError, error) {
+	switch format {
+	case "csv":
+		return readCSV(body)
+	case "jsonl":
+		return readJSONLines(body)
+	}
+	return nil, nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func readCSV(body io.Reader) ([]*ImportRow, []models.ImportRowError, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["vehicle_type"]; !ok {
+		return nil, nil, errors.New("missing vehicle_type column")
+	}
+
+	var rows []*ImportRow
+	var rowErrors []models.ImportRowError
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if n > maxRows {
+			return nil, nil, fmt.Errorf("too many rows, the limit is %d", maxRows)
+		}
+		if err != nil {
+			rows = append(rows, nil)
+			rowErrors = append(rowErrors, models.ImportRowError{Row: n, Message: "Malformed CSV row"})
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var problems []string
+		number := func(name string) *float64 {
+			value := field(name)
+			if value == "" {
+				return nil
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				problems = append(problems, name+" is not a number")
+				return nil
+			}
+			return &f
+		}
+
+		row := &ImportRow{
+			PickupLat:      number("pickup_lat"),
+			PickupLng:      number("pickup_lng"),
+			PickupAddress:  field("pickup_address"),
+			DropoffLat:     number("dropoff_lat"),
+			DropoffLng:     number("dropoff_lng"),
+			DropoffAddress: field("dropoff_address"),
+			VehicleType:    field("vehicle_type"),
+			Cargo:          field("cargo"),
+			ScheduledAt:    field("scheduled_at"),
+			PromoCode:      field("promo_code"),
+			PaymentMethod:  field("payment_method"),
+			PaymentSource:  field("payment_source"),
+		}
+		if len(problems) > 0 {
+			rows = append(rows, nil)
+			rowErrors = append(rowErrors, models.ImportRowError{Row: n, Message: strings.Join(problems, "; ")})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+func readJSONLines(body io.Reader) ([]*ImportRow, []models.ImportRowError, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []*ImportRow
+	var rowErrors []models.ImportRowError
+	n := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		n++
+		if n > maxRows {
+			return nil, nil, fmt.Errorf("too many rows, the limit is %d", maxRows)
+		}
+
+		var row ImportRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			rows = append(rows, nil)
+			rowErrors = append(rowErrors, models.ImportRowError{Row: n, Message: "Invalid JSON"})
+			continue
+		}
+		rows = append(rows, &row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("reading upload: %v", err)
+	}
+	if n == 0 {
+		return nil, nil, errors.New("file is empty")
+	}
+	return rows, rowErrors, nil
+}
+
+// validate checks a row and turns it into a booking request for the given user.
+// Defaults from the upload apply where the row leaves a field empty.
+func validate(row *ImportRow, userID, paymentMethod, paymentSource string) (booking.BookingRequest, []string) {
+	var problems []string
+	req := booking.BookingRequest{
+		UserID:        userID,
+		VehicleType:   strings.ToLower(row.VehicleType),
+		Cargo:         row.Cargo,
+		PromoCode:     row.PromoCode,
+		PaymentMethod: row.PaymentMethod,
+		PaymentSource: row.PaymentSource,
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = paymentMethod
+	}
+	if req.PaymentSource == "" {
+		req.PaymentSource = paymentSource
+	}
+
+	if _, ok := utils.VehicleRates[req.VehicleType]; !ok {
+		problems = append(problems, "vehicle_type must be small, medium or large")
+	}
+
+	var ok bool
+	if req.PickupCoords, ok = point(row.PickupLat, row.PickupLng); !ok {
+		problems = append(problems, "pickup_lat and pickup_lng must both be given and in range")
+	} else if row.PickupLat == nil && row.PickupAddress == "" {
+		problems = append(problems, "pickup coordinates or pickup_address is required")
+	}
+	if req.DropoffCoords, ok = point(row.DropoffLat, row.DropoffLng); !ok {
+		problems = append(problems, "dropoff_lat and dropoff_lng must both be given and in range")
+	} else if row.DropoffLat == nil && row.DropoffAddress == "" {
+		problems = append(problems, "dropoff coordinates or dropoff_address is required")
+	}
+
+	if row.ScheduledAt != "" {
+		t, err := time.Parse(time.RFC3339, row.ScheduledAt)
+		if err != nil {
+			problems = append(problems, "scheduled_at must be an RFC 3339 timestamp")
+		} else if t.Before(time.Now()) {
+			problems = append(problems, "scheduled_at is in the past")
+		} else {
+			req.ScheduledAt = &t
+		}
+	}
+
+	return req, problems
+}
+
+// point builds coordinates from an optional latitude and longitude. Both or
+// neither must be present; ok is false if only one is or either is out of range.
+func point(lat, lng *float64) (models.Coordinates, bool) {
+	if lat == nil && lng == nil {
+		return models.Coordinates{}, true
+	}
+	if lat == nil || lng == nil {
+		return models.Coordinates{}, false
+	}
+	if *lat < -90 || *lat > 90 || *lng < -180 || *lng > 180 {
+		return models.Coordinates{}, false
+	}
+	return models.Coordinates{Latitude: *lat, Longitude: *lng}, true
+}
@@ -0,0 +1,73 @@
+package imports
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecoverImports takes over import jobs whose lease ran out while their rows
+// were being booked, because the server working on them stopped, and books
+// the rows left. It returns how many jobs were taken over.
+func RecoverImports(ctx context.Context) (int, error) {
+	recovered := 0
+	for {
+		var job storedJob
+		err := db.TakeOver(ctx, "import_jobs", bson.M{"status": models.ImportStatusProcessing}, &job)
+		if err == mongo.ErrNoDocuments {
+			return recovered, nil
+		} else if err != nil {
+			return recovered, fmt.Errorf("taking over import job: %w", err)
+		}
+		recovered++
+		go resume(job)
+	}
+}
+
+// resume books the rows an import job has left. The row being booked when
+// the server stopped may already have its booking, so rather than risk
+// booking it twice it is reported as failed for the customer to check.
+func resume(job storedJob) {
+	rows := job.Pending
+	if job.Current != nil {
+		for i, row := range rows {
+			if row.Row != *job.Current {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			record(ctx, job.ID, row.Row, bson.M{
+				"$inc":  bson.M{"processed": 1, "failed": 1},
+				"$push": bson.M{"errors": models.ImportRowError{Row: row.Row, Message: "The import was interrupted while booking this row; check your bookings before uploading it again"}},
+			})
+			cancel()
+			rows = append(rows[:i:i], rows[i+1:]...)
+			break
+		}
+	}
+	process(job.ImportJob, rows)
+}
+
+// StartRecovery looks for import jobs to take over in the background,
+// starting straight away so jobs interrupted by a restart do not wait.
+func StartRecovery() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := RecoverImports(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Import recovery failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Import recovery took over %d jobs", n)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
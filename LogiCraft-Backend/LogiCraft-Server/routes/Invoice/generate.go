@@ -0,0 +1,199 @@
+package invoice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes allows a booking only one invoice that has not been credited,
+// so completing a booking twice at once cannot invoice it twice. Credited
+// invoices differ by the credit note that cancelled them.
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("invoices").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "type", Value: 1}, {Key: "credited_by", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"type": models.InvoiceTypeInvoice}),
+	})
+	return err
+}
+
+// Generate creates the invoice for a completed booking. If the booking already
+// has an invoice that has not been credited, that invoice is returned instead.
+func Generate(ctx context.Context, booking models.Booking) (*models.Invoice, error) {
+	collection := db.GetCollection("invoices")
+
+	existing, err := currentInvoice(ctx, booking.ID)
+	if err == nil {
+		return existing, nil
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	var user models.User
+	err = db.GetCollection("users").FindOne(ctx, bson.M{"_id": booking.UserID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	seq, err := db.NextSequence(ctx, "invoice")
+	if err != nil {
+		return nil, err
+	}
+
+	inv := buildInvoice(booking, user)
+	inv.InvoiceNo = fmt.Sprintf("INV-%06d", seq)
+	inv.Type = models.InvoiceTypeInvoice
+
+	if _, err := collection.InsertOne(ctx, inv); mongo.IsDuplicateKeyError(err) {
+		// Another request invoiced the booking first
+		return currentInvoice(ctx, booking.ID)
+	} else if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// currentInvoice returns the invoice of a booking that has not been credited
+func currentInvoice(ctx context.Context, bookingID primitive.ObjectID) (*models.Invoice, error) {
+	var inv models.Invoice
+	err := db.GetCollection("invoices").FindOne(ctx, bson.M{
+		"booking_id":  bookingID,
+		"type":        models.InvoiceTypeInvoice,
+		"credited_by": bson.M{"$exists": false},
+	}).Decode(&inv)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Reissue credits the current invoice of a booking and generates a new invoice
+// from the booking's adjusted fare. It returns the credit note and the new invoice.
+func Reissue(ctx context.Context, booking models.Booking, reason string) (*models.Invoice, *models.Invoice, error) {
+	collection := db.GetCollection("invoices")
+
+	original, err := currentInvoice(ctx, booking.ID)
+	if err == mongo.ErrNoDocuments {
+		// Nothing has been invoiced yet, so there is nothing to credit
+		inv, err := Generate(ctx, booking)
+		return nil, inv, err
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	seq, err := db.NextSequence(ctx, "credit_note")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creditNote := *original
+	creditNote.ID = primitive.NilObjectID
+	creditNote.InvoiceNo = fmt.Sprintf("CN-%06d", seq)
+	creditNote.Type = models.InvoiceTypeCreditNote
+	creditNote.IssuedAt = time.Now()
+	creditNote.Reference = original.InvoiceNo
+	creditNote.Reason = reason
+	creditNote.Lines = nil
+	for _, line := range original.Lines {
+		creditNote.Lines = append(creditNote.Lines, models.InvoiceLine{Description: line.Description, Amount: -line.Amount})
+	}
+	creditNote.Taxes = nil
+	for _, tax := range original.Taxes {
+		creditNote.Taxes = append(creditNote.Taxes, models.InvoiceTax{Name: tax.Name, Rate: tax.Rate, Amount: -tax.Amount})
+	}
+	creditNote.TaxableAmount = -original.TaxableAmount
+	creditNote.Total = -original.Total
+
+	if _, err := collection.InsertOne(ctx, creditNote); err != nil {
+		return nil, nil, err
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": original.ID}, bson.M{"$set": bson.M{"credited_by": creditNote.InvoiceNo}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inv, err := Generate(ctx, booking)
+	if err != nil {
+		return &creditNote, nil, err
+	}
+	return &creditNote, inv, nil
+}
+
+// buildInvoice fills in the parties and fare breakdown. The booking cost is
+// what the customer was quoted, so taxes are treated as included in it.
+func buildInvoice(booking models.Booking, user models.User) models.Invoice {
+	company := config.App.Company
+
+	inv := models.Invoice{
+		BookingID: booking.ID,
+		UserID:    booking.UserID,
+		IssuedAt:  time.Now(),
+		Company: models.InvoiceParty{
+			Name:    company.Name,
+			Address: company.Address,
+			Phone:   company.Phone,
+			GSTIN:   company.GSTIN,
+		},
+		Customer: models.InvoiceParty{
+			Name:    user.Name,
+			Address: user.Address,
+			Phone:   user.PhoneNumber,
+		},
+	}
+
+	baseFare := booking.BaseFare
+	if baseFare == 0 {
+		baseFare = booking.Cost
+	}
+	inv.Lines = append(inv.Lines, models.InvoiceLine{
+		Description: fmt.Sprintf("Transportation charges, vehicle %s, %.2f km", booking.VehicleNo, booking.Distance),
+		Amount:      utils.RoundMoney(baseFare),
+	})
+	if booking.Discount != nil {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{
+			Description: fmt.Sprintf("Promo discount (%s)", booking.Discount.Code),
+			Amount:      -booking.Discount.Amount,
+		})
+	}
+	if adjustment := utils.RoundMoney(booking.Cost - baseFare + discountAmount(booking)); adjustment != 0 {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Description: "Fare adjustment", Amount: adjustment})
+	}
+
+	var totalRate float64
+	for _, tax := range config.App.Taxes {
+		totalRate += tax.Rate
+	}
+
+	total := utils.RoundMoney(booking.Cost)
+	taxable := utils.RoundMoney(total / (1 + totalRate/100))
+	var taxSum float64
+	for _, tax := range config.App.Taxes {
+		amount := utils.RoundMoney(taxable * tax.Rate / 100)
+		taxSum += amount
+		inv.Taxes = append(inv.Taxes, models.InvoiceTax{Name: tax.Name, Rate: tax.Rate, Amount: amount})
+	}
+
+	// Absorb rounding differences in the taxable amount so the invoice adds up to the fare
+	inv.TaxableAmount = utils.RoundMoney(total - taxSum)
+	inv.Total = total
+	return inv
+}
+
+func discountAmount(booking models.Booking) float64 {
+	if booking.Discount == nil {
+		return 0
+	}
+	return booking.Discount.Amount
+}
@@ -0,0 +1,165 @@
+package invoice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InvoiceResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Invoice models.Invoice `json:"invoice,omitempty"`
+}
+
+type InvoicesResponse struct {
+	Success  bool             `json:"success"`
+	Message  string           `json:"message"`
+	Invoices []models.Invoice `json:"invoices,omitempty"`
+}
+
+// GetInvoicesByBookingID retrieves the invoices and credit notes issued for a booking
+func GetInvoicesByBookingID(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "issued_at", Value: 1}})
+	cursor, err := db.GetCollection("invoices").Find(ctx, bson.M{"booking_id": id}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch invoices", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var invoices []models.Invoice
+	if err = cursor.All(ctx, &invoices); err != nil {
+		http.Error(w, "Error decoding invoices", http.StatusInternalServerError)
+		return
+	}
+
+	if len(invoices) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(InvoicesResponse{Success: false, Message: "No invoices found for this booking"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InvoicesResponse{
+		Success:  true,
+		Message:  "Invoices retrieved successfully",
+		Invoices: invoices,
+	})
+}
+
+// GetInvoice retrieves an invoice or credit note by its number as JSON
+func GetInvoice(w http.ResponseWriter, r *http.Request) {
+	inv, ok := findInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, inv.InvoiceNo))
+	json.NewEncoder(w).Encode(InvoiceResponse{
+		Success: true,
+		Message: "Invoice retrieved successfully",
+		Invoice: inv,
+	})
+}
+
+// GetInvoicePDF renders an invoice or credit note as a PDF download
+func GetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	inv, ok := findInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.InvoiceNo))
+	w.Write(utils.TextPDF(invoiceLines(inv)))
+}
+
+func findInvoice(w http.ResponseWriter, r *http.Request) (models.Invoice, bool) {
+	params := mux.Vars(r)
+	invoiceNo := params["invoiceNo"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+	err := db.GetCollection("invoices").FindOne(ctx, bson.M{"invoice_no": invoiceNo}).Decode(&inv)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(InvoiceResponse{Success: false, Message: "Invoice not found"})
+		return inv, false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch invoice", http.StatusInternalServerError)
+		return inv, false
+	}
+	return inv, true
+}
+
+func invoiceLines(inv models.Invoice) []string {
+	title := "TAX INVOICE"
+	if inv.Type == models.InvoiceTypeCreditNote {
+		title = "CREDIT NOTE"
+	}
+
+	lines := []string{
+		title,
+		"",
+		inv.Company.Name,
+		inv.Company.Address,
+	}
+	if inv.Company.GSTIN != "" {
+		lines = append(lines, "GSTIN: "+inv.Company.GSTIN)
+	}
+	lines = append(lines,
+		"",
+		"Number: "+inv.InvoiceNo,
+		"Date: "+inv.IssuedAt.Format("02 Jan 2006"),
+		"Booking: "+inv.BookingID.Hex(),
+	)
+	if inv.Reference != "" {
+		lines = append(lines, "Against invoice: "+inv.Reference)
+	}
+	if inv.Reason != "" {
+		lines = append(lines, "Reason: "+inv.Reason)
+	}
+	lines = append(lines,
+		"",
+		"Bill to:",
+		inv.Customer.Name,
+		inv.Customer.Address,
+		inv.Customer.Phone,
+		"",
+	)
+	for _, line := range inv.Lines {
+		lines = append(lines, fmt.Sprintf("%-60s INR %10.2f", line.Description, line.Amount))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-60s INR %10.2f", "Taxable value", inv.TaxableAmount))
+	for _, tax := range inv.Taxes {
+		lines = append(lines, fmt.Sprintf("%-60s INR %10.2f", fmt.Sprintf("%s @ %.2f%%", tax.Name, tax.Rate), tax.Amount))
+	}
+	lines = append(lines, fmt.Sprintf("%-60s INR %10.2f", "Total", inv.Total))
+	return lines
+}
@@ -0,0 +1,382 @@
+package planning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PlanResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Plan    models.Plan `json:"plan,omitempty"`
+}
+
+// PlanRequest is a set of deliveries to share among vehicles leaving a depot.
+// Leave VehicleNos empty to use the free vehicles nearest the depot.
+type PlanRequest struct {
+	UserID        string                `json:"user_id"`
+	Depot         models.Coordinates    `json:"depot"`
+	StartAt       *time.Time            `json:"start_at"` // when the vehicles leave, now if empty
+	ReturnToDepot bool                  `json:"return_to_depot"`
+	Deliveries    []models.PlanDelivery `json:"deliveries"`
+	VehicleNos    []string              `json:"vehicle_nos"`
+}
+
+// CommitRequest says how the bookings of a committed plan are paid for
+type CommitRequest struct {
+	PaymentMethod string `json:"payment_method"`
+	PaymentSource string `json:"payment_source"`
+}
+
+// CreatePlan accepts a planning problem and solves it in the background.
+// Poll GetPlan with the plan ID until it is solved, then commit it with CommitPlan.
+// A plan left solving by a server that stopped is solved again by StartRecovery.
+func CreatePlan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	startAt := now
+	if req.StartAt != nil {
+		startAt = *req.StartAt
+	}
+	if msg := validate(req, startAt); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	for i := range req.Deliveries {
+		if req.Deliveries[i].ServiceMinutes == 0 {
+			req.Deliveries[i].ServiceMinutes = config.App.Planning.ServiceMinutes
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	vehicleNos, msg, err := chooseVehicles(ctx, req.Depot, req.VehicleNos)
+	if err != nil {
+		fmt.Printf("Error choosing vehicles for plan: %v\n", err)
+		http.Error(w, "Failed to find vehicles", http.StatusInternalServerError)
+		return
+	}
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	plan := models.Plan{
+		UserID:        userID,
+		Status:        models.PlanStatusSolving,
+		Depot:         req.Depot,
+		StartAt:       startAt,
+		ReturnToDepot: req.ReturnToDepot,
+		Deliveries:    req.Deliveries,
+		VehicleNos:    vehicleNos,
+		Routes:        []models.PlanRoute{},
+		Unassigned:    []int{},
+		BookingIDs:    []primitive.ObjectID{},
+		Errors:        []models.PlanDeliveryError{},
+		CreatedAt:     now,
+		Lease:         db.NewLease(),
+	}
+	result, err := db.GetCollection("plans").InsertOne(ctx, plan)
+	if err != nil {
+		http.Error(w, "Failed to create plan", http.StatusInternalServerError)
+		return
+	}
+	plan.ID = result.InsertedID.(primitive.ObjectID)
+
+	go solve(plan)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PlanResponse{
+		Success: true,
+		Message: fmt.Sprintf("Planning %d deliveries on %d vehicles", len(plan.Deliveries), len(plan.VehicleNos)),
+		Plan:    plan,
+	})
+}
+
+// validate checks a planning problem, returning what is wrong with it or "" if nothing is
+func validate(req PlanRequest, startAt time.Time) string {
+	if !inRange(req.Depot) {
+		return "Depot coordinates are out of range"
+	}
+	if len(req.Deliveries) == 0 {
+		return "At least one delivery is required"
+	}
+	if max := config.App.Planning.MaxDeliveries; len(req.Deliveries) > max {
+		return fmt.Sprintf("Too many deliveries, the limit is %d", max)
+	}
+	for i, d := range req.Deliveries {
+		switch {
+		case !inRange(d.Location):
+			return fmt.Sprintf("Delivery %d: location is out of range", i)
+		case d.Load.Volume <= 0 || d.Load.Weight <= 0:
+			return fmt.Sprintf("Delivery %d: cargo volume and weight must be positive", i)
+		case d.ServiceMinutes < 0:
+			return fmt.Sprintf("Delivery %d: service_minutes cannot be negative", i)
+		case d.Earliest != nil && d.Latest != nil && d.Latest.Before(*d.Earliest):
+			return fmt.Sprintf("Delivery %d: latest is before earliest", i)
+		case d.Latest != nil && d.Latest.Before(startAt):
+			return fmt.Sprintf("Delivery %d: latest is before the plan starts", i)
+		}
+	}
+	return ""
+}
+
+func inRange(p models.Coordinates) bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 && p.Longitude >= -180 && p.Longitude <= 180
+}
+
+// chooseVehicles checks the vehicles named for a plan, or picks the free
+// vehicles nearest the depot when none are named. Only vehicles with a cargo
+// capacity and a driver can be planned.
+func chooseVehicles(ctx context.Context, depot models.Coordinates, named []string) ([]string, string, error) {
+	types := make([]string, 0, len(config.App.Sharing.Capacities))
+	for vehicleType := range config.App.Sharing.Capacities {
+		types = append(types, vehicleType)
+	}
+
+	var filter bson.M
+	opts := options.Find()
+	if len(named) > 0 {
+		filter = bson.M{"vehicle_no": bson.M{"$in": named}}
+	} else {
+		filter = bson.M{
+			"busy":         false,
+			"online":       true,
+			"vehicle_type": bson.M{"$in": types},
+			"location": bson.M{"$nearSphere": bson.M{
+				"$geometry":    models.PointOf(depot),
+				"$maxDistance": config.App.Dispatch.SearchRadiusKm * 1000, // metres
+			}},
+		}
+		opts.SetLimit(int64(config.App.Planning.MaxVehicles))
+	}
+	cursor, err := db.GetCollection("vehicles").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("finding vehicles: %w", err)
+	}
+	var vehicles []models.Vehicle
+	if err := cursor.All(ctx, &vehicles); err != nil {
+		return nil, "", fmt.Errorf("decoding vehicles: %w", err)
+	}
+
+	numbers := make([]string, len(vehicles))
+	for i, v := range vehicles {
+		numbers[i] = v.VehicleNo
+	}
+	drivers, err := driversOf(ctx, numbers)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(named) > 0 {
+		found := map[string]models.Vehicle{}
+		for _, v := range vehicles {
+			found[v.VehicleNo] = v
+		}
+		listed := map[string]bool{}
+		for _, no := range named {
+			if listed[no] {
+				return nil, fmt.Sprintf("Vehicle %s is listed more than once", no), nil
+			}
+			listed[no] = true
+			v, ok := found[no]
+			if !ok {
+				return nil, fmt.Sprintf("Vehicle %s not found", no), nil
+			}
+			if _, ok := drivers[no]; !ok {
+				return nil, fmt.Sprintf("Vehicle %s has no driver", no), nil
+			}
+			if _, ok := config.App.Sharing.Capacities[v.VehicleType]; !ok {
+				return nil, fmt.Sprintf("Vehicle %s has no cargo capacity", no), nil
+			}
+		}
+		return named, "", nil
+	}
+
+	var chosen []string
+	for _, v := range vehicles {
+		if _, ok := drivers[v.VehicleNo]; ok {
+			chosen = append(chosen, v.VehicleNo)
+		}
+	}
+	if len(chosen) == 0 {
+		return nil, "No available vehicles found near the depot", nil
+	}
+	return chosen, "", nil
+}
+
+// driversOf returns the driver assignments of the given vehicles by vehicle number
+func driversOf(ctx context.Context, vehicleNos []string) (map[string]models.Assignment, error) {
+	cursor, err := db.GetCollection("assignments").Find(ctx, bson.M{"vehicle_no": bson.M{"$in": vehicleNos}})
+	if err != nil {
+		return nil, fmt.Errorf("finding assignments for vehicles: %w", err)
+	}
+	var assignments []models.Assignment
+	if err := cursor.All(ctx, &assignments); err != nil {
+		return nil, fmt.Errorf("decoding assignments: %w", err)
+	}
+	drivers := make(map[string]models.Assignment, len(assignments))
+	for _, a := range assignments {
+		drivers[a.VehicleNo] = a
+	}
+	return drivers, nil
+}
+
+// GetPlan returns a plan with its routes once solved, and the bookings made once committed
+func GetPlan(w http.ResponseWriter, r *http.Request) {
+	planID, err := primitive.ObjectIDFromHex(mux.Vars(r)["planId"])
+	if err != nil {
+		http.Error(w, "Invalid Plan ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var plan models.Plan
+	err = db.GetCollection("plans").FindOne(ctx, bson.M{"_id": planID}).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(PlanResponse{Success: false, Message: "Plan not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlanResponse{
+		Success: true,
+		Message: "Plan retrieved successfully",
+		Plan:    plan,
+	})
+}
+
+// CommitPlan claims the vehicles of a solved plan and books each planned
+// delivery on its vehicle in the background. Poll GetPlan for the bookings
+// made and any deliveries that could not be booked. A plan left committing by
+// a server that stopped is finished by StartRecovery.
+func CommitPlan(w http.ResponseWriter, r *http.Request) {
+	planID, err := primitive.ObjectIDFromHex(mux.Vars(r)["planId"])
+	if err != nil {
+		http.Error(w, "Invalid Plan ID", http.StatusBadRequest)
+		return
+	}
+	var req CommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Move the plan on conditionally so it is only ever committed once
+	plans := db.GetCollection("plans")
+	var plan models.Plan
+	err = plans.FindOneAndUpdate(ctx,
+		bson.M{"_id": planID, "status": models.PlanStatusSolved},
+		bson.M{"$set": bson.M{
+			"status":         models.PlanStatusCommitting,
+			"payment_method": req.PaymentMethod,
+			"payment_source": req.PaymentSource,
+			"lease":          db.NewLease(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		count, countErr := plans.CountDocuments(ctx, bson.M{"_id": planID})
+		if countErr == nil && count == 0 {
+			http.Error(w, "Plan not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Only a solved plan that has not been committed can be committed", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch plan", http.StatusInternalServerError)
+		return
+	}
+
+	// Give the plan back to be committed again if its vehicles cannot be claimed
+	reopen := func() {
+		_, err := plans.UpdateOne(ctx, bson.M{"_id": planID}, bson.M{
+			"$set":   bson.M{"status": models.PlanStatusSolved},
+			"$unset": bson.M{"lease": ""},
+		})
+		if err != nil {
+			fmt.Printf("Error reopening plan %s: %v\n", planID.Hex(), err)
+		}
+	}
+
+	if len(plan.Routes) == 0 {
+		reopen()
+		http.Error(w, "The plan has no routes to commit", http.StatusConflict)
+		return
+	}
+
+	vehicles, drivers, err := routeVehicles(ctx, plan)
+	if err != nil {
+		reopen()
+		var bookingErr *booking.BookingError
+		if errors.As(err, &bookingErr) {
+			http.Error(w, bookingErr.Message, bookingErr.Status)
+			return
+		}
+		fmt.Printf("Error loading vehicles for plan %s: %v\n", planID.Hex(), err)
+		http.Error(w, "Failed to load the plan's vehicles", http.StatusInternalServerError)
+		return
+	}
+
+	claims := make([]booking.PlanClaim, len(plan.Routes))
+	for i, route := range plan.Routes {
+		claims[i] = booking.PlanClaim{Vehicle: vehicles[route.VehicleNo], Load: route.Load}
+	}
+	if err := booking.ClaimForPlan(ctx, planID, claims); err != nil {
+		reopen()
+		var bookingErr *booking.BookingError
+		if errors.As(err, &bookingErr) {
+			http.Error(w, bookingErr.Message, bookingErr.Status)
+			return
+		}
+		fmt.Printf("Error claiming vehicles for plan %s: %v\n", planID.Hex(), err)
+		http.Error(w, "Failed to claim the plan's vehicles", http.StatusInternalServerError)
+		return
+	}
+
+	go commit(plan, vehicles, drivers)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PlanResponse{
+		Success: true,
+		Message: "Committing plan",
+		Plan:    plan,
+	})
+}
@@ -0,0 +1,100 @@
+package planning
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecoverPlans takes over plans whose lease ran out while they were being
+// solved or committed, because the server working on them stopped. Solving
+// starts again; committing carries on from the first delivery without an
+// outcome. It returns how many plans were taken over.
+func RecoverPlans(ctx context.Context) (int, error) {
+	stale := bson.M{"status": bson.M{"$in": bson.A{models.PlanStatusSolving, models.PlanStatusCommitting}}}
+	recovered := 0
+	for {
+		var plan models.Plan
+		err := db.TakeOver(ctx, "plans", stale, &plan)
+		if err == mongo.ErrNoDocuments {
+			return recovered, nil
+		} else if err != nil {
+			return recovered, fmt.Errorf("taking over plan: %w", err)
+		}
+		recovered++
+		if plan.Status == models.PlanStatusSolving {
+			go solve(plan)
+		} else {
+			go resume(plan)
+		}
+	}
+}
+
+// resume finishes committing a plan on the vehicles claimed for it. If they
+// can no longer take the deliveries left, those deliveries are reported as
+// not booked and their load is taken off the vehicles.
+func resume(plan models.Plan) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	vehicles, drivers, err := routeVehicles(ctx, plan)
+	cancel()
+	if err == nil {
+		commit(plan, vehicles, drivers)
+		return
+	}
+	fmt.Printf("Error loading vehicles to resume plan %s: %v\n", plan.ID.Hex(), err)
+
+	release := db.KeepLease("plans", plan.ID, plan.Lease)
+	defer release()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	done, err := handled(ctx, plan)
+	cancel()
+	if err != nil {
+		fmt.Printf("Error resuming plan %s: %v\n", plan.ID.Hex(), err)
+		return
+	}
+	for _, route := range plan.Routes {
+		for _, stop := range route.Stops {
+			if done[stop.Delivery] {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			load := plan.Deliveries[stop.Delivery].Load
+			if err := booking.ReleasePlanned(ctx, route.VehicleNo, load); err != nil {
+				fmt.Printf("Error unloading vehicle %s: %v\n", route.VehicleNo, err)
+			}
+			failed := models.PlanDeliveryError{Delivery: stop.Delivery, Message: "The plan's vehicle was no longer available when committing resumed"}
+			if _, err := db.GetCollection("plans").UpdateOne(ctx, bson.M{"_id": plan.ID}, bson.M{"$push": bson.M{"errors": failed}}); err != nil {
+				fmt.Printf("Error updating plan %s: %v\n", plan.ID.Hex(), err)
+			}
+			cancel()
+		}
+	}
+	finish(plan)
+}
+
+// StartRecovery looks for plans to take over in the background, starting
+// straight away so plans interrupted by a restart do not wait.
+func StartRecovery() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := RecoverPlans(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Plan recovery failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Plan recovery took over %d plans", n)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
@@ -0,0 +1,328 @@
+package planning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+	tracking "logi-craft/routes/Tracking"
+	"logi-craft/routing"
+	"logi-craft/vrp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// solve plans the routes of a new plan and stores them, or marks the plan
+// failed. The plan's lease is held until then.
+func solve(plan models.Plan) {
+	release := db.KeepLease("plans", plan.ID, plan.Lease)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.App.Planning.SearchTime()+time.Minute)
+	routes, unassigned, distance, err := planRoutes(ctx, plan)
+	cancel()
+
+	update := bson.M{"status": models.PlanStatusFailed}
+	if err != nil {
+		fmt.Printf("Error solving plan %s: %v\n", plan.ID.Hex(), err)
+		update["error"] = "Failed to plan routes"
+	} else {
+		update = bson.M{
+			"status":     models.PlanStatusSolved,
+			"routes":     routes,
+			"unassigned": unassigned,
+			"distance":   distance,
+			"solved_at":  time.Now(),
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// A server that took the plan over after this one lost its lease stores its own result
+	_, err = db.GetCollection("plans").UpdateOne(ctx,
+		bson.M{"_id": plan.ID, "lease.owner": plan.Lease.Owner},
+		bson.M{"$set": update, "$unset": bson.M{"lease": ""}})
+	if err != nil {
+		fmt.Printf("Error storing plan %s: %v\n", plan.ID.Hex(), err)
+	}
+}
+
+// planRoutes shares a plan's deliveries among its vehicles. Driving distances
+// and times come from the road network; times are those of the slowest of the
+// plan's vehicle types in the traffic when the plan starts.
+func planRoutes(ctx context.Context, plan models.Plan) ([]models.PlanRoute, []int, float64, error) {
+	cursor, err := db.GetCollection("vehicles").Find(ctx, bson.M{"vehicle_no": bson.M{"$in": plan.VehicleNos}})
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("finding vehicles: %w", err)
+	}
+	var found []models.Vehicle
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, nil, 0, fmt.Errorf("decoding vehicles: %w", err)
+	}
+	byNo := map[string]models.Vehicle{}
+	for _, v := range found {
+		byNo[v.VehicleNo] = v
+	}
+
+	var vehicles []models.Vehicle
+	var types []string
+	seen := map[string]bool{}
+	shift := config.App.Planning.ShiftHours * 60
+	problem := vrp.Problem{ReturnToDepot: plan.ReturnToDepot}
+	for _, no := range plan.VehicleNos {
+		v, ok := byNo[no]
+		if !ok {
+			continue
+		}
+		capacity, ok := config.App.Sharing.Capacities[v.VehicleType]
+		if !ok {
+			continue
+		}
+		vehicles = append(vehicles, v)
+		problem.Vehicles = append(problem.Vehicles, vrp.Vehicle{
+			Capacity: vrp.Load{Volume: capacity.Volume, Weight: capacity.Weight},
+			End:      shift,
+		})
+		if !seen[v.VehicleType] {
+			seen[v.VehicleType] = true
+			types = append(types, v.VehicleType)
+		}
+	}
+
+	minutes := func(t *time.Time, otherwise float64) float64 {
+		if t == nil {
+			return otherwise
+		}
+		return math.Max(t.Sub(plan.StartAt).Minutes(), 0)
+	}
+	locations := []models.Coordinates{plan.Depot}
+	for _, d := range plan.Deliveries {
+		locations = append(locations, d.Location)
+		problem.Stops = append(problem.Stops, vrp.Stop{
+			Demand:   vrp.Load{Volume: d.Load.Volume, Weight: d.Load.Weight},
+			Earliest: minutes(d.Earliest, 0),
+			Latest:   minutes(d.Latest, math.Inf(1)),
+			Service:  d.ServiceMinutes,
+		})
+	}
+
+	n := len(locations)
+	problem.Distance = make([][]float64, n)
+	problem.Time = make([][]float64, n)
+	for i := range locations {
+		problem.Distance[i] = make([]float64, n)
+		problem.Time[i] = make([]float64, n)
+	}
+	for to := range locations {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, 0, err
+		}
+		// One search backwards from each location finds the trips to it from all the others
+		trips := routing.ToPoint(locations, locations[to])
+		for from, trip := range trips {
+			if from == to {
+				continue
+			}
+			problem.Distance[from][to] = trip.Distance
+			for _, vehicleType := range types {
+				t := tracking.TravelTime(vehicleType, trip, plan.StartAt).Minutes()
+				problem.Time[from][to] = math.Max(problem.Time[from][to], t)
+			}
+		}
+	}
+
+	solution := vrp.Solve(problem, time.Now().Add(config.App.Planning.SearchTime()))
+
+	routes := []models.PlanRoute{}
+	for _, r := range solution.Routes {
+		v := vehicles[r.Vehicle]
+		route := models.PlanRoute{
+			VehicleNo:   v.VehicleNo,
+			VehicleType: v.VehicleType,
+			Distance:    r.Distance,
+			Load:        models.CargoLoad{Volume: r.Load.Volume, Weight: r.Load.Weight},
+		}
+		for k, i := range r.Stops {
+			arrive := plan.StartAt.Add(time.Duration(r.Arrivals[k] * float64(time.Minute)))
+			route.Stops = append(route.Stops, models.PlanStop{
+				Delivery: i,
+				ArriveAt: arrive,
+				DepartAt: arrive.Add(time.Duration(plan.Deliveries[i].ServiceMinutes * float64(time.Minute))),
+			})
+		}
+		routes = append(routes, route)
+	}
+	return routes, solution.Unassigned, solution.Distance, nil
+}
+
+// routeVehicles loads the vehicles driving a plan's routes and their drivers
+// by vehicle number
+func routeVehicles(ctx context.Context, plan models.Plan) (map[string]models.Vehicle, map[string]models.Assignment, error) {
+	numbers := make([]string, len(plan.Routes))
+	for i, route := range plan.Routes {
+		numbers[i] = route.VehicleNo
+	}
+
+	cursor, err := db.GetCollection("vehicles").Find(ctx, bson.M{"vehicle_no": bson.M{"$in": numbers}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding vehicles: %w", err)
+	}
+	var found []models.Vehicle
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, nil, fmt.Errorf("decoding vehicles: %w", err)
+	}
+	vehicles := make(map[string]models.Vehicle, len(found))
+	for _, v := range found {
+		vehicles[v.VehicleNo] = v
+	}
+
+	drivers, err := driversOf(ctx, numbers)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, no := range numbers {
+		if _, ok := vehicles[no]; !ok {
+			return nil, nil, &booking.BookingError{Status: http.StatusConflict, Message: fmt.Sprintf("Vehicle %s no longer exists", no)}
+		}
+		if _, ok := drivers[no]; !ok {
+			return nil, nil, &booking.BookingError{Status: http.StatusConflict, Message: fmt.Sprintf("Vehicle %s no longer has a driver", no)}
+		}
+	}
+	return vehicles, drivers, nil
+}
+
+// commit books every delivery on its planned vehicle, in route order, and
+// records each outcome on the plan. Each vehicle is already claimed and
+// carries the load of its whole route. Deliveries the plan already has an
+// outcome for, from a server that stopped part way through, are skipped.
+func commit(plan models.Plan, vehicles map[string]models.Vehicle, drivers map[string]models.Assignment) {
+	release := db.KeepLease("plans", plan.ID, plan.Lease)
+	defer release()
+	plans := db.GetCollection("plans")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	done, err := handled(ctx, plan)
+	cancel()
+	if err != nil {
+		// Try again once the lease runs out rather than book a delivery twice
+		fmt.Printf("Error resuming plan %s: %v\n", plan.ID.Hex(), err)
+		return
+	}
+
+	for _, route := range plan.Routes {
+		for _, stop := range route.Stops {
+			if done[stop.Delivery] {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			bookingID, err := bookStop(ctx, plan, route, stop, vehicles[route.VehicleNo], drivers[route.VehicleNo])
+
+			var update bson.M
+			if err != nil {
+				update = bson.M{"$push": bson.M{"errors": models.PlanDeliveryError{Delivery: stop.Delivery, Message: err.Error()}}}
+			} else {
+				update = bson.M{"$addToSet": bson.M{"booking_ids": bookingID}}
+			}
+			if _, err := plans.UpdateOne(ctx, bson.M{"_id": plan.ID}, update); err != nil {
+				fmt.Printf("Error updating plan %s: %v\n", plan.ID.Hex(), err)
+			}
+			cancel()
+		}
+	}
+	finish(plan)
+}
+
+// handled returns the deliveries of a plan that were booked or refused. A
+// booking made just before a server stopped may not have been recorded on
+// the plan yet, so it is added now.
+func handled(ctx context.Context, plan models.Plan) (map[int]bool, error) {
+	done := map[int]bool{}
+	for _, e := range plan.Errors {
+		done[e.Delivery] = true
+	}
+
+	cursor, err := db.GetCollection("bookings").Find(ctx,
+		bson.M{"plan_id": plan.ID},
+		options.Find().SetProjection(bson.M{"_id": 1, "plan_delivery": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("finding the plan's bookings: %w", err)
+	}
+	var booked []models.Booking
+	if err := cursor.All(ctx, &booked); err != nil {
+		return nil, fmt.Errorf("decoding the plan's bookings: %w", err)
+	}
+	ids := make([]primitive.ObjectID, 0, len(booked))
+	for _, b := range booked {
+		if b.PlanDelivery != nil {
+			done[*b.PlanDelivery] = true
+		}
+		ids = append(ids, b.ID)
+	}
+	if len(ids) > 0 {
+		_, err = db.GetCollection("plans").UpdateOne(ctx, bson.M{"_id": plan.ID}, bson.M{"$addToSet": bson.M{"booking_ids": bson.M{"$each": ids}}})
+		if err != nil {
+			return nil, fmt.Errorf("recording the plan's bookings: %w", err)
+		}
+	}
+	return done, nil
+}
+
+// finish marks a plan committed once every delivery has an outcome
+func finish(plan models.Plan) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.GetCollection("plans").UpdateOne(ctx,
+		bson.M{"_id": plan.ID, "lease.owner": plan.Lease.Owner},
+		bson.M{
+			"$set":   bson.M{"status": models.PlanStatusCommitted, "committed_at": time.Now()},
+			"$unset": bson.M{"lease": ""},
+		})
+	if err != nil {
+		fmt.Printf("Error completing plan %s: %v\n", plan.ID.Hex(), err)
+	}
+}
+
+// bookStop creates the booking for one planned delivery, picked up at the
+// depot. Errors are worded for the plan report.
+func bookStop(ctx context.Context, plan models.Plan, route models.PlanRoute, stop models.PlanStop, vehicle models.Vehicle, driver models.Assignment) (primitive.ObjectID, error) {
+	delivery := plan.Deliveries[stop.Delivery]
+	load := delivery.Load
+	bookingReq := booking.BookingRequest{
+		UserID:        plan.UserID.Hex(),
+		VehicleType:   route.VehicleType,
+		PickupCoords:  plan.Depot,
+		DropoffCoords: delivery.Location,
+		Cargo:         delivery.Cargo,
+		Load:          &load,
+		Receiver:      delivery.Receiver,
+		PaymentMethod: plan.PaymentMethod,
+		PaymentSource: plan.PaymentSource,
+	}
+	planned := booking.PlannedVehicle{
+		Vehicle:    vehicle,
+		Assignment: driver,
+		PlanID:     plan.ID,
+		Delivery:   stop.Delivery,
+		ETA:        models.BookingETA{PickupAt: plan.StartAt, DropoffAt: stop.ArriveAt, UpdatedAt: time.Now()},
+	}
+
+	result, err := booking.CreatePlannedBooking(ctx, bookingReq, planned)
+	if err != nil {
+		var bookingErr *booking.BookingError
+		if errors.As(err, &bookingErr) {
+			return primitive.NilObjectID, bookingErr
+		}
+		fmt.Printf("Error creating booking for plan %s delivery %d: %v\n", plan.ID.Hex(), stop.Delivery, err)
+		return primitive.NilObjectID, errors.New("Error creating booking")
+	}
+	return result.Booking.ID, nil
+}
@@ -0,0 +1,359 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/geoindex"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PresenceResponse struct {
+	Success    bool              `json:"success"`
+	Message    string            `json:"message"`
+	Assignment models.Assignment `json:"assignment,omitempty"`
+}
+
+// Backfill marks vehicles and assignments stored before drivers went online
+// and offline as offline, so their drivers go online with their app like
+// every other driver before they are dispatched again
+func Backfill(ctx context.Context) error {
+	for _, name := range []string{"vehicles", "assignments"} {
+		_, err := db.GetCollection(name).UpdateMany(ctx,
+			bson.M{"online": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"online": false}})
+		if err != nil {
+			return fmt.Errorf("backfilling %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// EnsureIndexes supports listing a driver's shifts and finding online drivers to check
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("shifts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "driver_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.GetCollection("assignments").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "online", Value: 1}, {Key: "last_seen_at", Value: 1}},
+	})
+	return err
+}
+
+// GoOnline makes a driver available for dispatch. The driver needs a vehicle
+// and, if they have shifts, must be within one of them.
+func GoOnline(w http.ResponseWriter, r *http.Request) {
+	driverID, err := primitive.ObjectIDFromHex(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeDriver(ctx, w, r, driverID) {
+		return
+	}
+
+	assignments := db.GetCollection("assignments")
+	var assignment models.Assignment
+	err = assignments.FindOne(ctx, bson.M{"uid": driverID}).Decode(&assignment)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch assignment", http.StatusInternalServerError)
+		return
+	}
+	if assignment.VehicleNo == "" {
+		http.Error(w, "Driver has no vehicle assigned", http.StatusConflict)
+		return
+	}
+
+	shifts, err := shiftsOf(ctx, driverID)
+	if err != nil {
+		fmt.Printf("Error fetching shifts: %v\n", err)
+		http.Error(w, "Failed to fetch shifts", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	if len(shifts) == 0 && config.App.Presence.RequireShift {
+		http.Error(w, "Driver has no shifts", http.StatusConflict)
+		return
+	}
+	if len(shifts) > 0 && !onShift(shifts, now) {
+		http.Error(w, "Driver is outside their shifts", http.StatusConflict)
+		return
+	}
+
+	set := bson.M{"online": true, "last_seen_at": now}
+	if !assignment.Online {
+		set["online_since"] = now
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = assignments.FindOneAndUpdate(ctx,
+		bson.M{"_id": assignment.ID, "vehicle_no": assignment.VehicleNo},
+		bson.M{"$set": set, "$unset": bson.M{"offline_at": "", "offline_reason": ""}},
+		opts).Decode(&assignment)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Driver's vehicle changed; try again", http.StatusConflict)
+		return
+	} else if err != nil {
+		fmt.Printf("Error updating assignment: %v\n", err)
+		http.Error(w, "Failed to go online", http.StatusInternalServerError)
+		return
+	}
+	if err := setVehicleOnline(ctx, assignment.VehicleNo, true); err != nil {
+		fmt.Printf("Error updating vehicle: %v\n", err)
+		http.Error(w, "Failed to go online", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresenceResponse{
+		Success:    true,
+		Message:    "Driver is online",
+		Assignment: assignment,
+	})
+}
+
+// GoOffline stops a driver from being dispatched. A booking they are already
+// serving is not affected.
+func GoOffline(w http.ResponseWriter, r *http.Request) {
+	driverID, err := primitive.ObjectIDFromHex(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeDriver(ctx, w, r, driverID) {
+		return
+	}
+
+	var assignment models.Assignment
+	err = db.GetCollection("assignments").FindOne(ctx, bson.M{"uid": driverID}).Decode(&assignment)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch assignment", http.StatusInternalServerError)
+		return
+	}
+
+	if assignment.Online {
+		if _, err := takeOffline(ctx, assignment, bson.M{}, models.OfflineByDriver); err != nil {
+			fmt.Printf("Error taking driver offline: %v\n", err)
+			http.Error(w, "Failed to go offline", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		assignment.Online = false
+		assignment.OfflineAt = &now
+		assignment.OfflineReason = models.OfflineByDriver
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresenceResponse{
+		Success:    true,
+		Message:    "Driver is offline",
+		Assignment: assignment,
+	})
+}
+
+// Heartbeat tells the server an online driver's device is still there. It
+// answers 409 once the driver has been taken offline, so the app can tell them.
+func Heartbeat(w http.ResponseWriter, r *http.Request) {
+	driverID, err := primitive.ObjectIDFromHex(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authorizeDriver(ctx, w, r, driverID) {
+		return
+	}
+
+	result, err := db.GetCollection("assignments").UpdateOne(ctx,
+		bson.M{"uid": driverID, "online": true},
+		bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	if err != nil {
+		http.Error(w, "Failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Driver is offline", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresenceResponse{Success: true, Message: "Heartbeat recorded"})
+}
+
+// authorizeDriver checks that a request was sent from the device of the driver
+// it is about, answering it with an error if not
+func authorizeDriver(ctx context.Context, w http.ResponseWriter, r *http.Request, driverID primitive.ObjectID) bool {
+	deviceDriver, err := authentication.DeviceDriver(ctx, r)
+	if err == authentication.ErrNoDevice {
+		http.Error(w, "Device is not authenticated", http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		fmt.Printf("Error authenticating device: %v\n", err)
+		http.Error(w, "Failed to authenticate device", http.StatusInternalServerError)
+		return false
+	}
+	if deviceDriver != driverID {
+		http.Error(w, "Device belongs to another driver", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Seen records that the driver of a vehicle was just heard from, keeping them
+// online. It does nothing if the driver is offline.
+func Seen(ctx context.Context, vehicleNo string) error {
+	_, err := db.GetCollection("assignments").UpdateOne(ctx,
+		bson.M{"vehicle_no": vehicleNo, "online": true},
+		bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	return err
+}
+
+// takeOffline marks an online driver and their vehicle offline if the
+// assignment still matches filter, and reports whether it did
+func takeOffline(ctx context.Context, assignment models.Assignment, filter bson.M, reason string) (bool, error) {
+	filter["_id"] = assignment.ID
+	filter["online"] = true
+	result, err := db.GetCollection("assignments").UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"online":         false,
+		"offline_at":     time.Now(),
+		"offline_reason": reason,
+	}})
+	if err != nil {
+		return false, fmt.Errorf("updating assignment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+	if assignment.VehicleNo != "" {
+		if err := setVehicleOnline(ctx, assignment.VehicleNo, false); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func setVehicleOnline(ctx context.Context, vehicleNo string, online bool) error {
+	_, err := db.GetCollection("vehicles").UpdateOne(ctx, bson.M{"vehicle_no": vehicleNo}, bson.M{
+		"$set":         bson.M{"online": online},
+		"$currentDate": bson.M{"updated_at": true},
+	})
+	if err != nil {
+		return fmt.Errorf("updating vehicle: %w", err)
+	}
+	geoindex.Default.SetOnline(vehicleNo, online)
+	return nil
+}
+
+// ExpireDue takes offline every online driver not heard from within the
+// presence timeout or whose shifts no longer cover the current time. Each
+// driver is taken offline with a conditional update, so a heartbeat arriving
+// at the same moment keeps them online. A driver who cannot be taken offline
+// is logged and left for the next check. It returns the number taken offline.
+func ExpireDue(ctx context.Context) (int, error) {
+	cursor, err := db.GetCollection("assignments").Find(ctx, bson.M{"online": true})
+	if err != nil {
+		return 0, err
+	}
+	var online []models.Assignment
+	if err := cursor.All(ctx, &online); err != nil {
+		return 0, err
+	}
+	if len(online) == 0 {
+		return 0, nil
+	}
+
+	driverIDs := make([]primitive.ObjectID, len(online))
+	for i, a := range online {
+		driverIDs[i] = a.UID
+	}
+	cursor, err = db.GetCollection("shifts").Find(ctx, bson.M{"driver_id": bson.M{"$in": driverIDs}})
+	if err != nil {
+		return 0, err
+	}
+	var all []models.Shift
+	if err := cursor.All(ctx, &all); err != nil {
+		return 0, err
+	}
+	shifts := map[primitive.ObjectID][]models.Shift{}
+	for _, s := range all {
+		shifts[s.DriverID] = append(shifts[s.DriverID], s)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-config.App.Presence.Timeout())
+	expired := 0
+	for _, a := range online {
+		var done bool
+		switch {
+		case a.LastSeenAt == nil || a.LastSeenAt.Before(cutoff):
+			done, err = takeOffline(ctx, a, bson.M{"$or": bson.A{
+				bson.M{"last_seen_at": bson.M{"$exists": false}},
+				bson.M{"last_seen_at": bson.M{"$lt": cutoff}},
+			}}, models.OfflineTimedOut)
+		case len(shifts[a.UID]) > 0 && !onShift(shifts[a.UID], now):
+			done, err = takeOffline(ctx, a, bson.M{}, models.OfflineShiftEnded)
+		case len(shifts[a.UID]) == 0 && config.App.Presence.RequireShift:
+			done, err = takeOffline(ctx, a, bson.M{}, models.OfflineShiftEnded)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("Error taking driver %s offline: %v", a.UID.Hex(), err)
+			continue
+		}
+		if done {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// StartPresenceChecks takes drivers offline in the background when their
+// device goes quiet or their shift ends.
+func StartPresenceChecks() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := ExpireDue(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Presence check failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Presence check took %d drivers offline", n)
+			}
+			time.Sleep(30 * time.Second)
+		}
+	}()
+}
@@ -0,0 +1,237 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ShiftResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Shift   models.Shift `json:"shift,omitempty"`
+}
+
+type ShiftListResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Shifts  []models.Shift `json:"shifts"`
+}
+
+// CreateShift lets an admin add a weekly shift to a driver's schedule
+func CreateShift(w http.ResponseWriter, r *http.Request) {
+	driverID, err := primitive.ObjectIDFromHex(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Days     []int  `json:"days"`
+		Start    string `json:"start"`
+		End      string `json:"end"`
+		Timezone string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	shift := models.Shift{
+		DriverID:  driverID,
+		Days:      req.Days,
+		Start:     req.Start,
+		End:       req.End,
+		Timezone:  req.Timezone,
+		CreatedAt: time.Now(),
+	}
+	if shift.Days == nil {
+		shift.Days = []int{}
+	}
+	if err := checkShift(shift); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	count, err := db.GetCollection("assignments").CountDocuments(ctx, bson.M{"uid": driverID})
+	if err != nil {
+		http.Error(w, "Failed to fetch assignment", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		http.Error(w, "Driver not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := db.GetCollection("shifts").InsertOne(ctx, shift)
+	if err != nil {
+		fmt.Printf("Error creating shift: %v\n", err)
+		http.Error(w, "Failed to create shift", http.StatusInternalServerError)
+		return
+	}
+	shift.ID = result.InsertedID.(primitive.ObjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ShiftResponse{
+		Success: true,
+		Message: "Shift created successfully",
+		Shift:   shift,
+	})
+}
+
+// GetDriverShifts lists a driver's shifts
+func GetDriverShifts(w http.ResponseWriter, r *http.Request) {
+	driverID, err := primitive.ObjectIDFromHex(mux.Vars(r)["driverId"])
+	if err != nil {
+		http.Error(w, "Invalid driver ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shifts, err := shiftsOf(ctx, driverID)
+	if err != nil {
+		http.Error(w, "Failed to fetch shifts", http.StatusInternalServerError)
+		return
+	}
+	if shifts == nil {
+		shifts = []models.Shift{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShiftListResponse{
+		Success: true,
+		Message: "Shifts retrieved successfully",
+		Shifts:  shifts,
+	})
+}
+
+// DeleteShift lets an admin remove a shift. A driver already online is taken offline by the
+// next presence check if their remaining shifts do not cover the current time.
+func DeleteShift(w http.ResponseWriter, r *http.Request) {
+	shiftID, err := primitive.ObjectIDFromHex(mux.Vars(r)["shiftId"])
+	if err != nil {
+		http.Error(w, "Invalid shift ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	result, err := db.GetCollection("shifts").DeleteOne(ctx, bson.M{"_id": shiftID})
+	if err != nil {
+		http.Error(w, "Failed to delete shift", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Shift not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShiftResponse{Success: true, Message: "Shift deleted successfully"})
+}
+
+func shiftsOf(ctx context.Context, driverID primitive.ObjectID) ([]models.Shift, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := db.GetCollection("shifts").Find(ctx, bson.M{"driver_id": driverID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var shifts []models.Shift
+	if err := cursor.All(ctx, &shifts); err != nil {
+		return nil, err
+	}
+	return shifts, nil
+}
+
+// checkShift reports what is wrong with a shift, if anything
+func checkShift(s models.Shift) error {
+	if s.Timezone == "" {
+		return errors.New("timezone is required")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	if _, err := time.Parse("15:04", s.Start); err != nil {
+		return errors.New("start must be HH:MM")
+	}
+	if _, err := time.Parse("15:04", s.End); err != nil {
+		return errors.New("end must be HH:MM")
+	}
+	for _, d := range s.Days {
+		if d < 0 || d > 6 {
+			return errors.New("days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	return nil
+}
+
+// onShift reports whether any of the shifts covers the given time. Shifts
+// that started the day before are checked too, since they may run past midnight.
+func onShift(shifts []models.Shift, at time.Time) bool {
+	for _, s := range shifts {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			continue
+		}
+		start, err1 := time.Parse("15:04", s.Start)
+		end, err2 := time.Parse("15:04", s.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		local := at.In(loc)
+		for _, back := range []int{1, 0} {
+			day := local.AddDate(0, 0, -back)
+			if !startsOn(s, day.Weekday()) {
+				continue
+			}
+			from := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+			to := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+			if !to.After(from) {
+				to = to.AddDate(0, 0, 1)
+			}
+			if !at.Before(from) && at.Before(to) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func startsOn(s models.Shift, day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
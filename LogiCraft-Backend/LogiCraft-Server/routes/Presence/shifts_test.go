@@ -0,0 +1,59 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"logi-craft/models"
+)
+
+func TestOnShift(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Monday 19 October 2026, in the shifts' own timezone
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, 19+day, hour, minute, 0, 0, kolkata)
+	}
+	day := models.Shift{Start: "09:00", End: "17:00", Timezone: "Asia/Kolkata"}
+	night := models.Shift{Start: "22:00", End: "06:00", Timezone: "Asia/Kolkata"}
+	mondayNight := night
+	mondayNight.Days = []int{int(time.Monday)}
+	weekdays := day
+	weekdays.Days = []int{1, 2, 3, 4, 5}
+	badZone := day
+	badZone.Timezone = "Mars/Olympus_Mons"
+
+	tests := []struct {
+		name   string
+		shifts []models.Shift
+		at     time.Time
+		want   bool
+	}{
+		{"no shifts", nil, at(0, 12, 0), false},
+		{"during a day shift", []models.Shift{day}, at(0, 12, 0), true},
+		{"as a day shift starts", []models.Shift{day}, at(0, 9, 0), true},
+		{"as a day shift ends", []models.Shift{day}, at(0, 17, 0), false},
+		{"before a day shift", []models.Shift{day}, at(0, 8, 59), false},
+		{"night shift before midnight", []models.Shift{night}, at(0, 23, 0), true},
+		{"night shift after midnight", []models.Shift{night}, at(1, 2, 0), true},
+		{"night shift has ended", []models.Shift{night}, at(1, 6, 0), false},
+		{"between night shifts", []models.Shift{night}, at(0, 12, 0), false},
+		{"night shift that started on its day", []models.Shift{mondayNight}, at(1, 3, 0), true},
+		{"night shift on a day it does not start", []models.Shift{mondayNight}, at(1, 23, 0), false},
+		{"early morning before a Monday night shift", []models.Shift{mondayNight}, at(0, 3, 0), false},
+		{"weekday shift on a Friday", []models.Shift{weekdays}, at(4, 10, 0), true},
+		{"weekday shift on a Saturday", []models.Shift{weekdays}, at(5, 10, 0), false},
+		{"time given in another timezone", []models.Shift{day}, at(0, 12, 0).UTC(), true},
+		{"unknown timezone is skipped", []models.Shift{badZone}, at(0, 12, 0), false},
+		{"any of several shifts", []models.Shift{badZone, night, day}, at(0, 12, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onShift(tt.shifts, tt.at); got != tt.want {
+				t.Errorf("onShift(%s) = %v, want %v", tt.at.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
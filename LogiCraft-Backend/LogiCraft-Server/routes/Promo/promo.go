@@ -0,0 +1,155 @@
+package promo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PromoResponse struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Promo   models.PromoCode `json:"promo,omitempty"`
+}
+
+type PromosResponse struct {
+	Success bool               `json:"success"`
+	Message string             `json:"message"`
+	Promos  []models.PromoCode `json:"promos,omitempty"`
+}
+
+// CreatePromoCode lets an admin create a new promo code
+func CreatePromoCode(w http.ResponseWriter, r *http.Request) {
+	var promo models.PromoCode
+	if err := json.NewDecoder(r.Body).Decode(&promo); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	promo.Code = strings.ToUpper(strings.TrimSpace(promo.Code))
+	if promo.Code == "" {
+		http.Error(w, "Missing promo code", http.StatusBadRequest)
+		return
+	}
+	if promo.Type != models.PromoTypeFlat && promo.Type != models.PromoTypePercent {
+		http.Error(w, "Promo type must be flat or percent", http.StatusBadRequest)
+		return
+	}
+	if promo.Value <= 0 || (promo.Type == models.PromoTypePercent && promo.Value > 100) {
+		http.Error(w, "Invalid promo value", http.StatusBadRequest)
+		return
+	}
+	if !promo.ValidUntil.IsZero() && promo.ValidUntil.Before(promo.ValidFrom) {
+		http.Error(w, "valid_until must be after valid_from", http.StatusBadRequest)
+		return
+	}
+
+	promo.UsedCount = 0
+	promo.Active = true
+	if promo.ValidFrom.IsZero() {
+		promo.ValidFrom = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	collection := db.GetCollection("promo_codes")
+
+	insertResult, err := collection.InsertOne(ctx, promo)
+	if mongo.IsDuplicateKeyError(err) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(PromoResponse{Success: false, Message: "Promo code already exists"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create promo code", http.StatusInternalServerError)
+		return
+	}
+	promo.ID = insertResult.InsertedID.(primitive.ObjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PromoResponse{
+		Success: true,
+		Message: "Promo code created successfully",
+		Promo:   promo,
+	})
+}
+
+// GetAllPromoCodes retrieves all promo codes
+func GetAllPromoCodes(w http.ResponseWriter, r *http.Request) {
+	collection := db.GetCollection("promo_codes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		http.Error(w, "Failed to fetch promo codes", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var promos []models.PromoCode
+	if err = cursor.All(ctx, &promos); err != nil {
+		http.Error(w, "Error decoding promo codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PromosResponse{
+		Success: true,
+		Message: "Promo codes retrieved successfully",
+		Promos:  promos,
+	})
+}
+
+// UpdatePromoCodeStatus lets an admin activate or deactivate a promo code
+func UpdatePromoCodeStatus(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	code := strings.ToUpper(params["code"])
+
+	var statusUpdate struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&statusUpdate); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	result, err := db.GetCollection("promo_codes").UpdateOne(ctx,
+		bson.M{"code": code},
+		bson.M{"$set": bson.M{"active": statusUpdate.Active}})
+	if err != nil {
+		http.Error(w, "Failed to update promo code", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Promo code not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PromoResponse{Success: true, Message: "Promo code updated successfully"})
+}
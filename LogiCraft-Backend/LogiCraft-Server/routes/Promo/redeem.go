@@ -0,0 +1,171 @@
+package promo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPromoNotFound     = errors.New("promo code not found")
+	ErrPromoInactive     = errors.New("promo code is not active")
+	ErrPromoExpired      = errors.New("promo code is not valid at this time")
+	ErrPromoVehicleType  = errors.New("promo code does not apply to this vehicle type")
+	ErrPromoMinFare      = errors.New("fare is below the promo code minimum")
+	ErrPromoExhausted    = errors.New("promo code usage limit reached")
+	ErrPromoUserExceeded = errors.New("promo code already used the maximum number of times")
+)
+
+// EnsureIndexes creates the unique indexes that redemption counting relies on
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("promo_codes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.GetCollection("promo_usages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "promo_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Validate checks whether a promo code can be applied to a fare and returns the discount amount.
+// It does not consume a redemption; use Redeem for that.
+func Validate(ctx context.Context, code string, userID primitive.ObjectID, vehicleType string, fare float64) (*models.PromoCode, float64, error) {
+	var promo models.PromoCode
+	err := db.GetCollection("promo_codes").FindOne(ctx, bson.M{"code": strings.ToUpper(strings.TrimSpace(code))}).Decode(&promo)
+	if err == mongo.ErrNoDocuments {
+		return nil, 0, ErrPromoNotFound
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	if !promo.Active {
+		return nil, 0, ErrPromoInactive
+	}
+
+	now := time.Now()
+	if now.Before(promo.ValidFrom) || (!promo.ValidUntil.IsZero() && now.After(promo.ValidUntil)) {
+		return nil, 0, ErrPromoExpired
+	}
+
+	if len(promo.VehicleTypes) > 0 && !contains(promo.VehicleTypes, vehicleType) {
+		return nil, 0, ErrPromoVehicleType
+	}
+
+	if fare < promo.MinFare {
+		return nil, 0, ErrPromoMinFare
+	}
+
+	if promo.MaxUses > 0 && promo.UsedCount >= promo.MaxUses {
+		return nil, 0, ErrPromoExhausted
+	}
+
+	if promo.PerUserLimit > 0 {
+		var usage models.PromoUsage
+		err = db.GetCollection("promo_usages").FindOne(ctx, bson.M{"promo_id": promo.ID, "user_id": userID}).Decode(&usage)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, 0, err
+		}
+		if usage.Count >= promo.PerUserLimit {
+			return nil, 0, ErrPromoUserExceeded
+		}
+	}
+
+	return &promo, DiscountFor(&promo, fare), nil
+}
+
+// DiscountFor calculates the discount a promo code gives on a fare
+func DiscountFor(promo *models.PromoCode, fare float64) float64 {
+	discount := promo.Value
+	if promo.Type == models.PromoTypePercent {
+		discount = fare * promo.Value / 100
+	}
+	if promo.MaxDiscount > 0 {
+		discount = math.Min(discount, promo.MaxDiscount)
+	}
+	return utils.RoundMoney(math.Min(discount, fare))
+}
+
+// Redeem atomically consumes one use of the promo code for the user.
+// The per-user counter is claimed first, then the global counter; if the global
+// limit has been reached the per-user claim is rolled back.
+func Redeem(ctx context.Context, promo *models.PromoCode, userID primitive.ObjectID) error {
+	usages := db.GetCollection("promo_usages")
+
+	if promo.PerUserLimit > 0 {
+		// The filter only matches while the count is under the limit. Once the
+		// limit is reached the upsert tries to insert a second document for the
+		// same (promo_id, user_id) pair, which the unique index rejects.
+		_, err := usages.UpdateOne(ctx,
+			bson.M{"promo_id": promo.ID, "user_id": userID, "count": bson.M{"$lt": promo.PerUserLimit}},
+			bson.M{"$inc": bson.M{"count": 1}},
+			options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrPromoUserExceeded
+		} else if err != nil {
+			return err
+		}
+	}
+
+	filter := bson.M{"_id": promo.ID, "active": true}
+	if promo.MaxUses > 0 {
+		filter["used_count"] = bson.M{"$lt": promo.MaxUses}
+	}
+
+	result, err := db.GetCollection("promo_codes").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"used_count": 1}})
+	if err == nil && result.ModifiedCount == 0 {
+		err = ErrPromoExhausted
+	}
+	if err != nil {
+		if promo.PerUserLimit > 0 {
+			usages.UpdateOne(ctx, bson.M{"promo_id": promo.ID, "user_id": userID}, bson.M{"$inc": bson.M{"count": -1}})
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Release gives back a redemption taken by Redeem, e.g. when the booking could not be created
+func Release(ctx context.Context, promo *models.PromoCode, userID primitive.ObjectID) {
+	db.GetCollection("promo_codes").UpdateOne(ctx, bson.M{"_id": promo.ID}, bson.M{"$inc": bson.M{"used_count": -1}})
+	if promo.PerUserLimit > 0 {
+		db.GetCollection("promo_usages").UpdateOne(ctx, bson.M{"promo_id": promo.ID, "user_id": userID}, bson.M{"$inc": bson.M{"count": -1}})
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRejection reports whether err means the promo code cannot be applied,
+// as opposed to a database failure
+func IsRejection(err error) bool {
+	switch err {
+	case ErrPromoNotFound, ErrPromoInactive, ErrPromoExpired, ErrPromoVehicleType,
+		ErrPromoMinFare, ErrPromoExhausted, ErrPromoUserExceeded:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package promo
+
+import (
+	"testing"
+
+	"logi-craft/models"
+)
+
+func TestDiscountFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		promo models.PromoCode
+		fare  float64
+		want  float64
+	}{
+		{"flat", models.PromoCode{Type: models.PromoTypeFlat, Value: 50}, 300, 50},
+		{"flat more than the fare", models.PromoCode{Type: models.PromoTypeFlat, Value: 500}, 300, 300},
+		{"flat under its cap", models.PromoCode{Type: models.PromoTypeFlat, Value: 50, MaxDiscount: 80}, 300, 50},
+		{"flat over its cap", models.PromoCode{Type: models.PromoTypeFlat, Value: 100, MaxDiscount: 80}, 300, 80},
+		{"percent", models.PromoCode{Type: models.PromoTypePercent, Value: 10}, 300, 30},
+		{"percent rounded to paise", models.PromoCode{Type: models.PromoTypePercent, Value: 15}, 123.45, 18.52},
+		{"percent over its cap", models.PromoCode{Type: models.PromoTypePercent, Value: 50, MaxDiscount: 100}, 300, 100},
+		{"percent under its cap", models.PromoCode{Type: models.PromoTypePercent, Value: 50, MaxDiscount: 200}, 300, 150},
+		{"whole fare", models.PromoCode{Type: models.PromoTypePercent, Value: 100}, 300, 300},
+		{"free ride", models.PromoCode{Type: models.PromoTypePercent, Value: 20}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiscountFor(&tt.promo, tt.fare); got != tt.want {
+				t.Errorf("DiscountFor(%s %v, %v) = %v, want %v", tt.promo.Type, tt.promo.Value, tt.fare, got, tt.want)
+			}
+		})
+	}
+}
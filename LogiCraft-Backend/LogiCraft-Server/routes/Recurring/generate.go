@@ -0,0 +1,245 @@
+package recurring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	booking "logi-craft/routes/Booking"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes supports listing a user's series and guarantees each occurrence is booked once
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("recurring_bookings").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.GetCollection("bookings").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "recurring_id", Value: 1}, {Key: "scheduled_at", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"recurring_id": bson.M{"$exists": true},
+		}),
+	})
+	return err
+}
+
+// GenerateDue books every occurrence of the active series that falls within the
+// recurring horizon. Each occurrence is claimed by moving last_scheduled_at
+// forward with a conditional update, so instances running this at the same time
+// never book an occurrence twice. A series that fails does not hold up the
+// others; their errors are returned together. It returns the number of
+// bookings created.
+func GenerateDue(ctx context.Context) (int, error) {
+	cursor, err := db.GetCollection("recurring_bookings").Find(ctx, bson.M{"status": models.RecurringStatusActive})
+	if err != nil {
+		return 0, err
+	}
+	var series []models.RecurringBooking
+	if err := cursor.All(ctx, &series); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	var errs []error
+	for _, s := range series {
+		n, err := generate(ctx, s)
+		created += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurring booking %s: %w", s.ID.Hex(), err))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// generate books the due occurrences of one series
+func generate(ctx context.Context, s models.RecurringBooking) (int, error) {
+	collection := db.GetCollection("recurring_bookings")
+
+	sched, err := parseRule(s.Rule)
+	if err != nil {
+		// Rules are checked on creation, so this only happens if the stored rule was edited by hand
+		fmt.Printf("Error parsing rule of recurring booking %s: %v\n", s.ID.Hex(), err)
+		return 0, nil
+	}
+
+	now := time.Now()
+	horizon := now.Add(config.App.Dispatch.RecurringHorizon())
+	skipped := make(map[string]bool, len(s.SkipDates))
+	for _, date := range s.SkipDates {
+		skipped[date] = true
+	}
+
+	// Occurrences missed while the series was paused are not booked late
+	from := now
+	if s.LastScheduledAt != nil && s.LastScheduledAt.After(now) {
+		from = *s.LastScheduledAt
+	}
+
+	created := 0
+	last := s.LastScheduledAt
+	for {
+		at := sched.Next(from)
+		if at.IsZero() || (s.EndsAt != nil && at.After(*s.EndsAt)) {
+			if s.EndsAt != nil && s.EndsAt.Before(now) {
+				_, err := collection.UpdateOne(ctx,
+					bson.M{"_id": s.ID, "status": models.RecurringStatusActive},
+					bson.M{"$set": bson.M{"status": models.RecurringStatusEnded}})
+				return created, err
+			}
+			return created, nil
+		}
+		if at.After(horizon) {
+			return created, nil
+		}
+
+		claim := bson.M{"_id": s.ID, "status": models.RecurringStatusActive, "last_scheduled_at": last}
+		if last == nil {
+			claim["last_scheduled_at"] = bson.M{"$exists": false}
+		}
+		result, err := collection.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"last_scheduled_at": at}})
+		if err != nil {
+			return created, err
+		}
+		if result.ModifiedCount == 0 {
+			// Another instance got here first, or the series was paused or ended
+			return created, nil
+		}
+		previous := last
+		last = &at
+		from = at
+
+		if skipped[sched.dateOf(at)] {
+			continue
+		}
+
+		scheduledAt := at
+		recurringID := s.ID
+		_, err = booking.CreateBooking(ctx, booking.BookingRequest{
+			UserID:        s.UserID.Hex(),
+			VehicleType:   s.VehicleType,
+			PickupCoords:  s.PickupLocation,
+			DropoffCoords: s.DropoffLocation,
+			Cargo:         s.Cargo,
+			ScheduledAt:   &scheduledAt,
+			PaymentMethod: s.PaymentMethod,
+			PaymentSource: s.PaymentSource,
+			RecurringID:   &recurringID,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			// An earlier run booked the occurrence before it failed
+			continue
+		}
+		var bookingErr *booking.BookingError
+		if err != nil && !errors.As(err, &bookingErr) {
+			// Something went wrong on our side, so give the occurrence back for the next run to book
+			if undoErr := unclaim(ctx, s.ID, at, previous); undoErr != nil {
+				return created, errors.Join(err, undoErr)
+			}
+			return created, fmt.Errorf("booking occurrence %s: %w", at, err)
+		}
+		if err != nil {
+			// The booking was refused, e.g. for lack of funds; skip this occurrence
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": bson.M{
+				"last_error": fmt.Sprintf("%s: %s", at.In(sched.loc).Format("2006-01-02 15:04"), bookingErr.Message),
+			}})
+			if err != nil {
+				return created, err
+			}
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$unset": bson.M{"last_error": ""}}); err != nil {
+			return created + 1, err
+		}
+		created++
+	}
+}
+
+// unclaim moves last_scheduled_at of a series back from an occurrence that could
+// not be booked, unless another instance has moved it on since
+func unclaim(ctx context.Context, seriesID primitive.ObjectID, at time.Time, previous *time.Time) error {
+	update := bson.M{"$unset": bson.M{"last_scheduled_at": ""}}
+	if previous != nil {
+		update = bson.M{"$set": bson.M{"last_scheduled_at": *previous}}
+	}
+	_, err := db.GetCollection("recurring_bookings").UpdateOne(ctx,
+		bson.M{"_id": seriesID, "last_scheduled_at": at}, update)
+	return err
+}
+
+// cancelBooked cancels the bookings of a series that are still waiting for
+// dispatch and whose time passes match, e.g. those on a skipped date
+func cancelBooked(ctx context.Context, s models.RecurringBooking, reason string, match func(time.Time) bool) error {
+	cursor, err := db.GetCollection("bookings").Find(ctx, bson.M{"recurring_id": s.ID, "job_status": "scheduled"})
+	if err != nil {
+		return err
+	}
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return err
+	}
+
+	actor := models.EventActor{Role: models.ActorCustomer, ID: s.UserID.Hex()}
+	for _, b := range bookings {
+		if b.ScheduledAt == nil || !match(*b.ScheduledAt) {
+			continue
+		}
+		if _, err := booking.CancelScheduled(ctx, b.ID, actor, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bookedOccurrences maps the scheduled time of each upcoming booking of a series to its ID
+func bookedOccurrences(ctx context.Context, seriesID primitive.ObjectID, from time.Time) (map[int64]primitive.ObjectID, error) {
+	filter := bson.M{
+		"recurring_id": seriesID,
+		"scheduled_at": bson.M{"$gte": from},
+		"job_status":   bson.M{"$nin": bson.A{"cancelled", "unfulfilled"}},
+	}
+	cursor, err := db.GetCollection("bookings").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var bookings []models.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+
+	booked := make(map[int64]primitive.ObjectID, len(bookings))
+	for _, b := range bookings {
+		booked[b.ScheduledAt.Unix()] = b.ID
+	}
+	return booked, nil
+}
+
+// StartRecurringBookings checks every five minutes for occurrences that are due
+// to be booked. It is safe to run on every instance.
+func StartRecurringBookings() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := GenerateDue(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("Recurring bookings run failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Recurring bookings run created %d bookings", n)
+			}
+			time.Sleep(5 * time.Minute)
+		}
+	}()
+}
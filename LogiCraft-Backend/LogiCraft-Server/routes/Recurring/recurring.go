@@ -0,0 +1,361 @@
+package recurring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultUpcoming = 10
+	maxUpcoming     = 100
+)
+
+type RecurringResponse struct {
+	Success   bool                    `json:"success"`
+	Message   string                  `json:"message"`
+	Recurring models.RecurringBooking `json:"recurring,omitempty"`
+	Upcoming  []models.Occurrence     `json:"upcoming,omitempty"`
+}
+
+type RecurringListResponse struct {
+	Success   bool                      `json:"success"`
+	Message   string                    `json:"message"`
+	Recurring []models.RecurringBooking `json:"recurring,omitempty"`
+}
+
+// CreateRecurringBooking sets up a route that is booked automatically on a schedule
+func CreateRecurringBooking(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID        string              `json:"user_id"`
+		VehicleType   string              `json:"vehicle_type"`
+		PickupCoords  models.Coordinates  `json:"pickup_coords"`
+		DropoffCoords models.Coordinates  `json:"dropoff_coords"`
+		Cargo         string              `json:"cargo"`
+		PaymentMethod string              `json:"payment_method"`
+		PaymentSource string              `json:"payment_source"`
+		Rule          models.ScheduleRule `json:"rule"`
+		EndsAt        *time.Time          `json:"ends_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := utils.VehicleRates[req.VehicleType]; !ok {
+		http.Error(w, "Invalid vehicle type", http.StatusBadRequest)
+		return
+	}
+	sched, err := parseRule(req.Rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sched.Next(time.Now()).IsZero() {
+		http.Error(w, "Schedule rule never occurs", http.StatusBadRequest)
+		return
+	}
+	if req.EndsAt != nil && req.EndsAt.Before(time.Now()) {
+		http.Error(w, "ends_at is in the past", http.StatusBadRequest)
+		return
+	}
+
+	series := models.RecurringBooking{
+		UserID:          userID,
+		VehicleType:     req.VehicleType,
+		PickupLocation:  req.PickupCoords,
+		DropoffLocation: req.DropoffCoords,
+		Cargo:           req.Cargo,
+		PaymentMethod:   req.PaymentMethod,
+		PaymentSource:   req.PaymentSource,
+		Rule:            req.Rule,
+		Status:          models.RecurringStatusActive,
+		SkipDates:       []string{},
+		EndsAt:          req.EndsAt,
+		CreatedAt:       time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.GetCollection("recurring_bookings").InsertOne(ctx, series)
+	if err != nil {
+		http.Error(w, "Failed to create recurring booking", http.StatusInternalServerError)
+		return
+	}
+	series.ID = result.InsertedID.(primitive.ObjectID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RecurringResponse{
+		Success:   true,
+		Message:   "Recurring booking created successfully",
+		Recurring: series,
+		Upcoming:  upcoming(series, sched, nil, defaultUpcoming),
+	})
+}
+
+// GetRecurringBookingsByUID lists a user's recurring bookings, newest first
+func GetRecurringBookingsByUID(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid, err := primitive.ObjectIDFromHex(params["uid"])
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := db.GetCollection("recurring_bookings").Find(ctx, bson.M{"user_id": uid}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch recurring bookings", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var series []models.RecurringBooking
+	if err = cursor.All(ctx, &series); err != nil {
+		http.Error(w, "Error decoding recurring bookings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecurringListResponse{
+		Success:   true,
+		Message:   "Recurring bookings retrieved successfully",
+		Recurring: series,
+	})
+}
+
+// GetRecurringBooking retrieves a recurring booking and its next ?count= occurrences,
+// showing which are skipped and which already have a booking
+func GetRecurringBooking(w http.ResponseWriter, r *http.Request) {
+	count := defaultUpcoming
+	if value := r.URL.Query().Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+		if n > maxUpcoming {
+			n = maxUpcoming
+		}
+		count = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(ctx, w, r)
+	if !ok {
+		return
+	}
+	sched, err := parseRule(series.Rule)
+	if err != nil {
+		http.Error(w, "Stored schedule rule is invalid", http.StatusInternalServerError)
+		return
+	}
+	booked, err := bookedOccurrences(ctx, series.ID, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to fetch bookings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecurringResponse{
+		Success:   true,
+		Message:   "Recurring booking retrieved successfully",
+		Recurring: series,
+		Upcoming:  upcoming(series, sched, booked, count),
+	})
+}
+
+// upcoming lists the next occurrences of a series from now
+func upcoming(series models.RecurringBooking, sched *schedule, booked map[int64]primitive.ObjectID, count int) []models.Occurrence {
+	occurrences := []models.Occurrence{}
+	if series.Status == models.RecurringStatusEnded {
+		return occurrences
+	}
+
+	skipped := make(map[string]bool, len(series.SkipDates))
+	for _, date := range series.SkipDates {
+		skipped[date] = true
+	}
+
+	at := time.Now()
+	for len(occurrences) < count {
+		at = sched.Next(at)
+		if at.IsZero() || (series.EndsAt != nil && at.After(*series.EndsAt)) {
+			break
+		}
+		occurrence := models.Occurrence{At: at, Skipped: skipped[sched.dateOf(at)]}
+		if id, ok := booked[at.Unix()]; ok {
+			occurrence.BookingID = &id
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences
+}
+
+// PauseRecurringBooking stops new occurrences being booked until the series is resumed.
+// Occurrences that fall while the series is paused are not booked later.
+func PauseRecurringBooking(w http.ResponseWriter, r *http.Request) {
+	setStatus(w, r, models.RecurringStatusActive, models.RecurringStatusPaused, "Recurring booking paused")
+}
+
+// ResumeRecurringBooking restarts a paused series
+func ResumeRecurringBooking(w http.ResponseWriter, r *http.Request) {
+	setStatus(w, r, models.RecurringStatusPaused, models.RecurringStatusActive, "Recurring booking resumed")
+}
+
+func setStatus(w http.ResponseWriter, r *http.Request, from, to, message string) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["recurringId"])
+	if err != nil {
+		http.Error(w, "Invalid Recurring ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var series models.RecurringBooking
+	err = db.GetCollection("recurring_bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": from},
+		bson.M{"$set": bson.M{"status": to}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&series)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Recurring booking not found or not "+from, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update recurring booking", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecurringResponse{Success: true, Message: message, Recurring: series})
+}
+
+// EndRecurringBooking ends a series for good and cancels its bookings that have not been dispatched yet
+func EndRecurringBooking(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["recurringId"])
+	if err != nil {
+		http.Error(w, "Invalid Recurring ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var series models.RecurringBooking
+	err = db.GetCollection("recurring_bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$ne": models.RecurringStatusEnded}},
+		bson.M{"$set": bson.M{"status": models.RecurringStatusEnded, "ends_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&series)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Recurring booking not found or already ended", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to end recurring booking", http.StatusInternalServerError)
+		return
+	}
+
+	err = cancelBooked(ctx, series, "Recurring booking ended", func(time.Time) bool { return true })
+	if err != nil {
+		http.Error(w, "Failed to cancel upcoming bookings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecurringResponse{Success: true, Message: "Recurring booking ended", Recurring: series})
+}
+
+// SkipOccurrence skips one date of a series, cancelling its booking if one was already made
+func SkipOccurrence(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Date string `json:"date"` // YYYY-MM-DD in the series' timezone
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		http.Error(w, "Date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	series, ok := findSeries(ctx, w, r)
+	if !ok {
+		return
+	}
+	if series.Status == models.RecurringStatusEnded {
+		http.Error(w, "Recurring booking has ended", http.StatusConflict)
+		return
+	}
+	sched, err := parseRule(series.Rule)
+	if err != nil {
+		http.Error(w, "Stored schedule rule is invalid", http.StatusInternalServerError)
+		return
+	}
+
+	err = db.GetCollection("recurring_bookings").FindOneAndUpdate(ctx,
+		bson.M{"_id": series.ID},
+		bson.M{"$addToSet": bson.M{"skip_dates": req.Date}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&series)
+	if err != nil {
+		http.Error(w, "Failed to skip date", http.StatusInternalServerError)
+		return
+	}
+
+	err = cancelBooked(ctx, series, "Date skipped", func(at time.Time) bool { return sched.dateOf(at) == req.Date })
+	if err != nil {
+		http.Error(w, "Failed to cancel booking for skipped date", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecurringResponse{Success: true, Message: "Date skipped", Recurring: series})
+}
+
+// findSeries loads the series named in the URL, writing an error response if it cannot
+func findSeries(ctx context.Context, w http.ResponseWriter, r *http.Request) (models.RecurringBooking, bool) {
+	var series models.RecurringBooking
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["recurringId"])
+	if err != nil {
+		http.Error(w, "Invalid Recurring ID", http.StatusBadRequest)
+		return series, false
+	}
+
+	err = db.GetCollection("recurring_bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&series)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(RecurringResponse{Success: false, Message: "Recurring booking not found"})
+		return series, false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch recurring booking", http.StatusInternalServerError)
+		return series, false
+	}
+	return series, true
+}
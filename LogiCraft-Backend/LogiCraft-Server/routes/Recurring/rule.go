@@ -0,0 +1,184 @@
+package recurring
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones must resolve even on hosts without a zoneinfo database
+
+	"logi-craft/models"
+)
+
+// schedule is a parsed ScheduleRule
+type schedule struct {
+	minutes  []int
+	hours    []int
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// Like cron, when both day of month and weekday are restricted a date matching either counts
+	anyDay, anyWeekday bool
+	loc                *time.Location
+}
+
+// cronFields are the bounds of the five cron fields, in order
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"weekday", 0, 7},
+}
+
+// parseRule checks a schedule rule and turns it into something Next can use
+func parseRule(rule models.ScheduleRule) (*schedule, error) {
+	if rule.Timezone == "" {
+		return nil, errors.New("timezone is required")
+	}
+	loc, err := time.LoadLocation(rule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", rule.Timezone)
+	}
+
+	var expr string
+	switch rule.Frequency {
+	case models.FrequencyDaily, models.FrequencyWeekdays:
+		t, err := time.Parse("15:04", rule.Time)
+		if err != nil {
+			return nil, errors.New("time must be HH:MM")
+		}
+		expr = fmt.Sprintf("%d %d * * *", t.Minute(), t.Hour())
+		if rule.Frequency == models.FrequencyWeekdays {
+			expr = fmt.Sprintf("%d %d * * 1-5", t.Minute(), t.Hour())
+		}
+	case models.FrequencyCron:
+		expr = rule.Cron
+	default:
+		return nil, errors.New("frequency must be daily, weekdays or cron")
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.New("cron expression must have five fields")
+	}
+	sets := make([]map[int]bool, len(fields))
+	for i, field := range fields {
+		sets[i], err = parseField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field: %v", cronFields[i].name, err)
+		}
+	}
+	if sets[4][7] {
+		sets[4][0] = true // 7 is another name for Sunday
+	}
+
+	return &schedule{
+		minutes:    sorted(sets[0]),
+		hours:      sorted(sets[1]),
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+		loc:        loc,
+	}, nil
+}
+
+// parseField reads a comma separated list of *, single values, ranges (a-b) and steps (*/n, a-b/n)
+func parseField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func sorted(set map[int]bool) []int {
+	values := make([]int, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	return values
+}
+
+// dayMatches reports whether the schedule runs on the given date
+func (s *schedule) dayMatches(t time.Time) bool {
+	if !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next returns the first occurrence strictly after t, or the zero time if
+// there is none within the next five years
+func (s *schedule) Next(t time.Time) time.Time {
+	local := t.In(s.loc)
+	y, m, d := local.Date()
+	for i := 0; i < 5*366; i++ {
+		date := time.Date(y, m, d+i, 0, 0, 0, 0, s.loc)
+		if !s.dayMatches(date) {
+			continue
+		}
+		for _, hour := range s.hours {
+			for _, minute := range s.minutes {
+				at := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, s.loc)
+				if at.After(t) {
+					return at
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// dateOf returns the calendar date of an occurrence in the schedule's timezone
+func (s *schedule) dateOf(t time.Time) string {
+	return t.In(s.loc).Format("2006-01-02")
+}
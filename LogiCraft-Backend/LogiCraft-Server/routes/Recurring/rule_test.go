@@ -0,0 +1,144 @@
+package recurring
+
+import (
+	"testing"
+	"time"
+
+	"logi-craft/models"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.ScheduleRule
+		ok   bool
+	}{
+		{"daily", models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30", Timezone: "Asia/Kolkata"}, true},
+		{"weekdays", models.ScheduleRule{Frequency: models.FrequencyWeekdays, Time: "18:00", Timezone: "UTC"}, true},
+		{"cron", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "*/15 9-17 * * 1,3,5", Timezone: "UTC"}, true},
+		{"Sunday as 7", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "0 0 * * 7", Timezone: "UTC"}, true},
+		{"no timezone", models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30"}, false},
+		{"unknown timezone", models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30", Timezone: "Nowhere/Special"}, false},
+		{"bad time", models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "8.30", Timezone: "UTC"}, false},
+		{"unknown frequency", models.ScheduleRule{Frequency: "hourly", Timezone: "UTC"}, false},
+		{"four fields", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "0 0 * *", Timezone: "UTC"}, false},
+		{"minute out of range", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "60 0 * * *", Timezone: "UTC"}, false},
+		{"day of month zero", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "0 0 0 * *", Timezone: "UTC"}, false},
+		{"backwards range", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "0 17-9 * * *", Timezone: "UTC"}, false},
+		{"zero step", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "*/0 * * * *", Timezone: "UTC"}, false},
+		{"not a number", models.ScheduleRule{Frequency: models.FrequencyCron, Cron: "0 noon * * *", Timezone: "UTC"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRule(tt.rule)
+			if (err == nil) != tt.ok {
+				t.Errorf("parseRule(%+v) error = %v, want ok %v", tt.rule, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cron := func(expr, timezone string) models.ScheduleRule {
+		return models.ScheduleRule{Frequency: models.FrequencyCron, Cron: expr, Timezone: timezone}
+	}
+
+	tests := []struct {
+		name  string
+		rule  models.ScheduleRule
+		after time.Time
+		want  time.Time
+	}{
+		{
+			name:  "later the same day",
+			rule:  models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30", Timezone: "Asia/Kolkata"},
+			after: time.Date(2026, 10, 19, 6, 0, 0, 0, kolkata),
+			want:  time.Date(2026, 10, 19, 8, 30, 0, 0, kolkata),
+		},
+		{
+			name:  "strictly after",
+			rule:  models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30", Timezone: "Asia/Kolkata"},
+			after: time.Date(2026, 10, 19, 8, 30, 0, 0, kolkata),
+			want:  time.Date(2026, 10, 20, 8, 30, 0, 0, kolkata),
+		},
+		{
+			name:  "daily time in the schedule's timezone",
+			rule:  models.ScheduleRule{Frequency: models.FrequencyDaily, Time: "08:30", Timezone: "Asia/Kolkata"},
+			after: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 20, 8, 30, 0, 0, kolkata),
+		},
+		{
+			name:  "weekdays skip the weekend",
+			rule:  models.ScheduleRule{Frequency: models.FrequencyWeekdays, Time: "09:00", Timezone: "UTC"},
+			after: time.Date(2026, 10, 23, 10, 0, 0, 0, time.UTC), // a Friday
+			want:  time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "every quarter hour in office hours",
+			rule:  cron("*/15 9-17 * * *", "UTC"),
+			after: time.Date(2026, 10, 19, 9, 50, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "office hours over for the day",
+			rule:  cron("*/15 9-17 * * *", "UTC"),
+			after: time.Date(2026, 10, 19, 17, 45, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "first of the month",
+			rule:  cron("0 6 1 * *", "UTC"),
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "day of month or weekday",
+			rule:  cron("0 6 1 * 5", "UTC"),
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 23, 6, 0, 0, 0, time.UTC), // the Friday comes before the 1st
+		},
+		{
+			name:  "Sunday as 7",
+			rule:  cron("0 0 * * 7", "UTC"),
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "leap day",
+			rule:  cron("0 0 29 2 *", "UTC"),
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "never",
+			rule:  cron("0 0 31 2 *", "UTC"),
+			after: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			want:  time.Time{},
+		},
+		{
+			name:  "across a daylight saving change",
+			rule:  cron("30 8 * * *", "America/New_York"),
+			after: time.Date(2026, 10, 31, 9, 0, 0, 0, newYork), // clocks go back on 1 November
+			want:  time.Date(2026, 11, 1, 8, 30, 0, 0, newYork),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.after.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+			}
+		})
+	}
+}
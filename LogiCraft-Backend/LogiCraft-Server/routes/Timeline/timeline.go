@@ -0,0 +1,101 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TimelineResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message"`
+	Events  []models.BookingEvent `json:"events"`
+}
+
+// EnsureIndexes supports reading a booking's events in order
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("booking_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return err
+}
+
+// Record appends an event to a booking's timeline. Failing to record an event
+// should not fail the change itself, so errors are logged rather than returned.
+func Record(ctx context.Context, bookingID primitive.ObjectID, eventType string, actor models.EventActor, data map[string]interface{}) {
+	_, err := db.GetCollection("booking_events").InsertOne(ctx, models.BookingEvent{
+		BookingID: bookingID,
+		Type:      eventType,
+		Actor:     actor,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		fmt.Printf("Error recording %s event for booking %s: %v\n", eventType, bookingID.Hex(), err)
+	}
+}
+
+// Actor builds an event actor, leaving the ID empty for a nil ObjectID
+func Actor(role string, id primitive.ObjectID) models.EventActor {
+	actor := models.EventActor{Role: role}
+	if !id.IsZero() {
+		actor.ID = id.Hex()
+	}
+	return actor
+}
+
+// GetBookingTimeline returns every event recorded for a booking, oldest first
+func GetBookingTimeline(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := db.GetCollection("bookings").CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(TimelineResponse{Success: false, Message: "Booking not found"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.GetCollection("booking_events").Find(ctx, bson.M{"booking_id": id}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch timeline", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.BookingEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		http.Error(w, "Error decoding timeline", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TimelineResponse{
+		Success: true,
+		Message: "Timeline retrieved successfully",
+		Events:  events,
+	})
+}
@@ -0,0 +1,179 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/notify"
+	timeline "logi-craft/routes/Timeline"
+	"logi-craft/routing"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyInterval limits how often an estimate is added to the ETA history of a booking
+const historyInterval = 30 * time.Second
+
+// TravelTime estimates how long a vehicle type takes to make a trip starting at the given time.
+// On the road network a vehicle goes no faster than its average speed over the road distance,
+// nor than the roads allow once slowed by the traffic at that time.
+func TravelTime(vehicleType string, trip routing.Trip, at time.Time) time.Duration {
+	speed := config.App.ETA.SpeedFor(vehicleType, at)
+	if speed <= 0 {
+		return 0
+	}
+	estimate := time.Duration(trip.Distance / speed * float64(time.Hour))
+	if factor := config.App.ETA.FactorAt(at); trip.OnRoad && factor > 0 {
+		estimate = max(estimate, time.Duration(float64(trip.Duration)/factor))
+	}
+	return estimate
+}
+
+// Estimate computes the pickup and dropoff ETAs for a booking with its vehicle at position.
+// Once the vehicle has reached the pickup, the pickup ETA is the actual arrival time.
+func Estimate(vehicleType string, position models.Coordinates, booking models.Booking, now time.Time) models.BookingETA {
+	eta := models.BookingETA{UpdatedAt: now}
+
+	if booking.ArrivedPickupAt != nil {
+		eta.PickupAt = *booking.ArrivedPickupAt
+		toDropoff := routing.Between(position, booking.DropoffLocation)
+		eta.DropoffAt = now.Add(TravelTime(vehicleType, toDropoff, now))
+		return eta
+	}
+
+	toPickup := routing.Between(position, booking.PickupLocation)
+	eta.PickupAt = now.Add(TravelTime(vehicleType, toPickup, now))
+
+	trip := routing.Between(booking.PickupLocation, booking.DropoffLocation)
+	eta.DropoffAt = eta.PickupAt.Add(TravelTime(vehicleType, trip, eta.PickupAt))
+	return eta
+}
+
+// Record stores an estimate in the ETA history of a booking
+func Record(ctx context.Context, booking models.Booking, position models.Coordinates, eta models.BookingETA) error {
+	_, err := db.GetCollection("eta_estimates").InsertOne(ctx, models.ETAEstimate{
+		BookingID: booking.ID,
+		VehicleNo: booking.VehicleNo,
+		Position:  position,
+		PickupAt:  eta.PickupAt,
+		DropoffAt: eta.DropoffAt,
+		CreatedAt: eta.UpdatedAt,
+	})
+	return err
+}
+
+// Refresh re-estimates the ETAs of the bookings a vehicle is serving after it reports a new position.
+// A vehicle serves several bookings at once when they share it.
+func Refresh(ctx context.Context, vehicle models.Vehicle) error {
+	cursor, err := db.GetCollection("bookings").Find(ctx, bson.M{"vehicle_no": vehicle.VehicleNo, "job_status": "in-transit"})
+	if err != nil {
+		return err
+	}
+	var active []models.Booking
+	if err := cursor.All(ctx, &active); err != nil {
+		return err
+	}
+
+	for _, booking := range active {
+		if err := refreshBooking(ctx, vehicle, booking); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshBooking re-estimates the ETA of one booking and records when the
+// vehicle first reaches its pickup point
+func refreshBooking(ctx context.Context, vehicle models.Vehicle, booking models.Booking) error {
+	bookings := db.GetCollection("bookings")
+	now := time.Now()
+	set := bson.M{}
+
+	if booking.ArrivedPickupAt == nil {
+		toPickup := utils.HaversineDistance(vehicle.Coordinates.Latitude, vehicle.Coordinates.Longitude,
+			booking.PickupLocation.Latitude, booking.PickupLocation.Longitude)
+		if toPickup <= config.App.ETA.PickupRadius {
+			booking.ArrivedPickupAt = &now
+			set["arrived_pickup_at"] = now
+		}
+	}
+
+	eta := Estimate(vehicle.VehicleType, vehicle.Coordinates, booking, now)
+	set["eta"] = eta
+
+	_, err := bookings.UpdateOne(ctx, bson.M{"_id": booking.ID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if set["arrived_pickup_at"] != nil {
+		timeline.Record(ctx, booking.ID, models.EventArrivedPickup, timeline.Actor(models.ActorDriver, booking.DriverID), map[string]interface{}{
+			"position": vehicle.Coordinates,
+		})
+	}
+
+	if err := notifyNear(ctx, booking, vehicle, eta); err != nil {
+		return err
+	}
+
+	var last models.ETAEstimate
+	err = db.GetCollection("eta_estimates").FindOne(ctx,
+		bson.M{"booking_id": booking.ID},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == mongo.ErrNoDocuments || now.Sub(last.CreatedAt) >= historyInterval || set["arrived_pickup_at"] != nil {
+		return Record(ctx, booking, vehicle.Coordinates, eta)
+	}
+	return nil
+}
+
+// notifyNear tells the receiver once that the vehicle, loaded at pickup, is
+// close to the dropoff. The flag is set conditionally so only one instance sends it.
+func notifyNear(ctx context.Context, booking models.Booking, vehicle models.Vehicle, eta models.BookingETA) error {
+	if booking.Receiver == nil || booking.ArrivedPickupAt == nil || booking.NearDropoffAt != nil {
+		return nil
+	}
+	toDropoff := utils.HaversineDistance(vehicle.Coordinates.Latitude, vehicle.Coordinates.Longitude,
+		booking.DropoffLocation.Latitude, booking.DropoffLocation.Longitude)
+	if toDropoff > config.App.Notify.NearRadius {
+		return nil
+	}
+
+	result, err := db.GetCollection("bookings").UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "near_dropoff_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"near_dropoff_at": time.Now()}})
+	if err != nil || result.ModifiedCount == 0 {
+		return err
+	}
+
+	notify.Send(ctx, notify.Message{
+		Kind:      notify.KindVehicleNear,
+		BookingID: booking.ID.Hex(),
+		Name:      booking.Receiver.Name,
+		Phone:     booking.Receiver.Phone,
+		Text: fmt.Sprintf("Your delivery in vehicle %s is nearly there, expected at %s.",
+			booking.VehicleNo, eta.DropoffAt.Format("15:04")),
+	})
+	return nil
+}
+
+// EnsureIndexes supports looking up the ETA history of a booking in order and
+// sets up the history of vehicle positions
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("eta_estimates").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "booking_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+	return ensureHistory(ctx)
+}
@@ -0,0 +1,214 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+	"logi-craft/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// positionsCollection is the time-series collection every accepted vehicle position is appended to
+const positionsCollection = "vehicle_positions"
+
+// maxTrailPositions caps the positions returned for one trail or path
+const maxTrailPositions = 5000
+
+// namespaceExists is the server error code for creating a collection that already exists
+const namespaceExists = 48
+
+// Trail is where a vehicle went over a period, oldest position first
+type Trail struct {
+	Positions []models.VehiclePosition `json:"positions"`
+	Distance  float64                  `json:"distance"`            // km driven between the positions
+	Truncated bool                     `json:"truncated,omitempty"` // more positions were recorded than returned
+}
+
+type PathResponse struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	VehicleNo string     `json:"vehicle_no,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+	Trail
+}
+
+// ensureHistory creates the time-series collection of vehicle positions, or
+// brings its retention in line with the config if it already exists. Positions
+// are kept for HistoryDays, or for good if that is not positive.
+func ensureHistory(ctx context.Context) error {
+	database := db.GetCollection(positionsCollection).Database()
+
+	var expireAfter interface{} = "off"
+	opts := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+		SetTimeField("recorded_at").
+		SetMetaField("vehicle_no").
+		SetGranularity("seconds"))
+	if retention := int64(config.App.Tracking.HistoryRetention().Seconds()); retention > 0 {
+		opts.SetExpireAfterSeconds(retention)
+		expireAfter = retention
+	}
+
+	err := database.CreateCollection(ctx, positionsCollection, opts)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(namespaceExists) {
+		err = database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: positionsCollection},
+			{Key: "expireAfterSeconds", Value: expireAfter},
+		}).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = db.GetCollection(positionsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "vehicle_no", Value: 1}, {Key: "recorded_at", Value: 1}},
+	})
+	return err
+}
+
+// RecordPosition appends an accepted location update to the vehicle's history
+func RecordPosition(ctx context.Context, vehicleNo string, driverID primitive.ObjectID, coordinates models.Coordinates, recordedAt time.Time) error {
+	_, err := db.GetCollection(positionsCollection).InsertOne(ctx, models.VehiclePosition{
+		VehicleNo:   vehicleNo,
+		DriverID:    driverID,
+		Coordinates: coordinates,
+		RecordedAt:  recordedAt,
+	})
+	return err
+}
+
+// GetTrail returns the positions of a vehicle recorded from one time up to
+// another. Leave driverID nil to include every driver of the vehicle.
+func GetTrail(ctx context.Context, vehicleNo string, driverID *primitive.ObjectID, from, to time.Time) (Trail, error) {
+	filter := bson.M{
+		"vehicle_no":  vehicleNo,
+		"recorded_at": bson.M{"$gte": from, "$lte": to},
+	}
+	if driverID != nil {
+		filter["driver_id"] = *driverID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "recorded_at", Value: 1}}).SetLimit(maxTrailPositions + 1)
+	cursor, err := db.GetCollection(positionsCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return Trail{}, err
+	}
+	trail := Trail{Positions: []models.VehiclePosition{}}
+	if err := cursor.All(ctx, &trail.Positions); err != nil {
+		return Trail{}, err
+	}
+	if len(trail.Positions) > maxTrailPositions {
+		trail.Positions = trail.Positions[:maxTrailPositions]
+		trail.Truncated = true
+	}
+
+	for i := 1; i < len(trail.Positions); i++ {
+		a, b := trail.Positions[i-1].Coordinates, trail.Positions[i].Coordinates
+		trail.Distance += utils.HaversineDistance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	}
+	trail.Distance = math.Round(trail.Distance*1000) / 1000 // to the metre
+	return trail, nil
+}
+
+// GetBookingPath returns the path the vehicle drove for a booking, from the
+// moment it reached the pickup until the booking was completed or cancelled,
+// or until now while it is under way. Only the devices of the booking's
+// customer and driver and of admins may see it.
+func GetBookingPath(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(PathResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := authentication.AuthorizeDevice(ctx, w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canSeeBooking(ctx, booking, userID)
+	if err != nil {
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Not allowed to see this booking's path", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if booking.VehicleNo == "" || booking.ArrivedPickupAt == nil {
+		json.NewEncoder(w).Encode(PathResponse{
+			Success:   true,
+			Message:   "The vehicle has not reached the pickup yet",
+			VehicleNo: booking.VehicleNo,
+			Trail:     Trail{Positions: []models.VehiclePosition{}},
+		})
+		return
+	}
+
+	from := *booking.ArrivedPickupAt
+	to := time.Now()
+	if booking.CompletedAt != nil {
+		to = *booking.CompletedAt
+	} else if booking.CancelledAt != nil {
+		to = *booking.CancelledAt
+	}
+	trail, err := GetTrail(ctx, booking.VehicleNo, &booking.DriverID, from, to)
+	if err != nil {
+		http.Error(w, "Failed to fetch vehicle positions", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(PathResponse{
+		Success:   true,
+		Message:   "Booking path retrieved successfully",
+		VehicleNo: booking.VehicleNo,
+		From:      &from,
+		To:        &to,
+		Trail:     trail,
+	})
+}
+
+// canSeeBooking reports whether a user is the booking's customer or driver, or an admin
+func canSeeBooking(ctx context.Context, booking models.Booking, userID primitive.ObjectID) (bool, error) {
+	if userID == booking.UserID || userID == booking.DriverID {
+		return true, nil
+	}
+
+	var user models.User
+	err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return user.UserType == "admin", nil
+}
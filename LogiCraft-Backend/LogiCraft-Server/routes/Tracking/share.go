@@ -0,0 +1,204 @@
+package tracking
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errInvalidLink = errors.New("invalid tracking link")
+	errExpiredLink = errors.New("tracking link has expired")
+)
+
+// activeStatuses are the booking statuses in which a public tracking link works
+var activeStatuses = map[string]bool{
+	"scheduled":   true,
+	"dispatching": true,
+	"in-transit":  true,
+}
+
+type ShareResponse struct {
+	Success   bool      `json:"success"`
+	Message   string    `json:"message"`
+	Token     string    `json:"token,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Setup checks the tracking config. Links signed by one instance must work on
+// every other and after a restart, so the link secret has to be configured.
+func Setup(cfg config.TrackingConfig) error {
+	if cfg.LinkSecret == "" {
+		return errors.New("tracking needs a link_secret shared by every instance")
+	}
+	return nil
+}
+
+// linkSecret returns the key that signs tracking links
+func linkSecret() []byte {
+	return []byte(config.App.Tracking.LinkSecret)
+}
+
+// signLink builds a token for a booking that expires at the given time. The
+// token holds the booking ID and expiry, followed by an HMAC of both.
+func signLink(bookingID primitive.ObjectID, expiresAt time.Time) string {
+	payload := make([]byte, 20)
+	copy(payload, bookingID[:])
+	binary.BigEndian.PutUint64(payload[12:], uint64(expiresAt.Unix()))
+
+	mac := hmac.New(sha256.New, linkSecret())
+	mac.Write(payload)
+	signature := mac.Sum(nil)[:16]
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// verifyLink checks a token's signature and expiry and returns its booking ID
+func verifyLink(token string, now time.Time) (primitive.ObjectID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return primitive.NilObjectID, errInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != 20 {
+		return primitive.NilObjectID, errInvalidLink
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return primitive.NilObjectID, errInvalidLink
+	}
+
+	mac := hmac.New(sha256.New, linkSecret())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)[:16]) {
+		return primitive.NilObjectID, errInvalidLink
+	}
+
+	if now.Unix() > int64(binary.BigEndian.Uint64(payload[12:])) {
+		return primitive.NilObjectID, errExpiredLink
+	}
+
+	var bookingID primitive.ObjectID
+	copy(bookingID[:], payload[:12])
+	return bookingID, nil
+}
+
+// ShareTracking lets the customer of an active booking create a public link
+// that shows its status, ETA and vehicle position to anyone who has it
+func ShareTracking(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ShareResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the customer can share a booking
+	if !authentication.AuthorizeUser(ctx, w, r, booking.UserID) {
+		return
+	}
+	if !activeStatuses[booking.JobStatus] {
+		http.Error(w, "Booking is no longer active", http.StatusConflict)
+		return
+	}
+
+	expiresAt := time.Now().Add(config.App.Tracking.LinkTTL()).Truncate(time.Second)
+	token := signLink(booking.ID, expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShareResponse{
+		Success:   true,
+		Message:   "Tracking link created successfully",
+		Token:     token,
+		Path:      "/track/" + token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// GetPublicTracking returns the status, ETA and vehicle position of the booking
+// a tracking link was made for. Links stop working once the booking is over.
+func GetPublicTracking(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := verifyLink(params["token"], time.Now())
+	if err == errExpiredLink {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Booking not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+	if !activeStatuses[booking.JobStatus] {
+		http.Error(w, "Booking is no longer active", http.StatusGone)
+		return
+	}
+
+	response := TrackingResponse{
+		Success:         true,
+		Message:         "Tracking retrieved successfully",
+		JobStatus:       booking.JobStatus,
+		ArrivedPickupAt: booking.ArrivedPickupAt,
+		ETA:             booking.ETA,
+	}
+
+	if booking.JobStatus == "in-transit" {
+		var vehicle models.Vehicle
+		err = db.GetCollection("vehicles").FindOne(ctx, bson.M{"vehicle_no": booking.VehicleNo}).Decode(&vehicle)
+		if err != nil {
+			http.Error(w, "Failed to fetch vehicle", http.StatusInternalServerError)
+			return
+		}
+		eta := Estimate(vehicle.VehicleType, vehicle.Coordinates, booking, time.Now())
+		response.VehicleNo = booking.VehicleNo
+		response.Position = &vehicle.Coordinates
+		response.ETA = &eta
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
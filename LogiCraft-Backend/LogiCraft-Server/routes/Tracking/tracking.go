@@ -0,0 +1,136 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TrackingResponse struct {
+	Success         bool                `json:"success"`
+	Message         string              `json:"message"`
+	BookingID       string              `json:"booking_id,omitempty"`
+	JobStatus       string              `json:"job_status,omitempty"`
+	VehicleNo       string              `json:"vehicle_no,omitempty"`
+	Position        *models.Coordinates `json:"position,omitempty"`
+	ETA             *models.BookingETA  `json:"eta,omitempty"`
+	ArrivedPickupAt *time.Time          `json:"arrived_pickup_at,omitempty"`
+	CompletedAt     *time.Time          `json:"completed_at,omitempty"`
+}
+
+type ETAHistoryResponse struct {
+	Success         bool                 `json:"success"`
+	Message         string               `json:"message"`
+	InitialETA      *models.BookingETA   `json:"initial_eta,omitempty"`
+	ArrivedPickupAt *time.Time           `json:"arrived_pickup_at,omitempty"`
+	CompletedAt     *time.Time           `json:"completed_at,omitempty"`
+	Estimates       []models.ETAEstimate `json:"estimates,omitempty"`
+}
+
+// GetBookingTracking returns the live position and ETAs of the vehicle serving a booking
+func GetBookingTracking(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(TrackingResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	response := TrackingResponse{
+		Success:         true,
+		Message:         "Tracking retrieved successfully",
+		BookingID:       booking.ID.Hex(),
+		JobStatus:       booking.JobStatus,
+		VehicleNo:       booking.VehicleNo,
+		ArrivedPickupAt: booking.ArrivedPickupAt,
+		CompletedAt:     booking.CompletedAt,
+	}
+
+	if booking.JobStatus == "in-transit" {
+		var vehicle models.Vehicle
+		err = db.GetCollection("vehicles").FindOne(ctx, bson.M{"vehicle_no": booking.VehicleNo}).Decode(&vehicle)
+		if err != nil {
+			http.Error(w, "Failed to fetch vehicle", http.StatusInternalServerError)
+			return
+		}
+		eta := Estimate(vehicle.VehicleType, vehicle.Coordinates, booking, time.Now())
+		response.Position = &vehicle.Coordinates
+		response.ETA = &eta
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetETAHistory returns every stored estimate for a booking alongside the actual
+// pickup and completion times
+func GetETAHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ETAHistoryResponse{Success: false, Message: "Booking not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := db.GetCollection("eta_estimates").Find(ctx, bson.M{"booking_id": id}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch estimates", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var estimates []models.ETAEstimate
+	if err = cursor.All(ctx, &estimates); err != nil {
+		http.Error(w, "Error decoding estimates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ETAHistoryResponse{
+		Success:         true,
+		Message:         "ETA history retrieved successfully",
+		InitialETA:      booking.InitialETA,
+		ArrivedPickupAt: booking.ArrivedPickupAt,
+		CompletedAt:     booking.CompletedAt,
+		Estimates:       estimates,
+	})
+}
@@ -0,0 +1,37 @@
+package vehicles
+
+import (
+	"context"
+
+	"logi-craft/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EnsureIndexes lets vehicles be searched by distance from a point and by when
+// they last changed. Vehicles stored before positions were kept as GeoJSON are
+// given a location from their coordinates first, so they are not left out of
+// the search.
+func EnsureIndexes(ctx context.Context) error {
+	collection := db.GetCollection("vehicles")
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"location": bson.M{"$exists": false}, "coordinates": bson.M{"$exists": true}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"location": bson.M{
+				"type":        "Point",
+				"coordinates": bson.A{"$coordinates.longitude", "$coordinates.latitude"},
+			},
+			"updated_at": "$$NOW",
+		}}}})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}, {Key: "vehicle_type", Value: 1}, {Key: "busy", Value: 1}}},
+		{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,225 @@
+package vehicles
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/live"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keepAliveInterval is how often an idle stream gets a comment, so proxies do not close it
+const keepAliveInterval = 15 * time.Second
+
+// StreamLiveUpdates pushes updates to the client as Server-Sent Events until it
+// disconnects. It follows one of:
+//
+//	?vehicle=<vehicle_no>              a vehicle, for its driver, the customer it is carrying or admins
+//	?booking=<booking id>              a booking and, while in transit, its vehicle, for its customer, driver or admins
+//	?bbox=minLng,minLat,maxLng,maxLat  every vehicle in a map area, for admins
+//
+// Positions are "position" events and booking changes "status" events. The
+// stream starts with the current state, so a client that reconnects catches up.
+// EventSource cannot send headers, so the device token may be given as ?token=.
+func StreamLiveUpdates(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	var filter live.Filter
+	switch {
+	case query.Get("vehicle") != "":
+		filter.VehicleNo = query.Get("vehicle")
+	case query.Get("booking") != "":
+		id, err := primitive.ObjectIDFromHex(query.Get("booking"))
+		if err != nil {
+			http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+			return
+		}
+		filter.BookingID = id
+	case query.Get("bbox") != "":
+		box, err := parseBox(query.Get("bbox"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Box = &box
+	default:
+		http.Error(w, "Choose a vehicle, booking or bbox to follow", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	userID, ok := authentication.AuthorizeStream(ctx, w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canFollow(ctx, &filter, userID)
+	if err != nil {
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Not allowed to follow these updates", http.StatusForbidden)
+		return
+	}
+
+	// Subscribe before loading the current state so no change in between is lost
+	sub := live.Default.Subscribe(filter)
+	defer live.Default.Unsubscribe(sub)
+
+	vehicles, booking, err := currentState(ctx, filter)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Booking not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch current state", http.StatusInternalServerError)
+		return
+	}
+	live.Default.Prime(sub, vehicles, booking)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case update, ok := <-sub.Updates():
+			if !ok {
+				// Fell too far behind or may no longer follow the vehicle; the
+				// client reconnects and starts from the current state, if still allowed
+				return
+			}
+			data, err := json.Marshal(update.Data())
+			if err != nil {
+				fmt.Printf("Error encoding live update: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", update.Kind, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// parseBox reads a bounding box given as minLng,minLat,maxLng,maxLat
+func parseBox(s string) (live.Box, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return live.Box{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return live.Box{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		v[i] = f
+	}
+	box := live.Box{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+	if box.MinLat > box.MaxLat || box.MinLng > box.MaxLng ||
+		box.MinLat < -90 || box.MaxLat > 90 || box.MinLng < -180 || box.MaxLng > 180 {
+		return live.Box{}, errors.New("bbox is not a valid area")
+	}
+	return box, nil
+}
+
+// canFollow reports whether a user may follow the updates a filter picks. A
+// customer following the vehicle carrying their booking may only do so until
+// the booking leaves it, so the filter is set to end with the booking.
+func canFollow(ctx context.Context, filter *live.Filter, userID primitive.ObjectID) (bool, error) {
+	if filter.VehicleNo != "" {
+		allowed, err := canSeeTrail(ctx, filter.VehicleNo, userID)
+		if err != nil || allowed {
+			return allowed, err
+		}
+		filter.Until, err = carrying(ctx, filter.VehicleNo, userID)
+		return !filter.Until.IsZero(), err
+	}
+
+	var user models.User
+	err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if user.UserType == "admin" {
+		return true, nil
+	}
+	if filter.Box != nil {
+		return false, nil
+	}
+
+	n, err := db.GetCollection("bookings").CountDocuments(ctx, bson.M{
+		"_id": filter.BookingID,
+		"$or": bson.A{bson.M{"user_id": userID}, bson.M{"driver_id": userID}},
+	})
+	return n > 0, err
+}
+
+// currentState loads what a filter follows as it is now
+func currentState(ctx context.Context, filter live.Filter) ([]models.Vehicle, *models.Booking, error) {
+	vehicles := db.GetCollection("vehicles")
+
+	if filter.Box != nil {
+		cursor, err := vehicles.Find(ctx, bson.M{
+			"coordinates.latitude":  bson.M{"$gte": filter.Box.MinLat, "$lte": filter.Box.MaxLat},
+			"coordinates.longitude": bson.M{"$gte": filter.Box.MinLng, "$lte": filter.Box.MaxLng},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		var inBox []models.Vehicle
+		err = cursor.All(ctx, &inBox)
+		return inBox, nil, err
+	}
+
+	var booking *models.Booking
+	vehicleNo := filter.VehicleNo
+	if !filter.BookingID.IsZero() {
+		booking = &models.Booking{}
+		if err := db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": filter.BookingID}).Decode(booking); err != nil {
+			return nil, nil, err
+		}
+		vehicleNo = booking.VehicleNo
+	}
+	if vehicleNo == "" {
+		return nil, booking, nil
+	}
+
+	var vehicle models.Vehicle
+	err := vehicles.FindOne(ctx, bson.M{"vehicle_no": vehicleNo}).Decode(&vehicle)
+	if err == mongo.ErrNoDocuments {
+		return nil, booking, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return []models.Vehicle{vehicle}, booking, nil
+}
@@ -1,241 +1,461 @@
-package vehicles
-
-import (
-	"context"
-	"encoding/json"
-	"logi-craft/db"
-	"logi-craft/models"
-	"net/http"
-	"time"
-
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-type VehicleRequest struct {
-	VehicleNo   string `bson:"vehicle_no"`
-	VehicleType string `bson:"vehicle_type"`
-}
-
-type VehicleResponse struct {
-	Success bool           `json:"success"`
-	Message string         `json:"message"`
-	Vehicle models.Vehicle `json:"vehicle,omitempty"`
-}
-
-// GetVehicleInfo retrieves vehicle information by vehicle number
-func GetVehicleInfoById(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Vehicle info requested")
-	params := mux.Vars(r)
-	vehicleNo := params["vehicleNo"]
-	if vehicleNo == "" {
-		http.Error(w, "Missing Vehicle Number parameter", http.StatusBadRequest)
-		return
-	}
-
-	collection := db.GetCollection("vehicles")
-	filter := bson.M{"vehicle_no": vehicleNo}
-	var vehicle models.Vehicle
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	err := collection.FindOne(ctx, filter).Decode(&vehicle)
-	if err == mongo.ErrNoDocuments {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(VehicleResponse{Success: false, Message: "Vehicle not found"})
-		return
-	} else if err != nil {
-		http.Error(w, "Failed to fetch vehicle", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(VehicleResponse{
-		Success: true,
-		Message: "Vehicle retrieved successfully",
-		Vehicle: vehicle,
-	})
-}
-
-type VehiclesResponse struct {
-	Success  bool             `json:"success"`
-	Message  string           `json:"message"`
-	Vehicles []models.Vehicle `json:"vehicles,omitempty"`
-}
-
-// GetAllVehicles retrieves all vehicles from the database
-func GetAllVehicles(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Fetching all vehicles")
-
-	// Get the vehicles collection
-	collection := db.GetCollection("vehicles")
-	var vehicles []models.Vehicle
-
-	// Define context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a cursor to find all vehicles
-	cursor, err := collection.Find(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	// Iterate through the cursor and decode each vehicle
-	for cursor.Next(ctx) {
-		var vehicle models.Vehicle
-		if err := cursor.Decode(&vehicle); err != nil {
-			http.Error(w, "Failed to decode vehicle", http.StatusInternalServerError)
-			return
-		}
-		vehicles = append(vehicles, vehicle)
-	}
-
-	// Check for any error that occurred during iteration
-	if err := cursor.Err(); err != nil {
-		http.Error(w, "Cursor error while fetching vehicles", http.StatusInternalServerError)
-		return
-	}
-
-	// Return the list of vehicles
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(VehiclesResponse{
-		Success:  true,
-		Message:  "Vehicles retrieved successfully",
-		Vehicles: vehicles,
-	})
-}
-
-// Handler function to add a new vehicle
-func AddVehicleHandler(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Add vehicle requested")
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var vehicle models.Vehicle
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&vehicle)
-	if err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
-		return
-	}
-	// Define context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var existingVehicle models.Vehicle
-	err = db.GetCollection("vehicles").FindOne(ctx, bson.M{"vehicle_no": vehicle.VehicleNo}).Decode(&existingVehicle)
-	if err == nil {
-		// Vehicle already exists
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Vehicle already exists"})
-		return
-	}
-
-	// fmt.Println(vehicle)
-
-	// Set default values
-	vehicle.Coordinates = models.Coordinates{Latitude: 28.612894, Longitude: 77.216721} // Default coordinates
-	vehicle.Busy = false                                                                // Default busy status
-
-	collection := db.GetCollection("vehicles")
-
-	// Insert vehicle into the database
-	_, err = collection.InsertOne(context.TODO(), vehicle)
-	if err != nil {
-		http.Error(w, "Failed to add vehicle", http.StatusInternalServerError)
-		return
-	}
-
-	// Return success response
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(vehicle)
-}
-
-// Struct for the request payload to update location
-type LocationUpdate struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	UID       string  `json:"uid"`
-}
-
-// UpdateVehicleLocation updates the location of a vehicle by its vehicle number
-func UpdateVehicleLocation(w http.ResponseWriter, r *http.Request) {
-	// fmt.Println("Update vehicle location requested")
-	// Extract vehicle_no from the URL
-	vars := mux.Vars(r)
-	vehicleNo := vars["vehicle_no"]
-
-	// Decode the request body
-	var locationUpdate LocationUpdate
-	err := json.NewDecoder(r.Body).Decode(&locationUpdate)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
-
-	// Set up context and MongoDB connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Get vehicles collection
-	vehiclesCollection := db.GetCollection("vehicles")
-
-	// Find the vehicle by vehicle_no and update its coordinates
-	filter := bson.M{"vehicle_no": vehicleNo}
-	update := bson.M{
-		"$set": bson.M{
-			"coordinates": models.Coordinates{
-				Latitude:  locationUpdate.Latitude,
-				Longitude: locationUpdate.Longitude,
-			},
-		},
-	}
-
-	// Perform the update
-	result, err := vehiclesCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		http.Error(w, "Failed to update vehicle location", http.StatusInternalServerError)
-		return
-	}
-
-	// Check if the vehicle was found and updated
-	if result.MatchedCount == 0 {
-		http.Error(w, "Vehicle not found", http.StatusNotFound)
-		return
-	}
-
-	// Return success response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Vehicle location updated successfully",
-	})
-}
-
-func GetVehicleCoordsHandler(w http.ResponseWriter, r *http.Request) {
-	// Get vehicle_no from the URL parameters
-	vars := mux.Vars(r)
-	vehicleNo := vars["vehicle_no"]
-
-	// Get the MongoDB collection
-	collection := db.GetCollection("vehicles")
-
-	// Find the vehicle by its vehicle number
-	filter := bson.M{"vehicle_no": vehicleNo}
-	var vehicle models.Vehicle
-	err := collection.FindOne(context.Background(), filter).Decode(&vehicle)
-	if err != nil {
-		http.Error(w, "Vehicle not found", http.StatusNotFound)
-		return
-	}
-
-	// Return the vehicle coordinates as JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(vehicle.Coordinates)
-}
+package vehicles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"logi-craft/db"
+	"logi-craft/geoindex"
+	"logi-craft/models"
+	authentication "logi-craft/routes/Authentication"
+	presence "logi-craft/routes/Presence"
+	tracking "logi-craft/routes/Tracking"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type VehicleRequest struct {
+	VehicleNo   string `bson:"vehicle_no"`
+	VehicleType string `bson:"vehicle_type"`
+}
+
+type VehicleResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Vehicle models.Vehicle `json:"vehicle,omitempty"`
+}
+
+// GetVehicleInfo retrieves vehicle information by vehicle number
+func GetVehicleInfoById(w http.ResponseWriter, r *http.Request) {
+	// fmt.Println("Vehicle info requested")
+	params := mux.Vars(r)
+	vehicleNo := params["vehicleNo"]
+	if vehicleNo == "" {
+		http.Error(w, "Missing Vehicle Number parameter", http.StatusBadRequest)
+		return
+	}
+
+	collection := db.GetCollection("vehicles")
+	filter := bson.M{"vehicle_no": vehicleNo}
+	var vehicle models.Vehicle
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := collection.FindOne(ctx, filter).Decode(&vehicle)
+	if err == mongo.ErrNoDocuments {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(VehicleResponse{Success: false, Message: "Vehicle not found"})
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch vehicle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VehicleResponse{
+		Success: true,
+		Message: "Vehicle retrieved successfully",
+		Vehicle: vehicle,
+	})
+}
+
+type VehiclesResponse struct {
+	Success  bool             `json:"success"`
+	Message  string           `json:"message"`
+	Vehicles []models.Vehicle `json:"vehicles,omitempty"`
+}
+
+// GetAllVehicles retrieves all vehicles from the database
+func GetAllVehicles(w http.ResponseWriter, r *http.Request) {
+	// fmt.Println("Fetching all vehicles")
+
+	// Get the vehicles collection
+	collection := db.GetCollection("vehicles")
+	var vehicles []models.Vehicle
+
+	// Define context with a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Create a cursor to find all vehicles
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		http.Error(w, "Failed to fetch vehicles", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	// Iterate through the cursor and decode each vehicle
+	for cursor.Next(ctx) {
+		var vehicle models.Vehicle
+		if err := cursor.Decode(&vehicle); err != nil {
+			http.Error(w, "Failed to decode vehicle", http.StatusInternalServerError)
+			return
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+
+	// Check for any error that occurred during iteration
+	if err := cursor.Err(); err != nil {
+		http.Error(w, "Cursor error while fetching vehicles", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the list of vehicles
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VehiclesResponse{
+		Success:  true,
+		Message:  "Vehicles retrieved successfully",
+		Vehicles: vehicles,
+	})
+}
+
+// Handler function to add a new vehicle
+func AddVehicleHandler(w http.ResponseWriter, r *http.Request) {
+	// fmt.Println("Add vehicle requested")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var vehicle models.Vehicle
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&vehicle)
+	if err != nil {
+		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	// Define context with a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var existingVehicle models.Vehicle
+	err = db.GetCollection("vehicles").FindOne(ctx, bson.M{"vehicle_no": vehicle.VehicleNo}).Decode(&existingVehicle)
+	if err == nil {
+		// Vehicle already exists
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Vehicle already exists"})
+		return
+	}
+
+	// fmt.Println(vehicle)
+
+	// Set default values
+	vehicle.Coordinates = models.Coordinates{Latitude: 28.612894, Longitude: 77.216721} // Default coordinates
+	vehicle.Busy = false                                                                // Default busy status
+	vehicle.Load = nil                                                                  // Nothing on board yet
+	now := time.Now()
+	vehicle.UpdatedAt = &now
+	location := models.PointOf(vehicle.Coordinates)
+	vehicle.Location = &location
+
+	collection := db.GetCollection("vehicles")
+
+	// Insert vehicle into the database
+	_, err = collection.InsertOne(context.TODO(), vehicle)
+	if err != nil {
+		http.Error(w, "Failed to add vehicle", http.StatusInternalServerError)
+		return
+	}
+
+	// Return success response
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(vehicle)
+}
+
+// maxClockSkew is how far ahead of the server's clock a device may date a location update
+const maxClockSkew = 2 * time.Minute
+
+// Struct for the request payload to update location
+type LocationUpdate struct {
+	Latitude   float64    `json:"latitude"`
+	Longitude  float64    `json:"longitude"`
+	UID        string     `json:"uid"`
+	RecordedAt *time.Time `json:"recorded_at"` // when the device took the reading; the time it arrives if empty
+}
+
+// UpdateVehicleLocation updates the location of a vehicle by its vehicle number.
+// Updates are only accepted from an authenticated device of the driver
+// assigned to the vehicle, and only if they are newer than the stored location.
+func UpdateVehicleLocation(w http.ResponseWriter, r *http.Request) {
+	// fmt.Println("Update vehicle location requested")
+	// Extract vehicle_no from the URL
+	vars := mux.Vars(r)
+	vehicleNo := vars["vehicle_no"]
+
+	// Decode the request body
+	var locationUpdate LocationUpdate
+	err := json.NewDecoder(r.Body).Decode(&locationUpdate)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if locationUpdate.Latitude < -90 || locationUpdate.Latitude > 90 || locationUpdate.Longitude < -180 || locationUpdate.Longitude > 180 {
+		http.Error(w, "Coordinates are out of range", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	recordedAt := now
+	if locationUpdate.RecordedAt != nil {
+		recordedAt = *locationUpdate.RecordedAt
+		if recordedAt.After(now.Add(maxClockSkew)) {
+			http.Error(w, "recorded_at is in the future", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Set up context and MongoDB connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Only the driver assigned to the vehicle may move it
+	driverID, err := authentication.DeviceDriver(ctx, r)
+	if err == authentication.ErrNoDevice {
+		http.Error(w, "Device is not authenticated", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		fmt.Printf("Error authenticating device: %v\n", err)
+		http.Error(w, "Failed to authenticate device", http.StatusInternalServerError)
+		return
+	}
+	if locationUpdate.UID != "" && locationUpdate.UID != driverID.Hex() {
+		http.Error(w, "Device belongs to another driver", http.StatusForbidden)
+		return
+	}
+	var assignment models.Assignment
+	err = db.GetCollection("assignments").FindOne(ctx, bson.M{"uid": driverID, "vehicle_no": vehicleNo}).Decode(&assignment)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Driver is not assigned to this vehicle", http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch assignment", http.StatusInternalServerError)
+		return
+	}
+
+	// Get vehicles collection
+	vehiclesCollection := db.GetCollection("vehicles")
+
+	// Find the vehicle by vehicle_no and update its coordinates, unless a newer reading is already stored
+	filter := bson.M{"vehicle_no": vehicleNo, "$or": bson.A{
+		bson.M{"location_at": bson.M{"$exists": false}},
+		bson.M{"location_at": bson.M{"$lt": recordedAt}},
+	}}
+	coordinates := models.Coordinates{
+		Latitude:  locationUpdate.Latitude,
+		Longitude: locationUpdate.Longitude,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"coordinates": coordinates,
+			"location":    models.PointOf(coordinates),
+			"location_at": recordedAt,
+		},
+		"$currentDate": bson.M{"updated_at": true},
+	}
+
+	// Perform the update
+	var vehicle models.Vehicle
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = vehiclesCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&vehicle)
+
+	// Check if the vehicle was found and updated
+	if err == mongo.ErrNoDocuments {
+		n, err := vehiclesCollection.CountDocuments(ctx, bson.M{"vehicle_no": vehicleNo})
+		if err != nil {
+			http.Error(w, "Failed to update vehicle location", http.StatusInternalServerError)
+		} else if n == 0 {
+			http.Error(w, "Vehicle not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "A newer location is already stored", http.StatusConflict)
+		}
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update vehicle location", http.StatusInternalServerError)
+		return
+	}
+
+	geoindex.Default.Put(vehicle)
+
+	// Keep every accepted position so trips can be replayed
+	if err := tracking.RecordPosition(ctx, vehicle.VehicleNo, driverID, coordinates, recordedAt); err != nil {
+		fmt.Printf("Error recording position of vehicle %s: %v\n", vehicle.VehicleNo, err)
+	}
+
+	// A location update shows the driver's device is still there
+	if vehicle.Online {
+		if err := presence.Seen(ctx, vehicle.VehicleNo); err != nil {
+			fmt.Printf("Error recording presence for vehicle %s: %v\n", vehicle.VehicleNo, err)
+		}
+	}
+
+	// Refresh the ETA of the booking this vehicle is serving
+	if vehicle.Busy {
+		if err := tracking.Refresh(ctx, vehicle); err != nil {
+			fmt.Printf("Error refreshing ETA for vehicle %s: %v\n", vehicle.VehicleNo, err)
+		}
+	}
+
+	// Return success response
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Vehicle location updated successfully",
+	})
+}
+
+func GetVehicleCoordsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get vehicle_no from the URL parameters
+	vars := mux.Vars(r)
+	vehicleNo := vars["vehicle_no"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Only the vehicle's driver, the customer it is serving and admins may see where it is
+	userID, ok := authentication.AuthorizeDevice(ctx, w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canSeeVehicle(ctx, vehicleNo, userID)
+	if err != nil {
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Not allowed to track this vehicle", http.StatusForbidden)
+		return
+	}
+
+	// Get the MongoDB collection
+	collection := db.GetCollection("vehicles")
+
+	// Find the vehicle by its vehicle number
+	filter := bson.M{"vehicle_no": vehicleNo}
+	var vehicle models.Vehicle
+	err = collection.FindOne(ctx, filter).Decode(&vehicle)
+	if err != nil {
+		http.Error(w, "Vehicle not found", http.StatusNotFound)
+		return
+	}
+
+	// Return the vehicle coordinates as JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vehicle.Coordinates)
+}
+
+// maxTrailRange is the longest period one trail request may cover
+const maxTrailRange = 7 * 24 * time.Hour
+
+// GetVehicleTrail returns the positions a vehicle reported between from and to,
+// given as RFC 3339 times. Without them it covers the last hour.
+func GetVehicleTrail(w http.ResponseWriter, r *http.Request) {
+	vehicleNo := mux.Vars(r)["vehicle_no"]
+	query := r.URL.Query()
+
+	to := time.Now()
+	if s := query.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "to must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour)
+	if s := query.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "from must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		http.Error(w, "from is after to", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) > maxTrailRange {
+		http.Error(w, "A trail may cover at most 7 days", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A vehicle's history is only for its driver and admins
+	userID, ok := authentication.AuthorizeDevice(ctx, w, r)
+	if !ok {
+		return
+	}
+	allowed, err := canSeeTrail(ctx, vehicleNo, userID)
+	if err != nil {
+		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Not allowed to see this vehicle's trail", http.StatusForbidden)
+		return
+	}
+
+	trail, err := tracking.GetTrail(ctx, vehicleNo, nil, from, to)
+	if err != nil {
+		http.Error(w, "Failed to fetch vehicle positions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tracking.PathResponse{
+		Success:   true,
+		Message:   "Vehicle trail retrieved successfully",
+		VehicleNo: vehicleNo,
+		From:      &from,
+		To:        &to,
+		Trail:     trail,
+	})
+}
+
+// canSeeTrail reports whether a user is an admin or the vehicle's driver
+func canSeeTrail(ctx context.Context, vehicleNo string, userID primitive.ObjectID) (bool, error) {
+	var user models.User
+	err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if user.UserType == "admin" {
+		return true, nil
+	}
+
+	n, err := db.GetCollection("assignments").CountDocuments(ctx, bson.M{"vehicle_no": vehicleNo, "uid": userID})
+	return n > 0, err
+}
+
+// canSeeVehicle reports whether a user is the vehicle's driver, the customer
+// of the booking it is serving, or an admin
+func canSeeVehicle(ctx context.Context, vehicleNo string, userID primitive.ObjectID) (bool, error) {
+	allowed, err := canSeeTrail(ctx, vehicleNo, userID)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	id, err := carrying(ctx, vehicleNo, userID)
+	return !id.IsZero(), err
+}
+
+// carrying returns the booking of a customer the vehicle is serving, or a
+// zero ID if it is not serving one
+func carrying(ctx context.Context, vehicleNo string, userID primitive.ObjectID) (primitive.ObjectID, error) {
+	var booking models.Booking
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	err := db.GetCollection("bookings").FindOne(ctx, bson.M{"vehicle_no": vehicleNo, "user_id": userID, "job_status": "in-transit"}, opts).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, nil
+	}
+	return booking.ID, err
+}
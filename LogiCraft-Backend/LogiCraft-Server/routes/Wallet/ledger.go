@@ -0,0 +1,376 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/payments"
+	timeline "logi-craft/routes/Timeline"
+	"logi-craft/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ledger accounts that are not tied to a single user
+const (
+	AccountGatewayClearing = "gateway_clearing"
+	AccountBookingRevenue  = "booking_revenue"
+	AccountAdjustments     = "adjustments"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient wallet balance")
+	ErrUnbalanced        = errors.New("ledger transaction does not balance")
+	ErrPaymentRequired   = errors.New("a payment method is required for this booking")
+	ErrUnknownMethod     = errors.New("unknown payment method")
+	ErrNothingToRefund   = errors.New("booking has no captured payment to refund")
+	ErrRefundTooLarge    = errors.New("refund exceeds the amount charged")
+	ErrPaymentChanged    = errors.New("booking payment changed, please retry")
+)
+
+// WalletAccount is the ledger account holding a user's wallet balance
+func WalletAccount(userID primitive.ObjectID) string {
+	return "wallet:" + userID.Hex()
+}
+
+// EnsureIndexes makes sure each user has at most one wallet
+func EnsureIndexes(ctx context.Context) error {
+	_, err := db.GetCollection("wallets").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.GetCollection("ledger").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "entries.account", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// Post records a balanced transaction in the ledger
+func Post(ctx context.Context, txn models.LedgerTransaction) (models.LedgerTransaction, error) {
+	var debits, credits float64
+	for _, entry := range txn.Entries {
+		debits += entry.Debit
+		credits += entry.Credit
+	}
+	if len(txn.Entries) < 2 || math.Abs(debits-credits) > 0.005 {
+		return txn, ErrUnbalanced
+	}
+
+	txn.CreatedAt = time.Now()
+	result, err := db.GetCollection("ledger").InsertOne(ctx, txn)
+	if err != nil {
+		return txn, err
+	}
+	txn.ID = result.InsertedID.(primitive.ObjectID)
+	return txn, nil
+}
+
+// movement is a two-legged transaction moving amount from the debit account to the credit account
+func movement(txnType, reference, memo, debitAccount, creditAccount string, amount float64) models.LedgerTransaction {
+	return models.LedgerTransaction{
+		Type:      txnType,
+		Reference: reference,
+		Memo:      memo,
+		Entries: []models.LedgerEntry{
+			{Account: debitAccount, Debit: amount},
+			{Account: creditAccount, Credit: amount},
+		},
+	}
+}
+
+// transfer posts a movement that touches no wallet balance
+func transfer(ctx context.Context, txnType, reference, memo, debitAccount, creditAccount string, amount float64) (models.LedgerTransaction, error) {
+	return Post(ctx, movement(txnType, reference, memo, debitAccount, creditAccount, amount))
+}
+
+// walletChange is a change to the balances of one user's wallet
+type walletChange struct {
+	userID    primitive.ObjectID
+	balance   float64 // added to the available balance
+	held      float64 // added to the held balance
+	needFunds bool    // fail with ErrInsufficientFunds unless the balance covers a negative change
+}
+
+// apply makes the change, creating the wallet if it has only money coming in
+func (c walletChange) apply(ctx context.Context) error {
+	filter := bson.M{"user_id": c.userID}
+	opts := options.Update()
+	if c.needFunds {
+		filter["balance"] = bson.M{"$gte": -c.balance}
+	} else {
+		opts.SetUpsert(true)
+	}
+	result, err := db.GetCollection("wallets").UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"balance": c.balance, "held": c.held}}, opts)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// undo reverses a change that was applied
+func (c walletChange) undo(ctx context.Context) error {
+	_, err := db.GetCollection("wallets").UpdateOne(ctx,
+		bson.M{"user_id": c.userID},
+		bson.M{"$inc": bson.M{"balance": -c.balance, "held": -c.held}})
+	return err
+}
+
+// postWithWallet changes a wallet and posts the ledger transaction recording it
+// together, so the balance never disagrees with the ledger. Both are written in
+// one transaction where the database supports it; otherwise the wallet change
+// is undone if the ledger transaction cannot be posted.
+func postWithWallet(ctx context.Context, change walletChange, txn models.LedgerTransaction) (models.LedgerTransaction, error) {
+	posted, err := db.Transaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if err := change.apply(sc); err != nil {
+			return nil, err
+		}
+		return Post(sc, txn)
+	})
+	if err == db.ErrNoTransactions {
+		if err := change.apply(ctx); err != nil {
+			return txn, err
+		}
+		posted, err := Post(ctx, txn)
+		if err != nil {
+			if undoErr := change.undo(ctx); undoErr != nil {
+				fmt.Printf("Error undoing wallet change for user %s: %v\n", change.userID.Hex(), undoErr)
+			}
+		}
+		return posted, err
+	}
+	if err != nil {
+		return txn, err
+	}
+	return posted.(models.LedgerTransaction), nil
+}
+
+// TopUp charges the payment source through the gateway and credits the wallet.
+// If the wallet cannot be credited the charge is refunded in full.
+func TopUp(ctx context.Context, userID primitive.ObjectID, amount float64, source string) (models.LedgerTransaction, error) {
+	payment, err := payments.Default.Charge(ctx, payments.ChargeRequest{
+		Amount:    amount,
+		Currency:  config.App.Payments.Currency,
+		Source:    source,
+		Reference: WalletAccount(userID),
+	})
+	if err != nil {
+		return models.LedgerTransaction{}, err
+	}
+
+	txn, err := postWithWallet(ctx, walletChange{userID: userID, balance: amount},
+		movement("topup", payment.ID, "Wallet top-up", AccountGatewayClearing, WalletAccount(userID), amount))
+	if err != nil {
+		if _, refundErr := payments.Default.Refund(ctx, payment.ID, amount); refundErr != nil {
+			fmt.Printf("Error refunding top-up %s after failing to credit wallet: %v\n", payment.ID, refundErr)
+		}
+		return models.LedgerTransaction{}, err
+	}
+	return txn, nil
+}
+
+// Adjust applies a manual correction to a wallet. Positive amounts credit the
+// customer, negative amounts debit them.
+func Adjust(ctx context.Context, userID primitive.ObjectID, amount float64, reason string) (models.LedgerTransaction, error) {
+	if amount >= 0 {
+		return postWithWallet(ctx, walletChange{userID: userID, balance: amount},
+			movement("adjustment", userID.Hex(), reason, AccountAdjustments, WalletAccount(userID), amount))
+	}
+	return postWithWallet(ctx, walletChange{userID: userID, balance: amount, needFunds: true},
+		movement("adjustment", userID.Hex(), reason, WalletAccount(userID), AccountAdjustments, -amount))
+}
+
+// Authorize secures the fare of a booking before dispatch. Wallet payments move
+// the fare from the available balance into the held balance; card payments
+// place an authorisation with the gateway.
+func Authorize(ctx context.Context, userID primitive.ObjectID, method, source string, amount float64) (*models.BookingPayment, error) {
+	amount = utils.RoundMoney(amount)
+
+	switch method {
+	case "":
+		if config.App.Payments.RequirePreauth {
+			return nil, ErrPaymentRequired
+		}
+		return nil, nil
+
+	case models.PaymentMethodWallet:
+		result, err := db.GetCollection("wallets").UpdateOne(ctx,
+			bson.M{"user_id": userID, "balance": bson.M{"$gte": amount}},
+			bson.M{"$inc": bson.M{"balance": -amount, "held": amount}})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			return nil, ErrInsufficientFunds
+		}
+		return &models.BookingPayment{Method: method, Status: payments.StatusAuthorized, Amount: amount}, nil
+
+	case models.PaymentMethodCard:
+		payment, err := payments.Default.Authorize(ctx, payments.ChargeRequest{
+			Amount:    amount,
+			Currency:  config.App.Payments.Currency,
+			Source:    source,
+			Reference: userID.Hex(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &models.BookingPayment{Method: method, Status: payments.StatusAuthorized, Amount: amount, PaymentID: payment.ID}, nil
+	}
+
+	return nil, ErrUnknownMethod
+}
+
+// Void releases an authorisation taken by Authorize, e.g. when the booking could not be created
+func Void(ctx context.Context, userID primitive.ObjectID, payment *models.BookingPayment) error {
+	if payment == nil || payment.Status != payments.StatusAuthorized {
+		return nil
+	}
+
+	switch payment.Method {
+	case models.PaymentMethodWallet:
+		_, err := db.GetCollection("wallets").UpdateOne(ctx,
+			bson.M{"user_id": userID},
+			bson.M{"$inc": bson.M{"balance": payment.Amount, "held": -payment.Amount}})
+		return err
+	case models.PaymentMethodCard:
+		_, err := payments.Default.Void(ctx, payment.PaymentID)
+		return err
+	}
+	return ErrUnknownMethod
+}
+
+// Capture charges a completed booking against its authorisation and records the revenue
+func Capture(ctx context.Context, booking models.Booking) error {
+	payment := booking.Payment
+	if payment == nil || payment.Status != payments.StatusAuthorized {
+		return nil
+	}
+
+	// Charge the final fare, which may be lower than the authorised amount after an adjustment
+	amount := math.Min(utils.RoundMoney(booking.Cost), payment.Amount)
+	reference := booking.ID.Hex()
+
+	// Mark the payment captured first so that a repeated completion cannot charge twice
+	bookings := db.GetCollection("bookings")
+	result, err := bookings.UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "payment.status": payments.StatusAuthorized},
+		bson.M{"$set": bson.M{"payment.status": payments.StatusCaptured, "payment.amount": amount}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	switch payment.Method {
+	case models.PaymentMethodWallet:
+		_, err = postWithWallet(ctx, walletChange{userID: booking.UserID, held: -payment.Amount, balance: payment.Amount - amount},
+			movement("booking_charge", reference, "Booking fare", WalletAccount(booking.UserID), AccountBookingRevenue, amount))
+	case models.PaymentMethodCard:
+		if _, err = payments.Default.Capture(ctx, payment.PaymentID, amount); err != nil {
+			break
+		}
+		if _, err := transfer(ctx, "booking_charge", reference, "Booking fare", AccountGatewayClearing, AccountBookingRevenue, amount); err != nil {
+			// The card was charged, so the payment stays captured
+			return fmt.Errorf("recording capture %s in the ledger: %w", payment.PaymentID, err)
+		}
+	default:
+		err = ErrUnknownMethod
+	}
+	if err != nil {
+		// Leave the payment authorised so the capture can be tried again
+		_, undoErr := bookings.UpdateOne(ctx,
+			bson.M{"_id": booking.ID, "payment.status": payments.StatusCaptured},
+			bson.M{"$set": bson.M{"payment.status": payments.StatusAuthorized, "payment.amount": payment.Amount}})
+		if undoErr != nil {
+			fmt.Printf("Error restoring payment of booking %s: %v\n", booking.ID.Hex(), undoErr)
+		}
+		return err
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventPaymentCaptured, models.EventActor{Role: models.ActorSystem}, map[string]interface{}{
+		"method": payment.Method,
+		"amount": amount,
+	})
+	return nil
+}
+
+// Refund returns part or all of a captured booking charge. Wallet payments are
+// refunded to the wallet, card payments back to the card.
+func Refund(ctx context.Context, booking models.Booking, amount float64, reason string) (models.LedgerTransaction, error) {
+	payment := booking.Payment
+	if payment == nil || (payment.Status != payments.StatusCaptured && payment.Status != payments.StatusRefunded) {
+		return models.LedgerTransaction{}, ErrNothingToRefund
+	}
+	amount = utils.RoundMoney(amount)
+	if amount <= 0 || payment.Refunded+amount > payment.Amount+0.005 {
+		return models.LedgerTransaction{}, ErrRefundTooLarge
+	}
+
+	// Claim the refund on the booking first so concurrent refunds cannot exceed the charge
+	bookings := db.GetCollection("bookings")
+	result, err := bookings.UpdateOne(ctx,
+		bson.M{"_id": booking.ID, "payment.refunded": bson.M{"$in": bson.A{nil, payment.Refunded}}},
+		bson.M{"$inc": bson.M{"payment.refunded": amount}, "$set": bson.M{"payment.status": payments.StatusRefunded}})
+	if err != nil {
+		return models.LedgerTransaction{}, err
+	}
+	if result.ModifiedCount == 0 {
+		return models.LedgerTransaction{}, ErrPaymentChanged
+	}
+
+	var txn models.LedgerTransaction
+	reference := booking.ID.Hex()
+	switch payment.Method {
+	case models.PaymentMethodWallet:
+		txn, err = postWithWallet(ctx, walletChange{userID: booking.UserID, balance: amount},
+			movement("refund", reference, reason, AccountBookingRevenue, WalletAccount(booking.UserID), amount))
+	case models.PaymentMethodCard:
+		if _, err = payments.Default.Refund(ctx, payment.PaymentID, amount); err != nil {
+			break
+		}
+		if txn, err = transfer(ctx, "refund", reference, reason, AccountBookingRevenue, AccountGatewayClearing, amount); err != nil {
+			// The card was refunded, so the claim stands
+			return txn, fmt.Errorf("recording refund of %s in the ledger: %w", payment.PaymentID, err)
+		}
+	default:
+		err = ErrUnknownMethod
+	}
+	if err != nil {
+		// Nothing was refunded, so give back the claim. Other refunds may have
+		// been claimed since, so the status is only reset if none are left.
+		_, undoErr := bookings.UpdateOne(ctx, bson.M{"_id": booking.ID}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"payment.refunded": bson.M{"$subtract": bson.A{"$payment.refunded", amount}}}}},
+			{{Key: "$set", Value: bson.M{"payment.status": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$payment.refunded", 0.005}}, payments.StatusRefunded, payments.StatusCaptured,
+			}}}}},
+		})
+		if undoErr != nil {
+			fmt.Printf("Error releasing refund claim on booking %s: %v\n", booking.ID.Hex(), undoErr)
+		}
+		return models.LedgerTransaction{}, err
+	}
+
+	timeline.Record(ctx, booking.ID, models.EventRefunded, models.EventActor{Role: models.ActorAdmin}, map[string]interface{}{
+		"amount": amount,
+		"reason": reason,
+	})
+	return txn, nil
+}
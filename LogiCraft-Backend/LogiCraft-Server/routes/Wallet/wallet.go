@@ -0,0 +1,251 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"logi-craft/db"
+	"logi-craft/models"
+	"logi-craft/payments"
+	authentication "logi-craft/routes/Authentication"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WalletResponse struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Wallet  models.Wallet `json:"wallet,omitempty"`
+}
+
+type TransactionResponse struct {
+	Success     bool                     `json:"success"`
+	Message     string                   `json:"message"`
+	Transaction models.LedgerTransaction `json:"transaction,omitempty"`
+}
+
+type TransactionsResponse struct {
+	Success      bool                       `json:"success"`
+	Message      string                     `json:"message"`
+	Transactions []models.LedgerTransaction `json:"transactions,omitempty"`
+}
+
+// GetWallet retrieves the wallet balance of a user
+func GetWallet(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid, err := primitive.ObjectIDFromHex(params["uid"])
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeUser(ctx, w, r, uid) {
+		return
+	}
+
+	wallet := models.Wallet{UserID: uid}
+	err = db.GetCollection("wallets").FindOne(ctx, bson.M{"user_id": uid}).Decode(&wallet)
+	if err != nil && err != mongo.ErrNoDocuments {
+		http.Error(w, "Failed to fetch wallet", http.StatusInternalServerError)
+		return
+	}
+
+	// A user without a wallet simply has a zero balance
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WalletResponse{
+		Success: true,
+		Message: "Wallet retrieved successfully",
+		Wallet:  wallet,
+	})
+}
+
+// GetWalletTransactions retrieves the ledger transactions that touch a user's wallet, newest first
+func GetWalletTransactions(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid, err := primitive.ObjectIDFromHex(params["uid"])
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeUser(ctx, w, r, uid) {
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := db.GetCollection("ledger").Find(ctx, bson.M{"entries.account": WalletAccount(uid)}, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch transactions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var transactions []models.LedgerTransaction
+	if err = cursor.All(ctx, &transactions); err != nil {
+		http.Error(w, "Error decoding transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransactionsResponse{
+		Success:      true,
+		Message:      "Transactions retrieved successfully",
+		Transactions: transactions,
+	})
+}
+
+// TopUpWallet charges the customer through the payment gateway and credits their wallet
+func TopUpWallet(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid, err := primitive.ObjectIDFromHex(params["uid"])
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Source string  `json:"source"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeUser(ctx, w, r, uid) {
+		return
+	}
+
+	txn, err := TopUp(ctx, uid, req.Amount, req.Source)
+	if err == payments.ErrDeclined {
+		http.Error(w, "Payment declined", http.StatusPaymentRequired)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to top up wallet", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransactionResponse{
+		Success:     true,
+		Message:     "Wallet topped up successfully",
+		Transaction: txn,
+	})
+}
+
+// AdjustWallet lets an admin credit or debit a wallet with a reason
+func AdjustWallet(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	uid, err := primitive.ObjectIDFromHex(params["uid"])
+	if err != nil {
+		http.Error(w, "Invalid UID format", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Amount == 0 || req.Reason == "" {
+		http.Error(w, "Amount and reason are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	txn, err := Adjust(ctx, uid, req.Amount, req.Reason)
+	if err == ErrInsufficientFunds {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to adjust wallet", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransactionResponse{
+		Success:     true,
+		Message:     "Wallet adjusted successfully",
+		Transaction: txn,
+	})
+}
+
+// RefundBooking refunds part or all of what was charged for a booking
+func RefundBooking(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(params["bookingId"])
+	if err != nil {
+		http.Error(w, "Invalid Booking ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !authentication.AuthorizeAdmin(ctx, w, r) {
+		return
+	}
+
+	var booking models.Booking
+	err = db.GetCollection("bookings").FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Booking not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch booking", http.StatusInternalServerError)
+		return
+	}
+
+	txn, err := Refund(ctx, booking, req.Amount, req.Reason)
+	if err == ErrNothingToRefund || err == ErrRefundTooLarge || err == ErrPaymentChanged {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to refund booking", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransactionResponse{
+		Success:     true,
+		Message:     "Booking refunded successfully",
+		Transaction: txn,
+	})
+}
@@ -0,0 +1,217 @@
+// Package routing finds driving distances and times over a road network
+// loaded from an OpenStreetMap extract, so fares and ETAs follow the roads
+// rather than a straight line.
+package routing
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"logi-craft/models"
+	"logi-craft/utils"
+)
+
+// cellSize is the side of a cell in the grid used to snap points to the
+// network, in degrees.
+const cellSize = 0.01
+
+// Graph is a directed road network. Edges are stored in compressed rows: the
+// edges leaving node n are first[n] up to first[n+1], and the edges arriving
+// at it are kept the same way in the reverse rows.
+type Graph struct {
+	lat, lon []float64
+
+	first    []int32
+	to       []int32
+	length   []float32 // km
+	duration []float32 // seconds at free-flow speed
+
+	revFirst    []int32
+	revFrom     []int32
+	revLength   []float32
+	revDuration []float32
+
+	maxSpeed float64 // km/h, the fastest any edge may be driven
+	cells    map[[2]int][]int32
+	searches sync.Pool // of *search
+}
+
+// graphFile is how a graph is stored on disk by Save.
+type graphFile struct {
+	Lat, Lon []float64
+	First    []int32
+	To       []int32
+	Length   []float32
+	Duration []float32
+}
+
+// edge is a road segment added to a builder.
+type edge struct {
+	from, to int32
+	length   float32
+	duration float32
+}
+
+// builder collects the nodes and edges of a graph before it is built.
+type builder struct {
+	lat, lon []float64
+	edges    []edge
+}
+
+func (b *builder) addNode(p models.Coordinates) int32 {
+	b.lat = append(b.lat, p.Latitude)
+	b.lon = append(b.lon, p.Longitude)
+	return int32(len(b.lat) - 1)
+}
+
+// addEdge adds a segment from one node to another driven at speed km/h.
+func (b *builder) addEdge(from, to int32, speed float64) {
+	km := utils.HaversineDistance(b.lat[from], b.lon[from], b.lat[to], b.lon[to])
+	b.edges = append(b.edges, edge{from: from, to: to, length: float32(km), duration: float32(km / speed * 3600)})
+}
+
+// build lays the edges out in compressed rows.
+func (b *builder) build() *Graph {
+	n := len(b.lat)
+	file := graphFile{
+		Lat:      b.lat,
+		Lon:      b.lon,
+		First:    make([]int32, n+1),
+		To:       make([]int32, len(b.edges)),
+		Length:   make([]float32, len(b.edges)),
+		Duration: make([]float32, len(b.edges)),
+	}
+	for _, e := range b.edges {
+		file.First[e.from+1]++
+	}
+	for i := 0; i < n; i++ {
+		file.First[i+1] += file.First[i]
+	}
+	next := append([]int32(nil), file.First[:n]...)
+	for _, e := range b.edges {
+		i := next[e.from]
+		next[e.from]++
+		file.To[i] = e.to
+		file.Length[i] = e.length
+		file.Duration[i] = e.duration
+	}
+	return newGraph(file)
+}
+
+// newGraph builds the reverse rows and snapping grid for a stored graph.
+func newGraph(file graphFile) *Graph {
+	g := &Graph{
+		lat:      file.Lat,
+		lon:      file.Lon,
+		first:    file.First,
+		to:       file.To,
+		length:   file.Length,
+		duration: file.Duration,
+		cells:    map[[2]int][]int32{},
+	}
+	n := len(g.lat)
+
+	g.revFirst = make([]int32, n+1)
+	for _, to := range g.to {
+		g.revFirst[to+1]++
+	}
+	for i := 0; i < n; i++ {
+		g.revFirst[i+1] += g.revFirst[i]
+	}
+	g.revFrom = make([]int32, len(g.to))
+	g.revLength = make([]float32, len(g.to))
+	g.revDuration = make([]float32, len(g.to))
+	next := append([]int32(nil), g.revFirst[:n]...)
+	for from := int32(0); from < int32(n); from++ {
+		for i := g.first[from]; i < g.first[from+1]; i++ {
+			j := next[g.to[i]]
+			next[g.to[i]]++
+			g.revFrom[j] = from
+			g.revLength[j] = g.length[i]
+			g.revDuration[j] = g.duration[i]
+			if g.duration[i] > 0 {
+				g.maxSpeed = math.Max(g.maxSpeed, float64(g.length[i]/g.duration[i])*3600)
+			}
+		}
+	}
+
+	for i := range g.lat {
+		if g.first[i] == g.first[i+1] && g.revFirst[i] == g.revFirst[i+1] {
+			continue // not on any road
+		}
+		key := cellOf(g.lat[i], g.lon[i])
+		g.cells[key] = append(g.cells[key], int32(i))
+	}
+	return g
+}
+
+func cellOf(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lon / cellSize)), int(math.Floor(lat / cellSize))}
+}
+
+// Nodes returns the number of nodes in the graph.
+func (g *Graph) Nodes() int {
+	return len(g.lat)
+}
+
+// Edges returns the number of directed edges in the graph.
+func (g *Graph) Edges() int {
+	return len(g.to)
+}
+
+// Snap returns the road node nearest to a point and its distance in km, or
+// false if there is none within maxKm.
+func (g *Graph) Snap(p models.Coordinates, maxKm float64) (int32, float64, bool) {
+	centre := cellOf(p.Latitude, p.Longitude)
+	// Cells are narrower than they are tall away from the equator
+	reachY := int(math.Ceil(maxKm/(cellSize*111.195))) + 1
+	reachX := int(math.Ceil(maxKm/(cellSize*111.195*math.Max(math.Cos(p.Latitude*math.Pi/180), 0.01)))) + 1
+
+	best, bestKm := int32(-1), maxKm
+	for dx := -reachX; dx <= reachX; dx++ {
+		for dy := -reachY; dy <= reachY; dy++ {
+			for _, n := range g.cells[[2]int{centre[0] + dx, centre[1] + dy}] {
+				d := utils.HaversineDistance(p.Latitude, p.Longitude, g.lat[n], g.lon[n])
+				if d <= bestKm {
+					best, bestKm = n, d
+				}
+			}
+		}
+	}
+	return best, bestKm, best >= 0
+}
+
+// Save writes the graph to a file that Load reads back much faster than
+// parsing the extract it was built from.
+func (g *Graph) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(graphFile{Lat: g.lat, Lon: g.lon, First: g.first, To: g.to, Length: g.length, Duration: g.duration})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Load reads a graph written by Save.
+func Load(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file graphFile
+	if err := gob.NewDecoder(f).Decode(&file); err != nil {
+		return nil, fmt.Errorf("reading road graph %s: %w", path, err)
+	}
+	if len(file.First) != len(file.Lat)+1 || len(file.To) != len(file.Length) || len(file.To) != len(file.Duration) {
+		return nil, fmt.Errorf("reading road graph %s: inconsistent graph", path)
+	}
+	return newGraph(file), nil
+}
@@ -0,0 +1,294 @@
+package routing
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"logi-craft/models"
+)
+
+// roadSpeeds are the speeds in km/h assumed for each kind of OpenStreetMap
+// highway that trucks may drive on, unless a way gives its own maxspeed.
+var roadSpeeds = map[string]float64{
+	"motorway":       90,
+	"motorway_link":  60,
+	"trunk":          70,
+	"trunk_link":     50,
+	"primary":        50,
+	"primary_link":   40,
+	"secondary":      40,
+	"secondary_link": 35,
+	"tertiary":       35,
+	"tertiary_link":  30,
+	"unclassified":   30,
+	"residential":    25,
+	"road":           25,
+	"service":        15,
+	"living_street":  10,
+}
+
+// way is a drivable OpenStreetMap way.
+type way struct {
+	nodes    []int64
+	speed    float64
+	forward  bool // may be driven in the order of its nodes
+	backward bool // may be driven against the order of its nodes
+}
+
+// ParseOSM builds a graph from an OpenStreetMap extract in XML form. Extracts
+// in PBF form can be converted first, for example with
+// "osmium cat city.osm.pbf -o city.osm". Only the largest part of the network
+// in which every node can reach every other is kept, so points are never
+// snapped onto a road that leads nowhere.
+func ParseOSM(r io.Reader) (*Graph, error) {
+	positions := map[int64]models.Coordinates{}
+	var ways []way
+
+	decoder := xml.NewDecoder(r)
+	var current *way
+	var tags map[string]string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading OpenStreetMap extract: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "node":
+				id, p, err := parseNode(t.Attr)
+				if err != nil {
+					return nil, err
+				}
+				positions[id] = p
+			case "way":
+				current = &way{}
+				tags = map[string]string{}
+			case "nd":
+				if current != nil {
+					ref, err := strconv.ParseInt(attr(t.Attr, "ref"), 10, 64)
+					if err != nil {
+						return nil, fmt.Errorf("reading OpenStreetMap way: bad node reference: %w", err)
+					}
+					current.nodes = append(current.nodes, ref)
+				}
+			case "tag":
+				if current != nil {
+					tags[attr(t.Attr, "k")] = attr(t.Attr, "v")
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "way" && current != nil {
+				if drivable(current, tags) {
+					ways = append(ways, *current)
+				}
+				current = nil
+			}
+		}
+	}
+
+	b := &builder{}
+	index := map[int64]int32{}
+	nodeOf := func(id int64) (int32, bool) {
+		if n, ok := index[id]; ok {
+			return n, true
+		}
+		p, ok := positions[id]
+		if !ok {
+			// Ways clipped at the edge of an extract refer to nodes outside it
+			return 0, false
+		}
+		n := b.addNode(p)
+		index[id] = n
+		return n, true
+	}
+	for _, w := range ways {
+		for i := 1; i < len(w.nodes); i++ {
+			from, okFrom := nodeOf(w.nodes[i-1])
+			to, okTo := nodeOf(w.nodes[i])
+			if !okFrom || !okTo || from == to {
+				continue
+			}
+			if w.forward {
+				b.addEdge(from, to, w.speed)
+			}
+			if w.backward {
+				b.addEdge(to, from, w.speed)
+			}
+		}
+	}
+	if len(b.edges) == 0 {
+		return nil, fmt.Errorf("reading OpenStreetMap extract: no drivable roads found")
+	}
+	return largestComponent(b.build()), nil
+}
+
+func parseNode(attrs []xml.Attr) (int64, models.Coordinates, error) {
+	id, err := strconv.ParseInt(attr(attrs, "id"), 10, 64)
+	if err != nil {
+		return 0, models.Coordinates{}, fmt.Errorf("reading OpenStreetMap node: bad id: %w", err)
+	}
+	lat, err := strconv.ParseFloat(attr(attrs, "lat"), 64)
+	if err != nil {
+		return 0, models.Coordinates{}, fmt.Errorf("reading OpenStreetMap node %d: bad latitude: %w", id, err)
+	}
+	lon, err := strconv.ParseFloat(attr(attrs, "lon"), 64)
+	if err != nil {
+		return 0, models.Coordinates{}, fmt.Errorf("reading OpenStreetMap node %d: bad longitude: %w", id, err)
+	}
+	return id, models.Coordinates{Latitude: lat, Longitude: lon}, nil
+}
+
+func attr(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// drivable reports whether trucks may use a way, filling in its speed and the
+// directions it may be driven in from its tags.
+func drivable(w *way, tags map[string]string) bool {
+	highway := tags["highway"]
+	speed, ok := roadSpeeds[highway]
+	if !ok {
+		return false
+	}
+	switch tags["access"] {
+	case "no", "private":
+		return false
+	}
+	switch tags["motor_vehicle"] {
+	case "no", "private":
+		return false
+	}
+	if limit, ok := parseMaxSpeed(tags["maxspeed"]); ok {
+		speed = limit
+	}
+	w.speed = speed
+
+	w.forward, w.backward = true, true
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		w.backward = false
+	case "-1", "reverse":
+		w.forward = false
+	case "no", "false", "0":
+	default:
+		if highway == "motorway" || highway == "motorway_link" || tags["junction"] == "roundabout" || tags["junction"] == "circular" {
+			w.backward = false
+		}
+	}
+	return true
+}
+
+// parseMaxSpeed reads a maxspeed tag such as "50" or "30 mph" as km/h.
+func parseMaxSpeed(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	factor := 1.0
+	if strings.HasSuffix(value, "mph") {
+		value = strings.TrimSpace(strings.TrimSuffix(value, "mph"))
+		factor = 1.609344
+	}
+	speed, err := strconv.ParseFloat(value, 64)
+	if err != nil || speed <= 0 {
+		return 0, false
+	}
+	return speed * factor, true
+}
+
+// largestComponent returns the largest strongly connected part of a graph,
+// found with Kosaraju's algorithm.
+func largestComponent(g *Graph) *Graph {
+	n := g.Nodes()
+
+	// Order nodes by when a depth-first search over outgoing edges finishes them
+	order := make([]int32, 0, n)
+	visited := make([]bool, n)
+	type frame struct{ node, next int32 }
+	var stack []frame
+	for start := int32(0); start < int32(n); start++ {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+		stack = append(stack, frame{start, g.first[start]})
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next < g.first[top.node+1] {
+				to := g.to[top.next]
+				top.next++
+				if !visited[to] {
+					visited[to] = true
+					stack = append(stack, frame{to, g.first[to]})
+				}
+				continue
+			}
+			order = append(order, top.node)
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	// Search incoming edges in reverse finishing order; each search finds one component
+	component := make([]int32, n)
+	for i := range component {
+		component[i] = -1
+	}
+	var sizes []int
+	var pending []int32
+	for i := n - 1; i >= 0; i-- {
+		start := order[i]
+		if component[start] >= 0 {
+			continue
+		}
+		id := int32(len(sizes))
+		sizes = append(sizes, 0)
+		component[start] = id
+		pending = append(pending[:0], start)
+		for len(pending) > 0 {
+			node := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			sizes[id]++
+			for j := g.revFirst[node]; j < g.revFirst[node+1]; j++ {
+				if from := g.revFrom[j]; component[from] < 0 {
+					component[from] = id
+					pending = append(pending, from)
+				}
+			}
+		}
+	}
+
+	largest := int32(0)
+	for id, size := range sizes {
+		if size > sizes[largest] {
+			largest = int32(id)
+		}
+	}
+
+	b := &builder{}
+	renumber := make([]int32, n)
+	for i := 0; i < n; i++ {
+		if component[i] == largest {
+			renumber[i] = b.addNode(models.Coordinates{Latitude: g.lat[i], Longitude: g.lon[i]})
+		}
+	}
+	for from := 0; from < n; from++ {
+		if component[from] != largest {
+			continue
+		}
+		for i := g.first[from]; i < g.first[from+1]; i++ {
+			if to := g.to[i]; component[to] == largest {
+				b.edges = append(b.edges, edge{from: renumber[from], to: renumber[to], length: g.length[i], duration: g.duration[i]})
+			}
+		}
+	}
+	return b.build()
+}
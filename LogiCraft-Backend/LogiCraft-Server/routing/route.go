@@ -0,0 +1,329 @@
+package routing
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"logi-craft/config"
+	"logi-craft/models"
+	"logi-craft/utils"
+)
+
+// accessSpeed is the speed in km/h assumed between a point and the road node
+// it was snapped to, such as along a yard or driveway.
+const accessSpeed = 15
+
+// Trip is the driving distance and time between two points.
+type Trip struct {
+	Distance float64       // km
+	Duration time.Duration // at free-flow speeds; zero unless OnRoad
+	OnRoad   bool          // false when no route was found and Distance is the straight line
+}
+
+// Default is the road network used by the server, set up by Setup. It is nil
+// when no network is configured, and every trip is then a straight line.
+var Default *Graph
+
+// Setup loads the road network named in the config.
+func Setup(cfg config.RoutingConfig) error {
+	Default = nil
+	if cfg.GraphFile == "" {
+		return nil
+	}
+	started := time.Now()
+	g, err := Open(cfg.GraphFile)
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded road network %s: %d nodes, %d edges in %s", cfg.GraphFile, g.Nodes(), g.Edges(), time.Since(started).Round(time.Millisecond))
+	Default = g
+	return nil
+}
+
+// Open loads a graph from an OpenStreetMap extract if the file name ends in
+// .osm, and otherwise from a file written by Save.
+func Open(path string) (*Graph, error) {
+	if !strings.HasSuffix(path, ".osm") {
+		return Load(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g, err := ParseOSM(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g, nil
+}
+
+// Between returns the trip from one point to another over the default network.
+func Between(from, to models.Coordinates) Trip {
+	return Default.Between(from, to)
+}
+
+// ToPoint returns the trips from each of several points to one point over
+// the default network.
+func ToPoint(from []models.Coordinates, to models.Coordinates) []Trip {
+	return Default.ToPoint(from, to)
+}
+
+func straight(from, to models.Coordinates) Trip {
+	return Trip{Distance: utils.HaversineDistance(from.Latitude, from.Longitude, to.Latitude, to.Longitude)}
+}
+
+// access is the trip between a point and the node it was snapped to.
+func access(km float64) (float64, float64) {
+	return km, km / accessSpeed * 3600
+}
+
+// trip completes a route between snapped nodes with the access legs at either
+// end. A road is never shorter than the straight line, which can otherwise
+// happen when both points snap to the same node.
+func trip(from, to models.Coordinates, km, seconds, fromKm, toKm float64) Trip {
+	aKm, aSeconds := access(fromKm)
+	bKm, bSeconds := access(toKm)
+	t := Trip{
+		Distance: km + aKm + bKm,
+		Duration: time.Duration((seconds + aSeconds + bSeconds) * float64(time.Second)),
+		OnRoad:   true,
+	}
+	t.Distance = math.Max(t.Distance, straight(from, to).Distance)
+	return t
+}
+
+// Between returns the fastest trip by road from one point to another, found
+// with A* search. It falls back to the straight line when either point is
+// further than the snap radius from a road or no route joins them.
+func (g *Graph) Between(from, to models.Coordinates) Trip {
+	if g == nil {
+		return straight(from, to)
+	}
+	snapKm := config.App.Routing.SnapRadiusKm
+	source, sourceKm, ok := g.Snap(from, snapKm)
+	if !ok {
+		return straight(from, to)
+	}
+	target, targetKm, ok := g.Snap(to, snapKm)
+	if !ok {
+		return straight(from, to)
+	}
+	km, seconds, ok := g.astar(source, target)
+	if !ok {
+		return straight(from, to)
+	}
+	return trip(from, to, km, seconds, sourceKm, targetKm)
+}
+
+// ToPoint returns the fastest trip by road from each of several points to
+// one point, such as from candidate vehicles to a pickup. A single search
+// backwards from the destination serves every origin. Origins that cannot be
+// routed get the straight line.
+func (g *Graph) ToPoint(from []models.Coordinates, to models.Coordinates) []Trip {
+	trips := make([]Trip, len(from))
+	for i, p := range from {
+		trips[i] = straight(p, to)
+	}
+	if g == nil {
+		return trips
+	}
+	snapKm := config.App.Routing.SnapRadiusKm
+	target, targetKm, ok := g.Snap(to, snapKm)
+	if !ok {
+		return trips
+	}
+
+	sources := map[int32][]int{}
+	sourceKm := make([]float64, len(from))
+	for i, p := range from {
+		if n, km, ok := g.Snap(p, snapKm); ok {
+			sources[n] = append(sources[n], i)
+			sourceKm[i] = km
+		}
+	}
+	if len(sources) == 0 {
+		return trips
+	}
+
+	g.backwards(target, sources, func(node int32, km, seconds float64) {
+		for _, i := range sources[node] {
+			trips[i] = trip(from[i], to, km, seconds, sourceKm[i], targetKm)
+		}
+	})
+	return trips
+}
+
+// search holds the state of one search, kept between searches so large
+// graphs do not allocate on every query. A node's entries are only valid
+// when its stamp matches the current generation.
+type search struct {
+	seconds []float64
+	km      []float64
+	stamp   []uint32
+	closed  []uint32
+	gen     uint32
+	queue   queue
+}
+
+func (g *Graph) acquire() *search {
+	s, _ := g.searches.Get().(*search)
+	if s == nil {
+		n := g.Nodes()
+		s = &search{seconds: make([]float64, n), km: make([]float64, n), stamp: make([]uint32, n), closed: make([]uint32, n)}
+	}
+	s.gen++
+	if s.gen == 0 {
+		// The stamps have wrapped around; start again from clean arrays
+		clear(s.stamp)
+		clear(s.closed)
+		s.gen = 1
+	}
+	s.queue = s.queue[:0]
+	return s
+}
+
+func (g *Graph) release(s *search) {
+	g.searches.Put(s)
+}
+
+// reach records a better way to a node and queues it with the given priority.
+func (s *search) reach(node int32, km, seconds, priority float64) {
+	if s.stamp[node] == s.gen && s.seconds[node] <= seconds {
+		return
+	}
+	s.stamp[node] = s.gen
+	s.seconds[node] = seconds
+	s.km[node] = km
+	s.queue.push(item{node: node, priority: priority})
+}
+
+// settle takes the next node off the queue, skipping nodes already settled.
+func (s *search) settle() (int32, bool) {
+	for s.queue.Len() > 0 {
+		node := s.queue.pop().node
+		if s.closed[node] != s.gen {
+			s.closed[node] = s.gen
+			return node, true
+		}
+	}
+	return 0, false
+}
+
+// astar finds the fastest route between two nodes. The straight line at the
+// fastest speed in the network never overestimates the time left, so the
+// first time the target is settled its route is the fastest.
+func (g *Graph) astar(source, target int32) (float64, float64, bool) {
+	s := g.acquire()
+	defer g.release(s)
+
+	remaining := func(n int32) float64 {
+		if g.maxSpeed <= 0 {
+			return 0
+		}
+		return utils.HaversineDistance(g.lat[n], g.lon[n], g.lat[target], g.lon[target]) / g.maxSpeed * 3600
+	}
+
+	s.reach(source, 0, 0, remaining(source))
+	for {
+		node, ok := s.settle()
+		if !ok {
+			return 0, 0, false
+		}
+		if node == target {
+			return s.km[node], s.seconds[node], true
+		}
+		for i := g.first[node]; i < g.first[node+1]; i++ {
+			to := g.to[i]
+			if s.closed[to] == s.gen {
+				continue
+			}
+			seconds := s.seconds[node] + float64(g.duration[i])
+			s.reach(to, s.km[node]+float64(g.length[i]), seconds, seconds+remaining(to))
+		}
+	}
+}
+
+// backwards runs Dijkstra's algorithm over incoming edges from target and
+// calls found for each of the sources with its fastest route to the target,
+// stopping once every source has been found.
+func (g *Graph) backwards(target int32, sources map[int32][]int, found func(node int32, km, seconds float64)) {
+	s := g.acquire()
+	defer g.release(s)
+
+	left := len(sources)
+	s.reach(target, 0, 0, 0)
+	for left > 0 {
+		node, ok := s.settle()
+		if !ok {
+			return
+		}
+		if _, ok := sources[node]; ok {
+			found(node, s.km[node], s.seconds[node])
+			left--
+		}
+		for i := g.revFirst[node]; i < g.revFirst[node+1]; i++ {
+			from := g.revFrom[i]
+			if s.closed[from] == s.gen {
+				continue
+			}
+			seconds := s.seconds[node] + float64(g.revDuration[i])
+			s.reach(from, s.km[node]+float64(g.revLength[i]), seconds, seconds)
+		}
+	}
+}
+
+// item is a node waiting in a search queue.
+type item struct {
+	node     int32
+	priority float64
+}
+
+// queue is a binary min-heap of nodes by priority. It is written out rather
+// than built on container/heap, which allocates for every item pushed.
+type queue []item
+
+func (q queue) Len() int { return len(q) }
+
+func (q *queue) push(it item) {
+	*q = append(*q, it)
+	h := *q
+	i := len(h) - 1
+	for i > 0 {
+		parent := (i - 1) / 2
+		if h[parent].priority <= h[i].priority {
+			break
+		}
+		h[parent], h[i] = h[i], h[parent]
+		i = parent
+	}
+}
+
+func (q *queue) pop() item {
+	h := *q
+	top := h[0]
+	last := len(h) - 1
+	h[0] = h[last]
+	h = h[:last]
+	i := 0
+	for {
+		smallest, left, right := i, 2*i+1, 2*i+2
+		if left < len(h) && h[left].priority < h[smallest].priority {
+			smallest = left
+		}
+		if right < len(h) && h[right].priority < h[smallest].priority {
+			smallest = right
+		}
+		if smallest == i {
+			break
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+	*q = h
+	return top
+}
@@ -0,0 +1,274 @@
+package routing
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"logi-craft/models"
+)
+
+// origin is the south-west corner of the test networks
+var origin = models.Coordinates{Latitude: 28.6, Longitude: 77.2}
+
+// at returns the point row and col steps of about 1.1 km north and east of origin
+func at(row, col int) models.Coordinates {
+	return models.Coordinates{Latitude: origin.Latitude + float64(row)*0.01, Longitude: origin.Longitude + float64(col)*0.01}
+}
+
+// grid builds a size by size grid of two-way roads with a random speed on
+// each road between 10 and 90 km/h
+func grid(rng *rand.Rand, size int) *Graph {
+	b := &builder{}
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			b.addNode(at(row, col))
+		}
+	}
+	road := func(from, to int32) {
+		speed := 10 + rng.Float64()*80
+		b.addEdge(from, to, speed)
+		b.addEdge(to, from, speed)
+	}
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			n := int32(row*size + col)
+			if col+1 < size {
+				road(n, n+1)
+			}
+			if row+1 < size {
+				road(n, n+int32(size))
+			}
+		}
+	}
+	return b.build()
+}
+
+// fastest finds the fastest time in seconds between every pair of nodes with
+// Floyd-Warshall, to check the searches against
+func fastest(g *Graph) [][]float64 {
+	n := g.Nodes()
+	seconds := make([][]float64, n)
+	for i := range seconds {
+		seconds[i] = make([]float64, n)
+		for j := range seconds[i] {
+			if i != j {
+				seconds[i][j] = math.Inf(1)
+			}
+		}
+		for e := g.first[i]; e < g.first[i+1]; e++ {
+			seconds[i][g.to[e]] = math.Min(seconds[i][g.to[e]], float64(g.duration[e]))
+		}
+	}
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if d := seconds[i][k] + seconds[k][j]; d < seconds[i][j] {
+					seconds[i][j] = d
+				}
+			}
+		}
+	}
+	return seconds
+}
+
+func TestAstarFindsFastestRoute(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	g := grid(rng, 6)
+	want := fastest(g)
+
+	for source := int32(0); source < int32(g.Nodes()); source++ {
+		for target := int32(0); target < int32(g.Nodes()); target++ {
+			_, seconds, ok := g.astar(source, target)
+			if !ok {
+				t.Fatalf("astar(%d, %d) found no route", source, target)
+			}
+			if math.Abs(seconds-want[source][target]) > 1e-6*want[source][target]+1e-9 {
+				t.Errorf("astar(%d, %d) = %f s, want %f s", source, target, seconds, want[source][target])
+			}
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	// Three nodes along a one-way street, and one node with no roads at all
+	b := &builder{}
+	b.addNode(at(0, 0))
+	b.addNode(at(0, 1))
+	b.addNode(at(0, 2))
+	b.addNode(at(5, 5))
+	b.addEdge(0, 1, 36)
+	b.addEdge(1, 2, 36)
+	g := b.build()
+	legKm := float64(g.length[0])
+
+	tests := []struct {
+		name     string
+		from, to models.Coordinates
+		onRoad   bool
+		km       float64 // ignored when the trip is a straight line
+		duration time.Duration
+	}{
+		{"along the street", at(0, 0), at(0, 2), true, 2 * legKm, time.Duration(2 * legKm / 36 * float64(time.Hour))},
+		{"one stop", at(0, 1), at(0, 2), true, legKm, time.Duration(legKm / 36 * float64(time.Hour))},
+		{"against a one-way street", at(0, 2), at(0, 0), false, 0, 0},
+		{"far from any road", at(0, 0), at(3, 3), false, 0, 0},
+		{"to a node with no roads", at(0, 0), at(5, 5), false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.Between(tt.from, tt.to)
+			straightKm := straight(tt.from, tt.to).Distance
+			if got.OnRoad != tt.onRoad {
+				t.Fatalf("OnRoad = %v, want %v", got.OnRoad, tt.onRoad)
+			}
+			if !tt.onRoad {
+				if got.Distance != straightKm || got.Duration != 0 {
+					t.Errorf("got %+v, want the straight line of %f km", got, straightKm)
+				}
+				return
+			}
+			if math.Abs(got.Distance-tt.km) > 1e-6 {
+				t.Errorf("Distance = %f km, want %f km", got.Distance, tt.km)
+			}
+			if d := got.Duration - tt.duration; d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("Duration = %s, want %s", got.Duration, tt.duration)
+			}
+			if got.Distance < straightKm {
+				t.Errorf("Distance %f km is shorter than the straight line of %f km", got.Distance, straightKm)
+			}
+		})
+	}
+
+	var none *Graph
+	if got := none.Between(at(0, 0), at(0, 2)); got.OnRoad {
+		t.Errorf("Between without a network = %+v, want the straight line", got)
+	}
+}
+
+func TestToPointMatchesBetween(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	g := grid(rng, 8)
+
+	// Points a little off the nodes, so the access legs are counted too
+	var from []models.Coordinates
+	for i := 0; i < 20; i++ {
+		p := at(rng.Intn(8), rng.Intn(8))
+		p.Latitude += (rng.Float64()*2 - 1) * 0.002
+		p.Longitude += (rng.Float64()*2 - 1) * 0.002
+		from = append(from, p)
+	}
+	from = append(from, at(20, 20)) // too far from any road
+	to := at(3, 4)
+
+	trips := g.ToPoint(from, to)
+	if len(trips) != len(from) {
+		t.Fatalf("ToPoint returned %d trips for %d points", len(trips), len(from))
+	}
+	for i, p := range from {
+		want := g.Between(p, to)
+		got := trips[i]
+		if got.OnRoad != want.OnRoad || math.Abs(got.Distance-want.Distance) > 1e-6 {
+			t.Errorf("point %d: ToPoint = %+v, Between = %+v", i, got, want)
+		}
+		if d := got.Duration - want.Duration; d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("point %d: ToPoint took %s, Between %s", i, got.Duration, want.Duration)
+		}
+	}
+}
+
+func TestLargestComponent(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes int
+		edges [][2]int32
+		want  []int32 // nodes of the input kept, in order
+		edgeN int
+	}{
+		{
+			name:  "one-way bridge between two loops",
+			nodes: 6,
+			// Loop 0-1-2-3, loop 4-5, and a one-way road from 3 to 4
+			edges: [][2]int32{{0, 1}, {1, 2}, {2, 3}, {3, 0}, {4, 5}, {5, 4}, {3, 4}},
+			want:  []int32{0, 1, 2, 3},
+			edgeN: 4,
+		},
+		{
+			name:  "dead end dropped",
+			nodes: 4,
+			// Two-way road 0-1-2 with a one-way spur into 3
+			edges: [][2]int32{{0, 1}, {1, 0}, {1, 2}, {2, 1}, {2, 3}},
+			want:  []int32{0, 1, 2},
+			edgeN: 4,
+		},
+		{
+			name:  "already connected",
+			nodes: 3,
+			edges: [][2]int32{{0, 1}, {1, 2}, {2, 0}},
+			want:  []int32{0, 1, 2},
+			edgeN: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &builder{}
+			for i := 0; i < tt.nodes; i++ {
+				b.addNode(at(0, i))
+			}
+			for _, e := range tt.edges {
+				b.addEdge(e[0], e[1], 30)
+			}
+			got := largestComponent(b.build())
+			if got.Nodes() != len(tt.want) || got.Edges() != tt.edgeN {
+				t.Fatalf("got %d nodes and %d edges, want %d and %d", got.Nodes(), got.Edges(), len(tt.want), tt.edgeN)
+			}
+			for i, n := range tt.want {
+				if p := at(0, int(n)); got.lat[i] != p.Latitude || got.lon[i] != p.Longitude {
+					t.Errorf("node %d is at %f,%f, want input node %d", i, got.lat[i], got.lon[i], n)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOSM(t *testing.T) {
+	extract := `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="1" lat="28.600" lon="77.200"/>
+  <node id="2" lat="28.600" lon="77.210"/>
+  <node id="3" lat="28.610" lon="77.210"/>
+  <node id="4" lat="28.620" lon="77.210"/>
+  <way id="10">
+    <nd ref="1"/><nd ref="2"/><nd ref="3"/>
+    <tag k="highway" v="residential"/>
+  </way>
+  <way id="11">
+    <nd ref="3"/><nd ref="4"/>
+    <tag k="highway" v="residential"/>
+    <tag k="oneway" v="yes"/>
+  </way>
+  <way id="12">
+    <nd ref="1"/><nd ref="3"/>
+    <tag k="highway" v="footway"/>
+  </way>
+  <way id="13">
+    <nd ref="2"/><nd ref="99"/>
+    <tag k="highway" v="primary"/>
+  </way>
+</osm>`
+	g, err := ParseOSM(strings.NewReader(extract))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The footway is not drivable, node 99 is outside the extract and the
+	// one-way road leads to a dead end, so only the two-way street is kept
+	if g.Nodes() != 3 || g.Edges() != 4 {
+		t.Errorf("got %d nodes and %d edges, want 3 and 4", g.Nodes(), g.Edges())
+	}
+
+	if _, err := ParseOSM(strings.NewReader(`<osm><node id="1" lat="0" lon="0"/></osm>`)); err == nil {
+		t.Error("an extract without roads was accepted")
+	}
+}
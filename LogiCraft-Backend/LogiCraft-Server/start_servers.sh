@@ -1,5 +1,11 @@
 #!/bin/bash
 
+# The instances must share a config file that sets tracking.link_secret
+if [ -z "$LOGICRAFT_CONFIG" ]; then
+    echo "Set LOGICRAFT_CONFIG to the config file" >&2
+    exit 1
+fi
+
 go build -o main main.go
 
 ./main 4001 &
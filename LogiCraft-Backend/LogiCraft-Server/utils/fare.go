@@ -0,0 +1,20 @@
+package utils
+
+import "math"
+
+// VehicleRates holds the per-km rate for each vehicle type.
+var VehicleRates = map[string]float64{
+	"small":  5,
+	"medium": 10,
+	"large":  15,
+}
+
+// CalculateFare returns the fare for a trip of the given distance (in km).
+func CalculateFare(distance float64, vehicleType string) float64 {
+	return RoundMoney(distance * VehicleRates[vehicleType])
+}
+
+// RoundMoney rounds an amount to two decimal places.
+func RoundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,36 @@
+package utils
+
+import "strings"
+
+// NormalizePhone strips spaces, dashes, dots and brackets from a phone number.
+// It returns "" if what is left is not an optional + followed by 7 to 15 digits.
+func NormalizePhone(phone string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return ""
+		}
+	}
+	digits := len(strings.TrimPrefix(b.String(), "+"))
+	if digits < 7 || digits > 15 {
+		return ""
+	}
+	return b.String()
+}
+
+// MaskPhone hides all but the last four digits of a phone number.
+func MaskPhone(phone string) string {
+	runes := []rune(phone)
+	for i := 0; i < len(runes)-4; i++ {
+		if runes[i] >= '0' && runes[i] <= '9' {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
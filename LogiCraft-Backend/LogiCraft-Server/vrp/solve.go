@@ -0,0 +1,389 @@
+// Package vrp plans delivery routes for a fleet: which vehicle serves each
+// delivery and in what order, within the vehicles' capacities and shifts and
+// the deliveries' time windows, keeping the total distance driven low.
+package vrp
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"logi-craft/matching"
+)
+
+// Load is an amount of cargo.
+type Load struct {
+	Volume float64 // cubic metres
+	Weight float64 // kg
+}
+
+func (l Load) add(o Load) Load {
+	return Load{Volume: l.Volume + o.Volume, Weight: l.Weight + o.Weight}
+}
+
+func (l Load) fits(capacity Load) bool {
+	const epsilon = 1e-9
+	return l.Volume <= capacity.Volume+epsilon && l.Weight <= capacity.Weight+epsilon
+}
+
+// Stop is a delivery to make. Times are in minutes from the start of the plan.
+type Stop struct {
+	Demand   Load
+	Earliest float64 // a vehicle arriving sooner waits
+	Latest   float64 // the latest a vehicle may arrive; +Inf for no limit
+	Service  float64 // minutes spent unloading
+}
+
+// Vehicle is a vehicle available to the plan. Times are in minutes from the
+// start of the plan.
+type Vehicle struct {
+	Capacity Load
+	Start    float64 // when it leaves the depot
+	End      float64 // when its shift ends, back at the depot if routes return there
+}
+
+// Problem is a set of stops to share among vehicles starting from a depot.
+// Location 0 is the depot and location i+1 is Stops[i].
+type Problem struct {
+	Distance      [][]float64 // km from one location to another
+	Time          [][]float64 // minutes from one location to another
+	Stops         []Stop
+	Vehicles      []Vehicle
+	ReturnToDepot bool // routes end back at the depot rather than at their last stop
+}
+
+// Route is the stops one vehicle makes, in order.
+type Route struct {
+	Vehicle  int
+	Stops    []int
+	Arrivals []float64 // minutes from the start of the plan at which each stop is served, after any wait for its window
+	Distance float64   // km
+	Load     Load
+}
+
+// Solution is a plan for a problem.
+type Solution struct {
+	Routes     []Route // only vehicles with stops
+	Unassigned []int   // stops no vehicle could make
+	Distance   float64 // km over all routes
+}
+
+// Solve plans routes with the Clarke-Wright savings algorithm and improves
+// them by local search until no move shortens them or the deadline passes.
+// The same problem always gives the same plan if the deadline is not reached.
+func Solve(p Problem, deadline time.Time) Solution {
+	s := &solver{p: p, routes: make([][]int, len(p.Vehicles)), deadline: deadline}
+	s.savings()
+	s.insertUnassigned()
+	s.improve()
+	return s.solution()
+}
+
+type solver struct {
+	p          Problem
+	routes     [][]int // stops of each vehicle in order
+	unassigned []int
+	deadline   time.Time
+}
+
+// cost returns the distance a vehicle drives to make the given stops in order,
+// or false if it cannot make them within its capacity, shift and the stops'
+// time windows.
+func (s *solver) cost(v Vehicle, route []int) (float64, bool) {
+	if len(route) == 0 {
+		return 0, true
+	}
+	var load Load
+	t, km, at := v.Start, 0.0, 0
+	for _, i := range route {
+		stop := s.p.Stops[i]
+		if load = load.add(stop.Demand); !load.fits(v.Capacity) {
+			return 0, false
+		}
+		t += s.p.Time[at][i+1]
+		km += s.p.Distance[at][i+1]
+		t = math.Max(t, stop.Earliest)
+		if t > stop.Latest {
+			return 0, false
+		}
+		t += stop.Service
+		at = i + 1
+	}
+	if s.p.ReturnToDepot {
+		t += s.p.Time[at][0]
+		km += s.p.Distance[at][0]
+	}
+	return km, t <= v.End
+}
+
+// savings builds routes by starting with one route per stop and repeatedly
+// joining the two routes whose join saves the most distance, as long as some
+// vehicle could still drive the joined route. The routes are then given to
+// vehicles that can drive them; stops on routes left without a vehicle are
+// left unassigned for insertion.
+func (s *solver) savings() {
+	// A vehicle with the most room and the longest shift of any decides which joins are allowed
+	var widest Vehicle
+	widest.Start = math.Inf(1)
+	for _, v := range s.p.Vehicles {
+		widest.Capacity.Volume = math.Max(widest.Capacity.Volume, v.Capacity.Volume)
+		widest.Capacity.Weight = math.Max(widest.Capacity.Weight, v.Capacity.Weight)
+		widest.Start = math.Min(widest.Start, v.Start)
+		widest.End = math.Max(widest.End, v.End)
+	}
+
+	routeOf := make([]int, len(s.p.Stops))
+	var routes [][]int
+	for i := range s.p.Stops {
+		if _, ok := s.cost(widest, []int{i}); !ok || len(s.p.Vehicles) == 0 {
+			routeOf[i] = -1
+			s.unassigned = append(s.unassigned, i)
+			continue
+		}
+		routeOf[i] = len(routes)
+		routes = append(routes, []int{i})
+	}
+
+	type saving struct {
+		from, to int
+		value    float64
+	}
+	var candidates []saving
+	d := s.p.Distance
+	for i := range s.p.Stops {
+		for j := range s.p.Stops {
+			if i == j || routeOf[i] < 0 || routeOf[j] < 0 {
+				continue
+			}
+			// Driving from i to j instead of back to the depot and out again
+			value := d[0][j+1] - d[i+1][j+1]
+			if s.p.ReturnToDepot {
+				value += d[i+1][0]
+			}
+			if value > 0 {
+				candidates = append(candidates, saving{from: i, to: j, value: value})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].value > candidates[b].value })
+
+	for _, c := range candidates {
+		a, b := routeOf[c.from], routeOf[c.to]
+		if a == b || routes[a][len(routes[a])-1] != c.from || routes[b][0] != c.to {
+			continue
+		}
+		joined := append(append([]int(nil), routes[a]...), routes[b]...)
+		if _, ok := s.cost(widest, joined); !ok {
+			continue
+		}
+		routes[a] = joined
+		for _, i := range routes[b] {
+			routeOf[i] = a
+		}
+		routes[b] = nil
+	}
+
+	var built [][]int
+	for _, r := range routes {
+		if len(r) > 0 {
+			built = append(built, r)
+		}
+	}
+	if len(built) == 0 {
+		return
+	}
+
+	// Give each route a vehicle that can drive it, preferring smaller vehicles
+	// so the larger ones stay free for stops that need them
+	var most float64
+	for _, v := range s.p.Vehicles {
+		most = math.Max(most, v.Capacity.Volume+v.Capacity.Weight/1000)
+	}
+	cost := make([][]float64, len(built))
+	for r, route := range built {
+		cost[r] = make([]float64, len(s.p.Vehicles))
+		for v, vehicle := range s.p.Vehicles {
+			cost[r][v] = math.Inf(1)
+			if _, ok := s.cost(vehicle, route); ok {
+				cost[r][v] = (vehicle.Capacity.Volume + vehicle.Capacity.Weight/1000) / most
+			}
+		}
+	}
+	for r, v := range matching.Assign(cost) {
+		if v >= 0 {
+			s.routes[v] = built[r]
+		} else {
+			s.unassigned = append(s.unassigned, built[r]...)
+		}
+	}
+}
+
+// insertUnassigned puts each unassigned stop where it adds the least
+// distance, if any vehicle can make it. Stops with the earliest deadlines go first.
+func (s *solver) insertUnassigned() {
+	sort.SliceStable(s.unassigned, func(a, b int) bool {
+		return s.p.Stops[s.unassigned[a]].Latest < s.p.Stops[s.unassigned[b]].Latest
+	})
+
+	var left []int
+	for _, i := range s.unassigned {
+		bestV, bestPos, bestAdded := -1, 0, math.Inf(1)
+		for v, route := range s.routes {
+			before, _ := s.cost(s.p.Vehicles[v], route)
+			for pos := 0; pos <= len(route); pos++ {
+				after, ok := s.cost(s.p.Vehicles[v], insert(route, pos, i))
+				if ok && after-before < bestAdded {
+					bestV, bestPos, bestAdded = v, pos, after-before
+				}
+			}
+		}
+		if bestV < 0 {
+			left = append(left, i)
+			continue
+		}
+		s.routes[bestV] = insert(s.routes[bestV], bestPos, i)
+	}
+	s.unassigned = left
+}
+
+// improve applies moves that shorten the plan until none is left or the
+// deadline passes: reversing part of a route, moving a stop to another place,
+// and swapping stops between routes.
+func (s *solver) improve() {
+	for improved := true; improved && time.Now().Before(s.deadline); {
+		improved = s.twoOpt() || s.relocate() || s.swap()
+		if improved && len(s.unassigned) > 0 {
+			// A shorter plan may have made room for a stop that fitted nowhere before
+			s.insertUnassigned()
+		}
+	}
+}
+
+// gain is how much shorter the plan gets by changing a vehicle's route, or
+// false if the vehicle cannot drive the new route.
+func (s *solver) gain(v int, route []int) (float64, bool) {
+	before, _ := s.cost(s.p.Vehicles[v], s.routes[v])
+	after, ok := s.cost(s.p.Vehicles[v], route)
+	return before - after, ok
+}
+
+// improvement is the least a move must gain to be applied, so rounding
+// cannot make moves undo each other forever.
+const improvement = 1e-9
+
+func (s *solver) twoOpt() bool {
+	for v, route := range s.routes {
+		for i := 0; i < len(route)-1; i++ {
+			for j := i + 1; j < len(route); j++ {
+				changed := append([]int(nil), route...)
+				for a, b := i, j; a < b; a, b = a+1, b-1 {
+					changed[a], changed[b] = changed[b], changed[a]
+				}
+				if g, ok := s.gain(v, changed); ok && g > improvement {
+					s.routes[v] = changed
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (s *solver) relocate() bool {
+	for from, route := range s.routes {
+		for i := range route {
+			stop := route[i]
+			removed := remove(route, i)
+			for to := range s.routes {
+				target := s.routes[to]
+				if to == from {
+					target = removed
+				}
+				for pos := 0; pos <= len(target); pos++ {
+					if to == from && pos == i {
+						continue
+					}
+					moved := insert(target, pos, stop)
+					var g float64
+					ok := true
+					if to == from {
+						g, ok = s.gain(from, moved)
+					} else {
+						g1, ok1 := s.gain(from, removed)
+						g2, ok2 := s.gain(to, moved)
+						g, ok = g1+g2, ok1 && ok2
+					}
+					if ok && g > improvement {
+						s.routes[from] = removed
+						if to != from {
+							s.routes[to] = moved
+						} else {
+							s.routes[from] = moved
+						}
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (s *solver) swap() bool {
+	for a := range s.routes {
+		for b := a + 1; b < len(s.routes); b++ {
+			for i := range s.routes[a] {
+				for j := range s.routes[b] {
+					routeA := append([]int(nil), s.routes[a]...)
+					routeB := append([]int(nil), s.routes[b]...)
+					routeA[i], routeB[j] = routeB[j], routeA[i]
+					gA, okA := s.gain(a, routeA)
+					gB, okB := s.gain(b, routeB)
+					if okA && okB && gA+gB > improvement {
+						s.routes[a], s.routes[b] = routeA, routeB
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (s *solver) solution() Solution {
+	sol := Solution{Unassigned: append([]int{}, s.unassigned...)}
+	sort.Ints(sol.Unassigned)
+	for v, route := range s.routes {
+		if len(route) == 0 {
+			continue
+		}
+		r := Route{Vehicle: v, Stops: route}
+		vehicle := s.p.Vehicles[v]
+		t, at := vehicle.Start, 0
+		for _, i := range route {
+			stop := s.p.Stops[i]
+			t = math.Max(t+s.p.Time[at][i+1], stop.Earliest)
+			r.Arrivals = append(r.Arrivals, t)
+			r.Load = r.Load.add(stop.Demand)
+			t += stop.Service
+			at = i + 1
+		}
+		r.Distance, _ = s.cost(vehicle, route)
+		sol.Distance += r.Distance
+		sol.Routes = append(sol.Routes, r)
+	}
+	return sol
+}
+
+func insert(route []int, pos, stop int) []int {
+	out := make([]int, 0, len(route)+1)
+	out = append(out, route[:pos]...)
+	out = append(out, stop)
+	return append(out, route[pos:]...)
+}
+
+func remove(route []int, pos int) []int {
+	out := make([]int, 0, len(route)-1)
+	out = append(out, route[:pos]...)
+	return append(out, route[pos+1:]...)
+}
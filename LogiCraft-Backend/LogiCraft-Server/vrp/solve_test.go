@@ -0,0 +1,255 @@
+package vrp
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// line builds a problem with the depot at 0 and stops at the given km along a
+// straight road, driven at 60 km/h so every km takes a minute
+func line(positions []float64, stops []Stop, vehicles []Vehicle, returnToDepot bool) Problem {
+	at := append([]float64{0}, positions...)
+	p := Problem{Stops: stops, Vehicles: vehicles, ReturnToDepot: returnToDepot}
+	p.Distance = make([][]float64, len(at))
+	for i := range at {
+		p.Distance[i] = make([]float64, len(at))
+		for j := range at {
+			p.Distance[i][j] = math.Abs(at[i] - at[j])
+		}
+	}
+	p.Time = p.Distance
+	return p
+}
+
+// open is a stop with no time window
+func open(volume float64) Stop {
+	return Stop{Demand: Load{Volume: volume}, Latest: math.Inf(1)}
+}
+
+func van(volume, start, end float64) Vehicle {
+	return Vehicle{Capacity: Load{Volume: volume, Weight: 1000}, Start: start, End: end}
+}
+
+func TestCost(t *testing.T) {
+	stops := []Stop{
+		open(1),
+		{Demand: Load{Volume: 1}, Earliest: 30, Latest: 40, Service: 5},
+		{Demand: Load{Volume: 1, Weight: 2000}, Latest: math.Inf(1)},
+		open(3),
+	}
+	p := line([]float64{10, 20, 5, 15}, stops, nil, false)
+
+	tests := []struct {
+		name          string
+		vehicle       Vehicle
+		route         []int
+		returnToDepot bool
+		km            float64
+		ok            bool
+	}{
+		{"empty route", van(1, 0, 0), nil, false, 0, true},
+		{"one stop", van(1, 0, 100), []int{0}, false, 10, true},
+		{"back to depot", van(1, 0, 100), []int{0}, true, 20, true},
+		{"waits for the window", van(2, 0, 100), []int{0, 1}, false, 20, true},
+		{"misses the window", van(2, 25, 100), []int{0, 1}, false, 0, false},
+		{"order matters for the window", van(2, 0, 100), []int{1, 0}, false, 30, true},
+		{"too much volume", van(3, 0, 100), []int{0, 3}, false, 0, false},
+		{"volume just fits", van(4, 0, 100), []int{0, 3}, false, 15, true},
+		{"too heavy", van(10, 0, 100), []int{2}, false, 0, false},
+		{"shift ends on the way back", van(2, 0, 30), []int{0}, true, 20, true},
+		{"shift too short to return", van(2, 0, 19), []int{0}, true, 20, false},
+		{"shift ends after service", van(2, 0, 34), []int{1}, false, 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.ReturnToDepot = tt.returnToDepot
+			s := &solver{p: p}
+			km, ok := s.cost(tt.vehicle, tt.route)
+			if ok != tt.ok || (ok && math.Abs(km-tt.km) > 1e-9) {
+				t.Errorf("cost(%v) = %f, %v, want %f, %v", tt.route, km, ok, tt.km, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSavingsJoinsStopsOnTheWay(t *testing.T) {
+	tests := []struct {
+		name       string
+		positions  []float64
+		stops      []Stop
+		vehicles   []Vehicle
+		routes     [][]int
+		unassigned []int
+	}{
+		{
+			name:      "one vehicle takes every stop in order",
+			positions: []float64{10, 20, 30},
+			stops:     []Stop{open(1), open(1), open(1)},
+			vehicles:  []Vehicle{van(3, 0, 100)},
+			routes:    [][]int{{0, 1, 2}},
+		},
+		{
+			name:      "capacity splits the stops",
+			positions: []float64{10, 20, 30},
+			stops:     []Stop{open(1), open(1), open(1)},
+			vehicles:  []Vehicle{van(2, 0, 100), van(1, 0, 100)},
+			routes:    [][]int{{1, 2}, {0}},
+		},
+		{
+			name:       "a stop no vehicle can hold",
+			positions:  []float64{10, 20},
+			stops:      []Stop{open(1), open(5)},
+			vehicles:   []Vehicle{van(2, 0, 100)},
+			routes:     [][]int{{0}},
+			unassigned: []int{1},
+		},
+		{
+			name:       "more routes than vehicles",
+			positions:  []float64{10, -10},
+			stops:      []Stop{open(1), open(1)},
+			vehicles:   []Vehicle{van(1, 0, 100)},
+			routes:     [][]int{{0}},
+			unassigned: []int{1},
+		},
+		{
+			name:       "no vehicles",
+			positions:  []float64{10},
+			stops:      []Stop{open(1)},
+			routes:     [][]int{},
+			unassigned: []int{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := line(tt.positions, tt.stops, tt.vehicles, true)
+			s := &solver{p: p, routes: make([][]int, len(p.Vehicles))}
+			s.savings()
+			if fmt.Sprint(s.routes) != fmt.Sprint(tt.routes) {
+				t.Errorf("routes = %v, want %v", s.routes, tt.routes)
+			}
+			if fmt.Sprint(s.unassigned) != fmt.Sprint(tt.unassigned) {
+				t.Errorf("unassigned = %v, want %v", s.unassigned, tt.unassigned)
+			}
+		})
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		vehicles []Vehicle
+		routes   [][]int
+		moved    bool
+		want     [][]int
+	}{
+		{
+			name:     "moves a stop to the vehicle going past it",
+			vehicles: []Vehicle{van(2, 0, 100), van(5, 0, 100)},
+			routes:   [][]int{{0, 2}, {1}},
+			moved:    true,
+			want:     [][]int{{0}, {2, 1}},
+		},
+		{
+			name:     "not into a full vehicle",
+			vehicles: []Vehicle{van(2, 0, 100), van(1, 0, 100)},
+			routes:   [][]int{{0, 2}, {1}},
+			moved:    false,
+			want:     [][]int{{0, 2}, {1}},
+		},
+		{
+			name:     "not past the end of a shift",
+			vehicles: []Vehicle{van(2, 0, 100), van(5, 0, 60)},
+			routes:   [][]int{{0, 2}, {1}},
+			moved:    false,
+			want:     [][]int{{0, 2}, {1}},
+		},
+		{
+			name:     "reorders within a route",
+			vehicles: []Vehicle{van(5, 0, 200)},
+			routes:   [][]int{{1, 0, 2}},
+			moved:    true,
+			want:     [][]int{{0, 1, 2}},
+		},
+	}
+	// Stop 0 is west of the depot and stop 2 on the way east to stop 1,
+	// which takes five minutes to unload
+	stops := []Stop{open(1), open(1), open(1)}
+	stops[2].Service = 5
+	p := line([]float64{-10, 30, 20}, stops, nil, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Vehicles = tt.vehicles
+			s := &solver{p: p, routes: tt.routes}
+			if moved := s.relocate(); moved != tt.moved {
+				t.Fatalf("relocate() = %v, want %v", moved, tt.moved)
+			}
+			if fmt.Sprint(s.routes) != fmt.Sprint(tt.want) {
+				t.Errorf("routes = %v, want %v", s.routes, tt.want)
+			}
+			for v, route := range s.routes {
+				if _, ok := s.cost(p.Vehicles[v], route); !ok {
+					t.Errorf("vehicle %d cannot drive %v", v, route)
+				}
+			}
+		})
+	}
+}
+
+func TestSolveKeepsEveryRouteFeasible(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 50; n++ {
+		var positions []float64
+		var stops []Stop
+		count := 3 + rng.Intn(15)
+		for i := 0; i < count; i++ {
+			positions = append(positions, (rng.Float64()*2-1)*50)
+			stop := Stop{Demand: Load{Volume: rng.Float64() * 3, Weight: rng.Float64() * 500}, Latest: math.Inf(1), Service: 5}
+			if rng.Intn(2) == 0 {
+				stop.Earliest = rng.Float64() * 120
+				stop.Latest = stop.Earliest + 30 + rng.Float64()*60
+			}
+			stops = append(stops, stop)
+		}
+		var vehicles []Vehicle
+		fleet := 1 + rng.Intn(4)
+		for i := 0; i < fleet; i++ {
+			vehicles = append(vehicles, Vehicle{Capacity: Load{Volume: 2 + rng.Float64()*8, Weight: 500 + rng.Float64()*1500}, End: 200 + rng.Float64()*200})
+		}
+		p := line(positions, stops, vehicles, rng.Intn(2) == 0)
+
+		sol := Solve(p, time.Now().Add(time.Second))
+		s := &solver{p: p}
+		seen := make([]int, len(stops))
+		total := 0.0
+		for _, r := range sol.Routes {
+			km, ok := s.cost(vehicles[r.Vehicle], r.Stops)
+			if !ok {
+				t.Fatalf("problem %d: vehicle %d cannot drive %v", n, r.Vehicle, r.Stops)
+			}
+			if math.Abs(km-r.Distance) > 1e-9 {
+				t.Errorf("problem %d: route %v is %f km, reported %f km", n, r.Stops, km, r.Distance)
+			}
+			for k, i := range r.Stops {
+				seen[i]++
+				if r.Arrivals[k] < stops[i].Earliest || r.Arrivals[k] > stops[i].Latest {
+					t.Errorf("problem %d: stop %d served at %f outside %f-%f", n, i, r.Arrivals[k], stops[i].Earliest, stops[i].Latest)
+				}
+			}
+			total += km
+		}
+		for _, i := range sol.Unassigned {
+			seen[i]++
+		}
+		for i, count := range seen {
+			if count != 1 {
+				t.Errorf("problem %d: stop %d appears %d times", n, i, count)
+			}
+		}
+		if math.Abs(total-sol.Distance) > 1e-6 {
+			t.Errorf("problem %d: routes add up to %f km, reported %f km", n, total, sol.Distance)
+		}
+	}
+}
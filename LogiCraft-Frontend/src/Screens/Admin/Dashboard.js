@@ -97,8 +97,9 @@ const AdminDashboard = () => {
         </Text>
         {driverData &&
           driverData.not_verified !== undefined &&
-          driverData.free !== undefined &&
-          driverData.busy !== undefined && (
+          driverData.online !== undefined &&
+          driverData.on_trip !== undefined &&
+          driverData.offline !== undefined && (
             <PieChart
               data={[
                 {
@@ -109,19 +110,26 @@ const AdminDashboard = () => {
                   legendFontSize: 15,
                 },
                 {
-                  name: "Free",
-                  population: driverData.free,
+                  name: "Online",
+                  population: driverData.online,
                   color: "#00ff00",
                   legendFontColor: "#7F7F7F",
                   legendFontSize: 15,
                 },
                 {
-                  name: "Busy",
-                  population: driverData.busy,
+                  name: "On Trip",
+                  population: driverData.on_trip,
                   color: "#0000ff",
                   legendFontColor: "#7F7F7F",
                   legendFontSize: 15,
                 },
+                {
+                  name: "Offline",
+                  population: driverData.offline,
+                  color: "#808080",
+                  legendFontColor: "#7F7F7F",
+                  legendFontSize: 15,
+                },
               ]}
               width={screenWidth}
               height={220}
@@ -123,11 +123,13 @@ const Bookings = ({ userDetails, logout }) => {
               method: "PUT",
               headers: {
                 "Content-Type": "application/json",
+                Authorization: `Bearer ${userDetails.device_token}`,
               },
               body: JSON.stringify({
                 latitude,
                 longitude,
                 uid: userDetails.uid,
+                recorded_at: new Date(location.timestamp).toISOString(),
               }),
             }
           );
@@ -87,11 +87,13 @@ const DriverDashboard = ({ userDetails, logout }) => {
               method: "PUT",
               headers: {
                 "Content-Type": "application/json",
+                Authorization: `Bearer ${userDetails.device_token}`,
               },
               body: JSON.stringify({
                 latitude,
                 longitude,
                 uid: userDetails.uid,
+                recorded_at: new Date(location.timestamp).toISOString(),
               }),
             }
           );
@@ -12,6 +12,54 @@ import tailwind from "twrnc";
 
 import { SERVER_URL } from "../../env";
 
+// followLiveUpdates reads a Server-Sent Events stream, calling onEvent with each
+// event's name and JSON data, and reconnects when the stream ends. It returns a
+// function that stops following.
+const followLiveUpdates = (url, onEvent) => {
+  let xhr = null;
+  let retryTimer = null;
+  let stopped = false;
+
+  const connect = () => {
+    let seen = 0;
+    let buffer = "";
+    xhr = new XMLHttpRequest();
+    xhr.open("GET", url);
+    xhr.setRequestHeader("Accept", "text/event-stream");
+    xhr.onprogress = () => {
+      buffer += xhr.responseText.slice(seen);
+      seen = xhr.responseText.length;
+      const messages = buffer.split("\n\n");
+      buffer = messages.pop();
+      messages.forEach((message) => {
+        let event = "message";
+        let data = "";
+        message.split("\n").forEach((line) => {
+          if (line.startsWith("event:")) event = line.slice(6).trim();
+          if (line.startsWith("data:")) data += line.slice(5).trim();
+        });
+        if (!data) return;
+        try {
+          onEvent(event, JSON.parse(data));
+        } catch (error) {
+          console.error("Error reading live update:", error);
+        }
+      });
+    };
+    xhr.onloadend = () => {
+      if (!stopped) retryTimer = setTimeout(connect, 3000);
+    };
+    xhr.send();
+  };
+
+  connect();
+  return () => {
+    stopped = true;
+    clearTimeout(retryTimer);
+    if (xhr) xhr.abort();
+  };
+};
+
 const Map = ({ route }) => {
   const { vehicle_no, pickup_location, dropoff_location, userDetails } =
     route.params;
@@ -23,7 +71,9 @@ const Map = ({ route }) => {
 
   const fetchVehicleCoords = async () => {
     try {
-      const response = await fetch(SERVER_URL + `vehicle-coords/${vehicle_no}`);
+      const response = await fetch(
+        SERVER_URL + `vehicle-coords/${vehicle_no}?uid=${userDetails.uid}`
+      );
       const data = await response.json();
       console.log(data);
       if (data) {
@@ -34,11 +84,19 @@ const Map = ({ route }) => {
     }
   };
 
-  // useFocusEffect to start interval for fetching vehicle coords when screen is focused
+  // Follow the vehicle over the server's live update stream while the screen is focused
   useFocusEffect(
     React.useCallback(() => {
-      const intervalId = setInterval(fetchVehicleCoords, 5000); // Fetch every 5 seconds
-      return () => clearInterval(intervalId); // Clear interval when user leaves the screen
+      const stop = followLiveUpdates(
+        SERVER_URL +
+          `live?vehicle=${encodeURIComponent(vehicle_no)}&uid=${userDetails.uid}`,
+        (event, data) => {
+          if (event === "position" && data.vehicle_no === vehicle_no) {
+            setVehicleCoords(data.coordinates);
+          }
+        }
+      );
+      return stop; // Close the stream when user leaves the screen
     }, [])
   );
 
@@ -61,7 +61,7 @@ const BookTransportation = ({ navigation }) => {
     return distance * rates[vehicleType];
   };
 
-  const Book = (distance, estimatedCost) => {
+  const Book = (distance, estimatedCost, upgradeTo = "") => {
     const api_url = SERVER_URL + "book";
     console.log(pickupLocation, dropoffLocation);
     console.log(
@@ -98,6 +98,7 @@ const BookTransportation = ({ navigation }) => {
         distance,
         estimatedCost,
         user_id: userDetails.uid,
+        upgrade_to: upgradeTo,
       }),
     })
       .then((response) => response.json())
@@ -110,6 +111,20 @@ const BookTransportation = ({ navigation }) => {
           setPickupAddress("");
           setDropoffAddress("");
           setVehicleType("small");
+        } else if (data.upgrades && data.upgrades.length > 0) {
+          // No vehicle of the chosen type is free, but a larger one is
+          const offer = data.upgrades[0];
+          Alert.alert(
+            "Upgrade available",
+            `${data.message}. Book a ${offer.vehicle_type} truck for ₹${offer.cost.toFixed(2)}?`,
+            [
+              { text: "No thanks", style: "cancel" },
+              {
+                text: "Book",
+                onPress: () => Book(distance, estimatedCost, offer.vehicle_type),
+              },
+            ]
+          );
         } else {
           Alert.alert(
             "Error",